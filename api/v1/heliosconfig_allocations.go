@@ -0,0 +1,88 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import "sort"
+
+// Addresses returns the non-empty addresses of the allocation.
+func (a *ServiceAllocation) Addresses() []string {
+	var out []string
+	for _, ip := range []string{a.IPv4, a.IPv6} {
+		if ip != "" {
+			out = append(out, ip)
+		}
+	}
+	return out
+}
+
+// FindAllocation returns the allocation of a service, or nil if it has none.
+func (s *HeliosConfigStatus) FindAllocation(namespace, name string) *ServiceAllocation {
+	for i := range s.Allocations {
+		if s.Allocations[i].Namespace == namespace && s.Allocations[i].Name == name {
+			return &s.Allocations[i]
+		}
+	}
+	return nil
+}
+
+// SetAllocation adds or replaces the allocation of a service. Allocations are
+// kept sorted by namespace and name so status updates are stable.
+func (s *HeliosConfigStatus) SetAllocation(a ServiceAllocation) {
+	if existing := s.FindAllocation(a.Namespace, a.Name); existing != nil {
+		*existing = a
+		return
+	}
+	s.Allocations = append(s.Allocations, a)
+	sort.Slice(s.Allocations, func(i, j int) bool {
+		if s.Allocations[i].Namespace != s.Allocations[j].Namespace {
+			return s.Allocations[i].Namespace < s.Allocations[j].Namespace
+		}
+		return s.Allocations[i].Name < s.Allocations[j].Name
+	})
+}
+
+// RemoveAllocation removes the allocation of a service and reports whether
+// there was one.
+func (s *HeliosConfigStatus) RemoveAllocation(namespace, name string) bool {
+	for i := range s.Allocations {
+		if s.Allocations[i].Namespace == namespace && s.Allocations[i].Name == name {
+			s.Allocations = append(s.Allocations[:i], s.Allocations[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// AllocatedAddresses returns every allocated IPv4 and IPv6 address, including
+// entries of the deprecated maps that have not been migrated yet.
+func (s *HeliosConfigStatus) AllocatedAddresses() []string {
+	var out []string
+	for i := range s.Allocations {
+		out = append(out, s.Allocations[i].Addresses()...)
+	}
+	for _, legacy := range []map[string]string{s.AllocatedIPs, s.AllocatedIPv6s} {
+		for _, ip := range legacy {
+			out = append(out, ip)
+		}
+	}
+	return out
+}
+
+// HasLegacyAllocations reports whether the deprecated maps still hold entries.
+func (s *HeliosConfigStatus) HasLegacyAllocations() bool {
+	return len(s.AllocatedIPs) > 0 || len(s.AllocatedIPv6s) > 0
+}
@@ -0,0 +1,39 @@
+package v1
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestHeliosConfigStatus_Allocations(t *testing.T) {
+	var s HeliosConfigStatus
+	s.SetAllocation(ServiceAllocation{Namespace: "b", Name: testServiceName, IPv4: testIPv4})
+	s.SetAllocation(ServiceAllocation{Namespace: "a", Name: testServiceName, IPv4: "10.0.0.2", IPv6: testIPv6})
+
+	if len(s.Allocations) != 2 || s.Allocations[0].Namespace != "a" {
+		t.Fatalf("Allocations = %+v, want both services sorted by namespace", s.Allocations)
+	}
+	if a := s.FindAllocation("b", testServiceName); a == nil || a.IPv4 != testIPv4 {
+		t.Errorf("FindAllocation() = %+v", a)
+	}
+
+	s.SetAllocation(ServiceAllocation{Namespace: "b", Name: testServiceName, IPv4: "10.0.0.3"})
+	if len(s.Allocations) != 2 || s.FindAllocation("b", testServiceName).IPv4 != "10.0.0.3" {
+		t.Errorf("SetAllocation() did not replace the existing entry: %+v", s.Allocations)
+	}
+
+	s.AllocatedIPs = map[string]string{"legacy": "10.0.0.9"}
+	got := s.AllocatedAddresses()
+	slices.Sort(got)
+	want := []string{"10.0.0.2", "10.0.0.3", "10.0.0.9", testIPv6}
+	if !slices.Equal(got, want) {
+		t.Errorf("AllocatedAddresses() = %v, want %v", got, want)
+	}
+
+	if !s.RemoveAllocation("a", testServiceName) || s.RemoveAllocation("a", testServiceName) {
+		t.Error("RemoveAllocation() should remove the entry exactly once")
+	}
+	if s.FindAllocation("a", testServiceName) != nil {
+		t.Error("removed allocation is still found")
+	}
+}
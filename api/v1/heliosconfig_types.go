@@ -17,7 +17,9 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -25,14 +27,28 @@ import (
 
 // HeliosConfigSpec defines the desired state of HeliosConfig.
 // +kubebuilder:validation:XValidation:rule="!has(self.weights) || self.weights.size() == 0 || self.method == 'WeightedRoundRobin'",message="weights can only be used with the WeightedRoundRobin method"
+// +kubebuilder:validation:XValidation:rule="!has(self.slowStartSeconds) || self.slowStartSeconds == 0 || self.method in ['WeightedRoundRobin', 'LeastConnection', 'Random']",message="slowStartSeconds can only be used with the WeightedRoundRobin, LeastConnection or Random method"
 // +kubebuilder:validation:XValidation:rule="!has(self.ports) || self.ports.all(p, self.ports.filter(q, q.port == p.port).size() == 1)",message="duplicate port in spec.ports"
+// +kubebuilder:validation:XValidation:rule="!has(self.ports) || self.ports.all(p, !has(p.affinity) || (has(p.protocol) ? p.protocol : (has(self.protocol) ? self.protocol : 'TCP')) == 'TCP')",message="affinity can only be set on TCP ports"
 // +kubebuilder:validation:XValidation:rule="!has(self.weights) || self.weights.all(w, self.weights.filter(v, v.serviceName == w.serviceName).size() == 1)",message="duplicate serviceName in spec.weights"
+// +kubebuilder:validation:XValidation:rule="(has(self.ipRange) || has(self.ipRanges)) != has(self.poolRef)",message="exactly one of ipRange/ipRanges and poolRef must be set"
+// +kubebuilder:validation:XValidation:rule="!has(self.poolRef) || (!has(self.ipv6Range) && !has(self.ipv6Ranges))",message="ipv6Range and ipv6Ranges cannot be combined with poolRef; set them on the pool"
+// +kubebuilder:validation:XValidation:rule="!has(self.poolRef) || (!has(self.excludeIPs) && !has(self.reservedRanges))",message="excludeIPs and reservedRanges cannot be combined with poolRef; set them on the pool"
 type HeliosConfigSpec struct {
 	// IPRange defines the IPv4 address range for load balancer.
 	// Supports single IP ("192.168.1.100"), range ("192.168.1.100-192.168.1.200"),
 	// and CIDR notation ("192.168.1.0/24").
-	// +kubebuilder:validation:Required
-	IPRange string `json:"ipRange"`
+	// Required unless IPRanges or PoolRef is set.
+	// +optional
+	IPRange string `json:"ipRange,omitempty"`
+
+	// IPRanges lists further IPv4 ranges, in any IPRange format, for address
+	// space that is not contiguous. Together with IPRange they form one pool;
+	// addresses are handed out lowest first across all ranges.
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=16
+	// +optional
+	IPRanges []string `json:"ipRanges,omitempty"`
 
 	// IPv6Range defines the IPv6 address range for dual-stack load balancer.
 	// Supports single IP ("fd00::1"), range ("fd00::1-fd00::ff"),
@@ -41,6 +57,36 @@ type HeliosConfigSpec struct {
 	// +optional
 	IPv6Range string `json:"ipv6Range,omitempty"`
 
+	// IPv6Ranges lists further IPv6 ranges, combined with IPv6Range the same
+	// way IPRanges is combined with IPRange.
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=16
+	// +optional
+	IPv6Ranges []string `json:"ipv6Ranges,omitempty"`
+
+	// ExcludeIPs lists addresses inside the ranges that are never allocated,
+	// such as a gateway or a block reserved for DHCP. Entries take any IPRange
+	// format and may be IPv4 or IPv6; an IPv4 CIDR here covers its network and
+	// broadcast addresses too.
+	// +kubebuilder:validation:MaxItems=64
+	// +optional
+	ExcludeIPs []string `json:"excludeIPs,omitempty"`
+
+	// ReservedRanges lists blocks inside the ranges that are held back for
+	// other uses, such as a DHCP scope or addresses assigned by hand. They are
+	// never allocated, exactly like ExcludeIPs; the separate field keeps whole
+	// reserved blocks apart from one-off exclusions. Entries take any IPRange
+	// format and may be IPv4 or IPv6.
+	// +kubebuilder:validation:MaxItems=16
+	// +optional
+	ReservedRanges []string `json:"reservedRanges,omitempty"`
+
+	// PoolRef names the cluster-scoped HeliosIPPool to allocate addresses from,
+	// instead of the inline IPv4 and IPv6 ranges.
+	// +kubebuilder:validation:MinLength=1
+	// +optional
+	PoolRef string `json:"poolRef,omitempty"`
+
 	// Service references the service to be load balanced
 	// +optional
 	Service string `json:"service,omitempty"`
@@ -57,20 +103,44 @@ type HeliosConfigSpec struct {
 	Ports []PortConfig `json:"ports,omitempty"`
 
 	// Method specifies the load balancing method
-	// +kubebuilder:validation:Enum=RoundRobin;LeastConnection;WeightedRoundRobin;IPHash;Random
+	// +kubebuilder:validation:Enum=RoundRobin;LeastConnection;WeightedRoundRobin;IPHash;Random;Maglev;RingHash;P2C;LeastResponseTime
 	// +kubebuilder:default:=RoundRobin
 	Method string `json:"method,omitempty"`
 
 	// Weights configures per-service backend weights for WeightedRoundRobin method.
+	// A service's weight is the default for each of its endpoints; a pod
+	// annotated with balancer.helios.dev/weight overrides it for its own.
 	// Bounded so the uniqueness rule stays inside the apiserver CEL cost budget.
 	// +kubebuilder:validation:MaxItems=64
 	// +optional
 	Weights []WeightConfig `json:"weights,omitempty"`
 
+	// SlowStartSeconds ramps a new or newly healthy backend from a tenth of its
+	// share up to full over this many seconds. It applies to the
+	// WeightedRoundRobin, LeastConnection and Random methods. 0 disables it.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=3600
+	// +optional
+	SlowStartSeconds int32 `json:"slowStartSeconds,omitempty"`
+
+	// DrainTimeoutSeconds is how long the proxy lets a backend that left the
+	// service's endpoints finish its open connections, sending it no new ones,
+	// before it closes them. When the HeliosConfig is deleted, its addresses
+	// stay allocated and its frontends take no new connections for this long
+	// before the addresses are released. 0 disables draining.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=3600
+	// +optional
+	DrainTimeoutSeconds int32 `json:"drainTimeoutSeconds,omitempty"`
+
 	// HealthCheck configures backend health checking
 	// +optional
 	HealthCheck *HealthCheckConfig `json:"healthCheck,omitempty"`
 
+	// OutlierDetection ejects backends whose proxied traffic keeps failing
+	// +optional
+	OutlierDetection *OutlierDetectionConfig `json:"outlierDetection,omitempty"`
+
 	// NamespaceSelector restricts which namespaces this config manages.
 	// If empty, all namespaces are managed.
 	// +optional
@@ -82,6 +152,74 @@ type HeliosConfigSpec struct {
 	// +kubebuilder:default:=0
 	// +optional
 	MaxAllocations int32 `json:"maxAllocations,omitempty"`
+
+	// Advertisement configures how allocated addresses are made reachable.
+	// Defaults to L2 (ARP/NDP) announcement by the speaker.
+	// +optional
+	Advertisement *AdvertisementConfig `json:"advertisement,omitempty"`
+}
+
+// AdvertisementConfig selects how the speaker advertises allocated addresses
+// +kubebuilder:validation:XValidation:rule="self.mode != 'BGP' || has(self.bgp)",message="bgp is required when the advertisement mode is BGP"
+type AdvertisementConfig struct {
+	// Mode is L2 (one elected node answers ARP/NDP for each address) or
+	// BGP (every ready node advertises a host route for each address)
+	// +kubebuilder:validation:Enum=L2;BGP
+	// +kubebuilder:default:=L2
+	Mode string `json:"mode,omitempty"`
+
+	// BGP configures the peers routes are advertised to (only used when mode is BGP)
+	// +optional
+	BGP *BGPConfig `json:"bgp,omitempty"`
+}
+
+// BGPConfig defines the local BGP speaker and its peers
+// +kubebuilder:validation:XValidation:rule="self.peers.all(p, self.peers.filter(q, q.address == p.address).size() == 1)",message="duplicate address in bgp.peers"
+type BGPConfig struct {
+	// LocalASN is the autonomous system number of the speakers
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=4294967295
+	LocalASN int64 `json:"localASN"`
+
+	// RouterID is the BGP identifier (an IPv4 address). Defaults to the
+	// local IPv4 address of each session; required for IPv6-only peers.
+	// +optional
+	RouterID string `json:"routerID,omitempty"`
+
+	// Peers lists the routers every speaker establishes a session with
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=16
+	Peers []BGPPeer `json:"peers"`
+}
+
+// BGPPeer defines a single BGP neighbor
+type BGPPeer struct {
+	// Address is the IPv4 or IPv6 address of the peer
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Address string `json:"address"`
+
+	// ASN is the autonomous system number of the peer. Equal to localASN for iBGP.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=4294967295
+	ASN int64 `json:"asn"`
+
+	// Port is the TCP port of the peer
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +kubebuilder:default:=179
+	Port int32 `json:"port,omitempty"`
+
+	// HoldTimeSeconds is the hold time proposed to the peer
+	// +kubebuilder:validation:Minimum=3
+	// +kubebuilder:validation:Maximum=65535
+	// +kubebuilder:default:=90
+	HoldTimeSeconds int32 `json:"holdTimeSeconds,omitempty"`
+
+	// PasswordSecretRef selects a key of a Secret in the HeliosConfig's namespace
+	// holding the TCP MD5 password for the session
+	// +optional
+	PasswordSecretRef *corev1.SecretKeySelector `json:"passwordSecretRef,omitempty"`
 }
 
 // WeightConfig defines the weight for a specific service backend
@@ -101,6 +239,7 @@ type WeightConfig struct {
 
 // HealthCheckConfig defines the health check parameters for backends
 // +kubebuilder:validation:XValidation:rule="self.protocol != 'HTTP' || (has(self.httpPath) && size(self.httpPath) > 0)",message="httpPath is required when the health check protocol is HTTP"
+// +kubebuilder:validation:XValidation:rule="!has(self.insecureSkipVerify) || !self.insecureSkipVerify || !has(self.caBundle)",message="insecureSkipVerify and caBundle are mutually exclusive"
 type HealthCheckConfig struct {
 	// Enabled enables or disables health checking
 	// +kubebuilder:default:=true
@@ -119,13 +258,97 @@ type HealthCheckConfig struct {
 	TimeoutMs int32 `json:"timeoutMs,omitempty"`
 
 	// Protocol specifies the health check protocol
-	// +kubebuilder:validation:Enum=TCP;HTTP
+	// +kubebuilder:validation:Enum=TCP;HTTP;GRPC
 	// +kubebuilder:default:=TCP
 	Protocol string `json:"protocol,omitempty"`
 
 	// HTTPPath is the HTTP path for HTTP health checks (only used when protocol is HTTP)
 	// +optional
 	HTTPPath string `json:"httpPath,omitempty"`
+
+	// Scheme is the scheme of HTTP and GRPC health checks; HTTPS checks use TLS
+	// +kubebuilder:validation:Enum=HTTP;HTTPS
+	// +optional
+	Scheme string `json:"scheme,omitempty"`
+
+	// InsecureSkipVerify accepts any certificate from the backend in HTTPS
+	// health checks
+	// +optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+
+	// CABundle is a PEM bundle of the CAs that verify the backend's certificate
+	// in HTTPS health checks. The system roots are used when it is empty.
+	// +optional
+	CABundle []byte `json:"caBundle,omitempty"`
+
+	// Host overrides the Host header, or the gRPC authority, and the TLS
+	// server name of HTTP and GRPC health checks
+	// +kubebuilder:validation:MaxLength=253
+	// +optional
+	Host string `json:"host,omitempty"`
+
+	// GRPCService is the service name GRPC health checks ask
+	// grpc.health.v1.Health about; empty asks about the whole server
+	// +kubebuilder:validation:MaxLength=256
+	// +optional
+	GRPCService string `json:"grpcService,omitempty"`
+
+	// Method is the request method of HTTP health checks (default: GET)
+	// +kubebuilder:validation:Enum=GET;HEAD;POST
+	// +optional
+	Method string `json:"method,omitempty"`
+
+	// Headers are extra headers sent with HTTP health checks
+	// +kubebuilder:validation:MaxItems=16
+	// +optional
+	Headers []HTTPHeader `json:"headers,omitempty"`
+
+	// ExpectedStatuses are the response codes that pass an HTTP health check.
+	// Any 2xx or 3xx code passes when it is empty.
+	// +kubebuilder:validation:MaxItems=32
+	// +kubebuilder:validation:items:Minimum=100
+	// +kubebuilder:validation:items:Maximum=599
+	// +optional
+	ExpectedStatuses []int32 `json:"expectedStatuses,omitempty"`
+
+	// BodyContains is a substring the response body of an HTTP health check
+	// must contain. Only the first 64KiB of the body are read.
+	// +kubebuilder:validation:MaxLength=1024
+	// +optional
+	BodyContains string `json:"bodyContains,omitempty"`
+
+	// BodyRegex is a regular expression the response body of an HTTP health
+	// check must match. Only the first 64KiB of the body are read.
+	// +kubebuilder:validation:MaxLength=1024
+	// +optional
+	BodyRegex string `json:"bodyRegex,omitempty"`
+
+	// HealthyThreshold is the number of consecutive successful checks that
+	// mark an unhealthy backend healthy again
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=10
+	// +kubebuilder:default:=2
+	HealthyThreshold int32 `json:"healthyThreshold,omitempty"`
+
+	// UnhealthyThreshold is the number of consecutive failed checks that
+	// mark a healthy backend unhealthy
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=10
+	// +kubebuilder:default:=3
+	UnhealthyThreshold int32 `json:"unhealthyThreshold,omitempty"`
+}
+
+// HTTPHeader is a header sent with HTTP health checks
+type HTTPHeader struct {
+	// Name of the header
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=256
+	// +kubebuilder:validation:Pattern=`^[-!#$%&'*+.^_|~0-9A-Za-z]+$`
+	Name string `json:"name"`
+
+	// Value of the header
+	// +kubebuilder:validation:MaxLength=4096
+	Value string `json:"value"`
 }
 
 // PortConfig defines the configuration for a port
@@ -139,14 +362,149 @@ type PortConfig struct {
 	// +kubebuilder:validation:Enum=TCP;UDP
 	// +optional
 	Protocol string `json:"protocol,omitempty"`
+
+	// IdleTimeoutSeconds ends a UDP flow of the built-in proxy after this
+	// long without a datagram in either direction (UDP only, defaults to 120)
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=3600
+	// +optional
+	IdleTimeoutSeconds int32 `json:"idleTimeoutSeconds,omitempty"`
+
+	// Affinity makes the built-in proxy pin the HTTP clients of this port to
+	// a backend (TCP only). The proxy routes a connection by its first request,
+	// so each connection carries a single request: responses get
+	// "Connection: close" and keep-alive clients reconnect for every request.
+	// Upgraded connections such as WebSockets stay open.
+	// +optional
+	Affinity *SessionAffinityConfig `json:"affinity,omitempty"`
+}
+
+// SessionAffinityConfig pins HTTP clients of a port to a backend by a request
+// header or by a cookie the proxy inserts. The first request of a client is
+// balanced by spec.method.
+// +kubebuilder:validation:XValidation:rule="self.mode != 'Header' || (has(self.headerName) && size(self.headerName) > 0)",message="headerName is required when the affinity mode is Header"
+type SessionAffinityConfig struct {
+	// Mode is Header (clients sending the same value of HeaderName share a
+	// backend) or Cookie (each client gets a cookie naming its backend)
+	// +kubebuilder:validation:Enum=Header;Cookie
+	Mode string `json:"mode"`
+
+	// HeaderName is the request header routed on (Header mode only)
+	// +kubebuilder:validation:MaxLength=256
+	// +kubebuilder:validation:Pattern=`^[-!#$%&'*+.^_|~0-9A-Za-z]+$`
+	// +optional
+	HeaderName string `json:"headerName,omitempty"`
+
+	// CookieName is the name of the inserted cookie (Cookie mode only,
+	// defaults to helios-affinity)
+	// +kubebuilder:validation:MaxLength=256
+	// +kubebuilder:validation:Pattern=`^[-!#$%&'*+.^_|~0-9A-Za-z]+$`
+	// +optional
+	CookieName string `json:"cookieName,omitempty"`
+
+	// TimeoutSeconds is how long a header value stays pinned after its last
+	// request, and the Max-Age of the cookie
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=86400
+	// +kubebuilder:default:=10800
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+}
+
+// OutlierDetectionConfig defines passive health checking: the built-in proxy
+// ejects a backend after enough connect failures, resets or HTTP 5xx
+// responses in a row, for a time that doubles with each ejection.
+// +kubebuilder:validation:XValidation:rule="self.maxEjectionSeconds >= self.baseEjectionSeconds",message="maxEjectionSeconds must not be less than baseEjectionSeconds"
+type OutlierDetectionConfig struct {
+	// Enabled enables or disables outlier detection
+	// +kubebuilder:default:=true
+	Enabled bool `json:"enabled"`
+
+	// ConsecutiveFailures is the number of failures in a row that eject a backend
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +kubebuilder:default:=5
+	ConsecutiveFailures int32 `json:"consecutiveFailures,omitempty"`
+
+	// BaseEjectionSeconds is how long the first ejection of a backend lasts
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=3600
+	// +kubebuilder:default:=30
+	BaseEjectionSeconds int32 `json:"baseEjectionSeconds,omitempty"`
+
+	// MaxEjectionSeconds caps how long an ejection lasts
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=3600
+	// +kubebuilder:default:=300
+	MaxEjectionSeconds int32 `json:"maxEjectionSeconds,omitempty"`
+
+	// MaxEjectionPercent caps the share of a service's backends ejected at
+	// once. One backend can always be ejected, however small the service.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +kubebuilder:default:=10
+	MaxEjectionPercent int32 `json:"maxEjectionPercent,omitempty"`
+}
+
+// BackendEjection records a backend that a node's proxy ejected
+type BackendEjection struct {
+	// Node is the node whose proxy ejected the backend
+	Node string `json:"node"`
+
+	// Frontend is the proxy frontend the backend serves, as ip:port/protocol
+	Frontend string `json:"frontend"`
+
+	// Backend is the address of the backend, as ip:port
+	Backend string `json:"backend"`
+
+	// EjectedUntil is when the ejection ends
+	EjectedUntil metav1.Time `json:"ejectedUntil"`
+}
+
+// ServiceAllocation records the addresses allocated to one service
+type ServiceAllocation struct {
+	// Namespace of the service
+	Namespace string `json:"namespace"`
+
+	// Name of the service
+	Name string `json:"name"`
+
+	// UID of the service the addresses were allocated to. A service recreated
+	// under the same name has a new UID and gets a fresh allocation.
+	// +optional
+	UID types.UID `json:"uid,omitempty"`
+
+	// IPv4 is the allocated IPv4 address
+	// +optional
+	IPv4 string `json:"ipv4,omitempty"`
+
+	// IPv6 is the allocated IPv6 address (dual-stack)
+	// +optional
+	IPv6 string `json:"ipv6,omitempty"`
+
+	// AllocatedAt is when the addresses were allocated
+	// +optional
+	AllocatedAt metav1.Time `json:"allocatedAt,omitempty"`
 }
 
 // HeliosConfigStatus defines the observed state of HeliosConfig.
 type HeliosConfigStatus struct {
-	// AllocatedIPs is a map of service names to their allocated IPv4 addresses
+	// Allocations lists the addresses allocated to each service
+	// +listType=map
+	// +listMapKey=namespace
+	// +listMapKey=name
+	// +optional
+	Allocations []ServiceAllocation `json:"allocations,omitempty"`
+
+	// AllocatedIPs is a map of service names to their allocated IPv4 addresses.
+	// Deprecated: use Allocations. The controller migrates existing entries
+	// into Allocations and clears this field.
+	// +optional
 	AllocatedIPs map[string]string `json:"allocatedIPs,omitempty"`
 
-	// AllocatedIPv6s is a map of service names to their allocated IPv6 addresses (dual-stack)
+	// AllocatedIPv6s is a map of service names to their allocated IPv6 addresses (dual-stack).
+	// Deprecated: use Allocations. The controller migrates existing entries
+	// into Allocations and clears this field.
+	// +optional
 	AllocatedIPv6s map[string]string `json:"allocatedIPv6s,omitempty"`
 
 	// State represents the current state of the load balancer
@@ -165,6 +523,15 @@ type HeliosConfigStatus struct {
 	// Phase represents the current state of the HeliosConfig
 	// +optional
 	Phase string `json:"phase,omitempty"`
+
+	// EjectedBackends lists the backends outlier detection keeps out, per node.
+	// Each node's speaker maintains its own entries.
+	// +listType=map
+	// +listMapKey=node
+	// +listMapKey=frontend
+	// +listMapKey=backend
+	// +optional
+	EjectedBackends []BackendEjection `json:"ejectedBackends,omitempty"`
 }
 
 // HeliosConfig Constants
@@ -172,6 +539,11 @@ const (
 	// LoadBalancerClassHelios is the load balancer class name for Helios LB.
 	LoadBalancerClassHelios = "helios-lb"
 
+	// EndpointWeightAnnotation on a Pod sets the weight, 1 to 100, of its
+	// endpoints under the WeightedRoundRobin method. It overrides the weight
+	// of the pod's service in spec.weights.
+	EndpointWeightAnnotation = "balancer.helios.dev/weight"
+
 	// Load balancing methods accepted by spec.method. Mirrors the
 	// +kubebuilder:validation:Enum marker on HeliosConfigSpec.Method.
 	MethodRoundRobin         = "RoundRobin"
@@ -179,12 +551,32 @@ const (
 	MethodWeightedRoundRobin = "WeightedRoundRobin"
 	MethodIPHash             = "IPHash"
 	MethodRandom             = "Random"
+	MethodMaglev             = "Maglev"
+	MethodRingHash           = "RingHash"
+	MethodP2C                = "P2C"
+	MethodLeastResponseTime  = "LeastResponseTime"
 
 	// Protocols accepted by port configuration (TCP/UDP) and health check
-	// configuration (TCP/HTTP).
+	// configuration (TCP/HTTP/GRPC).
 	ProtocolTCP  = "TCP"
 	ProtocolUDP  = "UDP"
 	ProtocolHTTP = "HTTP"
+	ProtocolGRPC = "GRPC"
+
+	// Schemes and request methods accepted by HTTP health check configuration.
+	SchemeHTTP     = "HTTP"
+	SchemeHTTPS    = "HTTPS"
+	HTTPMethodGET  = "GET"
+	HTTPMethodHEAD = "HEAD"
+	HTTPMethodPOST = "POST"
+
+	// Advertisement modes accepted by spec.advertisement.mode.
+	AdvertisementModeL2  = "L2"
+	AdvertisementModeBGP = "BGP"
+
+	// Session affinity modes accepted by spec.ports[].affinity.mode.
+	AffinityModeHeader = "Header"
+	AffinityModeCookie = "Cookie"
 
 	// State constants
 	StatePending = "Pending"
@@ -202,6 +594,7 @@ const (
 	ReasonNetworkError      = "NetworkError"
 	ReasonIPAllocationError = "IPAllocationError"
 	ReasonIPConflict        = "IPConflict"
+	ReasonPoolNotFound      = "PoolNotFound"
 )
 
 // +kubebuilder:object:root=true
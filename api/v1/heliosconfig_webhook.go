@@ -18,12 +18,17 @@ package v1
 
 import (
 	"context"
+	"crypto/x509"
 	"fmt"
+	"math"
 	"net"
+	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/somaz94/helios-lb/internal/network"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -52,42 +57,149 @@ var _ admission.Validator[*HeliosConfig] = &HeliosConfigValidator{}
 // ValidateCreate validates a HeliosConfig on creation.
 func (v *HeliosConfigValidator) ValidateCreate(ctx context.Context, hc *HeliosConfig) (admission.Warnings, error) {
 	helioslog.Info("validate create", "name", hc.Name)
-	return nil, v.validateSpec(ctx, hc, "")
+	return v.validateSpec(ctx, hc, "")
 }
 
 // ValidateUpdate validates a HeliosConfig on update.
 func (v *HeliosConfigValidator) ValidateUpdate(ctx context.Context, _ *HeliosConfig, hc *HeliosConfig) (admission.Warnings, error) {
 	helioslog.Info("validate update", "name", hc.Name)
-	return nil, v.validateSpec(ctx, hc, hc.Name)
+	return v.validateSpec(ctx, hc, hc.Name)
 }
 
 // validateSpec runs all field-level and cross-config validations for a HeliosConfig.
 // excludeName is the config to exclude from the overlap check (the config itself, on update).
-func (v *HeliosConfigValidator) validateSpec(ctx context.Context, hc *HeliosConfig, excludeName string) error {
-	if err := validateIPRange(hc.Spec.IPRange); err != nil {
-		return fmt.Errorf("ipRange: %w", err)
+func (v *HeliosConfigValidator) validateSpec(ctx context.Context, hc *HeliosConfig, excludeName string) (admission.Warnings, error) {
+	if err := validateAddressSource(&hc.Spec); err != nil {
+		return nil, err
 	}
-	if hc.Spec.IPv6Range != "" {
-		if err := validateIPRange(hc.Spec.IPv6Range); err != nil {
-			return fmt.Errorf("ipv6Range: %w", err)
-		}
+	warnings, err := validateExclusions(hc.Spec.ExcludeIPs, hc.Spec.ReservedRanges, hc.Spec.IPv4RangeSet(), hc.Spec.IPv6RangeSet())
+	if err != nil {
+		return nil, err
 	}
 	if err := validatePorts(hc.Spec.Ports); err != nil {
-		return err
+		return nil, err
+	}
+	if err := validateAffinity(hc.Spec.Ports, hc.Spec.Protocol); err != nil {
+		return nil, err
 	}
 	if err := validateWeights(hc.Spec.Weights, hc.Spec.Method); err != nil {
-		return err
+		return nil, err
+	}
+	if err := validateSlowStart(hc.Spec.SlowStartSeconds, hc.Spec.Method); err != nil {
+		return nil, err
+	}
+	if err := validateDrainTimeout(hc.Spec.DrainTimeoutSeconds); err != nil {
+		return nil, err
 	}
 	if err := validateHealthCheck(hc.Spec.HealthCheck); err != nil {
+		return nil, err
+	}
+	if err := validateOutlierDetection(hc.Spec.OutlierDetection); err != nil {
+		return nil, err
+	}
+	if err := validateAdvertisement(hc.Spec.Advertisement); err != nil {
+		return nil, err
+	}
+	// Cross-config checks; skipped when no client is wired (unit tests).
+	if v.Client == nil {
+		return warnings, nil
+	}
+	if hc.Spec.PoolRef != "" {
+		return append(warnings, v.checkPoolRef(ctx, hc.Spec.PoolRef)...), nil
+	}
+	return warnings, v.checkIPRangeOverlap(ctx, hc, excludeName)
+}
+
+// validateAddressSource checks that the config takes its addresses either from
+// inline ranges or from a HeliosIPPool, and that inline ranges are well-formed.
+func validateAddressSource(spec *HeliosConfigSpec) error {
+	if spec.PoolRef != "" {
+		if spec.IPv4RangeSet() != "" || spec.IPv6RangeSet() != "" {
+			return fmt.Errorf("inline IP ranges cannot be combined with poolRef %q", spec.PoolRef)
+		}
+		if len(spec.ExcludeIPs) > 0 || len(spec.ReservedRanges) > 0 {
+			return fmt.Errorf("excludeIPs and reservedRanges cannot be combined with poolRef %q; set them on the pool", spec.PoolRef)
+		}
+		return nil
+	}
+	if err := validateRangeSet("ipRange", spec.IPRange, "ipRanges", spec.IPRanges, true); err != nil {
 		return err
 	}
-	// Cross-config IP range overlap check; skipped when no client is wired (unit tests).
-	if v.Client != nil {
-		return v.checkIPRangeOverlap(ctx, hc, excludeName)
+	return validateRangeSet("ipv6Range", spec.IPv6Range, "ipv6Ranges", spec.IPv6Ranges, false)
+}
+
+// validateRangeSet validates the ranges of one address family, given as the
+// single-range field and the list field, and rejects ranges that overlap each
+// other. When required is true at least one range must be present.
+func validateRangeSet(singleField, single, listField string, list []string, required bool) error {
+	type namedRange struct {
+		field string
+		value string
+	}
+	var entries []namedRange
+	if single != "" {
+		entries = append(entries, namedRange{singleField, single})
+	}
+	for i, r := range list {
+		entries = append(entries, namedRange{fmt.Sprintf("%s[%d]", listField, i), r})
+	}
+	if len(entries) == 0 {
+		if required {
+			return fmt.Errorf("one of %s and %s is required", singleField, listField)
+		}
+		return nil
+	}
+
+	parsed := make([]network.IPRange, len(entries))
+	for i, e := range entries {
+		if err := validateIPRange(e.value); err != nil {
+			return fmt.Errorf("%s: %w", e.field, err)
+		}
+		start, end, err := network.ParseIPRange(e.value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", e.field, err)
+		}
+		parsed[i] = network.IPRange{Start: start, End: end}
+		for j := range i {
+			if parsed[j].Overlaps(parsed[i]) {
+				return fmt.Errorf("%s %q overlaps %s %q", e.field, e.value, entries[j].field, entries[j].value)
+			}
+		}
 	}
 	return nil
 }
 
+// validateExclusions checks the format of excludeIPs and reservedRanges
+// entries and warns about entries that lie outside every range of their
+// family, since those exclude nothing and usually point at a typo.
+func validateExclusions(excludeIPs, reservedRanges []string, ipv4Set, ipv6Set string) (admission.Warnings, error) {
+	var warnings admission.Warnings
+	for _, list := range []struct {
+		field   string
+		entries []string
+	}{{"excludeIPs", excludeIPs}, {"reservedRanges", reservedRanges}} {
+		for i, e := range list.entries {
+			field := fmt.Sprintf("%s[%d]", list.field, i)
+			if err := validateIPRange(e); err != nil {
+				return nil, fmt.Errorf("%s: %w", field, err)
+			}
+			block, err := network.ParseAddressBlock(e)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", field, err)
+			}
+			set := ipv4Set
+			if len(block.Start) == net.IPv6len {
+				set = ipv6Set
+			}
+			ranges, _ := network.ParseRangeSet(set)
+			if !slices.ContainsFunc(ranges, block.Overlaps) {
+				warnings = append(warnings, fmt.Sprintf("%s %q lies outside the configured ranges and excludes nothing", field, e))
+			}
+		}
+	}
+	return warnings, nil
+}
+
 // ValidateDelete validates a HeliosConfig on deletion.
 func (v *HeliosConfigValidator) ValidateDelete(_ context.Context, _ *HeliosConfig) (admission.Warnings, error) {
 	return nil, nil
@@ -144,9 +256,12 @@ func validateIPRange(ipRange string) error {
 	return nil
 }
 
-// The bound and enum checks in validatePorts, validateWeights, and validateHealthCheck
-// intentionally mirror the +kubebuilder:validation markers on the matching spec fields
-// (PortConfig.Port/Protocol, WeightConfig.Weight, HealthCheckConfig.Protocol) in
+// The bound and enum checks in validatePorts, validateAffinity, validateWeights,
+// validateSlowStart, validateDrainTimeout, validateHealthCheck and
+// validateOutlierDetection intentionally mirror the +kubebuilder:validation markers on
+// the matching spec fields (PortConfig.Port/Protocol, SessionAffinityConfig,
+// WeightConfig.Weight, SlowStartSeconds, DrainTimeoutSeconds, and the
+// HealthCheckConfig, HTTPHeader and OutlierDetectionConfig fields) in
 // heliosconfig_types.go. The CRD schema is the primary admission gate; these webhook
 // checks are a defense-in-depth backstop and the path unit tests exercise directly.
 // Keep the two in lock-step: when a marker bound changes, update the matching check here.
@@ -171,6 +286,48 @@ func validatePorts(ports []PortConfig) error {
 	return nil
 }
 
+// validateAffinity validates the session affinity of each port. protocol is
+// spec.protocol, the default of the ports.
+func validateAffinity(ports []PortConfig, protocol string) error {
+	for _, p := range ports {
+		a := p.Affinity
+		if a == nil {
+			continue
+		}
+		portProtocol := p.Protocol
+		if portProtocol == "" {
+			portProtocol = protocol
+		}
+		if portProtocol == ProtocolUDP {
+			return fmt.Errorf("affinity can only be set on TCP ports, port %d is UDP", p.Port)
+		}
+		switch a.Mode {
+		case AffinityModeHeader:
+			if a.HeaderName == "" {
+				return fmt.Errorf("affinity headerName is required for mode Header on port %d", p.Port)
+			}
+			if a.CookieName != "" {
+				return fmt.Errorf("affinity cookieName can only be used with mode Cookie on port %d", p.Port)
+			}
+		case AffinityModeCookie:
+			if a.HeaderName != "" {
+				return fmt.Errorf("affinity headerName can only be used with mode Header on port %d", p.Port)
+			}
+		default:
+			return fmt.Errorf("invalid affinity mode %q for port %d: must be Header or Cookie", a.Mode, p.Port)
+		}
+		for _, name := range []string{a.HeaderName, a.CookieName} {
+			if len(name) > 256 || name != "" && !httpHeaderName.MatchString(name) {
+				return fmt.Errorf("invalid affinity header or cookie name %q for port %d", name, p.Port)
+			}
+		}
+		if a.TimeoutSeconds < 0 || a.TimeoutSeconds > 86400 {
+			return fmt.Errorf("affinity timeoutSeconds for port %d must be between 1 and 86400, got %d", p.Port, a.TimeoutSeconds)
+		}
+	}
+	return nil
+}
+
 // validateWeights validates weight configurations.
 func validateWeights(weights []WeightConfig, method string) error {
 	if len(weights) > 0 && method != MethodWeightedRoundRobin {
@@ -192,72 +349,317 @@ func validateWeights(weights []WeightConfig, method string) error {
 	return nil
 }
 
+// validateSlowStart validates the slow start window, which only the weighted,
+// least-connection and random methods honor.
+func validateSlowStart(seconds int32, method string) error {
+	if seconds < 0 || seconds > 3600 {
+		return fmt.Errorf("slowStartSeconds must be between 0 and 3600, got %d", seconds)
+	}
+	switch method {
+	case MethodWeightedRoundRobin, MethodLeastConnection, MethodRandom:
+		return nil
+	}
+	if seconds > 0 {
+		return fmt.Errorf("slowStartSeconds can only be used with the WeightedRoundRobin, LeastConnection or Random method, got %q", method)
+	}
+	return nil
+}
+
+// validateDrainTimeout validates the drain timeout.
+func validateDrainTimeout(seconds int32) error {
+	if seconds < 0 || seconds > 3600 {
+		return fmt.Errorf("drainTimeoutSeconds must be between 0 and 3600, got %d", seconds)
+	}
+	return nil
+}
+
 // validateHealthCheck validates health check configuration.
 func validateHealthCheck(hc *HealthCheckConfig) error {
 	if hc == nil {
 		return nil
 	}
 	switch hc.Protocol {
-	case "", ProtocolTCP, ProtocolHTTP:
+	case "", ProtocolTCP, ProtocolHTTP, ProtocolGRPC:
 	default:
-		return fmt.Errorf("invalid health check protocol %q: must be TCP or HTTP", hc.Protocol)
+		return fmt.Errorf("invalid health check protocol %q: must be TCP, HTTP or GRPC", hc.Protocol)
 	}
 	if hc.Protocol == ProtocolHTTP && hc.HTTPPath == "" {
 		return fmt.Errorf("httpPath is required when health check protocol is HTTP")
 	}
+	// Zero means unset; the CRD defaults it.
+	if hc.HealthyThreshold < 0 || hc.HealthyThreshold > 10 {
+		return fmt.Errorf("healthyThreshold must be between 1 and 10, got %d", hc.HealthyThreshold)
+	}
+	if hc.UnhealthyThreshold < 0 || hc.UnhealthyThreshold > 10 {
+		return fmt.Errorf("unhealthyThreshold must be between 1 and 10, got %d", hc.UnhealthyThreshold)
+	}
+	return validateHTTPHealthCheck(hc)
+}
+
+// httpHeaderName matches the token characters a header name may use.
+var httpHeaderName = regexp.MustCompile(`^[-!#$%&'*+.^_|~0-9A-Za-z]+$`)
+
+// validateHTTPHealthCheck validates the fields that shape HTTP health checks.
+func validateHTTPHealthCheck(hc *HealthCheckConfig) error {
+	switch hc.Scheme {
+	case "", SchemeHTTP, SchemeHTTPS:
+	default:
+		return fmt.Errorf("invalid health check scheme %q: must be HTTP or HTTPS", hc.Scheme)
+	}
+	switch hc.Method {
+	case "", HTTPMethodGET, HTTPMethodHEAD, HTTPMethodPOST:
+	default:
+		return fmt.Errorf("invalid health check method %q: must be GET, HEAD or POST", hc.Method)
+	}
+	if hc.InsecureSkipVerify && len(hc.CABundle) > 0 {
+		return fmt.Errorf("insecureSkipVerify and caBundle are mutually exclusive")
+	}
+	if len(hc.CABundle) > 0 && !x509.NewCertPool().AppendCertsFromPEM(hc.CABundle) {
+		return fmt.Errorf("caBundle contains no PEM certificates")
+	}
+	if len(hc.Host) > 253 {
+		return fmt.Errorf("host must be at most 253 characters")
+	}
+	if len(hc.Headers) > 16 {
+		return fmt.Errorf("at most 16 health check headers are allowed, got %d", len(hc.Headers))
+	}
+	for _, h := range hc.Headers {
+		if len(h.Name) > 256 || !httpHeaderName.MatchString(h.Name) {
+			return fmt.Errorf("invalid health check header name %q", h.Name)
+		}
+		if strings.EqualFold(h.Name, "Host") {
+			return fmt.Errorf("set the Host header with host, not headers")
+		}
+		if len(h.Value) > 4096 {
+			return fmt.Errorf("value of health check header %q must be at most 4096 characters", h.Name)
+		}
+	}
+	if len(hc.ExpectedStatuses) > 32 {
+		return fmt.Errorf("at most 32 expected statuses are allowed, got %d", len(hc.ExpectedStatuses))
+	}
+	for _, code := range hc.ExpectedStatuses {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("expected status %d out of valid range (100-599)", code)
+		}
+	}
+	if len(hc.BodyContains) > 1024 || len(hc.BodyRegex) > 1024 {
+		return fmt.Errorf("bodyContains and bodyRegex must be at most 1024 characters")
+	}
+	if hc.BodyRegex != "" {
+		if _, err := regexp.Compile(hc.BodyRegex); err != nil {
+			return fmt.Errorf("invalid bodyRegex: %w", err)
+		}
+	}
+	if hc.Method == HTTPMethodHEAD && (hc.BodyContains != "" || hc.BodyRegex != "") {
+		return fmt.Errorf("HEAD health checks have no body to match")
+	}
+	if len(hc.GRPCService) > 256 {
+		return fmt.Errorf("grpcService must be at most 256 characters")
+	}
+	if hc.Protocol == ProtocolGRPC && (hc.Method != "" || len(hc.Headers) > 0 || len(hc.ExpectedStatuses) > 0 ||
+		hc.BodyContains != "" || hc.BodyRegex != "" || hc.HTTPPath != "") {
+		return fmt.Errorf("httpPath, method, headers, expectedStatuses, bodyContains and bodyRegex do not apply to GRPC health checks")
+	}
+	return nil
+}
+
+// validateOutlierDetection validates outlier detection configuration. Zero
+// values are unset; the CRD defaults them.
+func validateOutlierDetection(od *OutlierDetectionConfig) error {
+	if od == nil {
+		return nil
+	}
+	if od.ConsecutiveFailures < 0 || od.ConsecutiveFailures > 100 {
+		return fmt.Errorf("consecutiveFailures must be between 1 and 100, got %d", od.ConsecutiveFailures)
+	}
+	if od.BaseEjectionSeconds < 0 || od.BaseEjectionSeconds > 3600 {
+		return fmt.Errorf("baseEjectionSeconds must be between 1 and 3600, got %d", od.BaseEjectionSeconds)
+	}
+	if od.MaxEjectionSeconds < 0 || od.MaxEjectionSeconds > 3600 {
+		return fmt.Errorf("maxEjectionSeconds must be between 1 and 3600, got %d", od.MaxEjectionSeconds)
+	}
+	if od.BaseEjectionSeconds > 0 && od.MaxEjectionSeconds > 0 && od.MaxEjectionSeconds < od.BaseEjectionSeconds {
+		return fmt.Errorf("maxEjectionSeconds must not be less than baseEjectionSeconds")
+	}
+	if od.MaxEjectionPercent < 0 || od.MaxEjectionPercent > 100 {
+		return fmt.Errorf("maxEjectionPercent must be between 1 and 100, got %d", od.MaxEjectionPercent)
+	}
 	return nil
 }
 
+// validateAdvertisement validates the advertisement mode and BGP peers.
+func validateAdvertisement(adv *AdvertisementConfig) error {
+	if adv == nil {
+		return nil
+	}
+	switch adv.Mode {
+	case "", AdvertisementModeL2, AdvertisementModeBGP:
+	default:
+		return fmt.Errorf("invalid advertisement mode %q: must be L2 or BGP", adv.Mode)
+	}
+	if adv.Mode != AdvertisementModeBGP {
+		return nil
+	}
+	bgp := adv.BGP
+	if bgp == nil {
+		return fmt.Errorf("bgp is required when advertisement mode is BGP")
+	}
+	if bgp.LocalASN < 1 || bgp.LocalASN > math.MaxUint32 {
+		return fmt.Errorf("bgp localASN %d out of valid range (1-4294967295)", bgp.LocalASN)
+	}
+	if bgp.RouterID != "" {
+		if ip := net.ParseIP(bgp.RouterID); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("bgp routerID %q must be an IPv4 address", bgp.RouterID)
+		}
+	}
+	if len(bgp.Peers) == 0 {
+		return fmt.Errorf("bgp requires at least one peer")
+	}
+	seen := make(map[string]bool)
+	for _, p := range bgp.Peers {
+		ip := net.ParseIP(p.Address)
+		if ip == nil {
+			return fmt.Errorf("invalid bgp peer address %q", p.Address)
+		}
+		if p.ASN < 1 || p.ASN > math.MaxUint32 {
+			return fmt.Errorf("bgp peer %s asn %d out of valid range (1-4294967295)", p.Address, p.ASN)
+		}
+		if p.Port != 0 && (p.Port < 1 || p.Port > 65535) {
+			return fmt.Errorf("bgp peer %s port %d out of valid range (1-65535)", p.Address, p.Port)
+		}
+		if p.HoldTimeSeconds != 0 && (p.HoldTimeSeconds < 3 || p.HoldTimeSeconds > 65535) {
+			return fmt.Errorf("bgp peer %s holdTimeSeconds %d out of valid range (3-65535)", p.Address, p.HoldTimeSeconds)
+		}
+		if ip.To4() == nil && bgp.RouterID == "" {
+			return fmt.Errorf("bgp routerID is required for IPv6 peer %s", p.Address)
+		}
+		if seen[ip.String()] {
+			return fmt.Errorf("duplicate bgp peer %s", p.Address)
+		}
+		seen[ip.String()] = true
+	}
+	return nil
+}
+
+// checkPoolRef warns when the referenced HeliosIPPool does not exist. The
+// config is still admitted so pools and configs can be applied in any order;
+// the controller waits for the pool before allocating.
+func (v *HeliosConfigValidator) checkPoolRef(ctx context.Context, poolRef string) admission.Warnings {
+	var pool HeliosIPPool
+	if err := v.Client.Get(ctx, client.ObjectKey{Name: poolRef}, &pool); err != nil {
+		if apierrors.IsNotFound(err) {
+			return admission.Warnings{fmt.Sprintf("HeliosIPPool %q does not exist yet", poolRef)}
+		}
+		helioslog.Error(err, "failed to get HeliosIPPool for poolRef check", "pool", poolRef)
+	}
+	return nil
+}
+
+// rangeOwner is an object that owns address ranges: a HeliosIPPool or a
+// HeliosConfig with inline ranges. ipRange and ipv6Range are range set
+// expressions covering all of the owner's ranges of that family.
+type rangeOwner struct {
+	kind      string
+	name      string
+	ipRange   string
+	ipv6Range string
+}
+
+// listRangeOwners returns every HeliosIPPool and every HeliosConfig with inline
+// ranges, skipping the pool excludePool and the config excludeConfig. Configs
+// that reference a pool own no ranges of their own.
+func listRangeOwners(ctx context.Context, c client.Reader, excludePool, excludeConfig string) ([]rangeOwner, error) {
+	var pools HeliosIPPoolList
+	if err := c.List(ctx, &pools); err != nil {
+		return nil, err
+	}
+	var configs HeliosConfigList
+	if err := c.List(ctx, &configs); err != nil {
+		return nil, err
+	}
+
+	owners := make([]rangeOwner, 0, len(pools.Items)+len(configs.Items))
+	for _, p := range pools.Items {
+		if p.Name == excludePool {
+			continue
+		}
+		owners = append(owners, rangeOwner{
+			kind: "HeliosIPPool", name: p.Name, ipRange: p.Spec.IPv4RangeSet(), ipv6Range: p.Spec.IPv6RangeSet(),
+		})
+	}
+	for _, hc := range configs.Items {
+		if hc.Name == excludeConfig || hc.Spec.PoolRef != "" {
+			continue
+		}
+		owners = append(owners, rangeOwner{
+			kind: "HeliosConfig", name: hc.Name, ipRange: hc.Spec.IPv4RangeSet(), ipv6Range: hc.Spec.IPv6RangeSet(),
+		})
+	}
+	return owners, nil
+}
+
 // checkIPRangeOverlap checks if the new HeliosConfig's IPv4 and IPv6 ranges overlap
-// with any existing HeliosConfig. excludeName is the name of the config to exclude
-// from the check (used during updates).
+// with any HeliosIPPool or other HeliosConfig with inline ranges. excludeName is the
+// name of the config to exclude from the check (used during updates).
 func (v *HeliosConfigValidator) checkIPRangeOverlap(ctx context.Context, hc *HeliosConfig, excludeName string) error {
-	var list HeliosConfigList
-	if err := v.Client.List(ctx, &list); err != nil {
+	owners, err := listRangeOwners(ctx, v.Client, "", excludeName)
+	if err != nil {
 		// If we can't list, log and skip overlap check rather than blocking.
-		helioslog.Error(err, "failed to list HeliosConfigs for overlap check")
+		helioslog.Error(err, "failed to list range owners for overlap check")
 		return nil
 	}
+	return checkOwnersOverlap(hc.Spec.IPv4RangeSet(), hc.Spec.IPv6RangeSet(), owners)
+}
 
-	if err := overlapForRange(hc.Spec.IPRange, excludeName, list.Items, false); err != nil {
+// checkOwnersOverlap reports the first overlap of ipRange or ipv6Range with the
+// matching family range of any owner.
+func checkOwnersOverlap(ipRange, ipv6Range string, owners []rangeOwner) error {
+	if err := overlapForRange(ipRange, owners, false); err != nil {
 		return err
 	}
-	if hc.Spec.IPv6Range != "" {
-		return overlapForRange(hc.Spec.IPv6Range, excludeName, list.Items, true)
+	if ipv6Range != "" {
+		return overlapForRange(ipv6Range, owners, true)
 	}
 	return nil
 }
 
-// overlapForRange reports an error if newRange overlaps the matching IP family range of
-// any existing config except excludeName. When useIPv6 is true, each existing config's
-// IPv6Range is compared; otherwise its IPRange. Empty or unparseable existing ranges are
-// skipped (they are validated on their own admission).
-func overlapForRange(newRange, excludeName string, items []HeliosConfig, useIPv6 bool) error {
-	newStart, newEnd, err := network.ParseIPRange(newRange)
+// overlapForRange reports an error if any range of the range set newRange overlaps any
+// range of the matching IP family of an owner. When useIPv6 is true, each owner's IPv6
+// ranges are compared; otherwise its IPv4 ranges. Empty or unparseable existing ranges
+// are skipped (they are validated on their own admission).
+func overlapForRange(newRange string, owners []rangeOwner, useIPv6 bool) error {
+	newRanges, err := network.ParseRangeSet(newRange)
 	if err != nil {
-		return nil // already validated by validateIPRange
+		return nil // already validated by validateRangeSet
 	}
-	for i := range items {
-		existing := &items[i]
-		if existing.Name == excludeName {
-			continue
-		}
-		exRange := existing.Spec.IPRange
+	for _, owner := range owners {
+		exRange := owner.ipRange
 		if useIPv6 {
-			exRange = existing.Spec.IPv6Range
+			exRange = owner.ipv6Range
 		}
 		if exRange == "" {
 			continue
 		}
-		existStart, existEnd, err := network.ParseIPRange(exRange)
+		existRanges, err := network.ParseRangeSet(exRange)
 		if err != nil {
 			continue
 		}
-		// Ranges overlap if newStart <= existEnd && newEnd >= existStart.
-		if network.CompareIPs(newStart, existEnd) <= 0 && network.CompareIPs(newEnd, existStart) >= 0 {
-			return fmt.Errorf("IP range %q overlaps with existing HeliosConfig %q (range: %s)",
-				newRange, existing.Name, exRange)
+		for _, n := range newRanges {
+			for _, e := range existRanges {
+				if n.Overlaps(e) {
+					return fmt.Errorf("IP range %q overlaps with existing %s %q (range: %s)",
+						formatRange(n), owner.kind, owner.name, formatRange(e))
+				}
+			}
 		}
 	}
 	return nil
 }
+
+// formatRange renders a parsed range for error messages.
+func formatRange(r network.IPRange) string {
+	if r.Start.Equal(r.End) {
+		return r.Start.String()
+	}
+	return r.Start.String() + "-" + r.End.String()
+}
@@ -2,11 +2,21 @@ package v1
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
 	"net"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/somaz94/helios-lb/internal/network"
 
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
@@ -82,6 +92,37 @@ func TestValidatePorts(t *testing.T) {
 	}
 }
 
+func TestValidateAffinity(t *testing.T) {
+	tests := []struct {
+		name     string
+		affinity *SessionAffinityConfig
+		protocol string
+		wantErr  bool
+	}{
+		{"no affinity", nil, ProtocolUDP, false},
+		{"header", &SessionAffinityConfig{Mode: AffinityModeHeader, HeaderName: "X-User"}, ProtocolTCP, false},
+		{"cookie with defaults", &SessionAffinityConfig{Mode: AffinityModeCookie}, "", false},
+		{"cookie with name and timeout", &SessionAffinityConfig{Mode: AffinityModeCookie, CookieName: "sticky", TimeoutSeconds: 600}, ProtocolTCP, false},
+		{"udp port", &SessionAffinityConfig{Mode: AffinityModeCookie}, ProtocolUDP, true},
+		{"header without name", &SessionAffinityConfig{Mode: AffinityModeHeader}, ProtocolTCP, true},
+		{"header with cookie name", &SessionAffinityConfig{Mode: AffinityModeHeader, HeaderName: "X-User", CookieName: "sticky"}, ProtocolTCP, true},
+		{"cookie with header name", &SessionAffinityConfig{Mode: AffinityModeCookie, HeaderName: "X-User"}, ProtocolTCP, true},
+		{"invalid mode", &SessionAffinityConfig{Mode: "ClientIP"}, ProtocolTCP, true},
+		{"invalid header name", &SessionAffinityConfig{Mode: AffinityModeHeader, HeaderName: "X User"}, ProtocolTCP, true},
+		{"timeout too long", &SessionAffinityConfig{Mode: AffinityModeCookie, TimeoutSeconds: 86401}, ProtocolTCP, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ports := []PortConfig{{Port: 80, Protocol: tt.protocol, Affinity: tt.affinity}}
+			err := validateAffinity(ports, ProtocolTCP)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateAffinity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestValidateWeights(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -111,6 +152,48 @@ func TestValidateWeights(t *testing.T) {
 	}
 }
 
+func TestValidateSlowStart(t *testing.T) {
+	tests := []struct {
+		name    string
+		seconds int32
+		method  string
+		wantErr bool
+	}{
+		{"unset", 0, MethodRoundRobin, false},
+		{"weighted", 30, MethodWeightedRoundRobin, false},
+		{"least connection", 3600, MethodLeastConnection, false},
+		{"random", 1, MethodRandom, false},
+		{"wrong method", 30, MethodMaglev, true},
+		{"negative", -1, MethodLeastConnection, true},
+		{"too long", 3601, MethodLeastConnection, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSlowStart(tt.seconds, tt.method)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateSlowStart() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateDrainTimeout(t *testing.T) {
+	for _, tt := range []struct {
+		seconds int32
+		wantErr bool
+	}{
+		{0, false},
+		{3600, false},
+		{-1, true},
+		{3601, true},
+	} {
+		if err := validateDrainTimeout(tt.seconds); (err != nil) != tt.wantErr {
+			t.Errorf("validateDrainTimeout(%d) error = %v, wantErr %v", tt.seconds, err, tt.wantErr)
+		}
+	}
+}
+
 func TestValidateHealthCheck(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -120,8 +203,30 @@ func TestValidateHealthCheck(t *testing.T) {
 		{"nil config", nil, false},
 		{"valid TCP", &HealthCheckConfig{Protocol: ProtocolTCP}, false},
 		{"valid HTTP", &HealthCheckConfig{Protocol: ProtocolHTTP, HTTPPath: "/health"}, false},
-		{"invalid protocol", &HealthCheckConfig{Protocol: "GRPC"}, true},
+		{"invalid protocol", &HealthCheckConfig{Protocol: "UDP"}, true},
+		{"valid GRPC", &HealthCheckConfig{Protocol: ProtocolGRPC, GRPCService: "api.v1.Orders", Scheme: SchemeHTTPS}, false},
+		{"GRPC with HTTP-only fields", &HealthCheckConfig{Protocol: ProtocolGRPC, ExpectedStatuses: []int32{200}}, true},
 		{"HTTP without path", &HealthCheckConfig{Protocol: ProtocolHTTP}, true},
+		{"valid thresholds", &HealthCheckConfig{HealthyThreshold: 1, UnhealthyThreshold: 10}, false},
+		{"healthy threshold too high", &HealthCheckConfig{HealthyThreshold: 11}, true},
+		{"unhealthy threshold negative", &HealthCheckConfig{UnhealthyThreshold: -1}, true},
+		{"valid HTTPS check", &HealthCheckConfig{
+			Protocol: ProtocolHTTP, HTTPPath: "/health", Scheme: SchemeHTTPS, CABundle: testCABundle(t),
+			Host: "api.example.com", Method: HTTPMethodPOST,
+			Headers:          []HTTPHeader{{Name: "X-Probe", Value: "helios"}},
+			ExpectedStatuses: []int32{200, 204},
+			BodyContains:     "ok", BodyRegex: `"status":\s*"up"`,
+		}, false},
+		{"invalid scheme", &HealthCheckConfig{Scheme: "FTP"}, true},
+		{"invalid method", &HealthCheckConfig{Method: "DELETE"}, true},
+		{"skip verify with CA bundle", &HealthCheckConfig{InsecureSkipVerify: true, CABundle: testCABundle(t)}, true},
+		{"CA bundle without certificates", &HealthCheckConfig{CABundle: []byte("not a certificate")}, true},
+		{"invalid header name", &HealthCheckConfig{Headers: []HTTPHeader{{Name: "X Probe"}}}, true},
+		{"Host in headers", &HealthCheckConfig{Headers: []HTTPHeader{{Name: "host", Value: "a"}}}, true},
+		{"status too low", &HealthCheckConfig{ExpectedStatuses: []int32{99}}, true},
+		{"status too high", &HealthCheckConfig{ExpectedStatuses: []int32{600}}, true},
+		{"invalid body regex", &HealthCheckConfig{BodyRegex: "("}, true},
+		{"body match on HEAD", &HealthCheckConfig{Method: HTTPMethodHEAD, BodyContains: "ok"}, true},
 	}
 
 	for _, tt := range tests {
@@ -134,6 +239,68 @@ func TestValidateHealthCheck(t *testing.T) {
 	}
 }
 
+func TestValidateOutlierDetection(t *testing.T) {
+	tests := []struct {
+		name    string
+		od      *OutlierDetectionConfig
+		wantErr bool
+	}{
+		{"nil config", nil, false},
+		{"unset fields", &OutlierDetectionConfig{Enabled: true}, false},
+		{"valid config", &OutlierDetectionConfig{
+			Enabled: true, ConsecutiveFailures: 3, BaseEjectionSeconds: 10, MaxEjectionSeconds: 600, MaxEjectionPercent: 50,
+		}, false},
+		{"consecutive failures too high", &OutlierDetectionConfig{ConsecutiveFailures: 101}, true},
+		{"base ejection too long", &OutlierDetectionConfig{BaseEjectionSeconds: 3601}, true},
+		{"max ejection negative", &OutlierDetectionConfig{MaxEjectionSeconds: -1}, true},
+		{"max below base", &OutlierDetectionConfig{BaseEjectionSeconds: 60, MaxEjectionSeconds: 30}, true},
+		{"percent too high", &OutlierDetectionConfig{MaxEjectionPercent: 101}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateOutlierDetection(tt.od)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateOutlierDetection() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateAdvertisement(t *testing.T) {
+	bgp := func(peers ...BGPPeer) *AdvertisementConfig {
+		return &AdvertisementConfig{Mode: AdvertisementModeBGP, BGP: &BGPConfig{LocalASN: 65001, Peers: peers}}
+	}
+	peer := BGPPeer{Address: testIPv4, ASN: 65000}
+	tests := []struct {
+		name    string
+		adv     *AdvertisementConfig
+		wantErr bool
+	}{
+		{"nil config", nil, false},
+		{"L2 mode", &AdvertisementConfig{Mode: AdvertisementModeL2}, false},
+		{"valid BGP", bgp(peer), false},
+		{"four-octet ASN", bgp(BGPPeer{Address: testIPv4, ASN: 4200000000}), false},
+		{"invalid mode", &AdvertisementConfig{Mode: "OSPF"}, true},
+		{"BGP without config", &AdvertisementConfig{Mode: AdvertisementModeBGP}, true},
+		{"BGP without peers", bgp(), true},
+		{"invalid peer address", bgp(BGPPeer{Address: "router-1", ASN: 65000}), true},
+		{"peer ASN too high", bgp(BGPPeer{Address: testIPv4, ASN: 1 << 32}), true},
+		{"hold time too short", bgp(BGPPeer{Address: testIPv4, ASN: 65000, HoldTimeSeconds: 2}), true},
+		{"duplicate peers", bgp(peer, peer), true},
+		{"IPv6 peer without router ID", bgp(BGPPeer{Address: testIPv6, ASN: 65000}), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAdvertisement(tt.adv)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateAdvertisement() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestCompareIPs(t *testing.T) {
 	tests := []struct {
 		name string
@@ -361,7 +528,7 @@ func TestDeepCopy(t *testing.T) {
 			IPRange:           testIPRange,
 			Method:            MethodRoundRobin,
 			Protocol:          ProtocolTCP,
-			Ports:             []PortConfig{{Port: 80, Protocol: ProtocolTCP}, {Port: 443}},
+			Ports:             []PortConfig{{Port: 80, Affinity: &SessionAffinityConfig{Mode: AffinityModeCookie}}, {Port: 443}},
 			Weights:           []WeightConfig{{ServiceName: testServiceName, Weight: 5}},
 			NamespaceSelector: []string{testNamespace, "production"},
 			MaxAllocations:    10,
@@ -372,12 +539,24 @@ func TestDeepCopy(t *testing.T) {
 				Protocol:        ProtocolHTTP,
 				HTTPPath:        "/healthz",
 			},
+			OutlierDetection: &OutlierDetectionConfig{Enabled: true, ConsecutiveFailures: 5},
+			Advertisement: &AdvertisementConfig{
+				Mode: AdvertisementModeBGP,
+				BGP: &BGPConfig{LocalASN: 65001, Peers: []BGPPeer{{
+					Address:           testIP,
+					ASN:               65000,
+					PasswordSecretRef: &corev1.SecretKeySelector{Key: "password"},
+				}}},
+			},
 		},
 		Status: HeliosConfigStatus{
 			AllocatedIPs: map[string]string{testServiceName: testIPv4, "svc2": "10.0.0.2"},
 			State:        StateActive,
 			Phase:        StateActive,
 			Message:      "OK",
+			EjectedBackends: []BackendEjection{{
+				Node: "node-a", Frontend: "10.0.0.1:80/TCP", Backend: "10.1.0.5:8080", EjectedUntil: metav1.Now(),
+			}},
 		},
 	}
 
@@ -400,6 +579,19 @@ func TestDeepCopy(t *testing.T) {
 	if len(copied.Status.AllocatedIPs) != 2 {
 		t.Errorf("DeepCopy AllocatedIPs length mismatch")
 	}
+	copied.Spec.OutlierDetection.ConsecutiveFailures = 1
+	copied.Status.EjectedBackends[0].Node = "node-b"
+	if hc.Spec.OutlierDetection.ConsecutiveFailures != 5 || hc.Status.EjectedBackends[0].Node != "node-a" {
+		t.Error("DeepCopy OutlierDetection or EjectedBackends is not independent")
+	}
+	copied.Spec.Ports[0].Affinity.CookieName = "changed"
+	if hc.Spec.Ports[0].Affinity.CookieName != "" {
+		t.Error("DeepCopy Ports affinity is not independent")
+	}
+	copied.Spec.Advertisement.BGP.Peers[0].PasswordSecretRef.Key = "changed"
+	if hc.Spec.Advertisement.BGP.Peers[0].PasswordSecretRef.Key != "password" {
+		t.Error("DeepCopy Advertisement is not independent")
+	}
 
 	// Ensure deep copy is independent
 	copied.Status.AllocatedIPs["svc3"] = "10.0.0.3"
@@ -595,6 +787,145 @@ func TestCheckIPRangeOverlap_IPv6(t *testing.T) {
 	})
 }
 
+func TestValidateRangeSet(t *testing.T) {
+	v := &HeliosConfigValidator{Client: nil}
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		spec    HeliosConfigSpec
+		wantErr string
+	}{
+		{"ipRange and ipRanges", HeliosConfigSpec{IPRange: testIPRange, IPRanges: []string{"10.0.5.0/28"}}, ""},
+		{"ipRanges only", HeliosConfigSpec{IPRanges: []string{"10.0.1.10-10.0.1.20", "10.0.5.0/28"}}, ""},
+		{"ipv6Ranges", HeliosConfigSpec{IPRange: testIPv4, IPv6Ranges: []string{"fd00::1", "fd00::10-fd00::1f"}}, ""},
+		{"no IPv4 range", HeliosConfigSpec{IPv6Range: testIPv6Range}, "ipRanges"},
+		{"invalid entry", HeliosConfigSpec{IPRanges: []string{"10.0.0.1", "bad"}}, "ipRanges[1]"},
+		{"entry overlaps ipRange", HeliosConfigSpec{IPRange: testIPRange, IPRanges: []string{"10.0.0.5"}}, "overlaps ipRange"},
+		{"entries overlap each other", HeliosConfigSpec{IPRanges: []string{"10.0.5.0/28", "10.0.5.8-10.0.5.20"}}, "overlaps ipRanges[0]"},
+		{"ipv6 entries overlap each other", HeliosConfigSpec{IPRange: testIPv4, IPv6Ranges: []string{"fd00::/120", "fd00::80"}}, "ipv6Ranges[1]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hc := &HeliosConfig{ObjectMeta: metav1.ObjectMeta{Name: testConfigName}, Spec: tt.spec}
+			_, err := v.ValidateCreate(ctx, hc)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateExclusions(t *testing.T) {
+	v := &HeliosConfigValidator{Client: nil}
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		spec        HeliosConfigSpec
+		wantErr     string
+		wantWarning string
+	}{
+		{"inside the range", HeliosConfigSpec{IPRange: "192.168.1.0/24", ExcludeIPs: []string{"192.168.1.1", "192.168.1.50-192.168.1.60"}}, "", ""},
+		{"IPv6 inside the IPv6 range", HeliosConfigSpec{IPRange: testIPv4, IPv6Range: testIPv6Range, ExcludeIPs: []string{"fd00::1"}}, "", ""},
+		{"invalid entry", HeliosConfigSpec{IPRange: testIPRange, ExcludeIPs: []string{"bad"}}, "excludeIPs[0]", ""},
+		{"outside the range", HeliosConfigSpec{IPRange: testIPRange, ExcludeIPs: []string{"10.0.0.1", "10.9.0.1"}}, "", "excludeIPs[1]"},
+		{"IPv6 on a single-stack config", HeliosConfigSpec{IPRange: testIPRange, ExcludeIPs: []string{"fd00::1"}}, "", "excludeIPs[0]"},
+		{"combined with poolRef", HeliosConfigSpec{PoolRef: "shared", ExcludeIPs: []string{"10.0.0.1"}}, "excludeIPs", ""},
+		{"reserved range inside the range", HeliosConfigSpec{IPRange: "192.168.1.0/24", ReservedRanges: []string{"192.168.1.50-192.168.1.60"}}, "", ""},
+		{"invalid reserved range", HeliosConfigSpec{IPRange: testIPRange, ReservedRanges: []string{"bad"}}, "reservedRanges[0]", ""},
+		{"reserved range outside the range", HeliosConfigSpec{IPRange: testIPRange, ReservedRanges: []string{"10.9.0.0/24"}}, "", "reservedRanges[0]"},
+		{"reserved range combined with poolRef", HeliosConfigSpec{PoolRef: "shared", ReservedRanges: []string{"10.0.0.0/28"}}, "reservedRanges", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hc := &HeliosConfig{ObjectMeta: metav1.ObjectMeta{Name: testConfigName}, Spec: tt.spec}
+			warnings, err := v.ValidateCreate(ctx, hc)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want it to mention %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if tt.wantWarning == "" {
+				if len(warnings) != 0 {
+					t.Errorf("expected no warnings, got %v", warnings)
+				}
+				return
+			}
+			if len(warnings) != 1 || !strings.Contains(warnings[0], tt.wantWarning) {
+				t.Errorf("warnings = %v, want one mentioning %q", warnings, tt.wantWarning)
+			}
+		})
+	}
+}
+
+// Reserved ranges are taken out of the range set like excluded addresses.
+func TestRangeSet_ExcludesReservedRanges(t *testing.T) {
+	spec := HeliosConfigSpec{
+		IPRange:        "192.168.1.0/24",
+		ExcludeIPs:     []string{"192.168.1.1"},
+		ReservedRanges: []string{"192.168.1.50-192.168.1.60"},
+	}
+	for _, tt := range []struct {
+		ip   string
+		want bool
+	}{
+		{"192.168.1.1", false},
+		{"192.168.1.55", false},
+		{"192.168.1.61", true},
+	} {
+		if got := network.IPAllocatable(tt.ip, spec.IPv4RangeSet()); got != tt.want {
+			t.Errorf("IPAllocatable(%q) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	pool := HeliosIPPoolSpec{IPRange: "10.2.0.0/24", ReservedRanges: []string{"10.2.0.128/25"}}
+	if network.IPAllocatable("10.2.0.200", pool.IPv4RangeSet()) {
+		t.Error("address in a pool's reserved range is allocatable")
+	}
+}
+
+func TestCheckIPRangeOverlap_RangeSets(t *testing.T) {
+	existing := &HeliosConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "existing-set", Namespace: testNamespace},
+		Spec:       HeliosConfigSpec{IPRanges: []string{"10.0.1.10-10.0.1.20", "10.0.5.0/28"}},
+	}
+	cl := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(existing).Build()
+	v := &HeliosConfigValidator{Client: cl}
+	ctx := context.Background()
+
+	t.Run("range in the gap accepted", func(t *testing.T) {
+		hc := &HeliosConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: testNewConfigName},
+			Spec:       HeliosConfigSpec{IPRange: "10.0.2.0/24"},
+		}
+		if _, err := v.ValidateCreate(ctx, hc); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	})
+
+	t.Run("overlap with a later range rejected", func(t *testing.T) {
+		hc := &HeliosConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: testNewConfigName},
+			Spec:       HeliosConfigSpec{IPRange: "10.0.3.1", IPRanges: []string{"10.0.5.4"}},
+		}
+		_, err := v.ValidateCreate(ctx, hc)
+		if err == nil || !strings.Contains(err.Error(), "existing-set") {
+			t.Errorf("error = %v, want an overlap with existing-set", err)
+		}
+	})
+}
+
 func TestDeepCopy_EmptyStatus(t *testing.T) {
 	// Test status with nil AllocatedIPs and nil Conditions
 	hc := &HeliosConfig{
@@ -607,3 +938,26 @@ func TestDeepCopy_EmptyStatus(t *testing.T) {
 		t.Error("expected nil AllocatedIPs in deep copy of empty status")
 	}
 }
+
+// testCABundle returns the PEM encoding of a self-signed CA certificate.
+func testCABundle(t *testing.T) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "helios-test-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
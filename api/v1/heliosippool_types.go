@@ -0,0 +1,92 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HeliosIPPoolSpec defines the address ranges of a HeliosIPPool.
+// +kubebuilder:validation:XValidation:rule="has(self.ipRange) || has(self.ipRanges)",message="one of ipRange and ipRanges must be set"
+type HeliosIPPoolSpec struct {
+	// IPRange defines the IPv4 address range of the pool.
+	// Supports single IP ("192.168.1.100"), range ("192.168.1.100-192.168.1.200"),
+	// and CIDR notation ("192.168.1.0/24").
+	// Required unless IPRanges is set.
+	// +optional
+	IPRange string `json:"ipRange,omitempty"`
+
+	// IPRanges lists further IPv4 ranges of the pool, for address space that
+	// is not contiguous.
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=16
+	// +optional
+	IPRanges []string `json:"ipRanges,omitempty"`
+
+	// IPv6Range defines the IPv6 address range of the pool.
+	// When set, configs that use the pool allocate dual-stack addresses.
+	// +optional
+	IPv6Range string `json:"ipv6Range,omitempty"`
+
+	// IPv6Ranges lists further IPv6 ranges of the pool.
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=16
+	// +optional
+	IPv6Ranges []string `json:"ipv6Ranges,omitempty"`
+
+	// ExcludeIPs lists addresses inside the pool's ranges that are never
+	// allocated, in any IPRange format and either family.
+	// +kubebuilder:validation:MaxItems=64
+	// +optional
+	ExcludeIPs []string `json:"excludeIPs,omitempty"`
+
+	// ReservedRanges lists blocks inside the pool's ranges that are held back
+	// for other uses and never allocated, in any IPRange format and either
+	// family.
+	// +kubebuilder:validation:MaxItems=16
+	// +optional
+	ReservedRanges []string `json:"reservedRanges,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="IPRange",type="string",JSONPath=".spec.ipRange"
+// +kubebuilder:printcolumn:name="IPv6Range",type="string",JSONPath=".spec.ipv6Range"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// HeliosIPPool is the Schema for the heliosippools API. A pool owns address
+// ranges centrally; HeliosConfigs in any namespace allocate from it through
+// spec.poolRef.
+type HeliosIPPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec HeliosIPPoolSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// HeliosIPPoolList contains a list of HeliosIPPool.
+type HeliosIPPoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HeliosIPPool `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HeliosIPPool{}, &HeliosIPPoolList{})
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var heliosippoollog = logf.Log.WithName("heliosippool-webhook")
+
+// HeliosIPPoolValidator implements admission.Validator[*HeliosIPPool].
+// +kubebuilder:object:generate=false
+type HeliosIPPoolValidator struct {
+	Client client.Reader
+}
+
+// SetupIPPoolWebhookWithManager registers the HeliosIPPool validating webhook with the manager.
+func SetupIPPoolWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr, &HeliosIPPool{}).
+		WithValidator(&HeliosIPPoolValidator{Client: mgr.GetClient()}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/validate-balancer-helios-dev-v1-heliosippool,mutating=false,failurePolicy=fail,sideEffects=None,groups=balancer.helios.dev,resources=heliosippools,verbs=create;update,versions=v1,name=vheliosippool.kb.io,admissionReviewVersions=v1
+
+var _ admission.Validator[*HeliosIPPool] = &HeliosIPPoolValidator{}
+
+// ValidateCreate validates a HeliosIPPool on creation.
+func (v *HeliosIPPoolValidator) ValidateCreate(ctx context.Context, pool *HeliosIPPool) (admission.Warnings, error) {
+	heliosippoollog.Info("validate create", "name", pool.Name)
+	return v.validatePool(ctx, pool)
+}
+
+// ValidateUpdate validates a HeliosIPPool on update.
+func (v *HeliosIPPoolValidator) ValidateUpdate(ctx context.Context, _ *HeliosIPPool, pool *HeliosIPPool) (admission.Warnings, error) {
+	heliosippoollog.Info("validate update", "name", pool.Name)
+	return v.validatePool(ctx, pool)
+}
+
+// ValidateDelete validates a HeliosIPPool on deletion.
+func (v *HeliosIPPoolValidator) ValidateDelete(_ context.Context, _ *HeliosIPPool) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validatePool validates the pool's ranges, exclusions and reserved ranges and
+// rejects overlap with other pools and with HeliosConfigs that carry inline
+// ranges.
+func (v *HeliosIPPoolValidator) validatePool(ctx context.Context, pool *HeliosIPPool) (admission.Warnings, error) {
+	if err := validateRangeSet("ipRange", pool.Spec.IPRange, "ipRanges", pool.Spec.IPRanges, true); err != nil {
+		return nil, err
+	}
+	if err := validateRangeSet("ipv6Range", pool.Spec.IPv6Range, "ipv6Ranges", pool.Spec.IPv6Ranges, false); err != nil {
+		return nil, err
+	}
+	warnings, err := validateExclusions(pool.Spec.ExcludeIPs, pool.Spec.ReservedRanges, pool.Spec.IPv4RangeSet(), pool.Spec.IPv6RangeSet())
+	if err != nil {
+		return nil, err
+	}
+	// Cross-object overlap check; skipped when no client is wired (unit tests).
+	if v.Client == nil {
+		return warnings, nil
+	}
+	owners, err := listRangeOwners(ctx, v.Client, pool.Name, "")
+	if err != nil {
+		// If we can't list, log and skip overlap check rather than blocking.
+		heliosippoollog.Error(err, "failed to list range owners for overlap check")
+		return warnings, nil
+	}
+	return warnings, checkOwnersOverlap(pool.Spec.IPv4RangeSet(), pool.Spec.IPv6RangeSet(), owners)
+}
@@ -0,0 +1,151 @@
+package v1
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const testPoolName = "shared"
+
+func TestHeliosIPPoolValidator(t *testing.T) {
+	existingPool := &HeliosIPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: testPoolName},
+		Spec:       HeliosIPPoolSpec{IPRange: testIPRange, IPv6Range: testIPv6Range},
+	}
+	inline := &HeliosConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "inline", Namespace: testNamespace},
+		Spec:       HeliosConfigSpec{IPRange: "10.1.0.1-10.1.0.10"},
+	}
+	cl := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(existingPool, inline).Build()
+	v := &HeliosIPPoolValidator{Client: cl}
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		spec    HeliosIPPoolSpec
+		wantErr string
+	}{
+		{"disjoint pool accepted", HeliosIPPoolSpec{IPRange: "10.2.0.1-10.2.0.10"}, ""},
+		{"invalid ipRange", HeliosIPPoolSpec{IPRange: "bad"}, "ipRange"},
+		{"invalid ipv6Range", HeliosIPPoolSpec{IPRange: "10.2.0.1", IPv6Range: "bad"}, "ipv6Range"},
+		{"overlaps another pool", HeliosIPPoolSpec{IPRange: "10.0.0.5-10.0.0.20"}, "HeliosIPPool"},
+		{"overlaps another pool's IPv6", HeliosIPPoolSpec{IPRange: "10.2.0.1", IPv6Range: "fd00::80"}, "HeliosIPPool"},
+		{"overlaps an inline config", HeliosIPPoolSpec{IPRange: "10.1.0.5"}, "HeliosConfig"},
+		{"ipRanges only accepted", HeliosIPPoolSpec{IPRanges: []string{"10.2.0.1-10.2.0.10", "10.3.0.0/28"}}, ""},
+		{"no IPv4 range", HeliosIPPoolSpec{IPv6Range: "fd01::1"}, "ipRanges"},
+		{"invalid ipRanges entry", HeliosIPPoolSpec{IPRanges: []string{"10.2.0.1", "bad"}}, "ipRanges[1]"},
+		{"ipRanges entry overlaps another pool", HeliosIPPoolSpec{IPRanges: []string{"10.2.0.1", "10.0.0.9"}}, "HeliosIPPool"},
+		{"ipv6Ranges entry overlaps another pool", HeliosIPPoolSpec{IPRange: "10.2.0.1", IPv6Ranges: []string{"fd01::1", "fd00::80"}}, "HeliosIPPool"},
+		{"excludeIPs inside the pool", HeliosIPPoolSpec{IPRange: "10.2.0.0/24", ExcludeIPs: []string{"10.2.0.1"}}, ""},
+		{"invalid excludeIPs entry", HeliosIPPoolSpec{IPRange: "10.2.0.0/24", ExcludeIPs: []string{"bad"}}, "excludeIPs[0]"},
+		{"reservedRanges inside the pool", HeliosIPPoolSpec{IPRange: "10.2.0.0/24", ReservedRanges: []string{"10.2.0.128/25"}}, ""},
+		{"invalid reservedRanges entry", HeliosIPPoolSpec{IPRange: "10.2.0.0/24", ReservedRanges: []string{"bad"}}, "reservedRanges[0]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &HeliosIPPool{ObjectMeta: metav1.ObjectMeta{Name: "new-pool"}, Spec: tt.spec}
+			_, err := v.ValidateCreate(ctx, pool)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("update excludes self", func(t *testing.T) {
+		updated := existingPool.DeepCopy()
+		updated.Spec.IPRange = "10.0.0.1-10.0.0.20"
+		if _, err := v.ValidateUpdate(ctx, existingPool, updated); err != nil {
+			t.Errorf("expected no error for self-update, got %v", err)
+		}
+	})
+
+	t.Run("exclusion outside the pool warns", func(t *testing.T) {
+		pool := &HeliosIPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "new-pool"},
+			Spec:       HeliosIPPoolSpec{IPRange: "10.2.0.0/24", ExcludeIPs: []string{"10.3.0.1"}},
+		}
+		warnings, err := v.ValidateCreate(ctx, pool)
+		if err != nil || len(warnings) != 1 || !strings.Contains(warnings[0], "excludeIPs[0]") {
+			t.Errorf("expected one excludeIPs warning, got warnings %v, err %v", warnings, err)
+		}
+	})
+}
+
+func TestValidateCreate_PoolRef(t *testing.T) {
+	pool := &HeliosIPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: testPoolName},
+		Spec:       HeliosIPPoolSpec{IPRange: testIPRange},
+	}
+	sibling := &HeliosConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "sibling", Namespace: "team-a"},
+		Spec:       HeliosConfigSpec{PoolRef: testPoolName},
+	}
+	cl := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(pool, sibling).Build()
+	v := &HeliosConfigValidator{Client: cl}
+	ctx := context.Background()
+
+	t.Run("configs may share a pool", func(t *testing.T) {
+		hc := &HeliosConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: testNewConfigName, Namespace: testNamespace},
+			Spec:       HeliosConfigSpec{PoolRef: testPoolName},
+		}
+		warnings, err := v.ValidateCreate(ctx, hc)
+		if err != nil || len(warnings) != 0 {
+			t.Errorf("expected clean admission, got warnings %v, err %v", warnings, err)
+		}
+	})
+
+	t.Run("missing pool warns", func(t *testing.T) {
+		hc := &HeliosConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: testNewConfigName, Namespace: testNamespace},
+			Spec:       HeliosConfigSpec{PoolRef: "missing"},
+		}
+		warnings, err := v.ValidateCreate(ctx, hc)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(warnings) != 1 {
+			t.Errorf("expected a warning for the missing pool, got %v", warnings)
+		}
+	})
+
+	t.Run("poolRef with ipRange rejected", func(t *testing.T) {
+		hc := &HeliosConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: testNewConfigName, Namespace: testNamespace},
+			Spec:       HeliosConfigSpec{PoolRef: testPoolName, IPRange: "10.2.0.1"},
+		}
+		if _, err := v.ValidateCreate(ctx, hc); err == nil {
+			t.Error("expected error for poolRef combined with ipRange")
+		}
+	})
+
+	t.Run("inline range overlapping a pool rejected", func(t *testing.T) {
+		hc := &HeliosConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: testNewConfigName, Namespace: testNamespace},
+			Spec:       HeliosConfigSpec{IPRange: testIPv4},
+		}
+		_, err := v.ValidateCreate(ctx, hc)
+		if err == nil || !strings.Contains(err.Error(), "HeliosIPPool") {
+			t.Errorf("expected overlap error naming the pool, got %v", err)
+		}
+	})
+
+	t.Run("neither ipRange nor poolRef rejected", func(t *testing.T) {
+		hc := &HeliosConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: testNewConfigName, Namespace: testNamespace},
+		}
+		if _, err := v.ValidateCreate(ctx, hc); err == nil {
+			t.Error("expected error when no address source is set")
+		}
+	})
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"slices"
+
+	"github.com/somaz94/helios-lb/internal/network"
+)
+
+// IPv4RangeSet returns the config's inline IPv4 ranges, ipRange followed by
+// ipRanges, with the IPv4 entries of excludeIPs and reservedRanges taken out,
+// as one range set expression understood by the network package. It is empty when the config references
+// a pool.
+func (s *HeliosConfigSpec) IPv4RangeSet() string {
+	return rangeSet(s.IPRange, s.IPRanges, s.ExcludeIPs, s.ReservedRanges)
+}
+
+// IPv6RangeSet returns the config's inline IPv6 ranges, with the IPv6 entries
+// of excludeIPs and reservedRanges taken out, as one range set expression. It is empty for single-stack
+// configs.
+func (s *HeliosConfigSpec) IPv6RangeSet() string {
+	return rangeSet(s.IPv6Range, s.IPv6Ranges, s.ExcludeIPs, s.ReservedRanges)
+}
+
+// IPv4RangeSet returns the pool's IPv4 ranges, with its IPv4 exclusions, as
+// one range set expression.
+func (s *HeliosIPPoolSpec) IPv4RangeSet() string {
+	return rangeSet(s.IPRange, s.IPRanges, s.ExcludeIPs, s.ReservedRanges)
+}
+
+// IPv6RangeSet returns the pool's IPv6 ranges, with its IPv6 exclusions, as
+// one range set expression. It is empty for IPv4-only pools.
+func (s *HeliosIPPoolSpec) IPv6RangeSet() string {
+	return rangeSet(s.IPv6Range, s.IPv6Ranges, s.ExcludeIPs, s.ReservedRanges)
+}
+
+// rangeSet joins the ranges of one family and applies the exclusions that
+// belong to it, from excludeIPs and reservedRanges alike.
+func rangeSet(single string, list, excludeIPs, reservedRanges []string) string {
+	exclude := append(slices.Clip(excludeIPs), reservedRanges...)
+	return network.WithExclusions(network.JoinRangeSet(append([]string{single}, list...)...), exclude)
+}
@@ -20,7 +20,6 @@ import (
 	"crypto/tls"
 	"flag"
 	"os"
-	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -38,7 +37,6 @@ import (
 
 	balancerv1 "github.com/somaz94/helios-lb/api/v1"
 	"github.com/somaz94/helios-lb/internal/controller"
-	"github.com/somaz94/helios-lb/internal/loadbalancer"
 	"github.com/somaz94/helios-lb/internal/metrics"
 	"github.com/somaz94/helios-lb/internal/network"
 	// +kubebuilder:scaffold:imports
@@ -152,13 +150,12 @@ func main() {
 	// Initialize NetworkManager
 	networkMgr := network.NewNetworkManager()
 
-	// Initialize load balancer
-	lb := loadbalancer.NewLoadBalancer(loadbalancer.BalancerConfig{
-		Type:           loadbalancer.RoundRobin,
-		HealthCheck:    true,
-		CheckInterval:  time.Second * 5,
-		MetricsEnabled: true,
-	})
+	// Rebuild the allocator from cluster state before any reconcile allocates.
+	restorer := controller.NewAllocationRestorer(mgr.GetClient(), networkMgr, mgr.Elected())
+	if err := mgr.Add(restorer); err != nil {
+		setupLog.Error(err, "unable to add allocation restorer")
+		os.Exit(1)
+	}
 
 	// Initialize IP manager
 	ipMgr := &controller.IPManager{
@@ -172,9 +169,9 @@ func main() {
 		Client:     mgr.GetClient(),
 		Scheme:     mgr.GetScheme(),
 		NetworkMgr: networkMgr,
-		Balancer:   lb,
 		Metrics:    metricsRecorder,
 		IPMgr:      ipMgr,
+		Restorer:   restorer,
 		// SA1019: GetEventRecorder returns the events.k8s.io/v1 recorder, whose
 		// Eventf signature differs. Migrating the event surface is tracked separately.
 		//nolint:staticcheck
@@ -188,6 +185,10 @@ func main() {
 			setupLog.Error(err, "unable to create webhook", "webhook", "HeliosConfig")
 			os.Exit(1)
 		}
+		if err = balancerv1.SetupIPPoolWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "HeliosIPPool")
+			os.Exit(1)
+		}
 	}
 	// +kubebuilder:scaffold:builder
 
@@ -200,6 +201,10 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if err := mgr.AddReadyzCheck("allocation-state", restorer.ReadyzCheck); err != nil {
+		setupLog.Error(err, "unable to set up allocation state check")
+		os.Exit(1)
+	}
 
 	setupLog.Info("starting manager",
 		"version", version,
@@ -0,0 +1,203 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"os"
+
+	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
+	// to ensure that exec-entrypoint and run can make use of them.
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+
+	balancerv1 "github.com/somaz94/helios-lb/api/v1"
+	"github.com/somaz94/helios-lb/internal/bgp"
+	"github.com/somaz94/helios-lb/internal/metrics"
+	"github.com/somaz94/helios-lb/internal/proxy"
+	"github.com/somaz94/helios-lb/internal/speaker"
+)
+
+// Build-time variables injected via ldflags
+var (
+	version   = "dev"
+	gitCommit = "unknown"
+	buildDate = "unknown"
+)
+
+var (
+	scheme   = runtime.NewScheme()
+	setupLog = ctrl.Log.WithName("setup")
+)
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(balancerv1.AddToScheme(scheme))
+}
+
+func main() {
+	var metricsAddr string
+	var probeAddr string
+	var nodeName string
+	var namespace string
+	var ifaceName string
+	var enableProxy bool
+
+	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
+		"Use :8080 for HTTP, or leave as 0 to disable the metrics service.")
+	flag.StringVar(&probeAddr, "health-probe-bind-address", ":9083", "The address the probe endpoint binds to.")
+	flag.StringVar(&nodeName, "node-name", os.Getenv("NODE_NAME"),
+		"Name of the node this speaker runs on. Defaults to the NODE_NAME environment variable.")
+	flag.StringVar(&namespace, "namespace", os.Getenv("POD_NAMESPACE"),
+		"Namespace of the leases the speakers hold to take part in the election. "+
+			"Defaults to the POD_NAMESPACE environment variable.")
+	flag.StringVar(&ifaceName, "interface", "",
+		"Network interface to announce addresses on. Required.")
+	flag.BoolVar(&enableProxy, "enable-proxy", false,
+		"Serve allocated addresses with the built-in userspace TCP/UDP proxy, which forwards to the "+
+			"service endpoints using each HeliosConfig's method. Leave disabled when kube-proxy handles them.")
+	opts := zap.Options{
+		Development: true,
+	}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+
+	if nodeName == "" {
+		setupLog.Error(nil, "node name is required; set --node-name or NODE_NAME")
+		os.Exit(1)
+	}
+	if namespace == "" {
+		setupLog.Error(nil, "namespace is required; set --namespace or POD_NAMESPACE")
+		os.Exit(1)
+	}
+	if ifaceName == "" {
+		setupLog.Error(nil, "--interface is required")
+		os.Exit(1)
+	}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+		Scheme:                 scheme,
+		Metrics:                metricsserver.Options{BindAddress: metricsAddr},
+		HealthProbeBindAddress: probeAddr,
+		// Only the speaker leases are read; leave the rest of the cluster's
+		// leases uncached.
+		Cache: cache.Options{ByObject: map[client.Object]cache.ByObject{
+			&coordinationv1.Lease{}: {Namespaces: map[string]cache.Config{namespace: {}}},
+		}},
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to start manager")
+		os.Exit(1)
+	}
+
+	conn, err := speaker.ListenPacket(ifaceName)
+	if err != nil {
+		setupLog.Error(err, "unable to open packet socket", "interface", ifaceName)
+		os.Exit(1)
+	}
+	announcer := speaker.NewAnnouncer(conn, ctrl.Log.WithName("announcer").WithValues("interface", ifaceName))
+	if err := mgr.Add(announcer); err != nil {
+		setupLog.Error(err, "unable to add announcer")
+		os.Exit(1)
+	}
+
+	routes := bgp.NewManager(ctrl.Log.WithName("bgp").WithValues("node", nodeName))
+	if err := mgr.Add(routes); err != nil {
+		setupLog.Error(err, "unable to add BGP manager")
+		os.Exit(1)
+	}
+
+	heartbeat := &speaker.Heartbeat{
+		Client:    mgr.GetClient(),
+		Reader:    mgr.GetAPIReader(),
+		Namespace: namespace,
+		NodeName:  nodeName,
+		Logger:    ctrl.Log.WithName("heartbeat").WithValues("node", nodeName),
+	}
+	if err := mgr.Add(heartbeat); err != nil {
+		setupLog.Error(err, "unable to add heartbeat")
+		os.Exit(1)
+	}
+
+	if err = (&speaker.Speaker{
+		Client:       mgr.GetClient(),
+		NodeName:     nodeName,
+		Namespace:    namespace,
+		Announcer:    announcer,
+		Routes:       routes,
+		SecretReader: mgr.GetAPIReader(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Speaker")
+		os.Exit(1)
+	}
+
+	if enableProxy {
+		dataPlane := proxy.New(ctrl.Log.WithName("proxy").WithValues("node", nodeName), metrics.NewMetricsRecorder())
+		if err := mgr.Add(dataPlane); err != nil {
+			setupLog.Error(err, "unable to add proxy")
+			os.Exit(1)
+		}
+		syncer := &speaker.ProxySyncer{
+			Client:   mgr.GetClient(),
+			Proxy:    dataPlane,
+			NodeName: nodeName,
+			// SA1019: see cmd/main.go; the controller uses the same recorder.
+			//nolint:staticcheck
+			Recorder: mgr.GetEventRecorderFor("helios-lb-speaker"),
+		}
+		dataPlane.OnHealthChange(syncer.HealthChanged)
+		dataPlane.OnOutlierChange(syncer.OutlierChanged)
+		if err = syncer.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Proxy")
+			os.Exit(1)
+		}
+	}
+
+	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+
+	setupLog.Info("starting speaker",
+		"version", version,
+		"commit", gitCommit,
+		"buildDate", buildDate,
+		"node", nodeName,
+		"interface", ifaceName,
+		"proxy", enableProxy,
+	)
+	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+		setupLog.Error(err, "problem running speaker")
+		os.Exit(1)
+	}
+}
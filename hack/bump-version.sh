@@ -126,6 +126,11 @@ update_file "${ROOT_DIR}/config/manager/kustomization.yaml" \
     "newTag: ${NEW_VERSION}" \
     "kustomization.yaml (newTag)"
 
+update_file "${ROOT_DIR}/config/speaker/kustomization.yaml" \
+    "newTag: ${CURRENT_VERSION}" \
+    "newTag: ${NEW_VERSION}" \
+    "speaker kustomization.yaml (newTag)"
+
 echo ""
 echo "==> Updating documentation files..."
 update_file "${ROOT_DIR}/README.md" \
@@ -233,7 +233,7 @@ else
   log_fail "Basic: HeliosConfig not Active"
 fi
 
-ALLOCATED=$(kubectl get heliosconfig test-basic -n default -o jsonpath='{.status.allocatedIPs}' 2>/dev/null || echo "{}")
+ALLOCATED=$(kubectl get heliosconfig test-basic -n default -o jsonpath='{.status.allocations[*].ipv4}' 2>/dev/null || echo "{}")
 if [ "$ALLOCATED" != "{}" ] && [ -n "$ALLOCATED" ]; then
   log_pass "Basic: IP allocated"
 else
@@ -315,7 +315,7 @@ else
   log_fail "Dual-Stack: HeliosConfig not Active"
 fi
 
-ALLOCATED_V6=$(kubectl get heliosconfig test-dualstack -n default -o jsonpath='{.status.allocatedIPv6s}' 2>/dev/null || echo "{}")
+ALLOCATED_V6=$(kubectl get heliosconfig test-dualstack -n default -o jsonpath='{.status.allocations[*].ipv6}' 2>/dev/null || echo "{}")
 if [ "$ALLOCATED_V6" != "{}" ] && [ -n "$ALLOCATED_V6" ]; then
   log_pass "Dual-Stack: IPv6 allocated"
 else
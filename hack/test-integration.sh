@@ -281,7 +281,7 @@ else
 fi
 
 # Check allocated IPs
-ALLOCATED=$(kubectl get heliosconfig test-basic -n default -o jsonpath='{.status.allocatedIPs}' 2>/dev/null || echo "{}")
+ALLOCATED=$(kubectl get heliosconfig test-basic -n default -o jsonpath='{.status.allocations[*].ipv4}' 2>/dev/null || echo "{}")
 if [ "$ALLOCATED" != "{}" ] && [ -n "$ALLOCATED" ]; then
   log_pass "Basic: IP allocated (${ALLOCATED})"
 else
@@ -396,7 +396,7 @@ else
 fi
 
 # Check multiple IPs allocated
-ALLOCATED=$(kubectl get heliosconfig test-range -n default -o jsonpath='{.status.allocatedIPs}' 2>/dev/null || echo "{}")
+ALLOCATED=$(kubectl get heliosconfig test-range -n default -o jsonpath='{.status.allocations[*].name}' 2>/dev/null || echo "{}")
 log_info "Range: Allocated IPs: ${ALLOCATED}"
 if echo "$ALLOCATED" | grep -q "test-svc"; then
   log_pass "Range: Multiple services received IPs"
@@ -456,8 +456,8 @@ else
 fi
 
 # Check both IPv4 and IPv6 allocated
-ALLOCATED_V4=$(kubectl get heliosconfig test-dualstack -n default -o jsonpath='{.status.allocatedIPs}' 2>/dev/null || echo "{}")
-ALLOCATED_V6=$(kubectl get heliosconfig test-dualstack -n default -o jsonpath='{.status.allocatedIPv6s}' 2>/dev/null || echo "{}")
+ALLOCATED_V4=$(kubectl get heliosconfig test-dualstack -n default -o jsonpath='{.status.allocations[*].ipv4}' 2>/dev/null || echo "{}")
+ALLOCATED_V6=$(kubectl get heliosconfig test-dualstack -n default -o jsonpath='{.status.allocations[*].ipv6}' 2>/dev/null || echo "{}")
 if [ "$ALLOCATED_V4" != "{}" ] && [ -n "$ALLOCATED_V4" ]; then
   log_pass "Dual-Stack: IPv4 allocated (${ALLOCATED_V4})"
 else
@@ -0,0 +1,91 @@
+package bgp
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+
+	"github.com/go-logr/logr"
+)
+
+// Peer is a session and the routes to advertise over it.
+type Peer struct {
+	Config SessionConfig
+	Routes []netip.Addr
+}
+
+// Manager owns the BGP sessions of one speaker. Sync converges the running
+// sessions on the desired peers; sessions whose settings did not change are
+// kept up and only get their routes replaced.
+type Manager struct {
+	logger logr.Logger
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewManager creates a manager with no sessions.
+func NewManager(logger logr.Logger) *Manager {
+	return &Manager{logger: logger, sessions: make(map[string]*Session)}
+}
+
+// Sync starts, updates and stops sessions so exactly the given peers are
+// running. Peers with identical settings are merged into one session that
+// advertises the union of their routes.
+func (m *Manager) Sync(peers []Peer) {
+	desired := make(map[string]Peer, len(peers))
+	for _, p := range peers {
+		key := p.Config.key()
+		if prev, ok := desired[key]; ok {
+			p.Routes = append(append([]netip.Addr(nil), prev.Routes...), p.Routes...)
+		}
+		desired[key] = p
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, s := range m.sessions {
+		if _, ok := desired[key]; !ok {
+			s.Stop()
+			delete(m.sessions, key)
+		}
+	}
+	for key, p := range desired {
+		s, ok := m.sessions[key]
+		if !ok {
+			s = NewSession(p.Config, m.logger)
+			m.sessions[key] = s
+			s.SetRoutes(p.Routes)
+			s.Start()
+			continue
+		}
+		s.SetRoutes(p.Routes)
+	}
+}
+
+// Established returns the number of sessions that are currently up.
+func (m *Manager) Established() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, s := range m.sessions {
+		if s.Established() {
+			n++
+		}
+	}
+	return n
+}
+
+// Start blocks until ctx is done and then shuts every session down, so peers
+// withdraw our routes as soon as the speaker exits.
+func (m *Manager) Start(ctx context.Context) error {
+	<-ctx.Done()
+	m.Sync(nil)
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable; every node
+// runs its own sessions.
+func (m *Manager) NeedLeaderElection() bool {
+	return false
+}
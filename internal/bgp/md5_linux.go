@@ -0,0 +1,36 @@
+//go:build linux
+
+package bgp
+
+import (
+	"net/netip"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// md5SigControl returns a dialer control function that installs the TCP MD5
+// signature key for peer on the socket before it connects.
+func md5SigControl(peer netip.Addr, password string) func(network, address string, c syscall.RawConn) error {
+	return func(_, _ string, c syscall.RawConn) error {
+		sig := unix.TCPMD5Sig{Keylen: uint16(len(password))}
+		copy(sig.Key[:], password)
+		// Addr is a sockaddr_in or sockaddr_in6; the port is left zero.
+		if peer.Is4() {
+			sig.Addr.Family = unix.AF_INET
+			a := peer.As4()
+			copy(sig.Addr.Data[2:], a[:])
+		} else {
+			sig.Addr.Family = unix.AF_INET6
+			a := peer.As16()
+			copy(sig.Addr.Data[6:], a[:])
+		}
+		var sockErr error
+		if err := c.Control(func(fd uintptr) {
+			sockErr = unix.SetsockoptTCPMD5Sig(int(fd), unix.IPPROTO_TCP, unix.TCP_MD5SIG, &sig)
+		}); err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package bgp
+
+import (
+	"errors"
+	"net/netip"
+	"syscall"
+)
+
+// md5SigControl fails the dial: TCP MD5 signatures are only supported on Linux.
+func md5SigControl(netip.Addr, string) func(network, address string, c syscall.RawConn) error {
+	return func(string, string, syscall.RawConn) error {
+		return errors.New("TCP MD5 signatures are only supported on linux")
+	}
+}
@@ -0,0 +1,437 @@
+package bgp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+)
+
+// BGP-4 wire constants (RFC 4271, RFC 4760, RFC 6793).
+const (
+	msgOpen         = 1
+	msgUpdate       = 2
+	msgNotification = 3
+	msgKeepalive    = 4
+
+	headerLen     = 19
+	maxMessageLen = 4096
+	bgpVersion    = 4
+
+	// asTrans stands in for a four-octet ASN in the two-octet OPEN field.
+	asTrans = 23456
+
+	afiIPv4     = 1
+	afiIPv6     = 2
+	safiUnicast = 1
+
+	optParamCapabilities = 2
+	capMultiprotocol     = 1
+	capFourOctetAS       = 65
+
+	attrOrigin    = 1
+	attrASPath    = 2
+	attrNextHop   = 3
+	attrLocalPref = 5
+	attrMPReach   = 14
+	attrMPUnreach = 15
+
+	attrFlagOptional   = 0x80
+	attrFlagTransitive = 0x40
+	attrFlagExtended   = 0x10
+
+	originIGP        = 0
+	asPathSequence   = 2
+	defaultLocalPref = 100
+
+	// Notification error codes and the subcodes we send.
+	errOpenMessage      = 2
+	errHoldTimerExpired = 4
+	errCease            = 6
+	subBadPeerAS        = 2
+	subAdminShutdown    = 2
+)
+
+// errMessageTooLarge is returned for a message that exceeds maxMessageLen.
+var errMessageTooLarge = errors.New("bgp message exceeds 4096 bytes")
+
+// openMessage is the content of an OPEN message we care about.
+type openMessage struct {
+	ASN         uint32
+	HoldTime    uint16
+	RouterID    netip.Addr
+	FourOctetAS bool
+	IPv6Unicast bool
+}
+
+// updateMessage carries host routes being advertised or withdrawn. Next hop
+// applies to Advertised of the matching family.
+type updateMessage struct {
+	Advertised []netip.Addr
+	Withdrawn  []netip.Addr
+	NextHop    netip.Addr
+	ASPath     []uint32
+	LocalPref  uint32
+}
+
+// marshalMessage prepends the 19-byte header to body.
+func marshalMessage(typ uint8, body []byte) []byte {
+	msg := make([]byte, headerLen+len(body))
+	for i := 0; i < 16; i++ {
+		msg[i] = 0xff
+	}
+	binary.BigEndian.PutUint16(msg[16:18], uint16(len(msg)))
+	msg[18] = typ
+	copy(msg[headerLen:], body)
+	return msg
+}
+
+// readMessage reads one message and returns its type and body.
+func readMessage(r io.Reader) (uint8, []byte, error) {
+	var hdr [headerLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	for i := 0; i < 16; i++ {
+		if hdr[i] != 0xff {
+			return 0, nil, errors.New("bgp message marker is not all ones")
+		}
+	}
+	length := int(binary.BigEndian.Uint16(hdr[16:18]))
+	if length < headerLen {
+		return 0, nil, fmt.Errorf("bgp message length %d is shorter than the header", length)
+	}
+	if length > maxMessageLen {
+		return 0, nil, errMessageTooLarge
+	}
+	body := make([]byte, length-headerLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return hdr[18], body, nil
+}
+
+func marshalKeepalive() []byte {
+	return marshalMessage(msgKeepalive, nil)
+}
+
+func marshalNotification(code, subcode uint8) []byte {
+	return marshalMessage(msgNotification, []byte{code, subcode})
+}
+
+// marshalOpen encodes o. The multiprotocol and four-octet AS capabilities are
+// always offered; IPv6 unicast is offered when o.IPv6Unicast is set.
+func marshalOpen(o openMessage) []byte {
+	var caps []byte
+	addCap := func(code uint8, value []byte) {
+		caps = append(caps, code, uint8(len(value)))
+		caps = append(caps, value...)
+	}
+	addCap(capMultiprotocol, []byte{0, afiIPv4, 0, safiUnicast})
+	if o.IPv6Unicast {
+		addCap(capMultiprotocol, []byte{0, afiIPv6, 0, safiUnicast})
+	}
+	asn := make([]byte, 4)
+	binary.BigEndian.PutUint32(asn, o.ASN)
+	addCap(capFourOctetAS, asn)
+
+	myAS := uint16(asTrans)
+	if o.ASN <= 0xffff {
+		myAS = uint16(o.ASN)
+	}
+	body := make([]byte, 10, 10+2+len(caps))
+	body[0] = bgpVersion
+	binary.BigEndian.PutUint16(body[1:3], myAS)
+	binary.BigEndian.PutUint16(body[3:5], o.HoldTime)
+	routerID := o.RouterID.As4()
+	copy(body[5:9], routerID[:])
+	body[9] = uint8(2 + len(caps))
+	body = append(body, optParamCapabilities, uint8(len(caps)))
+	body = append(body, caps...)
+	return marshalMessage(msgOpen, body)
+}
+
+// parseOpen decodes an OPEN body.
+func parseOpen(body []byte) (openMessage, error) {
+	if len(body) < 10 {
+		return openMessage{}, errors.New("short OPEN message")
+	}
+	if body[0] != bgpVersion {
+		return openMessage{}, fmt.Errorf("unsupported BGP version %d", body[0])
+	}
+	o := openMessage{
+		ASN:      uint32(binary.BigEndian.Uint16(body[1:3])),
+		HoldTime: binary.BigEndian.Uint16(body[3:5]),
+		RouterID: netip.AddrFrom4([4]byte(body[5:9])),
+	}
+	params := body[10:]
+	if int(body[9]) != len(params) {
+		return openMessage{}, errors.New("OPEN optional parameter length mismatch")
+	}
+	for len(params) >= 2 {
+		typ, plen := params[0], int(params[1])
+		if 2+plen > len(params) {
+			return openMessage{}, errors.New("truncated OPEN optional parameter")
+		}
+		if typ == optParamCapabilities {
+			if err := o.parseCapabilities(params[2 : 2+plen]); err != nil {
+				return openMessage{}, err
+			}
+		}
+		params = params[2+plen:]
+	}
+	return o, nil
+}
+
+func (o *openMessage) parseCapabilities(caps []byte) error {
+	for len(caps) >= 2 {
+		code, clen := caps[0], int(caps[1])
+		if 2+clen > len(caps) {
+			return errors.New("truncated OPEN capability")
+		}
+		value := caps[2 : 2+clen]
+		switch {
+		case code == capFourOctetAS && clen == 4:
+			o.FourOctetAS = true
+			o.ASN = binary.BigEndian.Uint32(value)
+		case code == capMultiprotocol && clen == 4:
+			if binary.BigEndian.Uint16(value[0:2]) == afiIPv6 && value[3] == safiUnicast {
+				o.IPv6Unicast = true
+			}
+		}
+		caps = caps[2+clen:]
+	}
+	return nil
+}
+
+// marshalPrefix encodes a host route in NLRI form (length byte plus address).
+func marshalPrefix(addr netip.Addr) []byte {
+	raw := addr.AsSlice()
+	return append([]byte{uint8(len(raw) * 8)}, raw...)
+}
+
+// parsePrefixes decodes host routes from NLRI; shorter prefixes are rejected
+// because we only ever exchange /32 and /128 routes.
+func parsePrefixes(b []byte, afi uint16) ([]netip.Addr, error) {
+	var out []netip.Addr
+	for len(b) > 0 {
+		bits := int(b[0])
+		n := (bits + 7) / 8
+		if 1+n > len(b) {
+			return nil, errors.New("truncated NLRI")
+		}
+		raw := b[1 : 1+n]
+		switch {
+		case afi == afiIPv4 && bits == 32:
+			out = append(out, netip.AddrFrom4([4]byte(raw)))
+		case afi == afiIPv6 && bits == 128:
+			out = append(out, netip.AddrFrom16([16]byte(raw)))
+		default:
+			return nil, fmt.Errorf("unsupported prefix length /%d", bits)
+		}
+		b = b[1+n:]
+	}
+	return out, nil
+}
+
+func appendAttr(dst []byte, flags, code uint8, value []byte) []byte {
+	if len(value) > 0xff {
+		dst = append(dst, flags|attrFlagExtended, code, 0, 0)
+		binary.BigEndian.PutUint16(dst[len(dst)-2:], uint16(len(value)))
+	} else {
+		dst = append(dst, flags, code, uint8(len(value)))
+	}
+	return append(dst, value...)
+}
+
+// marshalASPath encodes path as a single AS_SEQUENCE. fourOctet selects the
+// ASN width negotiated for the session.
+func marshalASPath(path []uint32, fourOctet bool) []byte {
+	if len(path) == 0 {
+		return nil
+	}
+	out := []byte{asPathSequence, uint8(len(path))}
+	for _, asn := range path {
+		if fourOctet {
+			out = binary.BigEndian.AppendUint32(out, asn)
+			continue
+		}
+		if asn > 0xffff {
+			asn = asTrans
+		}
+		out = binary.BigEndian.AppendUint16(out, uint16(asn))
+	}
+	return out
+}
+
+// marshalUpdate encodes u for one address family, taken from the routes it
+// carries. IPv4 uses the classic NLRI fields; IPv6 uses MP_REACH_NLRI and
+// MP_UNREACH_NLRI. A withdraw-only update carries no path attributes.
+func marshalUpdate(u updateMessage, fourOctet bool) []byte {
+	ipv6 := routesAreIPv6(u)
+
+	var withdrawn, nlri, attrs []byte
+	if len(u.Advertised) > 0 {
+		attrs = appendAttr(attrs, attrFlagTransitive, attrOrigin, []byte{originIGP})
+		attrs = appendAttr(attrs, attrFlagTransitive, attrASPath, marshalASPath(u.ASPath, fourOctet))
+		if !ipv6 {
+			nh := u.NextHop.As4()
+			attrs = appendAttr(attrs, attrFlagTransitive, attrNextHop, nh[:])
+		}
+		if u.LocalPref != 0 {
+			attrs = appendAttr(attrs, attrFlagTransitive, attrLocalPref, binary.BigEndian.AppendUint32(nil, u.LocalPref))
+		}
+	}
+
+	for _, addr := range u.Withdrawn {
+		withdrawn = append(withdrawn, marshalPrefix(addr)...)
+	}
+	for _, addr := range u.Advertised {
+		nlri = append(nlri, marshalPrefix(addr)...)
+	}
+
+	if ipv6 {
+		if len(nlri) > 0 {
+			nh := u.NextHop.As16()
+			reach := []byte{0, afiIPv6, safiUnicast, 16}
+			reach = append(reach, nh[:]...)
+			reach = append(reach, 0) // reserved
+			reach = append(reach, nlri...)
+			attrs = appendAttr(attrs, attrFlagOptional, attrMPReach, reach)
+		}
+		if len(withdrawn) > 0 {
+			unreach := append([]byte{0, afiIPv6, safiUnicast}, withdrawn...)
+			attrs = appendAttr(attrs, attrFlagOptional, attrMPUnreach, unreach)
+		}
+		withdrawn, nlri = nil, nil
+	}
+
+	body := binary.BigEndian.AppendUint16(nil, uint16(len(withdrawn)))
+	body = append(body, withdrawn...)
+	body = binary.BigEndian.AppendUint16(body, uint16(len(attrs)))
+	body = append(body, attrs...)
+	body = append(body, nlri...)
+	return marshalMessage(msgUpdate, body)
+}
+
+func routesAreIPv6(u updateMessage) bool {
+	if len(u.Advertised) > 0 {
+		return u.Advertised[0].Is6()
+	}
+	return len(u.Withdrawn) > 0 && u.Withdrawn[0].Is6()
+}
+
+// parseUpdate decodes an UPDATE body, merging both address families.
+func parseUpdate(body []byte, fourOctet bool) (updateMessage, error) {
+	var u updateMessage
+	if len(body) < 4 {
+		return u, errors.New("short UPDATE message")
+	}
+	wlen := int(binary.BigEndian.Uint16(body[0:2]))
+	if 2+wlen+2 > len(body) {
+		return u, errors.New("truncated withdrawn routes")
+	}
+	withdrawn, err := parsePrefixes(body[2:2+wlen], afiIPv4)
+	if err != nil {
+		return u, err
+	}
+	u.Withdrawn = withdrawn
+	rest := body[2+wlen:]
+	alen := int(binary.BigEndian.Uint16(rest[0:2]))
+	if 2+alen > len(rest) {
+		return u, errors.New("truncated path attributes")
+	}
+	if err := u.parseAttrs(rest[2:2+alen], fourOctet); err != nil {
+		return u, err
+	}
+	advertised, err := parsePrefixes(rest[2+alen:], afiIPv4)
+	if err != nil {
+		return u, err
+	}
+	u.Advertised = append(u.Advertised, advertised...)
+	return u, nil
+}
+
+func (u *updateMessage) parseAttrs(attrs []byte, fourOctet bool) error {
+	for len(attrs) >= 3 {
+		flags, code := attrs[0], attrs[1]
+		hdr, vlen := 3, int(attrs[2])
+		if flags&attrFlagExtended != 0 {
+			if len(attrs) < 4 {
+				return errors.New("truncated path attribute")
+			}
+			hdr, vlen = 4, int(binary.BigEndian.Uint16(attrs[2:4]))
+		}
+		if hdr+vlen > len(attrs) {
+			return errors.New("truncated path attribute")
+		}
+		value := attrs[hdr : hdr+vlen]
+		switch code {
+		case attrNextHop:
+			if vlen == 4 {
+				u.NextHop = netip.AddrFrom4([4]byte(value))
+			}
+		case attrLocalPref:
+			if vlen == 4 {
+				u.LocalPref = binary.BigEndian.Uint32(value)
+			}
+		case attrASPath:
+			u.ASPath = parseASPath(value, fourOctet)
+		case attrMPReach:
+			if vlen < 5 || binary.BigEndian.Uint16(value[0:2]) != afiIPv6 {
+				break
+			}
+			nhLen := int(value[3])
+			if 4+nhLen+1 > vlen || nhLen < 16 {
+				return errors.New("malformed MP_REACH_NLRI")
+			}
+			u.NextHop = netip.AddrFrom16([16]byte(value[4:20]))
+			routes, err := parsePrefixes(value[4+nhLen+1:], afiIPv6)
+			if err != nil {
+				return err
+			}
+			u.Advertised = append(u.Advertised, routes...)
+		case attrMPUnreach:
+			if vlen < 3 || binary.BigEndian.Uint16(value[0:2]) != afiIPv6 {
+				break
+			}
+			routes, err := parsePrefixes(value[3:], afiIPv6)
+			if err != nil {
+				return err
+			}
+			u.Withdrawn = append(u.Withdrawn, routes...)
+		}
+		attrs = attrs[hdr+vlen:]
+	}
+	return nil
+}
+
+func parseASPath(b []byte, fourOctet bool) []uint32 {
+	width := 2
+	if fourOctet {
+		width = 4
+	}
+	var path []uint32
+	for len(b) >= 2 {
+		count := int(b[1])
+		b = b[2:]
+		for i := 0; i < count && len(b) >= width; i++ {
+			if fourOctet {
+				path = append(path, binary.BigEndian.Uint32(b))
+			} else {
+				path = append(path, uint32(binary.BigEndian.Uint16(b)))
+			}
+			b = b[width:]
+		}
+	}
+	return path
+}
+
+// describeNotification renders a NOTIFICATION body for logs and errors.
+func describeNotification(body []byte) string {
+	if len(body) < 2 {
+		return "malformed notification"
+	}
+	return fmt.Sprintf("notification code %d subcode %d", body[0], body[1])
+}
@@ -0,0 +1,112 @@
+package bgp
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+)
+
+func TestOpen_RoundTrip(t *testing.T) {
+	in := openMessage{
+		ASN:         4200000001,
+		HoldTime:    90,
+		RouterID:    netip.MustParseAddr("10.0.0.1"),
+		FourOctetAS: true,
+		IPv6Unicast: true,
+	}
+	typ, body, err := readMessage(bytes.NewReader(marshalOpen(in)))
+	if err != nil || typ != msgOpen {
+		t.Fatalf("readMessage() = %d, %v", typ, err)
+	}
+	out, err := parseOpen(body)
+	if err != nil {
+		t.Fatalf("parseOpen() error = %v", err)
+	}
+	if out != in {
+		t.Errorf("parseOpen() = %+v, want %+v", out, in)
+	}
+}
+
+func TestUpdate_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		in        updateMessage
+		fourOctet bool
+	}{
+		{
+			name: "ipv4 ebgp",
+			in: updateMessage{
+				Advertised: []netip.Addr{netip.MustParseAddr("192.0.2.10"), netip.MustParseAddr("192.0.2.11")},
+				NextHop:    netip.MustParseAddr("10.0.0.1"),
+				ASPath:     []uint32{65001},
+			},
+			fourOctet: true,
+		},
+		{
+			name: "ipv4 ibgp two-octet",
+			in: updateMessage{
+				Advertised: []netip.Addr{netip.MustParseAddr("192.0.2.10")},
+				NextHop:    netip.MustParseAddr("10.0.0.1"),
+				LocalPref:  defaultLocalPref,
+			},
+		},
+		{
+			name: "ipv4 withdraw",
+			in:   updateMessage{Withdrawn: []netip.Addr{netip.MustParseAddr("192.0.2.10")}},
+		},
+		{
+			name: "ipv6 reach",
+			in: updateMessage{
+				Advertised: []netip.Addr{netip.MustParseAddr("fd00::10")},
+				NextHop:    netip.MustParseAddr("fd00::1"),
+				ASPath:     []uint32{65001},
+			},
+			fourOctet: true,
+		},
+		{
+			name: "ipv6 withdraw",
+			in:   updateMessage{Withdrawn: []netip.Addr{netip.MustParseAddr("fd00::10")}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			typ, body, err := readMessage(bytes.NewReader(marshalUpdate(tt.in, tt.fourOctet)))
+			if err != nil || typ != msgUpdate {
+				t.Fatalf("readMessage() = %d, %v", typ, err)
+			}
+			out, err := parseUpdate(body, tt.fourOctet)
+			if err != nil {
+				t.Fatalf("parseUpdate() error = %v", err)
+			}
+			if !equalAddrs(out.Advertised, tt.in.Advertised) || !equalAddrs(out.Withdrawn, tt.in.Withdrawn) {
+				t.Errorf("routes = +%v -%v, want +%v -%v", out.Advertised, out.Withdrawn, tt.in.Advertised, tt.in.Withdrawn)
+			}
+			if len(tt.in.Advertised) > 0 && out.NextHop != tt.in.NextHop {
+				t.Errorf("next hop = %v, want %v", out.NextHop, tt.in.NextHop)
+			}
+			if len(out.ASPath) != len(tt.in.ASPath) || out.LocalPref != tt.in.LocalPref {
+				t.Errorf("attrs = %v/%d, want %v/%d", out.ASPath, out.LocalPref, tt.in.ASPath, tt.in.LocalPref)
+			}
+		})
+	}
+}
+
+func TestReadMessage_RejectsBadMarker(t *testing.T) {
+	msg := marshalKeepalive()
+	msg[0] = 0
+	if _, _, err := readMessage(bytes.NewReader(msg)); err == nil {
+		t.Error("readMessage() accepted a header without the all-ones marker")
+	}
+}
+
+func equalAddrs(a, b []netip.Addr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,411 @@
+package bgp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	// DefaultPort is the well-known BGP port.
+	DefaultPort = 179
+	// DefaultHoldTime is offered when the config leaves it unset.
+	DefaultHoldTime = 90 * time.Second
+
+	// openTimeout bounds how long we wait for the peer's OPEN and KEEPALIVE.
+	openTimeout = 30 * time.Second
+	// routesPerUpdate keeps an UPDATE well below maxMessageLen even for /128s.
+	routesPerUpdate = 200
+
+	minRetryBackoff = time.Second
+	maxRetryBackoff = 30 * time.Second
+)
+
+// SessionConfig describes one BGP session to a peer.
+type SessionConfig struct {
+	LocalASN uint32
+	PeerASN  uint32
+	// PeerAddress is the peer's IP; PeerPort defaults to DefaultPort.
+	PeerAddress netip.Addr
+	PeerPort    int
+	HoldTime    time.Duration
+	// Password enables TCP MD5 signatures (RFC 2385) when non-empty.
+	Password string
+	// RouterID defaults to the local IPv4 address of the connection.
+	RouterID netip.Addr
+}
+
+// key identifies sessions that can be reused as is.
+func (c SessionConfig) key() string {
+	return fmt.Sprintf("%d|%d|%s|%d|%s|%s|%s", c.LocalASN, c.PeerASN, c.PeerAddress, c.port(), c.HoldTime, c.Password, c.RouterID)
+}
+
+func (c SessionConfig) port() int {
+	if c.PeerPort == 0 {
+		return DefaultPort
+	}
+	return c.PeerPort
+}
+
+func (c SessionConfig) holdTime() time.Duration {
+	if c.HoldTime == 0 {
+		return DefaultHoldTime
+	}
+	return c.HoldTime
+}
+
+// Session keeps a BGP session to one peer up and advertises a set of host
+// routes over it. It reconnects with backoff when the session drops and
+// re-advertises the full set on every new session. Routes of the family the
+// session cannot carry (IPv6 over a peer that did not negotiate it) are kept
+// but not sent.
+type Session struct {
+	cfg    SessionConfig
+	logger logr.Logger
+	dial   func(ctx context.Context, network, address string) (net.Conn, error)
+
+	mu          sync.Mutex
+	routes      map[netip.Addr]struct{}
+	established bool
+
+	changed chan struct{}
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// NewSession creates a session that is not yet running.
+func NewSession(cfg SessionConfig, logger logr.Logger) *Session {
+	s := &Session{
+		cfg:     cfg,
+		logger:  logger.WithValues("peer", cfg.PeerAddress.String(), "peerASN", cfg.PeerASN),
+		routes:  make(map[netip.Addr]struct{}),
+		changed: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	s.dial = s.dialPeer
+	return s
+}
+
+// Start runs the session in the background until Stop is called.
+func (s *Session) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go s.run(ctx)
+}
+
+// Stop tears the session down, telling the peer with a Cease notification so
+// it withdraws our routes immediately instead of waiting for the hold timer.
+func (s *Session) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+}
+
+// SetRoutes replaces the set of advertised host routes.
+func (s *Session) SetRoutes(routes []netip.Addr) {
+	next := make(map[netip.Addr]struct{}, len(routes))
+	for _, r := range routes {
+		next[r.Unmap()] = struct{}{}
+	}
+	s.mu.Lock()
+	s.routes = next
+	s.mu.Unlock()
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Established reports whether the session is currently up.
+func (s *Session) Established() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.established
+}
+
+func (s *Session) setEstablished(up bool) {
+	s.mu.Lock()
+	s.established = up
+	s.mu.Unlock()
+}
+
+func (s *Session) desiredRoutes() map[netip.Addr]struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[netip.Addr]struct{}, len(s.routes))
+	for r := range s.routes {
+		out[r] = struct{}{}
+	}
+	return out
+}
+
+func (s *Session) run(ctx context.Context) {
+	defer close(s.done)
+	backoff := minRetryBackoff
+	for {
+		address := net.JoinHostPort(s.cfg.PeerAddress.String(), strconv.Itoa(s.cfg.port()))
+		conn, err := s.dial(ctx, "tcp", address)
+		if err == nil {
+			err = s.serve(ctx, conn)
+			_ = conn.Close()
+			s.setEstablished(false)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		s.logger.Info("BGP session down, retrying", "error", err.Error(), "backoff", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+}
+
+func (s *Session) dialPeer(ctx context.Context, network, address string) (net.Conn, error) {
+	d := net.Dialer{Timeout: openTimeout}
+	if s.cfg.Password != "" {
+		d.Control = md5SigControl(s.cfg.PeerAddress, s.cfg.Password)
+	}
+	return d.DialContext(ctx, network, address)
+}
+
+// serve runs one session over conn until it fails or ctx is done.
+func (s *Session) serve(ctx context.Context, conn net.Conn) error {
+	local, err := netip.ParseAddrPort(conn.LocalAddr().String())
+	if err != nil {
+		return fmt.Errorf("parse local address: %w", err)
+	}
+	localAddr := local.Addr().Unmap()
+	routerID := s.cfg.RouterID
+	if !routerID.IsValid() {
+		routerID = localAddr
+	}
+	if !routerID.Is4() {
+		return errors.New("router ID must be an IPv4 address; set it explicitly on IPv6-only sessions")
+	}
+
+	peer, err := s.openSession(conn, routerID, localAddr)
+	if err != nil {
+		return err
+	}
+	hold := min(s.cfg.holdTime(), time.Duration(peer.HoldTime)*time.Second)
+	s.setEstablished(true)
+	s.logger.Info("BGP session established", "holdTime", hold)
+
+	// done stops the reader when serve returns for any reason, not only when
+	// the session is cancelled, so a reconnect does not leave it behind.
+	done := make(chan struct{})
+	defer close(done)
+	msgs := make(chan uint8)
+	readErr := make(chan error, 1)
+	go readMessages(conn, msgs, readErr, done)
+
+	// A hold time of zero disables keepalives and the hold timer entirely.
+	var keepalive, holdTimer <-chan time.Time
+	var holdDeadline *time.Timer
+	if hold > 0 {
+		ticker := time.NewTicker(hold / 3)
+		defer ticker.Stop()
+		keepalive = ticker.C
+		holdDeadline = time.NewTimer(hold)
+		defer holdDeadline.Stop()
+		holdTimer = holdDeadline.C
+	}
+
+	ibgp := s.cfg.LocalASN == s.cfg.PeerASN
+	advertised := make(map[netip.Addr]struct{})
+	sync := func() error {
+		return s.syncRoutes(conn, advertised, localAddr, peer, ibgp)
+	}
+	if err := sync(); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			_, _ = conn.Write(marshalNotification(errCease, subAdminShutdown))
+			return nil
+		case err := <-readErr:
+			return err
+		case <-msgs:
+			if holdDeadline != nil {
+				holdDeadline.Reset(hold)
+			}
+		case <-holdTimer:
+			_, _ = conn.Write(marshalNotification(errHoldTimerExpired, 0))
+			return errors.New("hold timer expired")
+		case <-keepalive:
+			if _, err := conn.Write(marshalKeepalive()); err != nil {
+				return err
+			}
+		case <-s.changed:
+			if err := sync(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readMessages reads the peer's messages from conn and passes their types to
+// msgs until done is closed. A read error or a NOTIFICATION is sent to
+// readErr, which must have room for it, and ends the loop.
+func readMessages(conn net.Conn, msgs chan<- uint8, readErr chan<- error, done <-chan struct{}) {
+	for {
+		typ, body, err := readMessage(conn)
+		if err != nil {
+			readErr <- err
+			return
+		}
+		if typ == msgNotification {
+			readErr <- fmt.Errorf("peer sent %s", describeNotification(body))
+			return
+		}
+		select {
+		case msgs <- typ:
+		case <-done:
+			return
+		}
+	}
+}
+
+// openSession exchanges OPEN and KEEPALIVE messages and returns the peer's
+// OPEN once the session is established.
+func (s *Session) openSession(conn net.Conn, routerID, localAddr netip.Addr) (openMessage, error) {
+	_ = conn.SetDeadline(time.Now().Add(openTimeout))
+	defer func() { _ = conn.SetDeadline(time.Time{}) }()
+
+	open := marshalOpen(openMessage{
+		ASN:         s.cfg.LocalASN,
+		HoldTime:    uint16(s.cfg.holdTime() / time.Second),
+		RouterID:    routerID,
+		IPv6Unicast: localAddr.Is6(),
+	})
+	if _, err := conn.Write(open); err != nil {
+		return openMessage{}, err
+	}
+
+	typ, body, err := readMessage(conn)
+	if err != nil {
+		return openMessage{}, err
+	}
+	if typ == msgNotification {
+		return openMessage{}, fmt.Errorf("peer rejected OPEN: %s", describeNotification(body))
+	}
+	if typ != msgOpen {
+		return openMessage{}, fmt.Errorf("expected OPEN, got message type %d", typ)
+	}
+	peer, err := parseOpen(body)
+	if err != nil {
+		return openMessage{}, err
+	}
+	if peer.ASN != s.cfg.PeerASN {
+		_, _ = conn.Write(marshalNotification(errOpenMessage, subBadPeerAS))
+		return openMessage{}, fmt.Errorf("peer ASN %d does not match configured %d", peer.ASN, s.cfg.PeerASN)
+	}
+	if peer.HoldTime != 0 && peer.HoldTime < 3 {
+		return openMessage{}, fmt.Errorf("peer proposed invalid hold time %ds", peer.HoldTime)
+	}
+
+	if _, err := conn.Write(marshalKeepalive()); err != nil {
+		return openMessage{}, err
+	}
+	typ, body, err = readMessage(conn)
+	if err != nil {
+		return openMessage{}, err
+	}
+	if typ == msgNotification {
+		return openMessage{}, fmt.Errorf("peer closed session: %s", describeNotification(body))
+	}
+	if typ != msgKeepalive {
+		return openMessage{}, fmt.Errorf("expected KEEPALIVE, got message type %d", typ)
+	}
+	return peer, nil
+}
+
+// syncRoutes sends the updates that move the peer from advertised to the
+// desired set, then records the new state in advertised.
+func (s *Session) syncRoutes(conn net.Conn, advertised map[netip.Addr]struct{}, localAddr netip.Addr, peer openMessage, ibgp bool) error {
+	desired := s.desiredRoutes()
+	var add4, add6, del4, del6 []netip.Addr
+	for r := range desired {
+		if _, ok := advertised[r]; ok {
+			continue
+		}
+		switch {
+		case r.Is4() && localAddr.Is4():
+			add4 = append(add4, r)
+		case r.Is6() && localAddr.Is6() && peer.IPv6Unicast:
+			add6 = append(add6, r)
+		}
+	}
+	for r := range advertised {
+		if _, ok := desired[r]; ok {
+			continue
+		}
+		if r.Is4() {
+			del4 = append(del4, r)
+		} else {
+			del6 = append(del6, r)
+		}
+	}
+
+	base := updateMessage{NextHop: localAddr}
+	if ibgp {
+		base.LocalPref = defaultLocalPref
+	} else {
+		base.ASPath = []uint32{s.cfg.LocalASN}
+	}
+	send := func(routes []netip.Addr, withdraw bool) error {
+		sortAddrs(routes)
+		for len(routes) > 0 {
+			n := min(len(routes), routesPerUpdate)
+			u := base
+			if withdraw {
+				u = updateMessage{Withdrawn: routes[:n]}
+			} else {
+				u.Advertised = routes[:n]
+			}
+			if _, err := conn.Write(marshalUpdate(u, peer.FourOctetAS)); err != nil {
+				return err
+			}
+			for _, r := range routes[:n] {
+				if withdraw {
+					delete(advertised, r)
+				} else {
+					advertised[r] = struct{}{}
+				}
+			}
+			routes = routes[n:]
+		}
+		return nil
+	}
+	for _, step := range []struct {
+		routes   []netip.Addr
+		withdraw bool
+	}{{del4, true}, {del6, true}, {add4, false}, {add6, false}} {
+		if err := send(step.routes, step.withdraw); err != nil {
+			return err
+		}
+	}
+	if n := len(add4) + len(add6) + len(del4) + len(del6); n > 0 {
+		s.logger.V(1).Info("BGP routes updated", "advertised", len(add4)+len(add6), "withdrawn", len(del4)+len(del6))
+	}
+	return nil
+}
+
+func sortAddrs(addrs []netip.Addr) {
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].Less(addrs[j]) })
+}
@@ -0,0 +1,237 @@
+package bgp
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// fakePeer is a minimal in-process BGP router. It accepts one session at a
+// time, completes the OPEN/KEEPALIVE handshake and reports every UPDATE and
+// NOTIFICATION it receives.
+type fakePeer struct {
+	t        *testing.T
+	ln       net.Listener
+	asn      uint32
+	updates  chan updateMessage
+	notified chan []byte
+}
+
+func newFakePeer(t *testing.T, asn uint32) *fakePeer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	p := &fakePeer{
+		t:        t,
+		ln:       ln,
+		asn:      asn,
+		updates:  make(chan updateMessage, 64),
+		notified: make(chan []byte, 4),
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go p.serve()
+	return p
+}
+
+func (p *fakePeer) port() int { return p.ln.Addr().(*net.TCPAddr).Port }
+
+func (p *fakePeer) serve() {
+	for {
+		conn, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		p.handle(conn)
+	}
+}
+
+func (p *fakePeer) handle(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	typ, body, err := readMessage(conn)
+	if err != nil || typ != msgOpen {
+		return
+	}
+	if _, err := parseOpen(body); err != nil {
+		return
+	}
+	_, _ = conn.Write(marshalOpen(openMessage{
+		ASN:         p.asn,
+		HoldTime:    90,
+		RouterID:    netip.MustParseAddr("10.255.0.1"),
+		FourOctetAS: true,
+	}))
+	_, _ = conn.Write(marshalKeepalive())
+	for {
+		typ, body, err := readMessage(conn)
+		if err != nil {
+			return
+		}
+		switch typ {
+		case msgUpdate:
+			u, err := parseUpdate(body, true)
+			if err != nil {
+				p.t.Errorf("peer received malformed UPDATE: %v", err)
+				return
+			}
+			p.updates <- u
+		case msgNotification:
+			p.notified <- body
+			return
+		}
+	}
+}
+
+func (p *fakePeer) nextUpdate(t *testing.T) updateMessage {
+	t.Helper()
+	select {
+	case u := <-p.updates:
+		return u
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer received no UPDATE")
+		return updateMessage{}
+	}
+}
+
+func (p *fakePeer) sessionConfig(localASN uint32) SessionConfig {
+	return SessionConfig{
+		LocalASN:    localASN,
+		PeerASN:     p.asn,
+		PeerAddress: netip.MustParseAddr("127.0.0.1"),
+		PeerPort:    p.port(),
+	}
+}
+
+func TestSession_AdvertisesAndWithdrawsRoutes(t *testing.T) {
+	peer := newFakePeer(t, 65000)
+	s := NewSession(peer.sessionConfig(65001), logr.Discard())
+	web := netip.MustParseAddr("192.0.2.10")
+	s.SetRoutes([]netip.Addr{web, netip.MustParseAddr("fd00::10")})
+	s.Start()
+	defer s.Stop()
+
+	u := peer.nextUpdate(t)
+	if !equalAddrs(u.Advertised, []netip.Addr{web}) {
+		t.Fatalf("advertised %v, want only the IPv4 route over an IPv4 session", u.Advertised)
+	}
+	if u.NextHop != netip.MustParseAddr("127.0.0.1") {
+		t.Errorf("next hop = %v, want the session's local address", u.NextHop)
+	}
+	if len(u.ASPath) != 1 || u.ASPath[0] != 65001 {
+		t.Errorf("AS_PATH = %v, want the local AS on an eBGP session", u.ASPath)
+	}
+	if !s.Established() {
+		t.Error("Established() = false after the peer received routes")
+	}
+
+	s.SetRoutes(nil)
+	if u := peer.nextUpdate(t); !equalAddrs(u.Withdrawn, []netip.Addr{web}) {
+		t.Errorf("withdrawn %v, want %v", u.Withdrawn, web)
+	}
+}
+
+func TestSession_IBGPSetsLocalPref(t *testing.T) {
+	peer := newFakePeer(t, 65000)
+	s := NewSession(peer.sessionConfig(65000), logr.Discard())
+	s.SetRoutes([]netip.Addr{netip.MustParseAddr("192.0.2.10")})
+	s.Start()
+	defer s.Stop()
+
+	u := peer.nextUpdate(t)
+	if len(u.ASPath) != 0 || u.LocalPref != defaultLocalPref {
+		t.Errorf("AS_PATH = %v, LOCAL_PREF = %d; want empty path and %d", u.ASPath, u.LocalPref, defaultLocalPref)
+	}
+}
+
+func TestSession_StopSendsCease(t *testing.T) {
+	peer := newFakePeer(t, 65000)
+	s := NewSession(peer.sessionConfig(65001), logr.Discard())
+	s.SetRoutes([]netip.Addr{netip.MustParseAddr("192.0.2.10")})
+	s.Start()
+	peer.nextUpdate(t)
+	s.Stop()
+
+	select {
+	case body := <-peer.notified:
+		if len(body) < 2 || body[0] != errCease || body[1] != subAdminShutdown {
+			t.Errorf("notification = %v, want Cease/administrative shutdown", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer was not notified on Stop")
+	}
+}
+
+func TestSession_RejectsUnexpectedPeerAS(t *testing.T) {
+	peer := newFakePeer(t, 65000)
+	cfg := peer.sessionConfig(65001)
+	cfg.PeerASN = 65099
+	s := NewSession(cfg, logr.Discard())
+	s.SetRoutes([]netip.Addr{netip.MustParseAddr("192.0.2.10")})
+	s.Start()
+	defer s.Stop()
+
+	select {
+	case body := <-peer.notified:
+		if body[0] != errOpenMessage || body[1] != subBadPeerAS {
+			t.Errorf("notification = %v, want OPEN error/bad peer AS", body)
+		}
+	case u := <-peer.updates:
+		t.Fatalf("advertised %v to a peer with the wrong AS", u.Advertised)
+	case <-time.After(5 * time.Second):
+		t.Fatal("session did not reject the peer")
+	}
+	if s.Established() {
+		t.Error("Established() = true for a rejected peer")
+	}
+}
+
+// A message read after serve stopped receiving must not keep the reader
+// blocked once serve is done with the connection.
+func TestReadMessages_StopsWhenDone(t *testing.T) {
+	local, remote := net.Pipe()
+	defer func() { _ = local.Close() }()
+	defer func() { _ = remote.Close() }()
+	go func() { _, _ = remote.Write(marshalKeepalive()) }()
+
+	msgs := make(chan uint8) // never received from, like after serve returned
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		readMessages(local, msgs, readErr, done)
+		close(exited)
+	}()
+
+	close(done)
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reader still blocked after done was closed")
+	}
+}
+
+func TestManager_SyncReusesSessions(t *testing.T) {
+	peer := newFakePeer(t, 65000)
+	m := NewManager(logr.Discard())
+	cfg := peer.sessionConfig(65001)
+	a, b := netip.MustParseAddr("192.0.2.10"), netip.MustParseAddr("192.0.2.11")
+
+	m.Sync([]Peer{{Config: cfg, Routes: []netip.Addr{a}}, {Config: cfg, Routes: []netip.Addr{b}}})
+	if u := peer.nextUpdate(t); !equalAddrs(u.Advertised, []netip.Addr{a, b}) {
+		t.Errorf("advertised %v, want the union of both peers' routes", u.Advertised)
+	}
+	m.Sync([]Peer{{Config: cfg, Routes: []netip.Addr{a}}})
+	if u := peer.nextUpdate(t); !equalAddrs(u.Withdrawn, []netip.Addr{b}) {
+		t.Errorf("withdrawn %v, want %v over the existing session", u.Withdrawn, b)
+	}
+	m.Sync(nil)
+	select {
+	case <-peer.notified:
+	case <-time.After(5 * time.Second):
+		t.Fatal("removed peer's session was not shut down")
+	}
+}
@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	balancerv1 "github.com/somaz94/helios-lb/api/v1"
+	"github.com/somaz94/helios-lb/internal/network"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// errAllocationStateNotRestored is reported by the readiness check while the
+// allocator has not been rebuilt from cluster state yet.
+var errAllocationStateNotRestored = errors.New("allocation state not restored yet")
+
+// AllocationRestorer rebuilds the allocator's in-memory used set from cluster
+// state when the manager starts, so the first allocation after a restart or a
+// leader failover cannot hand out an address that is already live.
+//
+// It runs once this replica is elected leader. The reconciler waits for it
+// through Wait, and the readiness check reports not-ready until it finishes.
+type AllocationRestorer struct {
+	Client     client.Reader
+	NetworkMgr *network.NetworkManager
+
+	elected <-chan struct{}
+	done    chan struct{}
+}
+
+// NewAllocationRestorer returns a restorer that marks addresses used in
+// networkMgr. elected is closed once this replica leads, as returned by
+// manager.Manager.Elected; the readiness check only gates on the restore after
+// that, so standby replicas stay ready.
+func NewAllocationRestorer(c client.Reader, networkMgr *network.NetworkManager, elected <-chan struct{}) *AllocationRestorer {
+	return &AllocationRestorer{
+		Client:     c,
+		NetworkMgr: networkMgr,
+		elected:    elected,
+		done:       make(chan struct{}),
+	}
+}
+
+// Start rebuilds the allocation state and returns. It implements
+// manager.Runnable; an error stops the manager rather than letting it
+// allocate from an incomplete picture.
+func (r *AllocationRestorer) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("allocation-restorer")
+
+	ips, err := r.liveAddresses(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to restore allocation state: %w", err)
+	}
+	for _, ip := range ips {
+		r.NetworkMgr.MarkUsed(ip)
+	}
+	logger.Info("restored allocation state", LogKeyAllocatedIPs, len(ips))
+	close(r.done)
+	return nil
+}
+
+// NeedLeaderElection reports true: only the leader allocates, and it must
+// rebuild from the state its predecessor left behind.
+func (r *AllocationRestorer) NeedLeaderElection() bool {
+	return true
+}
+
+// liveAddresses returns every address recorded in a HeliosConfig status and
+// every ingress address of a LoadBalancer service. Ingress addresses of
+// services Helios does not manage are included too: they are live on the
+// network all the same.
+func (r *AllocationRestorer) liveAddresses(ctx context.Context) ([]string, error) {
+	var configs balancerv1.HeliosConfigList
+	if err := r.Client.List(ctx, &configs); err != nil {
+		return nil, fmt.Errorf("failed to list HeliosConfigs: %w", err)
+	}
+	var services corev1.ServiceList
+	if err := r.Client.List(ctx, &services); err != nil {
+		return nil, fmt.Errorf("failed to list services: %w", err)
+	}
+
+	var ips []string
+	for _, hc := range configs.Items {
+		ips = append(ips, hc.Status.AllocatedAddresses()...)
+	}
+	for _, svc := range services.Items {
+		if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			continue
+		}
+		for _, ingress := range svc.Status.LoadBalancer.Ingress {
+			if ip := strings.TrimSpace(ingress.IP); ip != "" {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips, nil
+}
+
+// Restored reports whether the allocation state has been rebuilt.
+func (r *AllocationRestorer) Restored() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wait blocks until the allocation state has been rebuilt or ctx is done.
+func (r *AllocationRestorer) Wait(ctx context.Context) error {
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ReadyzCheck is a healthz.Checker that fails while this replica leads but has
+// not rebuilt its allocation state yet.
+func (r *AllocationRestorer) ReadyzCheck(_ *http.Request) error {
+	select {
+	case <-r.elected:
+	default:
+		return nil
+	}
+	if !r.Restored() {
+		return errAllocationStateNotRestored
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/somaz94/helios-lb/internal/network"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestAllocationRestorer_MarksLiveAddressesUsed(t *testing.T) {
+	cl := fake.NewClientBuilder().
+		WithScheme(newTestScheme()).
+		WithObjects(
+			allocatedHelios(allocation(nameSvc1, "192.168.1.100")),
+			// Live on a Service, but missing from every HeliosConfig status.
+			serviceWithIngress(nameSvcA, corev1.ServiceTypeLoadBalancer, "192.168.1.101"),
+			// Not a LoadBalancer: its stale ingress is ignored.
+			serviceWithIngress(nameTestSvc, corev1.ServiceTypeClusterIP, "192.168.1.102"),
+		).
+		Build()
+	networkMgr := network.NewNetworkManager()
+	restorer := NewAllocationRestorer(cl, networkMgr, make(chan struct{}))
+
+	if err := restorer.Start(context.Background()); err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	if !restorer.Restored() {
+		t.Fatal("expected the state to be restored after Start")
+	}
+
+	ip, err := networkMgr.AllocateIP(ipRangeNarrow)
+	if err != nil {
+		t.Fatalf("AllocateIP error: %v", err)
+	}
+	if ip != "192.168.1.102" {
+		t.Errorf("AllocateIP = %s, want 192.168.1.102 (the first address not live)", ip)
+	}
+}
+
+func TestAllocationRestorer_ReadyzCheck(t *testing.T) {
+	cl := fake.NewClientBuilder().WithScheme(newTestScheme()).Build()
+	elected := make(chan struct{})
+	restorer := NewAllocationRestorer(cl, network.NewNetworkManager(), elected)
+
+	if err := restorer.ReadyzCheck(nil); err != nil {
+		t.Errorf("a standby replica must be ready, got %v", err)
+	}
+
+	close(elected)
+	if err := restorer.ReadyzCheck(nil); !errors.Is(err, errAllocationStateNotRestored) {
+		t.Errorf("ReadyzCheck before restore = %v, want errAllocationStateNotRestored", err)
+	}
+
+	if err := restorer.Start(context.Background()); err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	if err := restorer.ReadyzCheck(nil); err != nil {
+		t.Errorf("ReadyzCheck after restore = %v, want nil", err)
+	}
+}
+
+func TestAllocationRestorer_WaitHonorsContext(t *testing.T) {
+	cl := fake.NewClientBuilder().WithScheme(newTestScheme()).Build()
+	restorer := NewAllocationRestorer(cl, network.NewNetworkManager(), make(chan struct{}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := restorer.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait before restore = %v, want context.DeadlineExceeded", err)
+	}
+
+	if err := restorer.Start(context.Background()); err != nil {
+		t.Fatalf("Start error: %v", err)
+	}
+	if err := restorer.Wait(context.Background()); err != nil {
+		t.Errorf("Wait after restore = %v, want nil", err)
+	}
+}
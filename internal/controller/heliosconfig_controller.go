@@ -21,22 +21,24 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	balancerv1 "github.com/somaz94/helios-lb/api/v1"
-	"github.com/somaz94/helios-lb/internal/loadbalancer"
 	"github.com/somaz94/helios-lb/internal/metrics"
 	"github.com/somaz94/helios-lb/internal/network"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -47,15 +49,18 @@ type HeliosConfigReconciler struct {
 	client.Client
 	Scheme     *runtime.Scheme
 	NetworkMgr *network.NetworkManager
-	Balancer   *loadbalancer.LoadBalancer
 	Metrics    *metrics.MetricsRecorder
 	IPMgr      *IPManager
 	Recorder   record.EventRecorder
+	// Restorer, when set, holds reconciles back until the allocator has been
+	// rebuilt from cluster state.
+	Restorer *AllocationRestorer
 }
 
 // +kubebuilder:rbac:groups=balancer.helios.dev,resources=heliosconfigs,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=balancer.helios.dev,resources=heliosconfigs/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=balancer.helios.dev,resources=heliosconfigs/finalizers,verbs=update
+// +kubebuilder:rbac:groups=balancer.helios.dev,resources=heliosippools,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="",resources=services/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
@@ -68,12 +73,18 @@ func (r *HeliosConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	logger := log.FromContext(ctx).WithValues(LogKeyConfig, req.Name, LogKeyNamespace, req.Namespace)
 	reconcileStart := time.Now()
 
+	if r.Restorer != nil {
+		if err := r.Restorer.Wait(ctx); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
 	var heliosConfig balancerv1.HeliosConfig
 	if err := r.Get(ctx, req.NamespacedName, &heliosConfig); err != nil {
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	logger = logger.WithValues(LogKeyIPRange, heliosConfig.Spec.IPRange, LogKeyPhase, heliosConfig.Status.Phase)
+	logger = logger.WithValues(LogKeyPhase, heliosConfig.Status.Phase)
 
 	// Record metrics on defer
 	defer func() {
@@ -86,10 +97,10 @@ func (r *HeliosConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		r.Metrics.RecordLBStatus(heliosConfig.Name, heliosConfig.Namespace,
 			heliosConfig.Status.Phase == balancerv1.StateActive)
 		r.Metrics.RecordIPPoolUtilization(heliosConfig.Name, heliosConfig.Namespace,
-			len(heliosConfig.Status.AllocatedIPs))
+			len(heliosConfig.Status.Allocations))
 		logger.V(1).Info("reconcile complete",
 			LogKeyReconcileTime, duration*1000,
-			LogKeyAllocatedIPs, len(heliosConfig.Status.AllocatedIPs))
+			LogKeyAllocatedIPs, len(heliosConfig.Status.Allocations))
 	}()
 
 	// Add finalizer if it doesn't exist
@@ -115,7 +126,23 @@ func (r *HeliosConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request
 
 	// Check if the HeliosConfig is being deleted
 	if !heliosConfig.DeletionTimestamp.IsZero() {
-		return ctrl.Result{}, r.handleDeletion(ctx, &heliosConfig)
+		return r.handleDeletion(ctx, &heliosConfig)
+	}
+
+	// Resolve the ranges to allocate from, following spec.poolRef if set.
+	ipRange, ipv6Range, err := r.IPMgr.ResolveRanges(ctx, &heliosConfig)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		return r.handleMissingPool(ctx, logger, &heliosConfig), nil
+	}
+	logger = logger.WithValues(LogKeyIPRange, ipRange)
+	if ipv6Range != "" {
+		logger = logger.WithValues(LogKeyIPv6Range, ipv6Range)
+	}
+	if heliosConfig.Spec.PoolRef != "" {
+		logger = logger.WithValues(LogKeyPool, heliosConfig.Spec.PoolRef)
 	}
 
 	// List all services
@@ -124,12 +151,36 @@ func (r *HeliosConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		return ctrl.Result{}, err
 	}
 
+	// Move allocations recorded by older versions into the namespaced list.
+	if MigrateLegacyAllocations(&heliosConfig.Status, serviceList.Items, heliosConfig.Namespace) {
+		logger.Info("migrated legacy allocations", LogKeyAllocatedIPs, len(heliosConfig.Status.Allocations))
+		if err := r.Status().Update(ctx, &heliosConfig); err != nil {
+			logger.Error(err, "failed to update status after migrating allocations")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Release allocations whose service was deleted or is no longer a Helios
+	// LoadBalancer, so the addresses go back to the pool. The pool only takes
+	// them back once the trimmed status is stored.
+	if released := r.IPMgr.TrimOrphaned(&heliosConfig, serviceList.Items); len(released) > 0 {
+		if err := r.Status().Update(ctx, &heliosConfig); err != nil {
+			logger.Error(err, "failed to update status after releasing orphaned IPs")
+			return ctrl.Result{}, err
+		}
+		r.IPMgr.ReleaseOrphaned(ctx, logger, &heliosConfig, released)
+		for _, rel := range released {
+			r.Recorder.Eventf(&heliosConfig, corev1.EventTypeNormal, "IPReleased",
+				"Released IP %s from service %s/%s (%s)", rel.IP, rel.Namespace, rel.Service, rel.Reason)
+		}
+	}
+
 	// Filter eligible services using extracted logic
 	eligible := FilterEligibleServices(
 		serviceList.Items,
 		heliosConfig.Spec.NamespaceSelector,
-		heliosConfig.Spec.IPRange,
-		heliosConfig.Spec.IPv6Range,
+		ipRange,
+		ipv6Range,
 	)
 
 	logger.V(1).Info("discovered eligible services", LogKeyServiceCount, len(eligible))
@@ -170,9 +221,9 @@ func (r *HeliosConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		if err := r.IPMgr.CheckQuota(&heliosConfig); err != nil {
 			svcLogger.Info("max allocations reached, skipping remaining services",
 				LogKeyMaxAlloc, heliosConfig.Spec.MaxAllocations,
-				LogKeyCurrentAlloc, len(heliosConfig.Status.AllocatedIPs))
+				LogKeyCurrentAlloc, len(heliosConfig.Status.Allocations))
 			r.Recorder.Eventf(&heliosConfig, corev1.EventTypeWarning, "QuotaExceeded",
-				"Max allocations reached (%d/%d)", len(heliosConfig.Status.AllocatedIPs), heliosConfig.Spec.MaxAllocations)
+				"Max allocations reached (%d/%d)", len(heliosConfig.Status.Allocations), heliosConfig.Spec.MaxAllocations)
 			break
 		}
 
@@ -202,6 +253,7 @@ func (r *HeliosConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request
 			if statusErr := r.Status().Update(ctx, &heliosConfig); statusErr != nil {
 				svcLogger.Error(statusErr, "failed to update status after allocation failure")
 			}
+			r.IPMgr.RecordFreeAddresses(logger, &heliosConfig, ipRange, ipv6Range)
 			r.Metrics.RecordRequeueReason(heliosConfig.Name, heliosConfig.Namespace, "ip_allocation_error")
 			return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
 		}
@@ -215,16 +267,14 @@ func (r *HeliosConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		}
 
 		// Update HeliosConfig status
-		if heliosConfig.Status.AllocatedIPs == nil {
-			heliosConfig.Status.AllocatedIPs = make(map[string]string)
-		}
-		heliosConfig.Status.AllocatedIPs[svc.Name] = ip
-		if ipv6 != "" {
-			if heliosConfig.Status.AllocatedIPv6s == nil {
-				heliosConfig.Status.AllocatedIPv6s = make(map[string]string)
-			}
-			heliosConfig.Status.AllocatedIPv6s[svc.Name] = ipv6
-		}
+		heliosConfig.Status.SetAllocation(balancerv1.ServiceAllocation{
+			Namespace:   svc.Namespace,
+			Name:        svc.Name,
+			UID:         svc.UID,
+			IPv4:        ip,
+			IPv6:        ipv6,
+			AllocatedAt: metav1.Now(),
+		})
 		heliosConfig.Status.Phase = balancerv1.StateActive
 		heliosConfig.Status.State = balancerv1.StateActive
 		heliosConfig.Status.Message = "IP allocated successfully"
@@ -248,19 +298,58 @@ func (r *HeliosConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		}
 	}
 
+	r.IPMgr.RecordFreeAddresses(logger, &heliosConfig, ipRange, ipv6Range)
 	r.Metrics.RecordRequeueReason(heliosConfig.Name, heliosConfig.Namespace, "periodic")
 	return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
 }
 
-// handleDeletion handles the deletion of a HeliosConfig
-func (r *HeliosConfigReconciler) handleDeletion(ctx context.Context, heliosConfig *balancerv1.HeliosConfig) error {
+// handleMissingPool marks the config failed while the HeliosIPPool it references
+// does not exist. Existing allocations are kept; the pool watch re-queues the
+// config once the pool is created.
+func (r *HeliosConfigReconciler) handleMissingPool(
+	ctx context.Context,
+	logger logr.Logger,
+	heliosConfig *balancerv1.HeliosConfig,
+) ctrl.Result {
+	msg := fmt.Sprintf("HeliosIPPool %q not found", heliosConfig.Spec.PoolRef)
+	logger.Info("referenced pool not found", LogKeyPool, heliosConfig.Spec.PoolRef)
+	r.Recorder.Event(heliosConfig, corev1.EventTypeWarning, "PoolNotFound", msg)
+	heliosConfig.Status.Phase = balancerv1.StateFailed
+	heliosConfig.Status.State = balancerv1.StateFailed
+	heliosConfig.Status.Message = msg
+	meta.SetStatusCondition(&heliosConfig.Status.Conditions, metav1.Condition{
+		Type:               balancerv1.ConditionTypeReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             balancerv1.ReasonPoolNotFound,
+		Message:            msg,
+		ObservedGeneration: heliosConfig.Generation,
+	})
+	if err := r.Status().Update(ctx, heliosConfig); err != nil {
+		logger.Error(err, "failed to update status for missing pool")
+	}
+	r.Metrics.RecordRequeueReason(heliosConfig.Name, heliosConfig.Namespace, "pool_not_found")
+	return ctrl.Result{RequeueAfter: 30 * time.Second}
+}
+
+// handleDeletion handles the deletion of a HeliosConfig. With a drain timeout
+// the addresses are released only once it has passed since the deletion, so
+// the speakers can let open connections finish first.
+func (r *HeliosConfigReconciler) handleDeletion(ctx context.Context, heliosConfig *balancerv1.HeliosConfig) (ctrl.Result, error) {
 	logger := log.FromContext(ctx).WithValues(
 		LogKeyConfig, heliosConfig.Name,
 		LogKeyNamespace, heliosConfig.Namespace,
 	)
 
 	if controllerutil.ContainsFinalizer(heliosConfig, heliosConfigFinalizer) {
-		allocCount := len(heliosConfig.Status.AllocatedIPs)
+		if remaining := drainRemaining(heliosConfig, time.Now()); remaining > 0 {
+			logger.Info("waiting for connections to drain", LogKeyDrainLeft, remaining.String())
+			r.Recorder.Eventf(heliosConfig, corev1.EventTypeNormal, "Draining",
+				"Releasing allocated IPs in %s, once connections have drained", remaining.Round(time.Second))
+			r.Metrics.RecordRequeueReason(heliosConfig.Name, heliosConfig.Namespace, "draining")
+			return ctrl.Result{RequeueAfter: remaining}, nil
+		}
+
+		allocCount := len(heliosConfig.Status.AllocatedAddresses())
 		logger.Info("cleaning up allocated IPs", LogKeyAllocatedIPs, allocCount)
 		r.Recorder.Eventf(heliosConfig, corev1.EventTypeNormal, "CleanupStarted",
 			"Releasing %d allocated IPs", allocCount)
@@ -272,14 +361,24 @@ func (r *HeliosConfigReconciler) handleDeletion(ctx context.Context, heliosConfi
 			logger.Error(err, "failed to remove finalizer")
 			r.Recorder.Eventf(heliosConfig, corev1.EventTypeWarning, "CleanupFailed",
 				"Failed to remove finalizer: %v", err)
-			return err
+			return ctrl.Result{}, err
 		}
 		r.Recorder.Event(heliosConfig, corev1.EventTypeNormal, "CleanupComplete",
 			"All allocated IPs released and finalizer removed")
 		logger.Info("finalizer removed, deletion complete")
 	}
 
-	return nil
+	return ctrl.Result{}, nil
+}
+
+// drainRemaining returns how much of the drain timeout of a config being
+// deleted is left at now.
+func drainRemaining(heliosConfig *balancerv1.HeliosConfig, now time.Time) time.Duration {
+	timeout := time.Duration(heliosConfig.Spec.DrainTimeoutSeconds) * time.Second
+	if timeout <= 0 || heliosConfig.DeletionTimestamp.IsZero() {
+		return 0
+	}
+	return heliosConfig.DeletionTimestamp.Add(timeout).Sub(now)
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -289,19 +388,34 @@ func (r *HeliosConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Watches(
 			&corev1.Service{},
 			handler.EnqueueRequestsFromMapFunc(r.findLoadBalancerServices),
-			builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
-				svc, ok := obj.(*corev1.Service)
-				if !ok {
-					return false
-				}
-				return svc.Spec.Type == corev1.ServiceTypeLoadBalancer
-			})),
+			builder.WithPredicates(loadBalancerServiceChanged()),
+		).
+		Watches(
+			&balancerv1.HeliosIPPool{},
+			handler.EnqueueRequestsFromMapFunc(r.findPoolConfigs),
 		).
 		Complete(r)
 }
 
-// findLoadBalancerServices watches for LoadBalancer type services and enqueues
-// all HeliosConfig resources whose IP range covers the service's loadBalancerIP.
+// loadBalancerServiceChanged passes events for LoadBalancer services. Updates
+// also pass when the service stops being a LoadBalancer, so its IP is released.
+func loadBalancerServiceChanged() predicate.Predicate {
+	isLB := func(obj client.Object) bool {
+		svc, ok := obj.(*corev1.Service)
+		return ok && svc.Spec.Type == corev1.ServiceTypeLoadBalancer
+	}
+	return predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return isLB(e.Object) },
+		DeleteFunc:  func(e event.DeleteEvent) bool { return isLB(e.Object) },
+		UpdateFunc:  func(e event.UpdateEvent) bool { return isLB(e.ObjectOld) || isLB(e.ObjectNew) },
+		GenericFunc: func(e event.GenericEvent) bool { return isLB(e.Object) },
+	}
+}
+
+// findLoadBalancerServices maps a service event to the HeliosConfigs that need
+// to act on it: every config whose IP range covers the service's
+// loadBalancerIP, and every config holding an allocation for the service so a
+// deleted or retyped service gets its IP released.
 func (r *HeliosConfigReconciler) findLoadBalancerServices(ctx context.Context, obj client.Object) []reconcile.Request {
 	svc, ok := obj.(*corev1.Service)
 	if !ok {
@@ -320,10 +434,29 @@ func (r *HeliosConfigReconciler) findLoadBalancerServices(ctx context.Context, o
 
 	var requests []reconcile.Request
 	for _, hc := range heliosConfigs.Items {
+		_, hasLegacyV4 := hc.Status.AllocatedIPs[svc.Name]
+		_, hasLegacyV6 := hc.Status.AllocatedIPv6s[svc.Name]
+		if hc.Status.FindAllocation(svc.Namespace, svc.Name) != nil || hasLegacyV4 || hasLegacyV6 {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: hc.Name, Namespace: hc.Namespace},
+			})
+			continue
+		}
+		if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			continue
+		}
 		// If the service has a loadBalancerIP, only enqueue configs whose range covers it.
 		// If it has no loadBalancerIP, enqueue all configs so they can attempt allocation.
-		if svc.Spec.LoadBalancerIP != "" && !network.IPInRange(svc.Spec.LoadBalancerIP, hc.Spec.IPRange) {
-			continue
+		if svc.Spec.LoadBalancerIP != "" {
+			ipRange, ipv6Range, err := r.IPMgr.ResolveRanges(ctx, &hc)
+			if err != nil {
+				continue
+			}
+			inV4 := network.IPInRange(svc.Spec.LoadBalancerIP, ipRange)
+			inV6 := ipv6Range != "" && network.IPInRange(svc.Spec.LoadBalancerIP, ipv6Range)
+			if !inV4 && !inV6 {
+				continue
+			}
 		}
 		requests = append(requests, reconcile.Request{
 			NamespacedName: types.NamespacedName{
@@ -334,3 +467,25 @@ func (r *HeliosConfigReconciler) findLoadBalancerServices(ctx context.Context, o
 	}
 	return requests
 }
+
+// findPoolConfigs maps a HeliosIPPool event to every HeliosConfig that
+// references the pool.
+func (r *HeliosConfigReconciler) findPoolConfigs(ctx context.Context, obj client.Object) []reconcile.Request {
+	var heliosConfigs balancerv1.HeliosConfigList
+	if err := r.List(ctx, &heliosConfigs); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list HeliosConfigs in pool watch handler",
+			LogKeyPool, obj.GetName())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, hc := range heliosConfigs.Items {
+		if hc.Spec.PoolRef != obj.GetName() {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: hc.Name, Namespace: hc.Namespace},
+		})
+	}
+	return requests
+}
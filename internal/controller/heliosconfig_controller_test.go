@@ -8,7 +8,6 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	balancerv1 "github.com/somaz94/helios-lb/api/v1"
-	"github.com/somaz94/helios-lb/internal/loadbalancer"
 	"github.com/somaz94/helios-lb/internal/metrics"
 	"github.com/somaz94/helios-lb/internal/network"
 	corev1 "k8s.io/api/core/v1"
@@ -32,19 +31,12 @@ var _ = Describe("HeliosConfig Controller", func() {
 	BeforeEach(func() {
 		testID++
 		networkMgr := network.NewNetworkManager()
-		balancer := loadbalancer.NewLoadBalancer(loadbalancer.BalancerConfig{
-			Type:           loadbalancer.RoundRobin,
-			HealthCheck:    true,
-			CheckInterval:  time.Second * 5,
-			MetricsEnabled: true,
-		})
 		metricsRecorder := metrics.NewMetricsRecorder()
 
 		reconciler = &HeliosConfigReconciler{
 			Client:     k8sClient,
 			Scheme:     k8sClient.Scheme(),
 			NetworkMgr: networkMgr,
-			Balancer:   balancer,
 			Metrics:    metricsRecorder,
 			IPMgr: &IPManager{
 				Client:     k8sClient,
@@ -450,12 +442,6 @@ var _ = Describe("HeliosConfig Controller", func() {
 var _ = Describe("SetupWithManager", func() {
 	It("should register controller with manager successfully", func() {
 		networkMgr := network.NewNetworkManager()
-		balancer := loadbalancer.NewLoadBalancer(loadbalancer.BalancerConfig{
-			Type:           loadbalancer.RoundRobin,
-			HealthCheck:    true,
-			CheckInterval:  time.Second * 5,
-			MetricsEnabled: true,
-		})
 		metricsRecorder := metrics.NewMetricsRecorder()
 
 		mgr, err := ctrl.NewManager(cfg, ctrl.Options{
@@ -470,7 +456,6 @@ var _ = Describe("SetupWithManager", func() {
 			Client:     mgr.GetClient(),
 			Scheme:     mgr.GetScheme(),
 			NetworkMgr: networkMgr,
-			Balancer:   balancer,
 			Metrics:    metricsRecorder,
 			IPMgr: &IPManager{
 				Client:     mgr.GetClient(),
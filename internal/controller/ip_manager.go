@@ -3,7 +3,9 @@ package controller
 import (
 	"context"
 	"fmt"
+	"math/big"
 	"net"
+	"slices"
 	"strings"
 
 	"github.com/go-logr/logr"
@@ -39,6 +41,45 @@ func splitRequestedIP(requested string) (v4, v6 string) {
 	return "", trimmed
 }
 
+// ResolveRanges returns the IPv4 and IPv6 ranges the config allocates from:
+// its own spec ranges, or those of the HeliosIPPool named by spec.poolRef. Each
+// family is returned as a network range set expression covering all of its
+// ranges. The IPv6 range is empty for single-stack configs. A missing pool is returned as
+// a NotFound error.
+func (m *IPManager) ResolveRanges(ctx context.Context, heliosConfig *balancerv1.HeliosConfig) (string, string, error) {
+	if heliosConfig.Spec.PoolRef == "" {
+		return heliosConfig.Spec.IPv4RangeSet(), heliosConfig.Spec.IPv6RangeSet(), nil
+	}
+	var pool balancerv1.HeliosIPPool
+	if err := m.Client.Get(ctx, types.NamespacedName{Name: heliosConfig.Spec.PoolRef}, &pool); err != nil {
+		return "", "", fmt.Errorf("failed to get HeliosIPPool %q: %w", heliosConfig.Spec.PoolRef, err)
+	}
+	return pool.Spec.IPv4RangeSet(), pool.Spec.IPv6RangeSet(), nil
+}
+
+// RecordFreeAddresses reports how many addresses of each family are still free
+// in the ranges the config allocates from, as returned by ResolveRanges. The
+// count covers addresses taken by any config, so configs sharing a
+// HeliosIPPool report the same value. The IPv6 family is only reported for
+// dual-stack configs.
+func (m *IPManager) RecordFreeAddresses(logger logr.Logger, heliosConfig *balancerv1.HeliosConfig, ipRange, ipv6Range string) {
+	for _, family := range []struct {
+		name    string
+		ipRange string
+	}{{"ipv4", ipRange}, {"ipv6", ipv6Range}} {
+		if family.ipRange == "" {
+			continue
+		}
+		free, err := m.NetworkMgr.FreeCount(family.ipRange)
+		if err != nil {
+			logger.Error(err, "failed to count free IPs", LogKeyIPRange, family.ipRange)
+			continue
+		}
+		count, _ := new(big.Float).SetInt(free).Float64()
+		m.Metrics.RecordIPPoolFree(heliosConfig.Name, heliosConfig.Namespace, family.name, count)
+	}
+}
+
 // allocateIP honors a requested address when one was given, otherwise takes the
 // next free address from the range.
 func (m *IPManager) allocateIP(ipRange, requested string) (string, error) {
@@ -49,7 +90,8 @@ func (m *IPManager) allocateIP(ipRange, requested string) (string, error) {
 }
 
 // AllocateAndAssign allocates an IP from the config's range and assigns it to the service.
-// It marks IPs from other HeliosConfigs as used to prevent duplicates.
+// It marks IPs from other HeliosConfigs as used to prevent duplicates, which also
+// keeps configs sharing a HeliosIPPool from handing out the same address.
 // For dual-stack configs, it allocates both IPv4 and IPv6 addresses.
 // Returns the allocated IPv4 IP, IPv6 IP (empty if single-stack), or an error.
 func (m *IPManager) AllocateAndAssign(
@@ -58,6 +100,11 @@ func (m *IPManager) AllocateAndAssign(
 	heliosConfig *balancerv1.HeliosConfig,
 	svc *corev1.Service,
 ) (string, string, error) {
+	ipRange, ipv6Range, err := m.ResolveRanges(ctx, heliosConfig)
+	if err != nil {
+		return "", "", NewRetryableError("failed to resolve IP ranges", err)
+	}
+
 	// Mark IPs already allocated by other configs to avoid duplicates
 	var allConfigs balancerv1.HeliosConfigList
 	if err := m.Client.List(ctx, &allConfigs); err != nil {
@@ -67,10 +114,7 @@ func (m *IPManager) AllocateAndAssign(
 		if other.Name == heliosConfig.Name && other.Namespace == heliosConfig.Namespace {
 			continue
 		}
-		for _, ip := range other.Status.AllocatedIPs {
-			m.NetworkMgr.MarkUsed(ip)
-		}
-		for _, ip := range other.Status.AllocatedIPv6s {
+		for _, ip := range other.Status.AllocatedAddresses() {
 			m.NetworkMgr.MarkUsed(ip)
 		}
 	}
@@ -81,15 +125,15 @@ func (m *IPManager) AllocateAndAssign(
 	requestedV4, requestedV6 := splitRequestedIP(svc.Spec.LoadBalancerIP)
 
 	// Allocate IPv4
-	ip, err := m.allocateIP(heliosConfig.Spec.IPRange, requestedV4)
+	ip, err := m.allocateIP(ipRange, requestedV4)
 	if err != nil {
 		return "", "", NewRetryableError("IPv4 allocation failed", err)
 	}
 
 	// Allocate IPv6 if dual-stack
 	var ipv6 string
-	if heliosConfig.Spec.IPv6Range != "" {
-		ipv6, err = m.allocateIP(heliosConfig.Spec.IPv6Range, requestedV6)
+	if ipv6Range != "" {
+		ipv6, err = m.allocateIP(ipv6Range, requestedV6)
 		if err != nil {
 			m.NetworkMgr.ReleaseIP(ip)
 			return "", "", NewRetryableError("IPv6 allocation failed", err)
@@ -160,45 +204,226 @@ func (m *IPManager) ReleaseAll(
 	logger logr.Logger,
 	heliosConfig *balancerv1.HeliosConfig,
 ) {
-	// Collect all service names that need ingress cleared
-	serviceNames := make(map[string]bool)
+	// Entries of the deprecated maps carry no namespace; they were written by
+	// versions that only managed services in the config's own namespace.
+	MigrateLegacyAllocations(&heliosConfig.Status, nil, heliosConfig.Namespace)
 
-	for serviceName, ip := range heliosConfig.Status.AllocatedIPs {
-		m.NetworkMgr.ReleaseIP(ip)
-		m.Metrics.RecordIPAllocation(ip, false)
-		logger.Info("released IPv4", LogKeyService, serviceName, LogKeyIP, ip)
-		serviceNames[serviceName] = true
-	}
-
-	for serviceName, ip := range heliosConfig.Status.AllocatedIPv6s {
-		m.NetworkMgr.ReleaseIP(ip)
-		m.Metrics.RecordIPAllocation(ip, false)
-		logger.Info("released IPv6", LogKeyService, serviceName, LogKeyIPv6, ip)
-		serviceNames[serviceName] = true
-	}
+	for i := range heliosConfig.Status.Allocations {
+		alloc := &heliosConfig.Status.Allocations[i]
+		for _, ip := range alloc.Addresses() {
+			m.NetworkMgr.ReleaseIP(ip)
+			m.Metrics.RecordIPAllocation(ip, false)
+			logger.Info("released IP", LogKeyService, alloc.Name, LogKeyNamespace, alloc.Namespace, LogKeyIP, ip)
+		}
 
-	// Clear ingress for all affected services
-	for serviceName := range serviceNames {
+		// A service recreated under the same name has a new UID; its ingress
+		// belongs to whoever serves it now and is left alone.
 		var svc corev1.Service
 		if err := m.Client.Get(ctx, types.NamespacedName{
-			Name:      serviceName,
-			Namespace: heliosConfig.Namespace,
-		}, &svc); err == nil {
+			Name:      alloc.Name,
+			Namespace: alloc.Namespace,
+		}, &svc); err == nil && (alloc.UID == "" || alloc.UID == svc.UID) {
 			svc.Status.LoadBalancer.Ingress = nil
 			if err := m.Client.Status().Update(ctx, &svc); err != nil {
 				logger.Error(err, "failed to clear service ingress",
-					LogKeyService, serviceName)
+					LogKeyService, alloc.Name, LogKeyNamespace, alloc.Namespace)
 			}
 		}
 	}
 }
 
+// MigrateLegacyAllocations moves the entries of the deprecated name-keyed
+// AllocatedIPs and AllocatedIPv6s maps into Allocations and clears the maps.
+// The owning service is the one of that name whose ingress reports the
+// address, or the only service of that name; failing both, it is assumed to
+// live in defaultNamespace. Returns whether anything was migrated.
+func MigrateLegacyAllocations(status *balancerv1.HeliosConfigStatus, services []corev1.Service, defaultNamespace string) bool {
+	if !status.HasLegacyAllocations() {
+		return false
+	}
+	names := make(map[string]bool)
+	for name := range status.AllocatedIPs {
+		names[name] = true
+	}
+	for name := range status.AllocatedIPv6s {
+		names[name] = true
+	}
+	for name := range names {
+		alloc := balancerv1.ServiceAllocation{
+			Namespace:   defaultNamespace,
+			Name:        name,
+			IPv4:        status.AllocatedIPs[name],
+			IPv6:        status.AllocatedIPv6s[name],
+			AllocatedAt: status.LastUpdated,
+		}
+		if svc := legacyOwner(name, alloc.Addresses(), services); svc != nil {
+			alloc.Namespace = svc.Namespace
+			alloc.UID = svc.UID
+		}
+		if status.FindAllocation(alloc.Namespace, alloc.Name) == nil {
+			status.SetAllocation(alloc)
+		}
+	}
+	status.AllocatedIPs = nil
+	status.AllocatedIPv6s = nil
+	return true
+}
+
+// legacyOwner picks the service a name-keyed legacy allocation belongs to.
+func legacyOwner(name string, ips []string, services []corev1.Service) *corev1.Service {
+	var candidates []*corev1.Service
+	for i := range services {
+		if services[i].Name != name {
+			continue
+		}
+		for _, in := range services[i].Status.LoadBalancer.Ingress {
+			if slices.Contains(ips, in.IP) {
+				return &services[i]
+			}
+		}
+		candidates = append(candidates, &services[i])
+	}
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	return nil
+}
+
+// Reasons an allocation is released while its HeliosConfig lives on.
+const (
+	ReleaseReasonServiceDeleted     = "service_deleted"
+	ReleaseReasonServiceTypeChanged = "service_type_changed"
+)
+
+// ReleasedIP describes an orphaned address found by TrimOrphaned.
+type ReleasedIP struct {
+	Namespace string
+	Service   string
+	IP        string
+	Reason    string
+
+	// retyped is the service when it still exists but is no longer a Helios
+	// LoadBalancer, so its ingress can be cleaned up.
+	retyped *corev1.Service
+}
+
+// TrimOrphaned removes the allocations of services that are gone or no longer
+// Helios LoadBalancer services from the config status and returns their
+// addresses. services is the current list of all services in the cluster.
+// The addresses stay reserved in the allocator: the caller persists the
+// trimmed status first and then hands them to ReleaseOrphaned, so a failed
+// status update cannot leave an address free in the pool while the stored
+// status still assigns it to a service.
+func (m *IPManager) TrimOrphaned(heliosConfig *balancerv1.HeliosConfig, services []corev1.Service) []ReleasedIP {
+	var released []ReleasedIP
+	var keep []balancerv1.ServiceAllocation
+	for _, alloc := range heliosConfig.Status.Allocations {
+		svc, reason, orphaned := orphanReason(&alloc, services)
+		if !orphaned {
+			keep = append(keep, alloc)
+			continue
+		}
+		for _, ip := range alloc.Addresses() {
+			released = append(released, ReleasedIP{
+				Namespace: alloc.Namespace,
+				Service:   alloc.Name,
+				IP:        ip,
+				Reason:    reason,
+				retyped:   svc,
+			})
+		}
+	}
+	heliosConfig.Status.Allocations = keep
+	return released
+}
+
+// ReleaseOrphaned returns the addresses found by TrimOrphaned to the pool and
+// clears them from the ingress of retyped services. Call it only once the
+// trimmed status has been persisted.
+func (m *IPManager) ReleaseOrphaned(
+	ctx context.Context,
+	logger logr.Logger,
+	heliosConfig *balancerv1.HeliosConfig,
+	released []ReleasedIP,
+) {
+	var retyped []*corev1.Service
+	ingressIPs := make(map[*corev1.Service][]string)
+	for _, rel := range released {
+		m.NetworkMgr.ReleaseIP(rel.IP)
+		m.Metrics.RecordIPAllocation(rel.IP, false)
+		m.Metrics.RecordIPRelease(heliosConfig.Name, heliosConfig.Namespace, rel.Reason)
+		logger.Info("released orphaned IP", LogKeyService, rel.Service, LogKeyNamespace, rel.Namespace,
+			LogKeyIP, rel.IP, "reason", rel.Reason)
+		if rel.retyped == nil {
+			continue
+		}
+		if _, ok := ingressIPs[rel.retyped]; !ok {
+			retyped = append(retyped, rel.retyped)
+		}
+		ingressIPs[rel.retyped] = append(ingressIPs[rel.retyped], rel.IP)
+	}
+	for _, svc := range retyped {
+		m.clearIngress(ctx, logger, svc, ingressIPs[svc])
+	}
+}
+
+// orphanReason reports whether an allocation is orphaned and why. It also
+// returns the service when it still exists but is no longer a Helios
+// LoadBalancer, so its ingress can be cleaned up. A service recreated under
+// the same name has a new UID and counts as deleted.
+func orphanReason(alloc *balancerv1.ServiceAllocation, services []corev1.Service) (*corev1.Service, string, bool) {
+	for i := range services {
+		svc := &services[i]
+		if svc.Namespace != alloc.Namespace || svc.Name != alloc.Name {
+			continue
+		}
+		if alloc.UID != "" && svc.UID != alloc.UID {
+			return nil, ReleaseReasonServiceDeleted, true
+		}
+		if isHeliosLoadBalancer(svc) {
+			return nil, "", false
+		}
+		return svc, ReleaseReasonServiceTypeChanged, true
+	}
+	return nil, ReleaseReasonServiceDeleted, true
+}
+
+// clearIngress removes released IPs from the ingress status of a retyped
+// service, so clients stop being pointed at them. Ingress of LoadBalancer
+// services belongs to whichever load balancer owns their class and is left
+// alone.
+func (m *IPManager) clearIngress(ctx context.Context, logger logr.Logger, svc *corev1.Service, ips []string) {
+	if svc.Spec.Type == corev1.ServiceTypeLoadBalancer {
+		return
+	}
+	ingress := make([]corev1.LoadBalancerIngress, 0, len(svc.Status.LoadBalancer.Ingress))
+	for _, in := range svc.Status.LoadBalancer.Ingress {
+		if !slices.Contains(ips, in.IP) {
+			ingress = append(ingress, in)
+		}
+	}
+	if len(ingress) == len(svc.Status.LoadBalancer.Ingress) {
+		return
+	}
+	svc.Status.LoadBalancer.Ingress = ingress
+	if err := m.Client.Status().Update(ctx, svc); err != nil {
+		logger.Error(err, "failed to clear service ingress",
+			LogKeyService, svc.Name, LogKeyNamespace, svc.Namespace)
+	}
+}
+
 // CheckIPConflicts checks if any IP in this config's ranges (IPv4 and IPv6) is already allocated by another HeliosConfig.
+// Configs referencing the same HeliosIPPool share its ranges by design and are not checked against each other.
 // Returns a map of conflicting IPs to the owning HeliosConfig name, or nil if no conflicts.
 func (m *IPManager) CheckIPConflicts(
 	ctx context.Context,
 	heliosConfig *balancerv1.HeliosConfig,
 ) (map[string]string, error) {
+	ipRange, ipv6Range, err := m.ResolveRanges(ctx, heliosConfig)
+	if err != nil {
+		return nil, err
+	}
+
 	var allConfigs balancerv1.HeliosConfigList
 	if err := m.Client.List(ctx, &allConfigs); err != nil {
 		return nil, fmt.Errorf("failed to list HeliosConfigs: %w", err)
@@ -209,18 +434,21 @@ func (m *IPManager) CheckIPConflicts(
 		if other.Name == heliosConfig.Name && other.Namespace == heliosConfig.Namespace {
 			continue
 		}
-		// Check IPv4 conflicts
-		for svcName, ip := range other.Status.AllocatedIPs {
-			if network.IPInRange(ip, heliosConfig.Spec.IPRange) {
-				conflicts[ip] = fmt.Sprintf("%s/%s (svc: %s)", other.Namespace, other.Name, svcName)
-			}
+		if heliosConfig.Spec.PoolRef != "" && other.Spec.PoolRef == heliosConfig.Spec.PoolRef {
+			continue
 		}
-		// Check IPv6 conflicts
-		if heliosConfig.Spec.IPv6Range != "" {
-			for svcName, ip := range other.Status.AllocatedIPv6s {
-				if network.IPInRange(ip, heliosConfig.Spec.IPv6Range) {
-					conflicts[ip] = fmt.Sprintf("%s/%s (svc: %s, ipv6)", other.Namespace, other.Name, svcName)
-				}
+		// The other config may not have been migrated yet; read it as if it were.
+		status := other.Status.DeepCopy()
+		MigrateLegacyAllocations(status, nil, other.Namespace)
+		for _, alloc := range status.Allocations {
+			svc := alloc.Namespace + "/" + alloc.Name
+			// Check IPv4 conflicts
+			if alloc.IPv4 != "" && network.IPInRange(alloc.IPv4, ipRange) {
+				conflicts[alloc.IPv4] = fmt.Sprintf("%s/%s (svc: %s)", other.Namespace, other.Name, svc)
+			}
+			// Check IPv6 conflicts
+			if alloc.IPv6 != "" && ipv6Range != "" && network.IPInRange(alloc.IPv6, ipv6Range) {
+				conflicts[alloc.IPv6] = fmt.Sprintf("%s/%s (svc: %s, ipv6)", other.Namespace, other.Name, svc)
 			}
 		}
 	}
@@ -234,9 +462,9 @@ func (m *IPManager) CheckIPConflicts(
 // CheckQuota returns an error if the config has reached its max allocations.
 func (m *IPManager) CheckQuota(heliosConfig *balancerv1.HeliosConfig) error {
 	if heliosConfig.Spec.MaxAllocations > 0 &&
-		int32(len(heliosConfig.Status.AllocatedIPs)) >= heliosConfig.Spec.MaxAllocations {
+		int32(len(heliosConfig.Status.Allocations)) >= heliosConfig.Spec.MaxAllocations {
 		return fmt.Errorf("max allocations reached: %d/%d",
-			len(heliosConfig.Status.AllocatedIPs), heliosConfig.Spec.MaxAllocations)
+			len(heliosConfig.Status.Allocations), heliosConfig.Spec.MaxAllocations)
 	}
 	return nil
 }
@@ -0,0 +1,226 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	balancerv1 "github.com/somaz94/helios-lb/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const namePool = "shared-pool"
+
+func testPool() *balancerv1.HeliosIPPool {
+	return &balancerv1.HeliosIPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: namePool},
+		Spec:       balancerv1.HeliosIPPoolSpec{IPRange: ipRangeNarrow},
+	}
+}
+
+func poolHelios(name, namespace string, allocs ...balancerv1.ServiceAllocation) *balancerv1.HeliosConfig {
+	return &balancerv1.HeliosConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       name,
+			Namespace:  namespace,
+			Finalizers: []string{heliosConfigFinalizer},
+		},
+		Spec: balancerv1.HeliosConfigSpec{
+			PoolRef: namePool,
+			Method:  methodRoundRobin,
+		},
+		Status: balancerv1.HeliosConfigStatus{Allocations: allocs},
+	}
+}
+
+func lbService(name, namespace string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeLoadBalancer,
+			Ports: []corev1.ServicePort{{Port: 80}},
+		},
+	}
+}
+
+func newPoolClient(objs ...client.Object) client.Client {
+	return fake.NewClientBuilder().
+		WithScheme(newTestScheme()).
+		WithObjects(objs...).
+		WithStatusSubresource(&balancerv1.HeliosConfig{}, &corev1.Service{}).
+		Build()
+}
+
+func reconcileConfig(t *testing.T, r *HeliosConfigReconciler, name, namespace string) {
+	t.Helper()
+	if _, err := r.Reconcile(context.Background(), reconcile.Request{
+		NamespacedName: types.NamespacedName{Name: name, Namespace: namespace},
+	}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+}
+
+func TestResolveRanges(t *testing.T) {
+	pool := testPool()
+	pool.Spec.IPv6Range = "fd00::1-fd00::ff"
+	m := &IPManager{Client: newPoolClient(pool)}
+	ctx := context.Background()
+
+	inline := allocatedHelios()
+	v4, v6, err := m.ResolveRanges(ctx, inline)
+	if err != nil || v4 != ipRangeNarrow || v6 != "" {
+		t.Errorf("inline ranges = (%q, %q, %v), want (%q, \"\", nil)", v4, v6, err, ipRangeNarrow)
+	}
+
+	v4, v6, err = m.ResolveRanges(ctx, poolHelios(nameHelios1, nsDefault))
+	if err != nil || v4 != ipRangeNarrow || v6 != "fd00::1-fd00::ff" {
+		t.Errorf("pool ranges = (%q, %q, %v), want the pool's ranges", v4, v6, err)
+	}
+
+	missing := poolHelios(nameHelios1, nsDefault)
+	missing.Spec.PoolRef = "no-such-pool"
+	if _, _, err := m.ResolveRanges(ctx, missing); err == nil {
+		t.Error("expected error for a missing pool")
+	}
+}
+
+func TestReconcile_AllocatesFromPool(t *testing.T) {
+	cl := newPoolClient(testPool(), poolHelios(nameHelios1, nsDefault), lbService(nameTestSvc, nsDefault))
+	r := newTestReconciler(cl)
+	reconcileConfig(t, r, nameHelios1, nsDefault)
+
+	var hc balancerv1.HeliosConfig
+	if err := cl.Get(context.Background(), types.NamespacedName{Name: nameHelios1, Namespace: nsDefault}, &hc); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	alloc := hc.Status.FindAllocation(nsDefault, nameTestSvc)
+	if alloc == nil || alloc.IPv4 != testLoadBalancerIP {
+		t.Fatalf("allocation = %+v, want %s from the pool", alloc, testLoadBalancerIP)
+	}
+}
+
+func TestReconcile_SharedPoolAcrossNamespaces(t *testing.T) {
+	// helios-1 in the default namespace already holds the first pool address;
+	// helios-2 in another namespace shares the pool and must not report a
+	// conflict, but take the next free address.
+	holder := poolHelios(nameHelios1, nsDefault, allocation(nameSvcA, testLoadBalancerIP))
+	cl := newPoolClient(testPool(), holder, poolHelios(nameHelios2, nsAllowed), lbService(nameTestSvc, nsAllowed))
+	r := newTestReconciler(cl)
+	reconcileConfig(t, r, nameHelios2, nsAllowed)
+
+	var hc balancerv1.HeliosConfig
+	if err := cl.Get(context.Background(), types.NamespacedName{Name: nameHelios2, Namespace: nsAllowed}, &hc); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if meta.IsStatusConditionTrue(hc.Status.Conditions, balancerv1.ConditionTypeDegraded) {
+		t.Error("configs sharing a pool should not report an IP conflict")
+	}
+	alloc := hc.Status.FindAllocation(nsAllowed, nameTestSvc)
+	if alloc == nil || alloc.IPv4 != "192.168.1.101" {
+		t.Fatalf("allocation = %+v, want 192.168.1.101", alloc)
+	}
+}
+
+// poolFreeGauge reads the helios_ip_pool_free gauge of a config and family.
+func poolFreeGauge(t *testing.T, name, namespace, family string) float64 {
+	t.Helper()
+	families, err := ctrlmetrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	want := map[string]string{"name": name, "namespace": namespace, "family": family}
+	for _, mf := range families {
+		if mf.GetName() != "helios_ip_pool_free" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			matched := 0
+			for _, l := range m.GetLabel() {
+				if want[l.GetName()] == l.GetValue() {
+					matched++
+				}
+			}
+			if matched == len(want) {
+				return m.GetGauge().GetValue()
+			}
+		}
+	}
+	t.Fatalf("no helios_ip_pool_free sample for %s/%s %s", namespace, name, family)
+	return 0
+}
+
+// The free count covers addresses taken by every config sharing the pool.
+func TestReconcile_RecordsFreePoolAddresses(t *testing.T) {
+	pool := testPool()
+	pool.Spec.IPv6Range = "fd00::1-fd00::ff"
+	holder := poolHelios(nameHelios1, nsDefault, allocation(nameSvcA, testLoadBalancerIP))
+	cl := newPoolClient(pool, holder, poolHelios(nameHelios2, nsAllowed), lbService(nameTestSvc, nsAllowed))
+	r := newTestReconciler(cl)
+	reconcileConfig(t, r, nameHelios2, nsAllowed)
+
+	// Eleven IPv4 addresses, one held by helios-1 and one just allocated.
+	if got := poolFreeGauge(t, nameHelios2, nsAllowed, "ipv4"); got != 9 {
+		t.Errorf("free ipv4 = %v, want 9", got)
+	}
+	if got := poolFreeGauge(t, nameHelios2, nsAllowed, "ipv6"); got != 254 {
+		t.Errorf("free ipv6 = %v, want 254", got)
+	}
+}
+
+func TestReconcile_MissingPool(t *testing.T) {
+	cl := newPoolClient(poolHelios(nameHelios1, nsDefault), lbService(nameTestSvc, nsDefault))
+	r := newTestReconciler(cl)
+	reconcileConfig(t, r, nameHelios1, nsDefault)
+
+	var hc balancerv1.HeliosConfig
+	if err := cl.Get(context.Background(), types.NamespacedName{Name: nameHelios1, Namespace: nsDefault}, &hc); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if hc.Status.Phase != balancerv1.StateFailed {
+		t.Errorf("phase = %q, want %q", hc.Status.Phase, balancerv1.StateFailed)
+	}
+	cond := meta.FindStatusCondition(hc.Status.Conditions, balancerv1.ConditionTypeReady)
+	if cond == nil || cond.Reason != balancerv1.ReasonPoolNotFound {
+		t.Errorf("Ready condition = %+v, want reason %s", cond, balancerv1.ReasonPoolNotFound)
+	}
+	if len(hc.Status.Allocations) != 0 {
+		t.Errorf("expected no allocations without a pool, got %v", hc.Status.Allocations)
+	}
+}
+
+func TestCheckIPConflicts_InlineRangeOverlappingPoolAllocations(t *testing.T) {
+	holder := poolHelios(nameHelios1, nsDefault, allocation(nameSvcA, testLoadBalancerIP))
+	inline := allocatedHelios()
+	cl := newPoolClient(testPool(), holder, inline)
+	m := &IPManager{Client: cl}
+
+	conflicts, err := m.CheckIPConflicts(context.Background(), inline)
+	if err != nil {
+		t.Fatalf("CheckIPConflicts() error = %v", err)
+	}
+	if _, ok := conflicts[testLoadBalancerIP]; !ok {
+		t.Errorf("expected conflict on %s, got %v", testLoadBalancerIP, conflicts)
+	}
+}
+
+func TestFindPoolConfigs(t *testing.T) {
+	other := poolHelios(nameHelios2, nsAllowed)
+	other.Spec.PoolRef = "other-pool"
+	cl := newPoolClient(poolHelios(nameHelios1, nsDefault), other, allocatedHelios())
+	r := newTestReconciler(cl)
+
+	requests := r.findPoolConfigs(context.Background(), testPool())
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(requests))
+	}
+	want := types.NamespacedName{Name: nameHelios1, Namespace: nsDefault}
+	if requests[0].NamespacedName != want {
+		t.Errorf("request = %v, want %v", requests[0].NamespacedName, want)
+	}
+}
@@ -18,4 +18,6 @@ const (
 	LogKeyConflictOwner = "conflictOwner"
 	LogKeyIPv6          = "ipv6"
 	LogKeyIPv6Range     = "ipv6Range"
+	LogKeyPool          = "pool"
+	LogKeyDrainLeft     = "drainRemaining"
 )
@@ -0,0 +1,340 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	balancerv1 "github.com/somaz94/helios-lb/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func allocation(name, ipv4 string) balancerv1.ServiceAllocation {
+	return balancerv1.ServiceAllocation{Namespace: nsDefault, Name: name, IPv4: ipv4}
+}
+
+func allocatedHelios(allocs ...balancerv1.ServiceAllocation) *balancerv1.HeliosConfig {
+	return &balancerv1.HeliosConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       nameTestHelios,
+			Namespace:  nsDefault,
+			Finalizers: []string{heliosConfigFinalizer},
+		},
+		Spec: balancerv1.HeliosConfigSpec{
+			IPRange: ipRangeNarrow,
+			Method:  methodRoundRobin,
+		},
+		Status: balancerv1.HeliosConfigStatus{
+			Allocations: allocs,
+			Phase:       balancerv1.StateActive,
+		},
+	}
+}
+
+func serviceWithIngress(name string, svcType corev1.ServiceType, ip string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: nsDefault},
+		Spec: corev1.ServiceSpec{
+			Type:  svcType,
+			Ports: []corev1.ServicePort{{Port: 80}},
+		},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{
+				Ingress: []corev1.LoadBalancerIngress{{IP: ip}},
+			},
+		},
+	}
+}
+
+func reconcileOrphans(t *testing.T, objs ...client.Object) (*HeliosConfigReconciler, client.Client) {
+	t.Helper()
+	cl := fake.NewClientBuilder().
+		WithScheme(newTestScheme()).
+		WithObjects(objs...).
+		WithStatusSubresource(&balancerv1.HeliosConfig{}, &corev1.Service{}).
+		Build()
+	r := newTestReconciler(cl)
+	if _, err := r.Reconcile(context.Background(), reconcile.Request{
+		NamespacedName: types.NamespacedName{Name: nameTestHelios, Namespace: nsDefault},
+	}); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	return r, cl
+}
+
+func releasedEvents(r *HeliosConfigReconciler) []string {
+	recorder := r.Recorder.(*record.FakeRecorder)
+	var events []string
+	for {
+		select {
+		case e := <-recorder.Events:
+			if strings.Contains(e, "IPReleased") {
+				events = append(events, e)
+			}
+		default:
+			return events
+		}
+	}
+}
+
+func TestReconcile_ReleasesIPOfDeletedService(t *testing.T) {
+	helios := allocatedHelios(allocation(nameSvc1, "192.168.1.100"), allocation(nameSvcA, "192.168.1.101"))
+	live := serviceWithIngress(nameSvcA, corev1.ServiceTypeLoadBalancer, "192.168.1.101")
+	r, cl := reconcileOrphans(t, helios, live)
+
+	var updated balancerv1.HeliosConfig
+	if err := cl.Get(context.Background(), client.ObjectKeyFromObject(helios), &updated); err != nil {
+		t.Fatalf("get HeliosConfig: %v", err)
+	}
+	if updated.Status.FindAllocation(nsDefault, nameSvc1) != nil {
+		t.Errorf("allocations = %v, want the deleted service's entry removed", updated.Status.Allocations)
+	}
+	if a := updated.Status.FindAllocation(nsDefault, nameSvcA); a == nil || a.IPv4 != "192.168.1.101" {
+		t.Errorf("allocations = %v, want the live service kept", updated.Status.Allocations)
+	}
+	events := releasedEvents(r)
+	if len(events) != 1 || !strings.Contains(events[0], "192.168.1.100") || !strings.Contains(events[0], ReleaseReasonServiceDeleted) {
+		t.Errorf("events = %v, want one IPReleased event for the deleted service", events)
+	}
+}
+
+func TestReconcile_ReleasesIPOfServiceNoLongerLoadBalancer(t *testing.T) {
+	dualStack := allocation(nameSvc1, "192.168.1.100")
+	dualStack.IPv6 = "fd00::1"
+	helios := allocatedHelios(dualStack)
+	svc := serviceWithIngress(nameSvc1, corev1.ServiceTypeClusterIP, "192.168.1.100")
+	r, cl := reconcileOrphans(t, helios, svc)
+
+	var updated balancerv1.HeliosConfig
+	if err := cl.Get(context.Background(), client.ObjectKeyFromObject(helios), &updated); err != nil {
+		t.Fatalf("get HeliosConfig: %v", err)
+	}
+	if len(updated.Status.Allocations) != 0 {
+		t.Errorf("allocations = %v, want both families released", updated.Status.Allocations)
+	}
+	var updatedSvc corev1.Service
+	if err := cl.Get(context.Background(), client.ObjectKeyFromObject(svc), &updatedSvc); err != nil {
+		t.Fatalf("get service: %v", err)
+	}
+	if len(updatedSvc.Status.LoadBalancer.Ingress) != 0 {
+		t.Errorf("ingress = %v, want the released IP cleared", updatedSvc.Status.LoadBalancer.Ingress)
+	}
+	if events := releasedEvents(r); len(events) != 2 {
+		t.Errorf("events = %v, want one IPReleased event per address", events)
+	}
+}
+
+// A trimmed address stays reserved until ReleaseOrphaned returns it to the
+// allocator, after which it can be handed out again.
+func TestReleaseOrphaned_ReturnsIPToPool(t *testing.T) {
+	m := newTestIPManager()
+	ip, err := m.NetworkMgr.AllocateIP(ipRangeNarrow)
+	if err != nil {
+		t.Fatalf("AllocateIP() error = %v", err)
+	}
+	helios := allocatedHelios(allocation(nameSvc1, ip))
+
+	released := m.TrimOrphaned(helios, nil)
+	if len(released) != 1 || released[0].IP != ip || released[0].Reason != ReleaseReasonServiceDeleted {
+		t.Fatalf("TrimOrphaned() = %+v", released)
+	}
+	if free, _ := m.NetworkMgr.FreeCount(ipRangeNarrow); free.Int64() != 10 {
+		t.Fatalf("FreeCount() after trim = %v, want the address still reserved", free)
+	}
+
+	m.ReleaseOrphaned(context.Background(), ctrl.Log.WithName("test"), helios, released)
+	if again, err := m.NetworkMgr.AllocateIP(ipRangeNarrow); err != nil || again != ip {
+		t.Errorf("AllocateIP() after release = %q, %v; want %q", again, err, ip)
+	}
+}
+
+// When the trimmed status cannot be stored, the stored status still assigns
+// the address, so the allocator must not hand it out.
+func TestReconcile_KeepsOrphanedIPWhenStatusUpdateFails(t *testing.T) {
+	helios := allocatedHelios(allocation(nameSvc1, "192.168.1.100"))
+	cl := fake.NewClientBuilder().
+		WithScheme(newTestScheme()).
+		WithObjects(helios).
+		WithStatusSubresource(&balancerv1.HeliosConfig{}, &corev1.Service{}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+				return fmt.Errorf("status update error")
+			},
+		}).
+		Build()
+	r := newTestReconciler(cl)
+	r.NetworkMgr.MarkUsed("192.168.1.100")
+
+	if _, err := r.Reconcile(context.Background(), reconcile.Request{
+		NamespacedName: types.NamespacedName{Name: nameTestHelios, Namespace: nsDefault},
+	}); err == nil {
+		t.Fatal("Reconcile() error = nil, want the status update error")
+	}
+	if free, _ := r.NetworkMgr.FreeCount(ipRangeNarrow); free.Int64() != 10 {
+		t.Errorf("FreeCount() = %v, want the address still reserved", free)
+	}
+	if events := releasedEvents(r); len(events) != 0 {
+		t.Errorf("events = %v, want none before the release is stored", events)
+	}
+}
+
+func TestTrimOrphaned_ServiceOfAnotherClass(t *testing.T) {
+	other := serviceWithIngress(nameSvc1, corev1.ServiceTypeLoadBalancer, "192.168.1.100")
+	other.Spec.LoadBalancerClass = ptr.To("other-lb")
+	helios := allocatedHelios(allocation(nameSvc1, "192.168.1.100"))
+
+	released := newTestIPManager().TrimOrphaned(helios, []corev1.Service{*other})
+	if len(released) != 1 || released[0].Reason != ReleaseReasonServiceTypeChanged {
+		t.Errorf("TrimOrphaned() = %+v, want the allocation released", released)
+	}
+}
+
+// Services of the same name in different namespaces keep separate allocations.
+func TestTrimOrphaned_MatchesNamespace(t *testing.T) {
+	other := allocation(nameSvc1, "192.168.1.101")
+	other.Namespace = nsAllowed
+	helios := allocatedHelios(allocation(nameSvc1, "192.168.1.100"), other)
+	live := serviceWithIngress(nameSvc1, corev1.ServiceTypeLoadBalancer, "192.168.1.101")
+	live.Namespace = nsAllowed
+
+	released := newTestIPManager().TrimOrphaned(helios, []corev1.Service{*live})
+	if len(released) != 1 || released[0].Namespace != nsDefault || released[0].IP != "192.168.1.100" {
+		t.Errorf("TrimOrphaned() = %+v, want only the default namespace allocation", released)
+	}
+	if len(helios.Status.Allocations) != 1 || helios.Status.Allocations[0].Namespace != nsAllowed {
+		t.Errorf("allocations = %+v", helios.Status.Allocations)
+	}
+}
+
+// A service recreated under the same name is a new service.
+func TestTrimOrphaned_RecreatedService(t *testing.T) {
+	alloc := allocation(nameSvc1, "192.168.1.100")
+	alloc.UID = "old-uid"
+	helios := allocatedHelios(alloc)
+	recreated := serviceWithIngress(nameSvc1, corev1.ServiceTypeLoadBalancer, "")
+	recreated.UID = "new-uid"
+
+	released := newTestIPManager().TrimOrphaned(helios, []corev1.Service{*recreated})
+	if len(released) != 1 || released[0].Reason != ReleaseReasonServiceDeleted {
+		t.Errorf("TrimOrphaned() = %+v, want the old allocation released", released)
+	}
+}
+
+func TestFindLoadBalancerServices_EnqueuesOwnerOfRetypedService(t *testing.T) {
+	helios := allocatedHelios(allocation(nameSvc1, "192.168.1.100"))
+	unrelated := allocatedHelios()
+	unrelated.Name = nameHelios2
+	cl := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(helios, unrelated).Build()
+	r := newTestReconciler(cl)
+
+	svc := serviceWithIngress(nameSvc1, corev1.ServiceTypeClusterIP, "192.168.1.100")
+	requests := r.findLoadBalancerServices(context.Background(), svc)
+	if len(requests) != 1 || requests[0].Name != nameTestHelios {
+		t.Errorf("requests = %v, want only the config holding the allocation", requests)
+	}
+}
+
+func TestLoadBalancerServiceChanged(t *testing.T) {
+	p := loadBalancerServiceChanged()
+	lb := serviceWithIngress(nameSvc1, corev1.ServiceTypeLoadBalancer, "192.168.1.100")
+	clusterIP := serviceWithIngress(nameSvc1, corev1.ServiceTypeClusterIP, "")
+
+	if !p.Delete(event.DeleteEvent{Object: lb}) {
+		t.Error("deleting a LoadBalancer service should pass")
+	}
+	if !p.Update(event.UpdateEvent{ObjectOld: lb, ObjectNew: clusterIP}) {
+		t.Error("a LoadBalancer service changing type should pass")
+	}
+	if p.Update(event.UpdateEvent{ObjectOld: clusterIP, ObjectNew: clusterIP}) {
+		t.Error("ClusterIP service updates should be filtered")
+	}
+}
+
+// ReleaseAll clears ingress in the service's own namespace, not the config's.
+func TestReleaseAll_ClearsIngressInServiceNamespace(t *testing.T) {
+	alloc := allocation(nameSvc1, "192.168.1.100")
+	alloc.Namespace = nsAllowed
+	helios := allocatedHelios(alloc)
+	svc := serviceWithIngress(nameSvc1, corev1.ServiceTypeLoadBalancer, "192.168.1.100")
+	svc.Namespace = nsAllowed
+	cl := fake.NewClientBuilder().
+		WithScheme(newTestScheme()).
+		WithObjects(helios, svc).
+		WithStatusSubresource(&balancerv1.HeliosConfig{}, &corev1.Service{}).
+		Build()
+	r := newTestReconciler(cl)
+
+	r.IPMgr.ReleaseAll(context.Background(), ctrl.Log.WithName("test"), helios)
+
+	var updated corev1.Service
+	if err := cl.Get(context.Background(), client.ObjectKeyFromObject(svc), &updated); err != nil {
+		t.Fatalf("get service: %v", err)
+	}
+	if len(updated.Status.LoadBalancer.Ingress) != 0 {
+		t.Errorf("ingress = %v, want cleared", updated.Status.LoadBalancer.Ingress)
+	}
+}
+
+// A service recreated under the same name keeps its ingress when the config
+// holding the old service's allocation is deleted.
+func TestReleaseAll_SkipsRecreatedService(t *testing.T) {
+	alloc := allocation(nameSvc1, "192.168.1.100")
+	alloc.UID = "old-uid"
+	helios := allocatedHelios(alloc)
+	svc := serviceWithIngress(nameSvc1, corev1.ServiceTypeLoadBalancer, "10.0.0.1")
+	svc.UID = "new-uid"
+	cl := fake.NewClientBuilder().
+		WithScheme(newTestScheme()).
+		WithObjects(helios, svc).
+		WithStatusSubresource(&balancerv1.HeliosConfig{}, &corev1.Service{}).
+		Build()
+	r := newTestReconciler(cl)
+
+	r.IPMgr.ReleaseAll(context.Background(), ctrl.Log.WithName("test"), helios)
+
+	var updated corev1.Service
+	if err := cl.Get(context.Background(), client.ObjectKeyFromObject(svc), &updated); err != nil {
+		t.Fatalf("get service: %v", err)
+	}
+	if len(updated.Status.LoadBalancer.Ingress) != 1 || updated.Status.LoadBalancer.Ingress[0].IP != "10.0.0.1" {
+		t.Errorf("ingress = %v, want the recreated service's ingress kept", updated.Status.LoadBalancer.Ingress)
+	}
+}
+
+func TestMigrateLegacyAllocations(t *testing.T) {
+	svc := serviceWithIngress("web", corev1.ServiceTypeLoadBalancer, "192.168.1.100")
+	svc.Namespace = nsAllowed
+	svc.UID = "web-uid"
+	status := balancerv1.HeliosConfigStatus{
+		AllocatedIPs:   map[string]string{"web": "192.168.1.100", "gone": "192.168.1.101"},
+		AllocatedIPv6s: map[string]string{"web": "fd00::100"},
+	}
+
+	if !MigrateLegacyAllocations(&status, []corev1.Service{*svc}, nsDefault) {
+		t.Fatal("MigrateLegacyAllocations() = false, want true")
+	}
+	if status.AllocatedIPs != nil || status.AllocatedIPv6s != nil {
+		t.Errorf("legacy maps = %v / %v, want cleared", status.AllocatedIPs, status.AllocatedIPv6s)
+	}
+	web := status.FindAllocation(nsAllowed, "web")
+	if web == nil || web.UID != "web-uid" || web.IPv4 != "192.168.1.100" || web.IPv6 != "fd00::100" {
+		t.Errorf("web allocation = %+v, want it matched to the service reporting the address", web)
+	}
+	if gone := status.FindAllocation(nsDefault, "gone"); gone == nil || gone.IPv4 != "192.168.1.101" {
+		t.Errorf("gone allocation = %+v, want it kept in the config namespace", gone)
+	}
+	if MigrateLegacyAllocations(&status, nil, nsDefault) {
+		t.Error("second migration reported work to do")
+	}
+}
@@ -16,10 +16,7 @@ func FilterEligibleServices(services []corev1.Service, namespaceSelector []strin
 
 	var result []corev1.Service
 	for _, svc := range services {
-		if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
-			continue
-		}
-		if svc.Spec.LoadBalancerClass != nil && *svc.Spec.LoadBalancerClass != v1.LoadBalancerClassHelios {
+		if !isHeliosLoadBalancer(&svc) {
 			continue
 		}
 		if len(nsAllowed) > 0 && !nsAllowed[svc.Namespace] {
@@ -44,3 +41,12 @@ func FilterEligibleServices(services []corev1.Service, namespaceSelector []strin
 	}
 	return result
 }
+
+// isHeliosLoadBalancer reports whether Helios LB is responsible for the service:
+// a LoadBalancer service without a class or with the Helios class.
+func isHeliosLoadBalancer(svc *corev1.Service) bool {
+	if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return false
+	}
+	return svc.Spec.LoadBalancerClass == nil || *svc.Spec.LoadBalancerClass == v1.LoadBalancerClassHelios
+}
@@ -7,7 +7,6 @@ import (
 	"time"
 
 	balancerv1 "github.com/somaz94/helios-lb/api/v1"
-	"github.com/somaz94/helios-lb/internal/loadbalancer"
 	"github.com/somaz94/helios-lb/internal/metrics"
 	"github.com/somaz94/helios-lb/internal/network"
 	corev1 "k8s.io/api/core/v1"
@@ -26,7 +25,7 @@ import (
 
 // testLoadBalancerIP is the LoadBalancer VIP these tests exercise end to end:
 // it is the sole address of the HeliosConfig IPRange, the Service's requested
-// LoadBalancerIP, and the value expected in AllocatedIPs and ingress status.
+// LoadBalancerIP, and the value expected in the allocations and ingress status.
 const testLoadBalancerIP = "192.168.1.100"
 
 func newTestScheme() *runtime.Scheme {
@@ -43,10 +42,7 @@ func newTestReconciler(cl client.Client) *HeliosConfigReconciler {
 		Client:     cl,
 		Scheme:     newTestScheme(),
 		NetworkMgr: networkMgr,
-		Balancer: loadbalancer.NewLoadBalancer(loadbalancer.BalancerConfig{
-			Type: loadbalancer.RoundRobin,
-		}),
-		Metrics: metricsRecorder,
+		Metrics:    metricsRecorder,
 		IPMgr: &IPManager{
 			Client:     cl,
 			NetworkMgr: networkMgr,
@@ -426,6 +422,56 @@ func TestHandleDeletion_WithAllocatedIPs(t *testing.T) {
 	}
 }
 
+func TestHandleDeletion_WaitsForDrain(t *testing.T) {
+	scheme := newTestScheme()
+	now := metav1.Now()
+	helios := &balancerv1.HeliosConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              nameTestHelios,
+			Namespace:         nsDefault,
+			Finalizers:        []string{heliosConfigFinalizer},
+			DeletionTimestamp: &now,
+		},
+		Spec: balancerv1.HeliosConfigSpec{
+			IPRange:             testLoadBalancerIP,
+			Method:              methodRoundRobin,
+			DrainTimeoutSeconds: 30,
+		},
+		Status: balancerv1.HeliosConfigStatus{
+			Allocations: []balancerv1.ServiceAllocation{{Namespace: nsDefault, Name: nameSvc1, IPv4: testLoadBalancerIP}},
+			Phase:       balancerv1.StateActive,
+		},
+	}
+
+	cl := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(helios).
+		WithStatusSubresource(&balancerv1.HeliosConfig{}).
+		Build()
+	r := newTestReconciler(cl)
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: nameTestHelios, Namespace: nsDefault}}
+
+	result, err := r.Reconcile(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.RequeueAfter <= 0 || result.RequeueAfter > 30*time.Second {
+		t.Errorf("expected a requeue within the drain timeout, got %v", result.RequeueAfter)
+	}
+	var draining balancerv1.HeliosConfig
+	if err := cl.Get(context.Background(), req.NamespacedName, &draining); err != nil {
+		t.Fatalf("failed to get HeliosConfig: %v", err)
+	}
+	if !controllerutil.ContainsFinalizer(&draining, heliosConfigFinalizer) || len(draining.Status.Allocations) != 1 {
+		t.Errorf("finalizers = %v, allocations = %v; want both kept while draining",
+			draining.Finalizers, draining.Status.Allocations)
+	}
+
+	if remaining := drainRemaining(&draining, now.Add(31*time.Second)); remaining > 0 {
+		t.Errorf("drainRemaining() after the timeout = %v, want none", remaining)
+	}
+}
+
 func TestHandleDeletion_RemoveFinalizerError(t *testing.T) {
 	scheme := newTestScheme()
 	now := metav1.Now()
@@ -495,7 +541,7 @@ func TestHandleDeletion_NoFinalizer(t *testing.T) {
 		Build()
 	r := newTestReconciler(cl)
 
-	if err := r.handleDeletion(context.Background(), helios); err != nil {
+	if _, err := r.handleDeletion(context.Background(), helios); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
@@ -1296,8 +1342,8 @@ func TestReconcile_MaxAllocations(t *testing.T) {
 		t.Fatalf("failed to get helios config: %v", err)
 	}
 
-	if len(updatedConfig.Status.AllocatedIPs) > 1 {
-		t.Errorf("expected at most 1 allocation due to maxAllocations, got %d", len(updatedConfig.Status.AllocatedIPs))
+	if len(updatedConfig.Status.Allocations) > 1 {
+		t.Errorf("expected at most 1 allocation due to maxAllocations, got %d", len(updatedConfig.Status.Allocations))
 	}
 }
 
@@ -1760,11 +1806,18 @@ func TestReconcile_DualStack(t *testing.T) {
 	if getErr := cl.Get(context.Background(), types.NamespacedName{Name: nameTestHelios, Namespace: nsDefault}, &updated); getErr != nil {
 		t.Fatalf("failed to get HeliosConfig: %v", getErr)
 	}
-	if updated.Status.AllocatedIPs[nameTestSvc] != testSingleIP {
-		t.Errorf("expected IPv4 172.16.0.100, got %s", updated.Status.AllocatedIPs[nameTestSvc])
+	alloc := updated.Status.FindAllocation(nsDefault, nameTestSvc)
+	if alloc == nil {
+		t.Fatalf("expected an allocation for %s/%s, got %v", nsDefault, nameTestSvc, updated.Status.Allocations)
+	}
+	if alloc.IPv4 != testSingleIP {
+		t.Errorf("expected IPv4 172.16.0.100, got %s", alloc.IPv4)
+	}
+	if alloc.IPv6 != "fd00::100" {
+		t.Errorf("expected IPv6 fd00::100, got %s", alloc.IPv6)
 	}
-	if updated.Status.AllocatedIPv6s[nameTestSvc] != "fd00::100" {
-		t.Errorf("expected IPv6 fd00::100, got %s", updated.Status.AllocatedIPv6s[nameTestSvc])
+	if alloc.AllocatedAt.IsZero() {
+		t.Error("expected allocatedAt to be set")
 	}
 
 	// Verify service has dual-stack ingress
@@ -2,9 +2,11 @@ package loadbalancer
 
 import (
 	"hash/fnv"
+	"math"
 	"math/rand"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // Compile-time interface checks
@@ -14,6 +16,8 @@ var (
 	_ Algorithm = (*weightedRoundRobinAlgorithm)(nil)
 	_ Algorithm = (*ipHashAlgorithm)(nil)
 	_ Algorithm = (*randomAlgorithm)(nil)
+	_ Algorithm = (*p2cAlgorithm)(nil)
+	_ Algorithm = (*leastResponseTimeAlgorithm)(nil)
 )
 
 // NewAlgorithm creates an Algorithm implementation for the given type.
@@ -27,11 +31,34 @@ func NewAlgorithm(balancerType BalancerType, weights []Weight) Algorithm {
 		return &ipHashAlgorithm{}
 	case RandomSelection:
 		return &randomAlgorithm{}
+	case Maglev:
+		return &maglevAlgorithm{}
+	case RingHash:
+		return &ringHashAlgorithm{}
+	case P2C:
+		return &p2cAlgorithm{}
+	case LeastResponseTime:
+		return &leastResponseTimeAlgorithm{}
 	default:
 		return &roundRobinAlgorithm{}
 	}
 }
 
+// newAlgorithm creates the algorithm of config. The algorithms that honor
+// slow start get its window.
+func newAlgorithm(config BalancerConfig) Algorithm {
+	algorithm := NewAlgorithm(config.Type, config.Weights)
+	switch a := algorithm.(type) {
+	case *weightedRoundRobinAlgorithm:
+		a.slowStart = config.SlowStart
+	case *leastConnectionAlgorithm:
+		a.slowStart = config.SlowStart
+	case *randomAlgorithm:
+		a.slowStart = config.SlowStart
+	}
+	return algorithm
+}
+
 // NextBackend returns the next backend server using the configured algorithm.
 func (lb *LoadBalancer) NextBackend(serviceName string, clientIP string) *Backend {
 	lb.mu.RLock()
@@ -43,9 +70,10 @@ func (lb *LoadBalancer) NextBackend(serviceName string, clientIP string) *Backen
 
 	backendsCopy := make([]*Backend, len(backends))
 	copy(backendsCopy, backends)
+	algorithm := lb.algorithm
 	lb.mu.RUnlock()
 
-	return lb.algorithm.Select(backendsCopy, serviceName, clientIP)
+	return algorithm.Select(backendsCopy, serviceName, clientIP)
 }
 
 // --- RoundRobin ---
@@ -70,23 +98,46 @@ func (a *roundRobinAlgorithm) Select(backends []*Backend, serviceName string, _
 	state := a.states[serviceName]
 	a.mu.Unlock()
 
-	index := atomic.AddUint32(&state.current, 1) % uint32(len(backends))
-	return backends[index]
+	// Start at the next position and skip backends that are unhealthy or
+	// ejected, so the rotation moves on to the next one that can serve.
+	start := atomic.AddUint32(&state.current, 1)
+	for i := range uint32(len(backends)) {
+		if backend := backends[(start+i)%uint32(len(backends))]; backend.Available() {
+			return backend
+		}
+	}
+	return nil
 }
 
 // --- LeastConnection ---
 
-type leastConnectionAlgorithm struct{}
+// leastConnectionAlgorithm picks the backend with the fewest connections.
+// During slow start a backend's connections, plus the one it would take on,
+// count as many times more as its ramp is short of full, so an idle backend
+// that just came up does not draw every new connection at once.
+type leastConnectionAlgorithm struct {
+	slowStart time.Duration
+}
 
 func (a *leastConnectionAlgorithm) Select(backends []*Backend, _ string, _ string) *Backend {
 	var leastConnBackend *Backend
 	leastConn := int32(^uint32(0) >> 1)
+	leastLoad := math.Inf(1)
+	now := time.Now()
 
 	for _, backend := range backends {
-		if !backend.IsHealthy() {
+		if !backend.Available() {
 			continue
 		}
 		connections := atomic.LoadInt32(&backend.Connections)
+		if a.slowStart > 0 {
+			load := float64(connections+1) / backend.slowStartFactor(a.slowStart, now)
+			if load < leastLoad {
+				leastLoad = load
+				leastConnBackend = backend
+			}
+			continue
+		}
 		if connections < leastConn {
 			leastConn = connections
 			leastConnBackend = backend
@@ -98,10 +149,28 @@ func (a *leastConnectionAlgorithm) Select(backends []*Backend, _ string, _ strin
 
 // --- WeightedRoundRobin ---
 
+// weightedRoundRobinAlgorithm implements smooth weighted round robin, as
+// nginx does: on every pick each backend's current weight grows by its
+// weight, the backend with the highest current weight wins and gives back the
+// total. Over a cycle every backend is picked in proportion to its weight,
+// interleaved rather than in runs, so weights 5:1:1 give a a b a c a a
+// instead of a a a a a b c.
 type weightedRoundRobinAlgorithm struct {
 	mu      sync.Mutex
-	states  map[string]*RoundRobinState
+	states  map[string]*smoothWeightedState
 	weights []Weight
+	// slowStart scales the weights of backends still ramping up.
+	slowStart time.Duration
+}
+
+// slowStartScale multiplies weights under slow start, so the weight of a
+// ramping backend can be a fraction of a small configured weight.
+const slowStartScale = 100
+
+// smoothWeightedState holds the current weights of one service's backends.
+type smoothWeightedState struct {
+	mu      sync.Mutex
+	current map[*Backend]int
 }
 
 func (a *weightedRoundRobinAlgorithm) Select(backends []*Backend, serviceName string, _ string) *Backend {
@@ -111,59 +180,76 @@ func (a *weightedRoundRobinAlgorithm) Select(backends []*Backend, serviceName st
 
 	a.mu.Lock()
 	if a.states == nil {
-		a.states = make(map[string]*RoundRobinState)
+		a.states = make(map[string]*smoothWeightedState)
 	}
-	if _, exists := a.states[serviceName]; !exists {
-		a.states[serviceName] = &RoundRobinState{}
+	state, exists := a.states[serviceName]
+	if !exists {
+		state = &smoothWeightedState{current: make(map[*Backend]int)}
+		a.states[serviceName] = state
 	}
-	state := a.states[serviceName]
 	a.mu.Unlock()
 
-	healthyBackends := make([]*Backend, 0, len(backends))
-	weights := make([]int, 0, len(backends))
-	totalWeight := 0
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	var best *Backend
+	totalWeight, healthy := 0, 0
+	now := time.Now()
 	for _, backend := range backends {
-		if backend.IsHealthy() {
-			w := a.resolveWeight(backend)
-			healthyBackends = append(healthyBackends, backend)
-			weights = append(weights, w)
-			totalWeight += w
+		if !backend.Available() {
+			continue
+		}
+		w := a.resolveWeight(backend)
+		if a.slowStart > 0 {
+			// Scaled up first so a ramping weight keeps its precision.
+			w = max(1, int(float64(w*slowStartScale)*backend.slowStartFactor(a.slowStart, now)))
+		}
+		state.current[backend] += w
+		totalWeight += w
+		healthy++
+		if best == nil || state.current[backend] > state.current[best] {
+			best = backend
 		}
 	}
 
-	if totalWeight == 0 {
+	if best == nil {
 		return nil
 	}
-
-	current := atomic.AddUint32(&state.current, 1)
-	point := int(current) % totalWeight
-
-	for i, backend := range healthyBackends {
-		point -= weights[i]
-		if point < 0 {
-			return backend
+	state.current[best] -= totalWeight
+
+	// Forget backends that left the pool or turned unhealthy, so they
+	// rejoin with a clean slate rather than a stale credit or debt.
+	if len(state.current) > healthy {
+		present := make(map[*Backend]bool, healthy)
+		for _, backend := range backends {
+			if backend.Available() {
+				present[backend] = true
+			}
+		}
+		for backend := range state.current {
+			if !present[backend] {
+				delete(state.current, backend)
+			}
 		}
 	}
 
-	return healthyBackends[0]
+	return best
 }
 
-// resolveWeight returns the effective weight for the backend's service,
-// using the configured weight when present and defaulting to 1 when zero.
-// It does not mutate the backend, keeping Select safe for concurrent use
-// on shared backend pointers.
+// resolveWeight returns the effective weight of a backend: its own weight
+// when set, else the configured weight of its service, else 1. It does not
+// mutate the backend, keeping Select safe for concurrent use on shared
+// backend pointers.
 func (a *weightedRoundRobinAlgorithm) resolveWeight(backend *Backend) int {
-	w := backend.Weight
+	if backend.Weight > 0 {
+		return backend.Weight
+	}
 	for _, weight := range a.weights {
-		if weight.ServiceName == backend.ServiceName {
-			w = weight.Weight
-			break
+		if weight.ServiceName == backend.ServiceName && weight.Weight > 0 {
+			return weight.Weight
 		}
 	}
-	if w == 0 {
-		w = 1
-	}
-	return w
+	return 1
 }
 
 // --- IPHash ---
@@ -181,7 +267,7 @@ func (a *ipHashAlgorithm) Select(backends []*Backend, _ string, clientIP string)
 
 	healthyBackends := make([]*Backend, 0, len(backends))
 	for _, backend := range backends {
-		if backend.IsHealthy() {
+		if backend.Available() {
 			healthyBackends = append(healthyBackends, backend)
 		}
 	}
@@ -195,7 +281,11 @@ func (a *ipHashAlgorithm) Select(backends []*Backend, _ string, clientIP string)
 
 // --- Random ---
 
-type randomAlgorithm struct{}
+// randomAlgorithm picks a backend at random. During slow start each backend
+// is picked in proportion to how far its ramp has come.
+type randomAlgorithm struct {
+	slowStart time.Duration
+}
 
 func (a *randomAlgorithm) Select(backends []*Backend, _ string, _ string) *Backend {
 	if len(backends) == 0 {
@@ -204,7 +294,7 @@ func (a *randomAlgorithm) Select(backends []*Backend, _ string, _ string) *Backe
 
 	healthyBackends := make([]*Backend, 0, len(backends))
 	for _, backend := range backends {
-		if backend.IsHealthy() {
+		if backend.Available() {
 			healthyBackends = append(healthyBackends, backend)
 		}
 	}
@@ -212,6 +302,123 @@ func (a *randomAlgorithm) Select(backends []*Backend, _ string, _ string) *Backe
 	if len(healthyBackends) == 0 {
 		return nil
 	}
+	if a.slowStart > 0 {
+		return pickBySlowStart(healthyBackends, a.slowStart)
+	}
 
 	return healthyBackends[rand.Intn(len(healthyBackends))]
 }
+
+// pickBySlowStart picks one of backends at random, each in proportion to
+// its slow start factor.
+func pickBySlowStart(backends []*Backend, window time.Duration) *Backend {
+	now := time.Now()
+	factors := make([]float64, len(backends))
+	total := 0.0
+	for i, backend := range backends {
+		factors[i] = backend.slowStartFactor(window, now)
+		total += factors[i]
+	}
+	r := rand.Float64() * total
+	for i, f := range factors {
+		if r < f {
+			return backends[i]
+		}
+		r -= f
+	}
+	return backends[len(backends)-1]
+}
+
+// --- P2C ---
+
+// p2cSampleAttempts bounds how many random indices P2C draws for one choice
+// before it falls back to scanning the backends.
+const p2cSampleAttempts = 8
+
+// p2cAlgorithm implements the power of two choices: it samples two distinct
+// healthy backends and picks the one with fewer connections. It avoids the
+// full scan of LeastConnection while staying close to its spread, and the
+// randomness keeps concurrent selections from piling onto the same backend.
+type p2cAlgorithm struct{}
+
+func (a *p2cAlgorithm) Select(backends []*Backend, _ string, _ string) *Backend {
+	i := sampleAvailable(backends, -1)
+	if i < 0 {
+		return nil
+	}
+	j := sampleAvailable(backends, i)
+	if j < 0 {
+		return backends[i]
+	}
+
+	first, second := backends[i], backends[j]
+	if atomic.LoadInt32(&second.Connections) < atomic.LoadInt32(&first.Connections) {
+		return second
+	}
+	return first
+}
+
+// sampleAvailable returns the index of a random available backend other than
+// skip, or -1 if there is none; skip is -1 to consider every backend. It draws
+// indices straight from backends and only scans them when p2cSampleAttempts
+// draws in a row hit unavailable backends, so the common case allocates
+// nothing and does not touch every backend.
+func sampleAvailable(backends []*Backend, skip int) int {
+	n := len(backends)
+	if skip >= 0 {
+		n--
+	}
+	if n <= 0 {
+		return -1
+	}
+	for range p2cSampleAttempts {
+		k := rand.Intn(n)
+		if skip >= 0 && k >= skip {
+			k++
+		}
+		if backends[k].Available() {
+			return k
+		}
+	}
+
+	// Most backends are unavailable. Reservoir sampling keeps the pick
+	// uniform over the available ones in a single pass.
+	picked, seen := -1, 0
+	for k, backend := range backends {
+		if k == skip || !backend.Available() {
+			continue
+		}
+		seen++
+		if rand.Intn(seen) == 0 {
+			picked = k
+		}
+	}
+	return picked
+}
+
+// --- LeastResponseTime ---
+
+// leastResponseTimeAlgorithm picks the healthy backend with the lowest
+// average latency weighted by its open connections, the time a new
+// connection can expect to wait behind the ones already there. Backends
+// without a latency observation yet are picked first, so they get measured.
+type leastResponseTimeAlgorithm struct{}
+
+func (a *leastResponseTimeAlgorithm) Select(backends []*Backend, _ string, _ string) *Backend {
+	var best *Backend
+	var bestScore int64
+	var bestConns int32
+
+	for _, backend := range backends {
+		if !backend.Available() {
+			continue
+		}
+		connections := atomic.LoadInt32(&backend.Connections)
+		score := int64(backend.Latency()) * int64(connections+1)
+		if best == nil || score < bestScore || (score == bestScore && connections < bestConns) {
+			best, bestScore, bestConns = backend, score, connections
+		}
+	}
+
+	return best
+}
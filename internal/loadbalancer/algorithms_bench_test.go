@@ -87,6 +87,24 @@ func BenchmarkIPHash(b *testing.B) {
 	}
 }
 
+func BenchmarkConsistentHash(b *testing.B) {
+	for name, newAlgo := range map[string]func() Algorithm{
+		"Maglev":   func() Algorithm { return &maglevAlgorithm{} },
+		"RingHash": func() Algorithm { return &ringHashAlgorithm{} },
+	} {
+		for _, n := range []int{3, 10, 100} {
+			b.Run(fmt.Sprintf("%s/backends-%d", name, n), func(b *testing.B) {
+				algo := newAlgo()
+				backends := newBenchBackends(n)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					algo.Select(backends, benchServiceName, fmt.Sprintf("192.168.1.%d", i%256))
+				}
+			})
+		}
+	}
+}
+
 func BenchmarkRandom(b *testing.B) {
 	for _, n := range []int{3, 10, 100} {
 		b.Run(fmt.Sprintf("backends-%d", n), func(b *testing.B) {
@@ -110,6 +128,10 @@ func BenchmarkAllAlgorithms_Parallel(b *testing.B) {
 		"WeightedRoundRobin": &weightedRoundRobinAlgorithm{weights: []Weight{{ServiceName: benchServiceName, Weight: 1}}},
 		"IPHash":             &ipHashAlgorithm{},
 		"Random":             &randomAlgorithm{},
+		"Maglev":             &maglevAlgorithm{},
+		"RingHash":           &ringHashAlgorithm{},
+		"P2C":                &p2cAlgorithm{},
+		"LeastResponseTime":  &leastResponseTimeAlgorithm{},
 	}
 
 	for name, algo := range algorithms {
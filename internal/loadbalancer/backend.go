@@ -1,6 +1,22 @@
 package loadbalancer
 
-import "sync/atomic"
+import (
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// latencyWeight is the weight, out of latencyScale, a new observation gets in
+// a backend's latency average. Past observations decay by a factor of
+// 1-latencyWeight/latencyScale per observation.
+const (
+	latencyWeight = 3
+	latencyScale  = 10
+)
+
+// minSlowStartFactor is the share of its full weight a backend starts its
+// slow start ramp with, so it takes some traffic from the start.
+const minSlowStartFactor = 0.1
 
 // IsHealthy returns the current health status of the backend
 func (b *Backend) IsHealthy() bool {
@@ -16,6 +32,74 @@ func (b *Backend) SetHealthy(healthy bool) {
 	}
 }
 
+// Available reports whether the backend may be picked: it is healthy, not
+// ejected by outlier detection and not draining.
+func (b *Backend) Available() bool {
+	return atomic.LoadInt32(&b.healthy) == 1 && atomic.LoadInt32(&b.ejected) == 0 &&
+		atomic.LoadInt32(&b.draining) == drainNone
+}
+
+// markAvailable starts the backend's slow start ramp.
+func (b *Backend) markAvailable() {
+	atomic.StoreInt64(&b.availableSince, time.Now().UnixNano())
+}
+
+// slowStartFactor returns the share, from minSlowStartFactor up to 1, of its
+// full weight the backend gets at now: it grows linearly over window from the
+// moment the backend turned available.
+func (b *Backend) slowStartFactor(window time.Duration, now time.Time) float64 {
+	since := atomic.LoadInt64(&b.availableSince)
+	if window <= 0 || since == 0 {
+		return 1
+	}
+	elapsed := now.Sub(time.Unix(0, since))
+	if elapsed >= window {
+		return 1
+	}
+	return max(minSlowStartFactor, float64(elapsed)/float64(window))
+}
+
+// ObserveLatency folds one latency measurement into the backend's
+// exponentially weighted moving average. The first observation is taken as
+// is.
+func (b *Backend) ObserveLatency(d time.Duration) {
+	if d <= 0 {
+		// Keep zero meaning "not measured yet".
+		d = 1
+	}
+	for {
+		old := atomic.LoadInt64(&b.latency)
+		next := int64(d)
+		if old != 0 {
+			next = old + (int64(d)-old)*latencyWeight/latencyScale
+		}
+		if atomic.CompareAndSwapInt64(&b.latency, old, next) {
+			return
+		}
+	}
+}
+
+// Latency returns the backend's average latency, or zero if none has been
+// observed yet.
+func (b *Backend) Latency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&b.latency))
+}
+
+// ProbeLatency returns how long the backend's last health check took,
+// whether it passed or not, or zero if it was never checked.
+func (b *Backend) ProbeLatency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&b.probeLatency))
+}
+
+// backendsUpdated wakes the health check loop to pick up added or removed
+// backends.
+func (lb *LoadBalancer) backendsUpdated() {
+	select {
+	case lb.backendsChanged <- struct{}{}:
+	default:
+	}
+}
+
 // AddBackend adds a new backend server
 func (lb *LoadBalancer) AddBackend(backend *Backend) {
 	lb.mu.Lock()
@@ -25,20 +109,39 @@ func (lb *LoadBalancer) AddBackend(backend *Backend) {
 		lb.backends[backend.ServiceName] = make([]*Backend, 0)
 		lb.stats[backend.ServiceName] = &LoadBalancerStats{}
 	}
+	if backend.IsHealthy() {
+		backend.markAvailable()
+	}
 	lb.backends[backend.ServiceName] = append(lb.backends[backend.ServiceName], backend)
+	lb.backendsUpdated()
 }
 
-// RemoveBackend removes a backend server
+// RemoveBackend removes a backend server. With a drain timeout configured
+// the backend drains instead: it is no longer selected, but stays in its pool
+// until its connections finish or the timeout passes.
 func (lb *LoadBalancer) RemoveBackend(address string, serviceName string) {
 	lb.mu.Lock()
 	defer lb.mu.Unlock()
 
-	if backends, exists := lb.backends[serviceName]; exists {
-		for i, backend := range backends {
-			if backend.Address == address {
-				lb.backends[serviceName] = append(backends[:i], backends[i+1:]...)
-				break
-			}
+	for _, backend := range lb.backends[serviceName] {
+		// A backend re-added under the address of a draining one is the
+		// one removed.
+		if backend.Address != address || backend.Draining() {
+			continue
 		}
+		if lb.config.DrainTimeout > 0 {
+			lb.drainLocked(backend, lb.config.DrainTimeout)
+		} else {
+			lb.removeLocked(backend)
+		}
+		return
 	}
 }
+
+// Backends returns a copy of the backends of a service, including those
+// that are unhealthy, ejected or draining.
+func (lb *LoadBalancer) Backends(serviceName string) []*Backend {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return slices.Clone(lb.backends[serviceName])
+}
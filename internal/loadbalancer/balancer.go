@@ -1,12 +1,36 @@
 package loadbalancer
 
 import (
+	"reflect"
+	"slices"
 	"sync/atomic"
 	"time"
 )
 
 // NewLoadBalancer creates a new load balancer instance
 func NewLoadBalancer(config BalancerConfig) *LoadBalancer {
+	config = withDefaults(config)
+	lb := &LoadBalancer{
+		backends:  make(map[string][]*Backend),
+		stats:     make(map[string]*LoadBalancerStats),
+		config:    config,
+		algorithm: newAlgorithm(config),
+		stopCh:    make(chan struct{}),
+		// Buffered so AddBackend and RemoveBackend never wait for the
+		// scheduler; one pending signal covers any number of changes.
+		backendsChanged: make(chan struct{}, 1),
+	}
+	lb.probe = newProber(config.HealthCheckOpts)
+
+	if config.HealthCheck {
+		lb.startHealthCheck(config.CheckInterval)
+	}
+
+	return lb
+}
+
+// withDefaults fills in the check interval and health check options left unset.
+func withDefaults(config BalancerConfig) BalancerConfig {
 	// Set default check interval if health check is enabled but interval is not positive
 	if config.HealthCheck && config.CheckInterval <= 0 {
 		config.CheckInterval = time.Second * 5
@@ -19,21 +43,76 @@ func NewLoadBalancer(config BalancerConfig) *LoadBalancer {
 	if config.HealthCheckOpts.Protocol == "" {
 		config.HealthCheckOpts.Protocol = protocolTCP
 	}
+	if config.HealthCheckOpts.HealthyThreshold <= 0 {
+		config.HealthCheckOpts.HealthyThreshold = 1
+	}
+	if config.HealthCheckOpts.UnhealthyThreshold <= 0 {
+		config.HealthCheckOpts.UnhealthyThreshold = 1
+	}
+	if config.HealthCheckOpts.Concurrency <= 0 {
+		config.HealthCheckOpts.Concurrency = defaultCheckConcurrency
+	}
+	config.OutlierDetectionOpts = config.OutlierDetectionOpts.withDefaults()
+	return config
+}
 
-	lb := &LoadBalancer{
-		backends:  make(map[string][]*Backend),
-		stats:     make(map[string]*LoadBalancerStats),
-		config:    config,
-		algorithm: NewAlgorithm(config.Type, config.Weights),
-		stopCh:    make(chan struct{}),
+// Reconfigure applies a new configuration in place. Backends and their
+// connection counts are kept. A new type, new weights or a new slow start
+// window swap the algorithm, which starts its rotation afresh; new health
+// check options apply from the next check, and a new interval or concurrency
+// restarts the scheduler. Turning health checks off marks every backend
+// healthy again, since nothing would ever clear a failed check, and turning
+// outlier detection off lets every ejected backend back in.
+func (lb *LoadBalancer) Reconfigure(config BalancerConfig) {
+	config = withDefaults(config)
+
+	lb.lifecycleMu.Lock()
+	defer lb.lifecycleMu.Unlock()
+	select {
+	case <-lb.stopCh:
+		return
+	default:
 	}
 
-	if config.HealthCheck {
-		lb.wg.Add(1)
-		go lb.healthCheckLoop()
+	lb.mu.Lock()
+	old := lb.config
+	lb.config = config
+	if config.Type != old.Type || !slices.Equal(config.Weights, old.Weights) || config.SlowStart != old.SlowStart {
+		lb.algorithm = newAlgorithm(config)
 	}
+	if !reflect.DeepEqual(config.HealthCheckOpts, old.HealthCheckOpts) {
+		lb.probe = newProber(config.HealthCheckOpts)
+	}
+	lb.mu.Unlock()
 
-	return lb
+	if old.OutlierDetection && !config.OutlierDetection {
+		lb.clearEjections()
+	}
+
+	if config.HealthCheck == old.HealthCheck && (!config.HealthCheck ||
+		config.CheckInterval == old.CheckInterval && config.HealthCheckOpts.Concurrency == old.HealthCheckOpts.Concurrency) {
+		return
+	}
+	lb.stopHealthCheck()
+	if config.HealthCheck {
+		lb.startHealthCheck(config.CheckInterval)
+		return
+	}
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	for _, backends := range lb.backends {
+		for _, backend := range backends {
+			atomic.StoreInt32(&backend.successes, 0)
+			atomic.StoreInt32(&backend.failures, 0)
+			if !atomic.CompareAndSwapInt32(&backend.healthy, 0, 1) {
+				continue
+			}
+			backend.markAvailable()
+			if lb.healthObserver != nil {
+				lb.healthObserver(backend, true)
+			}
+		}
+	}
 }
 
 // IncrementConnections increments the connection count for a backend
@@ -41,19 +120,24 @@ func (lb *LoadBalancer) IncrementConnections(backend *Backend) {
 	atomic.AddInt32(&backend.Connections, 1)
 }
 
-// DecrementConnections decrements the connection count for a backend
+// DecrementConnections decrements the connection count for a backend. The
+// last connection of a draining backend takes it out of its pool.
 func (lb *LoadBalancer) DecrementConnections(backend *Backend) {
-	atomic.AddInt32(&backend.Connections, -1)
+	if atomic.AddInt32(&backend.Connections, -1) == 0 && backend.Draining() {
+		lb.finishDrain(backend, false)
+	}
 }
 
 // Stop gracefully stops the load balancer
 func (lb *LoadBalancer) Stop() {
+	lb.lifecycleMu.Lock()
+	defer lb.lifecycleMu.Unlock()
 	select {
 	case <-lb.stopCh:
 		return
 	default:
 		close(lb.stopCh)
 	}
-	lb.wg.Wait()
+	lb.stopHealthCheck()
 	lb.checkWg.Wait()
 }
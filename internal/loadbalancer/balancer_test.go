@@ -1,6 +1,8 @@
 package loadbalancer
 
 import (
+	"fmt"
+	"math"
 	"net"
 	"sync/atomic"
 	"testing"
@@ -47,6 +49,8 @@ func TestLoadBalancer(t *testing.T) {
 		{"WeightedRoundRobin", WeightedRoundRobin, testWeightedRoundRobin},
 		{"IPHash", IPHash, testIPHash},
 		{"RandomSelection", RandomSelection, testRandomSelection},
+		{"P2C", P2C, testP2C},
+		{"LeastResponseTime", LeastResponseTime, testLeastResponseTime},
 	}
 
 	for _, tt := range tests {
@@ -120,6 +124,64 @@ func testWeightedRoundRobin(t *testing.T) {
 	}
 }
 
+func TestSmoothWeightedRoundRobin(t *testing.T) {
+	t.Run("Interleaves picks instead of emitting runs", func(t *testing.T) {
+		a := createTestBackend("192.168.1.1", "test-service", 5)
+		b := createTestBackend("192.168.1.2", "test-service", 1)
+		c := createTestBackend("192.168.1.3", "test-service", 1)
+		algo := &weightedRoundRobinAlgorithm{}
+		backends := []*Backend{a, b, c}
+
+		want := []*Backend{a, a, b, a, c, a, a}
+		for cycle := 0; cycle < 3; cycle++ {
+			for i, w := range want {
+				if got := algo.Select(backends, "test-service", ""); got != w {
+					t.Fatalf("cycle %d pick %d = %s, want %s", cycle, i, got.Address, w.Address)
+				}
+			}
+		}
+	})
+
+	t.Run("Endpoint weights override the service weight", func(t *testing.T) {
+		heavy := createTestBackend("192.168.1.1", "test-service", 3)
+		light := createTestBackend("192.168.1.2", "test-service", 0)
+		algo := &weightedRoundRobinAlgorithm{weights: []Weight{{ServiceName: "test-service", Weight: 1}}}
+
+		if w := algo.resolveWeight(heavy); w != 3 {
+			t.Errorf("resolveWeight(endpoint weight 3) = %d, want 3", w)
+		}
+		if w := algo.resolveWeight(light); w != 1 {
+			t.Errorf("resolveWeight(no endpoint weight) = %d, want the service weight 1", w)
+		}
+
+		selections := make(map[*Backend]int)
+		for i := 0; i < 40; i++ {
+			selections[algo.Select([]*Backend{heavy, light}, "test-service", "")]++
+		}
+		if selections[heavy] != 30 || selections[light] != 10 {
+			t.Errorf("selections = heavy %d, light %d, want 30 and 10", selections[heavy], selections[light])
+		}
+	})
+
+	t.Run("Skips unhealthy backends and forgets their state", func(t *testing.T) {
+		a := createTestBackend("192.168.1.1", "test-service", 1)
+		b := createTestBackend("192.168.1.2", "test-service", 1)
+		algo := &weightedRoundRobinAlgorithm{}
+		backends := []*Backend{a, b}
+
+		algo.Select(backends, "test-service", "")
+		b.SetHealthy(false)
+		for i := 0; i < 3; i++ {
+			if got := algo.Select(backends, "test-service", ""); got != a {
+				t.Fatalf("Select() = %s, want the only healthy backend", got.Address)
+			}
+		}
+		if _, ok := algo.states["test-service"].current[b]; ok {
+			t.Error("Expected the unhealthy backend's current weight to be dropped")
+		}
+	})
+}
+
 func testIPHash(t *testing.T) {
 	balancer := NewLoadBalancer(BalancerConfig{Type: IPHash})
 	defer balancer.Stop()
@@ -183,6 +245,129 @@ func testRandomSelection(t *testing.T) {
 	}
 }
 
+func testP2C(t *testing.T) {
+	balancer := NewLoadBalancer(BalancerConfig{Type: P2C})
+	defer balancer.Stop()
+
+	busiest := createTestBackend("192.168.1.1", "test-service", 1)
+	idlest := createTestBackend("192.168.1.2", "test-service", 1)
+	other := createTestBackend("192.168.1.3", "test-service", 1)
+	busiest.Connections = 8
+	idlest.Connections = 2
+	other.Connections = 5
+
+	balancer.AddBackend(busiest)
+	balancer.AddBackend(idlest)
+	balancer.AddBackend(other)
+
+	// The busiest backend loses every pair it is in; the idlest wins every
+	// pair it is in, which is two pairs out of three.
+	iterations := 3000
+	selections := make(map[string]int)
+	for i := 0; i < iterations; i++ {
+		selections[balancer.NextBackend("test-service", "").Address]++
+	}
+	if selections[busiest.Address] != 0 {
+		t.Errorf("Expected the busiest backend never to be picked, got %d picks", selections[busiest.Address])
+	}
+	if ratio := float64(selections[idlest.Address]) / float64(iterations); ratio < 0.6 || ratio > 0.73 {
+		t.Errorf("Expected the idlest backend in about 2/3 of picks, got %v", ratio)
+	}
+
+	// A single healthy backend needs no second sample.
+	busiest.SetHealthy(false)
+	other.SetHealthy(false)
+	if got := balancer.NextBackend("test-service", ""); got != idlest {
+		t.Errorf("Expected the only healthy backend, got %v", got)
+	}
+
+	// With most backends unhealthy, the random draws mostly miss and the
+	// scan fallback still finds the two that are available.
+	var many []*Backend
+	for i := range 64 {
+		b := createTestBackend(fmt.Sprintf("10.0.0.%d", i), "test-service", 1)
+		b.SetHealthy(i == 10 || i == 50)
+		many = append(many, b)
+	}
+	many[10].Connections = 3
+	p2c := &p2cAlgorithm{}
+	for i := 0; i < 200; i++ {
+		if got := p2c.Select(many, "", ""); got != many[50] {
+			t.Errorf("Expected the less loaded of the two healthy backends, got %v", got)
+			break
+		}
+	}
+	for _, b := range many {
+		b.SetHealthy(false)
+	}
+	if got := p2c.Select(many, "", ""); got != nil {
+		t.Errorf("Expected no backend when none is healthy, got %v", got)
+	}
+
+	// Sampling indices directly leaves nothing to allocate per pick.
+	for _, b := range many {
+		b.SetHealthy(true)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { p2c.Select(many, "", "") }); allocs != 0 {
+		t.Errorf("Expected no allocations per pick, got %v", allocs)
+	}
+}
+
+func testLeastResponseTime(t *testing.T) {
+	balancer := NewLoadBalancer(BalancerConfig{Type: LeastResponseTime})
+	defer balancer.Stop()
+
+	slow := createTestBackend("192.168.1.1", "test-service", 1)
+	fast := createTestBackend("192.168.1.2", "test-service", 1)
+	slow.ObserveLatency(10 * time.Millisecond)
+	fast.ObserveLatency(2 * time.Millisecond)
+	fast.Connections = 2
+
+	balancer.AddBackend(slow)
+	balancer.AddBackend(fast)
+
+	// 2ms with two connections ahead beats 10ms with none.
+	if got := balancer.NextBackend("test-service", ""); got != fast {
+		t.Errorf("Expected the fast backend, got %v", got)
+	}
+
+	// 2ms with five connections ahead no longer does.
+	fast.Connections = 5
+	if got := balancer.NextBackend("test-service", ""); got != slow {
+		t.Errorf("Expected the slow backend once the fast one is loaded, got %v", got)
+	}
+
+	// A backend with no observation yet is picked so it gets measured.
+	fresh := createTestBackend("192.168.1.3", "test-service", 1)
+	balancer.AddBackend(fresh)
+	if got := balancer.NextBackend("test-service", ""); got != fresh {
+		t.Errorf("Expected the unmeasured backend, got %v", got)
+	}
+}
+
+func TestBackendObserveLatency(t *testing.T) {
+	b := &Backend{}
+	if got := b.Latency(); got != 0 {
+		t.Fatalf("Latency() = %v before any observation, want 0", got)
+	}
+
+	b.ObserveLatency(100 * time.Millisecond)
+	if got := b.Latency(); got != 100*time.Millisecond {
+		t.Errorf("Latency() = %v after the first observation, want 100ms", got)
+	}
+
+	b.ObserveLatency(200 * time.Millisecond)
+	if got := b.Latency(); got != 130*time.Millisecond {
+		t.Errorf("Latency() = %v, want 130ms (30%% of the way to the new observation)", got)
+	}
+
+	b = &Backend{}
+	b.ObserveLatency(0)
+	if got := b.Latency(); got == 0 {
+		t.Error("Latency() = 0 after observing a zero duration, want it marked as measured")
+	}
+}
+
 func TestHealthCheck(t *testing.T) {
 	balancer := NewLoadBalancer(BalancerConfig{
 		Type:          RoundRobin,
@@ -668,3 +853,325 @@ func TestHealthCheckSuccessPath(t *testing.T) {
 		t.Error("Expected backend to be marked as healthy after successful TCP connection")
 	}
 }
+
+func TestReconfigure(t *testing.T) {
+	t.Run("Swaps the algorithm and keeps backends", func(t *testing.T) {
+		lb := NewLoadBalancer(BalancerConfig{Type: RoundRobin})
+		defer lb.Stop()
+		busy := createTestBackend("10.0.0.1", "test-svc", 1)
+		idle := createTestBackend("10.0.0.2", "test-svc", 1)
+		lb.AddBackend(busy)
+		lb.AddBackend(idle)
+		lb.IncrementConnections(busy)
+
+		lb.Reconfigure(BalancerConfig{Type: LeastConnection})
+
+		if _, ok := lb.algorithm.(*leastConnectionAlgorithm); !ok {
+			t.Fatalf("algorithm = %T, want *leastConnectionAlgorithm", lb.algorithm)
+		}
+		for range 3 {
+			if got := lb.NextBackend("test-svc", ""); got != idle {
+				t.Errorf("NextBackend() = %v, want the backend without connections", got)
+			}
+		}
+	})
+
+	t.Run("Keeps the algorithm state when only health options change", func(t *testing.T) {
+		lb := NewLoadBalancer(BalancerConfig{Type: RoundRobin})
+		defer lb.Stop()
+		before := lb.algorithm
+
+		lb.Reconfigure(BalancerConfig{Type: RoundRobin, HealthCheckOpts: HealthCheckOptions{Timeout: time.Second * 2}})
+
+		if lb.algorithm != before {
+			t.Error("expected the algorithm to be kept")
+		}
+		if lb.config.HealthCheckOpts.Timeout != time.Second*2 {
+			t.Errorf("timeout = %v, want 2s", lb.config.HealthCheckOpts.Timeout)
+		}
+	})
+
+	t.Run("Starts and stops health checks", func(t *testing.T) {
+		lb := NewLoadBalancer(BalancerConfig{Type: RoundRobin})
+		defer lb.Stop()
+		// A port that was just closed refuses connections.
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to start listener: %v", err)
+		}
+		port := ln.Addr().(*net.TCPAddr).Port
+		_ = ln.Close()
+		backend := &Backend{Address: "127.0.0.1", Port: port, ServiceName: "test-svc"}
+		backend.SetHealthy(true)
+		lb.AddBackend(backend)
+
+		lb.Reconfigure(BalancerConfig{
+			Type:            RoundRobin,
+			HealthCheck:     true,
+			CheckInterval:   time.Millisecond * 50,
+			HealthCheckOpts: HealthCheckOptions{Timeout: time.Millisecond * 10},
+		})
+		time.Sleep(time.Millisecond * 100)
+		if backend.IsHealthy() {
+			t.Fatal("Expected the enabled health check to mark the backend unhealthy")
+		}
+
+		lb.Reconfigure(BalancerConfig{Type: RoundRobin})
+		if !backend.IsHealthy() {
+			t.Error("Expected backends to be healthy once health checks are disabled")
+		}
+		if lb.checkStop != nil {
+			t.Error("Expected the health check loop to be stopped")
+		}
+	})
+
+	t.Run("Is a no-op after Stop", func(t *testing.T) {
+		lb := NewLoadBalancer(BalancerConfig{Type: RoundRobin})
+		lb.Stop()
+		lb.Reconfigure(BalancerConfig{Type: RoundRobin, HealthCheck: true})
+		if lb.checkStop != nil {
+			t.Error("Expected no health check loop on a stopped balancer")
+		}
+	})
+}
+
+func TestHealthThresholds(t *testing.T) {
+	opts := HealthCheckOptions{HealthyThreshold: 2, UnhealthyThreshold: 3}
+	tests := []struct {
+		name    string
+		healthy bool
+		checks  []bool
+		// changes holds, per check, whether it changed the backend's health.
+		changes []bool
+		want    bool
+	}{
+		{
+			name:    "Single failure keeps a healthy backend",
+			healthy: true,
+			checks:  []bool{false, true, false, false, true},
+			changes: []bool{false, false, false, false, false},
+			want:    true,
+		},
+		{
+			name:    "Consecutive failures eject a backend once",
+			healthy: true,
+			checks:  []bool{false, false, false, false},
+			changes: []bool{false, false, true, false},
+			want:    false,
+		},
+		{
+			name:    "A failure resets the rise count",
+			healthy: false,
+			checks:  []bool{true, false, true, true},
+			changes: []bool{false, false, false, true},
+			want:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := createTestBackend("192.168.1.1", "test-service", 1)
+			b.SetHealthy(tt.healthy)
+			for i, passed := range tt.checks {
+				if changed := b.recordCheck(passed, opts); changed != tt.changes[i] {
+					t.Errorf("check %d (passed=%v) changed = %v, want %v", i, passed, changed, tt.changes[i])
+				}
+			}
+			if b.IsHealthy() != tt.want {
+				t.Errorf("IsHealthy() = %v, want %v", b.IsHealthy(), tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeObserver(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start listener: %v", err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	lb := NewLoadBalancer(BalancerConfig{
+		Type:          RoundRobin,
+		HealthCheck:   true,
+		CheckInterval: 10 * time.Millisecond,
+	})
+	defer lb.Stop()
+	probes := make(chan *Backend, 10)
+	lb.OnProbe(func(b *Backend, took time.Duration) {
+		if took <= 0 {
+			t.Errorf("probe took %v, want a positive duration", took)
+		}
+		select {
+		case probes <- b:
+		default:
+		}
+	})
+	backend := &Backend{Address: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port, ServiceName: "svc"}
+	lb.AddBackend(backend)
+
+	select {
+	case got := <-probes:
+		if got != backend {
+			t.Errorf("observer told about %s, want %s", got.Address, backend.Address)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Observer not called")
+	}
+}
+
+func TestHealthObserver(t *testing.T) {
+	// A port that was just closed refuses connections.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start listener: %v", err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	_ = ln.Close()
+
+	lb := NewLoadBalancer(BalancerConfig{
+		Type:            RoundRobin,
+		HealthCheck:     true,
+		CheckInterval:   10 * time.Millisecond,
+		HealthCheckOpts: HealthCheckOptions{UnhealthyThreshold: 3},
+	})
+	changes := make(chan bool, 10)
+	lb.OnHealthChange(func(_ *Backend, healthy bool) { changes <- healthy })
+	backend := &Backend{Address: "127.0.0.1", Port: port, ServiceName: "svc"}
+	backend.SetHealthy(true)
+	lb.AddBackend(backend)
+
+	select {
+	case healthy := <-changes:
+		if healthy {
+			t.Error("Expected the observer to see the backend turn unhealthy")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Observer not called")
+	}
+
+	// Turning checks off brings the backend back and says so.
+	lb.Reconfigure(BalancerConfig{Type: RoundRobin})
+	defer lb.Stop()
+	select {
+	case healthy := <-changes:
+		if !healthy {
+			t.Error("Expected the observer to see the backend turn healthy")
+		}
+	default:
+		t.Error("Observer not called when disabling checks restored the backend")
+	}
+	if n := len(changes); n != 0 {
+		t.Errorf("Observer called %d more times, want once per change", n)
+	}
+}
+
+func TestSlowStart(t *testing.T) {
+	const window = time.Minute
+	// rampedBackend returns a backend that turned available the given share
+	// of the window ago.
+	rampedBackend := func(address string, share float64) *Backend {
+		b := createTestBackend(address, "test-service", 1)
+		atomic.StoreInt64(&b.availableSince, time.Now().Add(-time.Duration(share*float64(window))).UnixNano())
+		return b
+	}
+
+	t.Run("Factor ramps linearly from a floor", func(t *testing.T) {
+		now := time.Now()
+		tests := []struct {
+			name  string
+			share float64
+			want  float64
+		}{
+			{"just available", 0, minSlowStartFactor},
+			{"half way", 0.5, 0.5},
+			{"past the window", 2, 1},
+		}
+		for _, tt := range tests {
+			b := rampedBackend("10.0.0.1", tt.share)
+			if got := b.slowStartFactor(window, now); math.Abs(got-tt.want) > 0.01 {
+				t.Errorf("%s: slowStartFactor() = %v, want %v", tt.name, got, tt.want)
+			}
+		}
+		if got := rampedBackend("10.0.0.1", 0).slowStartFactor(0, now); got != 1 {
+			t.Errorf("slowStartFactor() without a window = %v, want 1", got)
+		}
+	})
+
+	t.Run("Becoming available starts the ramp", func(t *testing.T) {
+		lb := NewLoadBalancer(BalancerConfig{Type: RoundRobin})
+		defer lb.Stop()
+		added := createTestBackend("10.0.0.1", "test-service", 1)
+		lb.AddBackend(added)
+		if added.slowStartFactor(window, time.Now()) != minSlowStartFactor {
+			t.Error("an added backend does not start its ramp")
+		}
+		recovered := rampedBackend("10.0.0.2", 2)
+		recovered.SetHealthy(false)
+		if !recovered.recordCheck(true, HealthCheckOptions{HealthyThreshold: 1}) {
+			t.Fatal("passed check did not bring the backend back")
+		}
+		if recovered.slowStartFactor(window, time.Now()) != minSlowStartFactor {
+			t.Error("a backend that turned healthy does not start its ramp")
+		}
+	})
+
+	t.Run("LeastConnection holds back an idle new backend", func(t *testing.T) {
+		warm := rampedBackend("10.0.0.1", 2)
+		fresh := rampedBackend("10.0.0.2", 0)
+		warm.Connections = 3
+		backends := []*Backend{warm, fresh}
+
+		if got := (&leastConnectionAlgorithm{}).Select(backends, "test-service", ""); got != fresh {
+			t.Errorf("without slow start Select() = %s, want the idle backend", got.Address)
+		}
+		if got := (&leastConnectionAlgorithm{slowStart: window}).Select(backends, "test-service", ""); got != warm {
+			t.Errorf("with slow start Select() = %s, want the warm backend", got.Address)
+		}
+		// Once the warm backend is loaded enough, the new one takes its share.
+		warm.Connections = 20
+		if got := (&leastConnectionAlgorithm{slowStart: window}).Select(backends, "test-service", ""); got != fresh {
+			t.Errorf("with slow start and a busy warm backend Select() = %s, want the new backend", got.Address)
+		}
+	})
+
+	t.Run("WeightedRoundRobin scales the ramping weight", func(t *testing.T) {
+		warm := rampedBackend("10.0.0.1", 2)
+		ramping := rampedBackend("10.0.0.2", 0.25)
+		algo := &weightedRoundRobinAlgorithm{slowStart: window}
+		picks := 0
+		for range 100 {
+			if algo.Select([]*Backend{warm, ramping}, "test-service", "") == ramping {
+				picks++
+			}
+		}
+		// Weight 0.25 against 1 is a fifth of the picks.
+		if picks < 18 || picks > 22 {
+			t.Errorf("ramping backend picked %d of 100 times, want about 20", picks)
+		}
+	})
+
+	t.Run("Random picks in proportion to the ramp", func(t *testing.T) {
+		warm := rampedBackend("10.0.0.1", 2)
+		fresh := rampedBackend("10.0.0.2", 0)
+		algo := &randomAlgorithm{slowStart: window}
+		picks := 0
+		for range 2000 {
+			if algo.Select([]*Backend{warm, fresh}, "test-service", "") == fresh {
+				picks++
+			}
+		}
+		// A factor of 0.1 against 1 is about 9% of the picks.
+		if picks < 100 || picks > 300 {
+			t.Errorf("new backend picked %d of 2000 times, want about 180", picks)
+		}
+	})
+
+	t.Run("Reconfigure applies a new window", func(t *testing.T) {
+		lb := NewLoadBalancer(BalancerConfig{Type: LeastConnection})
+		defer lb.Stop()
+		lb.Reconfigure(BalancerConfig{Type: LeastConnection, SlowStart: window})
+		if a, ok := lb.algorithm.(*leastConnectionAlgorithm); !ok || a.slowStart != window {
+			t.Errorf("algorithm = %+v, want LeastConnection with the new window", lb.algorithm)
+		}
+	})
+}
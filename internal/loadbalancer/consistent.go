@@ -0,0 +1,181 @@
+package loadbalancer
+
+import (
+	"hash/fnv"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+var (
+	_ Algorithm = (*maglevAlgorithm)(nil)
+	_ Algorithm = (*ringHashAlgorithm)(nil)
+)
+
+const (
+	// maglevTableSize is the number of Maglev lookup table slots. It must be
+	// prime and well above the number of backends so each backend's share of
+	// the table, and of the clients, stays close to even.
+	maglevTableSize = 65537
+	// ringHashReplicas is the number of points each backend gets on the ring.
+	ringHashReplicas = 160
+)
+
+// consistentState is the lookup structure of one service, built for the
+// healthy backends it was built from. Select rebuilds it only when that set
+// changes, so the cost of a rebuild is paid once per membership change.
+type consistentState struct {
+	members []*Backend
+	lookup  func(key uint64) *Backend
+}
+
+// consistentTables keeps one lookup structure per service.
+type consistentTables struct {
+	mu     sync.Mutex
+	states map[string]*consistentState
+}
+
+// lookup returns the backend for clientIP among the healthy backends,
+// rebuilding the service's structure with build if membership changed.
+func (t *consistentTables) lookup(backends []*Backend, serviceName, clientIP string, build func([]*Backend) func(uint64) *Backend) *Backend {
+	healthyBackends := make([]*Backend, 0, len(backends))
+	for _, backend := range backends {
+		if backend.Available() {
+			healthyBackends = append(healthyBackends, backend)
+		}
+	}
+	if len(healthyBackends) == 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.states == nil {
+		t.states = make(map[string]*consistentState)
+	}
+	state, exists := t.states[serviceName]
+	if !exists || !slices.Equal(state.members, healthyBackends) {
+		state = &consistentState{members: healthyBackends, lookup: build(sortedByKey(healthyBackends))}
+		t.states[serviceName] = state
+	}
+	return state.lookup(hashKey(clientIP))
+}
+
+// --- Maglev ---
+
+// maglevAlgorithm implements Maglev hashing: every backend fills the slots of
+// a fixed-size table in its own pseudo-random order, taking turns, so a
+// membership change only moves the slots the added or removed backend gains
+// or gives up, and each backend owns an almost equal share of the table.
+type maglevAlgorithm struct {
+	tables consistentTables
+}
+
+func (a *maglevAlgorithm) Select(backends []*Backend, serviceName string, clientIP string) *Backend {
+	return a.tables.lookup(backends, serviceName, clientIP, buildMaglevTable)
+}
+
+func buildMaglevTable(backends []*Backend) func(uint64) *Backend {
+	const m = maglevTableSize
+	n := len(backends)
+	offsets := make([]uint64, n)
+	skips := make([]uint64, n)
+	for i, backend := range backends {
+		key := backendKey(backend)
+		offsets[i] = hashKey(key+"#offset") % m
+		skips[i] = hashKey(key+"#skip")%(m-1) + 1
+	}
+
+	table := make([]int32, m)
+	for i := range table {
+		table[i] = -1
+	}
+	next := make([]uint64, n)
+	filled := 0
+	for {
+		for i := range backends {
+			slot := (offsets[i] + next[i]*skips[i]) % m
+			for table[slot] >= 0 {
+				next[i]++
+				slot = (offsets[i] + next[i]*skips[i]) % m
+			}
+			table[slot] = int32(i)
+			next[i]++
+			filled++
+			if filled == m {
+				return func(key uint64) *Backend {
+					return backends[table[key%m]]
+				}
+			}
+		}
+	}
+}
+
+// --- RingHash ---
+
+// ringHashAlgorithm implements ring hashing: every backend is placed on a
+// hash ring at several points, and a client goes to the first point at or
+// after its own hash. Adding or removing a backend only moves the clients
+// between that backend's points and their predecessors.
+type ringHashAlgorithm struct {
+	tables consistentTables
+}
+
+func (a *ringHashAlgorithm) Select(backends []*Backend, serviceName string, clientIP string) *Backend {
+	return a.tables.lookup(backends, serviceName, clientIP, buildRing)
+}
+
+type ringPoint struct {
+	hash    uint64
+	backend *Backend
+}
+
+func buildRing(backends []*Backend) func(uint64) *Backend {
+	ring := make([]ringPoint, 0, len(backends)*ringHashReplicas)
+	for _, backend := range backends {
+		key := backendKey(backend)
+		for i := range ringHashReplicas {
+			ring = append(ring, ringPoint{hash: hashKey(key + "#" + strconv.Itoa(i)), backend: backend})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	return func(key uint64) *Backend {
+		i := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= key })
+		if i == len(ring) {
+			i = 0
+		}
+		return ring[i].backend
+	}
+}
+
+// backendKey identifies a backend independently of its position in the pool,
+// so its place in a lookup structure survives changes to other backends.
+func backendKey(b *Backend) string {
+	return net.JoinHostPort(b.Address, strconv.Itoa(b.Port))
+}
+
+// sortedByKey returns the backends ordered by backendKey, so a structure
+// built from the same set is the same whatever order the pool lists it in.
+func sortedByKey(backends []*Backend) []*Backend {
+	sorted := slices.Clone(backends)
+	sort.Slice(sorted, func(i, j int) bool { return backendKey(sorted[i]) < backendKey(sorted[j]) })
+	return sorted
+}
+
+// hashKey hashes s with FNV-1a and a final avalanche step, so keys that only
+// differ in their last characters, like neighbouring client IPs, still land
+// far apart.
+func hashKey(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,150 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"testing"
+)
+
+// consistentKeys is the number of client IPs the remap tests sample.
+const consistentKeys = 20000
+
+func consistentClients() []string {
+	clients := make([]string, consistentKeys)
+	for i := range clients {
+		clients[i] = fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)
+	}
+	return clients
+}
+
+// assignments returns the backend address each client maps to.
+func assignments(algo Algorithm, backends []*Backend, clients []string) map[string]string {
+	got := make(map[string]string, len(clients))
+	for _, c := range clients {
+		got[c] = algo.Select(backends, benchServiceName, c).Address
+	}
+	return got
+}
+
+// remapped returns the fraction of clients whose backend differs, and how
+// many of those moved between two backends that are in both sets.
+func remapped(before, after map[string]string, kept map[string]bool) (float64, int) {
+	moved, movedBetweenKept := 0, 0
+	for c, b := range before {
+		if after[c] == b {
+			continue
+		}
+		moved++
+		if kept[b] && kept[after[c]] {
+			movedBetweenKept++
+		}
+	}
+	return float64(moved) / float64(len(before)), movedBetweenKept
+}
+
+func TestConsistentHashing_Remapping(t *testing.T) {
+	clients := consistentClients()
+	algorithms := []struct {
+		name string
+		new  func() Algorithm
+		// maxStray is the share of clients that may move between backends
+		// that stay, on top of the ones the change has to move.
+		maxStray float64
+	}{
+		{"Maglev", func() Algorithm { return &maglevAlgorithm{} }, 0.02},
+		{"RingHash", func() Algorithm { return &ringHashAlgorithm{} }, 0},
+	}
+
+	for _, tt := range algorithms {
+		t.Run(tt.name, func(t *testing.T) {
+			backends := newBenchBackends(10)
+			kept := make(map[string]bool)
+			for _, b := range backends[:9] {
+				kept[b.Address] = true
+			}
+
+			algo := tt.new()
+			all := assignments(algo, backends, clients)
+
+			// Removing one of ten backends must move about its tenth.
+			removed := assignments(algo, backends[:9], clients)
+			fraction, stray := remapped(all, removed, kept)
+			if fraction > 0.1+0.05+tt.maxStray {
+				t.Errorf("removing 1 of 10 backends remapped %.3f of clients, want about 0.1", fraction)
+			}
+			if limit := int(tt.maxStray * consistentKeys); stray > limit {
+				t.Errorf("removing a backend moved %d clients between remaining backends, want at most %d", stray, limit)
+			}
+
+			// Adding it back must move about a tenth, onto the new backend.
+			restored := assignments(algo, backends, clients)
+			fraction, stray = remapped(removed, restored, kept)
+			if fraction > 0.1+0.05+tt.maxStray {
+				t.Errorf("adding a 10th backend remapped %.3f of clients, want about 0.1", fraction)
+			}
+			if limit := int(tt.maxStray * consistentKeys); stray > limit {
+				t.Errorf("adding a backend moved %d clients between existing backends, want at most %d", stray, limit)
+			}
+
+			// The same membership gives the same table.
+			if again, _ := remapped(all, restored, kept); again != 0 {
+				t.Errorf("restoring the backend set remapped %.3f of clients, want 0", again)
+			}
+		})
+	}
+
+	// The modulo hash it replaces remaps most clients on the same change.
+	algo := &ipHashAlgorithm{}
+	backends := newBenchBackends(10)
+	if fraction, _ := remapped(assignments(algo, backends, clients), assignments(algo, backends[:9], clients), nil); fraction < 0.5 {
+		t.Errorf("IPHash remapped %.3f of clients, expected the modulo hash to remap most", fraction)
+	}
+}
+
+func TestConsistentHashing_Balance(t *testing.T) {
+	clients := consistentClients()
+	for name, algo := range map[string]Algorithm{"Maglev": &maglevAlgorithm{}, "RingHash": &ringHashAlgorithm{}} {
+		t.Run(name, func(t *testing.T) {
+			counts := make(map[string]int)
+			for _, b := range assignments(algo, newBenchBackends(10), clients) {
+				counts[b]++
+			}
+			if len(counts) != 10 {
+				t.Fatalf("clients spread over %d backends, want 10", len(counts))
+			}
+			for b, n := range counts {
+				if share := float64(n) / consistentKeys; share < 0.05 || share > 0.15 {
+					t.Errorf("backend %s got %.3f of clients, want about 0.1", b, share)
+				}
+			}
+		})
+	}
+}
+
+func TestConsistentHashing_MembershipFollowsHealth(t *testing.T) {
+	for name, algo := range map[string]Algorithm{"Maglev": &maglevAlgorithm{}, "RingHash": &ringHashAlgorithm{}} {
+		t.Run(name, func(t *testing.T) {
+			backends := newBenchBackends(3)
+			clients := consistentClients()[:1000]
+			before := assignments(algo, backends, clients)
+
+			backends[0].SetHealthy(false)
+			for c, b := range assignments(algo, backends, clients) {
+				if b == backends[0].Address {
+					t.Fatalf("client %s sent to unhealthy backend %s", c, b)
+				}
+			}
+
+			backends[0].SetHealthy(true)
+			if fraction, _ := remapped(before, assignments(algo, backends, clients), nil); fraction != 0 {
+				t.Errorf("recovering backend remapped %.3f of clients, want 0", fraction)
+			}
+
+			for _, b := range backends {
+				b.SetHealthy(false)
+			}
+			if got := algo.Select(backends, benchServiceName, "10.0.0.1"); got != nil {
+				t.Errorf("Select() = %v with no healthy backends, want nil", got)
+			}
+		})
+	}
+}
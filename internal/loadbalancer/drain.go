@@ -0,0 +1,81 @@
+package loadbalancer
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Drain states of a backend.
+const (
+	drainNone int32 = iota
+	// drainActive: removed, no longer selected, waiting for its connections.
+	drainActive
+	// drainDone: out of its pool.
+	drainDone
+)
+
+// DrainObserver is called when a draining backend leaves its pool: once its
+// last connection finished, or with timedOut set when the drain timeout
+// passed first and connections are still open.
+type DrainObserver func(backend *Backend, timedOut bool)
+
+// OnDrain sets the observer told when backends finish draining. It is called
+// from the goroutines closing connections and from timers, and must not
+// block.
+func (lb *LoadBalancer) OnDrain(observer DrainObserver) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.drainObserver = observer
+}
+
+// Draining reports whether the backend was removed and is waiting for its
+// connections to finish.
+func (b *Backend) Draining() bool {
+	return atomic.LoadInt32(&b.draining) == drainActive
+}
+
+// drainLocked starts draining backend, or takes it out of its pool at once
+// when it has no connections. The caller holds lb.mu.
+func (lb *LoadBalancer) drainLocked(backend *Backend, timeout time.Duration) {
+	atomic.StoreInt32(&backend.draining, drainActive)
+	if atomic.LoadInt32(&backend.Connections) > 0 {
+		time.AfterFunc(timeout, func() { lb.finishDrain(backend, true) })
+		return
+	}
+	// A connection that closed meanwhile may have finished the drain.
+	if atomic.CompareAndSwapInt32(&backend.draining, drainActive, drainDone) {
+		lb.removeLocked(backend)
+	}
+}
+
+// finishDrain takes a draining backend out of its pool and tells the
+// observer. Only the first call for a drain does anything.
+func (lb *LoadBalancer) finishDrain(backend *Backend, timedOut bool) {
+	if !atomic.CompareAndSwapInt32(&backend.draining, drainActive, drainDone) {
+		return
+	}
+	lb.mu.Lock()
+	lb.removeLocked(backend)
+	observer := lb.drainObserver
+	lb.mu.Unlock()
+	select {
+	case <-lb.stopCh:
+		return
+	default:
+	}
+	if observer != nil {
+		observer(backend, timedOut)
+	}
+}
+
+// removeLocked takes backend out of its pool. The caller holds lb.mu.
+func (lb *LoadBalancer) removeLocked(backend *Backend) {
+	backends := lb.backends[backend.ServiceName]
+	for i, b := range backends {
+		if b == backend {
+			lb.backends[backend.ServiceName] = append(backends[:i], backends[i+1:]...)
+			lb.backendsUpdated()
+			return
+		}
+	}
+}
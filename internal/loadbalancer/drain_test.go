@@ -0,0 +1,128 @@
+package loadbalancer
+
+import (
+	"testing"
+	"time"
+)
+
+// poolHas reports whether backend is in the pool of its service.
+func poolHas(lb *LoadBalancer, backend *Backend) bool {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	for _, b := range lb.backends[backend.ServiceName] {
+		if b == backend {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDrain(t *testing.T) {
+	t.Run("without a timeout a backend goes at once", func(t *testing.T) {
+		lb := NewLoadBalancer(BalancerConfig{Type: RoundRobin})
+		defer lb.Stop()
+		backend := createTestBackend("10.0.0.1", "test-service", 1)
+		lb.AddBackend(backend)
+		lb.IncrementConnections(backend)
+		lb.RemoveBackend("10.0.0.1", "test-service")
+		if poolHas(lb, backend) || backend.Draining() {
+			t.Error("backend kept although draining is off")
+		}
+	})
+
+	t.Run("an idle backend goes at once", func(t *testing.T) {
+		lb := NewLoadBalancer(BalancerConfig{Type: RoundRobin, DrainTimeout: time.Minute})
+		defer lb.Stop()
+		backend := createTestBackend("10.0.0.1", "test-service", 1)
+		lb.AddBackend(backend)
+		lb.RemoveBackend("10.0.0.1", "test-service")
+		if poolHas(lb, backend) {
+			t.Error("backend without connections kept to drain")
+		}
+	})
+
+	t.Run("last connection ends the drain", func(t *testing.T) {
+		lb := NewLoadBalancer(BalancerConfig{Type: RoundRobin, DrainTimeout: time.Minute})
+		defer lb.Stop()
+		drained := make(chan bool, 1)
+		lb.OnDrain(func(_ *Backend, timedOut bool) { drained <- timedOut })
+		backend := createTestBackend("10.0.0.1", "test-service", 1)
+		other := createTestBackend("10.0.0.2", "test-service", 1)
+		lb.AddBackend(backend)
+		lb.AddBackend(other)
+		lb.IncrementConnections(backend)
+		lb.IncrementConnections(backend)
+
+		lb.RemoveBackend("10.0.0.1", "test-service")
+		if !backend.Draining() || !poolHas(lb, backend) {
+			t.Fatal("backend with connections not draining")
+		}
+		for range 4 {
+			if got := lb.NextBackend("test-service", ""); got != other {
+				t.Errorf("NextBackend() = %s, want the backend that is not draining", got.Address)
+			}
+		}
+		lb.DecrementConnections(backend)
+		if !poolHas(lb, backend) {
+			t.Fatal("backend left before its last connection")
+		}
+		lb.DecrementConnections(backend)
+		if poolHas(lb, backend) || backend.Draining() {
+			t.Error("backend kept after its last connection")
+		}
+		select {
+		case timedOut := <-drained:
+			if timedOut {
+				t.Error("drain reported as timed out")
+			}
+		default:
+			t.Error("drain not reported")
+		}
+	})
+
+	t.Run("timeout ends the drain", func(t *testing.T) {
+		lb := NewLoadBalancer(BalancerConfig{Type: RoundRobin, DrainTimeout: 20 * time.Millisecond})
+		defer lb.Stop()
+		drained := make(chan bool, 1)
+		lb.OnDrain(func(_ *Backend, timedOut bool) { drained <- timedOut })
+		backend := createTestBackend("10.0.0.1", "test-service", 1)
+		lb.AddBackend(backend)
+		lb.IncrementConnections(backend)
+		lb.RemoveBackend("10.0.0.1", "test-service")
+
+		select {
+		case timedOut := <-drained:
+			if !timedOut {
+				t.Error("drain not reported as timed out")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("drain did not time out")
+		}
+		if poolHas(lb, backend) {
+			t.Error("backend kept after the drain timeout")
+		}
+		// The connection closing afterwards changes nothing.
+		lb.DecrementConnections(backend)
+		select {
+		case <-drained:
+			t.Error("drain reported twice")
+		default:
+		}
+	})
+
+	t.Run("a backend re-added under a draining address is the one removed", func(t *testing.T) {
+		lb := NewLoadBalancer(BalancerConfig{Type: RoundRobin, DrainTimeout: time.Minute})
+		defer lb.Stop()
+		old := createTestBackend("10.0.0.1", "test-service", 1)
+		lb.AddBackend(old)
+		lb.IncrementConnections(old)
+		lb.RemoveBackend("10.0.0.1", "test-service")
+
+		replacement := createTestBackend("10.0.0.1", "test-service", 1)
+		lb.AddBackend(replacement)
+		lb.RemoveBackend("10.0.0.1", "test-service")
+		if poolHas(lb, replacement) || !poolHas(lb, old) {
+			t.Error("RemoveBackend() did not remove the backend that is not draining")
+		}
+	})
+}
@@ -1,90 +1,311 @@
 package loadbalancer
 
 import (
-	"fmt"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"io"
+	"math/rand"
 	"net"
 	"net/http"
+	"regexp"
+	"slices"
 	"strconv"
+	"sync/atomic"
 	"time"
 )
 
-// protocolTCP is the default health check protocol.
-const protocolTCP = "TCP"
+const (
+	// protocolTCP is the default health check protocol.
+	protocolTCP  = "TCP"
+	protocolHTTP = "HTTP"
+	protocolGRPC = "GRPC"
+	// schemeHTTPS selects TLS for HTTP health checks.
+	schemeHTTPS = "HTTPS"
+	// maxHealthBody bounds how much of a response body is read for body
+	// matching.
+	maxHealthBody = 64 << 10
+	// defaultCheckConcurrency is how many health checks run at once unless
+	// the options set Concurrency.
+	defaultCheckConcurrency = 32
+	// checkJitterPercent is how far, in percent of the interval, each
+	// backend's check interval is moved at random, so checks of many backends
+	// spread out instead of running in bursts.
+	checkJitterPercent = 10
+)
 
 // HealthCheckOptions holds configurable health check parameters.
 type HealthCheckOptions struct {
 	Timeout  time.Duration
-	Protocol string // "TCP" or "HTTP"
+	Protocol string // "TCP", "HTTP" or "GRPC"
 	HTTPPath string // path for HTTP checks (e.g., "/healthz")
+	// Scheme "HTTPS" enables TLS for HTTP and gRPC checks, verified against
+	// CABundle (PEM; the system roots if empty) unless InsecureSkipVerify.
+	// Host overrides the Host header or gRPC authority and the TLS server
+	// name.
+	Scheme             string // "HTTP" or "HTTPS"
+	InsecureSkipVerify bool
+	CABundle           []byte
+	Host               string
+	// GRPCService is the service gRPC checks ask about; empty asks about
+	// the server as a whole.
+	GRPCService string
+	// The remaining HTTP options shape the request and what counts as a
+	// passing response. The zero values send a plain GET and pass any 2xx or
+	// 3xx response.
+	Method           string
+	Headers          map[string]string
+	ExpectedStatuses []int
+	BodyContains     string
+	BodyRegex        string
+	// HealthyThreshold and UnhealthyThreshold are the consecutive passed and
+	// failed checks it takes to change a backend's health, so a single lost
+	// probe does not eject a backend.
+	HealthyThreshold   int
+	UnhealthyThreshold int
+	// Concurrency bounds how many checks of one balancer run at once across
+	// all its backends.
+	Concurrency int
 }
 
 // DefaultHealthCheckOptions returns the default health check options.
 func DefaultHealthCheckOptions() HealthCheckOptions {
 	return HealthCheckOptions{
-		Timeout:  time.Second,
-		Protocol: protocolTCP,
+		Timeout:            time.Second,
+		Protocol:           protocolTCP,
+		HealthyThreshold:   1,
+		UnhealthyThreshold: 1,
+		Concurrency:        defaultCheckConcurrency,
 	}
 }
 
-func (lb *LoadBalancer) healthCheckLoop() {
-	defer lb.wg.Done()
+// HealthObserver is called when a health check changes a backend's health.
+type HealthObserver func(backend *Backend, healthy bool)
+
+// OnHealthChange sets the observer told about health changes. It is called
+// from the health check loop and must not block.
+func (lb *LoadBalancer) OnHealthChange(observer HealthObserver) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.healthObserver = observer
+}
+
+// ProbeObserver is called with how long each health check of a backend took.
+type ProbeObserver func(backend *Backend, took time.Duration)
+
+// OnProbe sets the observer told about finished health checks. It is called
+// from the health check loop and must not block.
+func (lb *LoadBalancer) OnProbe(observer ProbeObserver) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.probeObserver = observer
+}
+
+// startHealthCheck starts the health check scheduler with the given interval.
+func (lb *LoadBalancer) startHealthCheck(interval time.Duration) {
+	lb.checkStop = make(chan struct{})
+	lb.wg.Add(1)
+	go lb.healthCheckLoop(lb.checkStop, interval)
+}
+
+// stopHealthCheck stops the running health check scheduler, if any, and
+// waits for it and its in-flight checks to return.
+func (lb *LoadBalancer) stopHealthCheck() {
+	if lb.checkStop == nil {
+		return
+	}
+	close(lb.checkStop)
+	lb.wg.Wait()
+	lb.checkStop = nil
+}
 
-	ticker := time.NewTicker(lb.config.CheckInterval)
-	defer ticker.Stop()
+// healthCheckLoop schedules the health checks: every backend gets its own
+// checker, which probes it once per jittered interval. A checker waits for
+// its probe to finish before it schedules the next one, so probes of one
+// backend never overlap, and checkers share a pool of Concurrency workers, so
+// a round of slow probes cannot pile up. The loop starts and stops checkers
+// as backends come and go.
+func (lb *LoadBalancer) healthCheckLoop(stop <-chan struct{}, interval time.Duration) {
+	defer lb.wg.Done()
 
-	// Initial health check
-	lb.checkWg.Add(1)
-	go func() {
-		lb.doHealthCheck()
-		lb.checkWg.Done()
+	lb.mu.RLock()
+	workers := make(chan struct{}, lb.config.HealthCheckOpts.Concurrency)
+	lb.mu.RUnlock()
+	checkers := make(map[*Backend]chan struct{})
+	defer func() {
+		for _, done := range checkers {
+			close(done)
+		}
+		lb.checkWg.Wait()
 	}()
 
 	for {
+		current := make(map[*Backend]bool)
+		lb.mu.RLock()
+		for _, backends := range lb.backends {
+			for _, backend := range backends {
+				current[backend] = true
+			}
+		}
+		lb.mu.RUnlock()
+		for backend := range current {
+			if _, ok := checkers[backend]; !ok {
+				done := make(chan struct{})
+				checkers[backend] = done
+				lb.checkWg.Add(1)
+				go lb.checkBackendLoop(backend, interval, stop, done, workers)
+			}
+		}
+		for backend, done := range checkers {
+			if !current[backend] {
+				close(done)
+				delete(checkers, backend)
+			}
+		}
+
 		select {
-		case <-lb.stopCh:
-			lb.checkWg.Wait()
+		case <-stop:
 			return
-		case <-ticker.C:
-			lb.checkWg.Add(1)
-			go func() {
-				lb.doHealthCheck()
-				lb.checkWg.Done()
-			}()
+		case <-lb.backendsChanged:
 		}
 	}
 }
 
-func (lb *LoadBalancer) doHealthCheck() {
-	// Use RLock instead of Lock
-	lb.mu.RLock()
-	backends := make(map[string][]*Backend)
-	for service, bkends := range lb.backends {
-		backends[service] = append([]*Backend{}, bkends...)
+// checkBackendLoop checks backend until stop or done is closed. The first
+// check comes at a random point of the first interval, so backends added
+// together are not probed together.
+func (lb *LoadBalancer) checkBackendLoop(backend *Backend, interval time.Duration, stop, done <-chan struct{}, workers chan struct{}) {
+	defer lb.checkWg.Done()
+
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(interval))))
+	defer timer.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-done:
+			return
+		case <-timer.C:
+		}
+		select {
+		case <-stop:
+			return
+		case <-done:
+			return
+		case workers <- struct{}{}:
+		}
+		lb.checkBackend(backend)
+		<-workers
+		timer.Reset(jitter(interval))
+	}
+}
+
+// jitter returns interval moved at random by up to checkJitterPercent of
+// itself either way.
+func jitter(interval time.Duration) time.Duration {
+	spread := int64(interval) * checkJitterPercent / 100
+	if spread <= 0 {
+		return interval
 	}
+	return interval - time.Duration(spread) + time.Duration(rand.Int63n(2*spread+1))
+}
+
+// checkBackend runs one health check of backend with the current options and
+// records its result and latency.
+func (lb *LoadBalancer) checkBackend(backend *Backend) {
+	lb.mu.RLock()
 	opts := lb.config.HealthCheckOpts
+	probe := lb.probe
+	observer := lb.healthObserver
+	probed := lb.probeObserver
 	lb.mu.RUnlock()
 
-	for _, bkends := range backends {
-		for _, backend := range bkends {
-			healthy := checkBackendHealth(backend, opts)
-			backend.SetHealthy(healthy)
+	start := time.Now()
+	healthy := checkBackendHealth(backend, opts, probe)
+	took := time.Since(start)
+	atomic.StoreInt64(&backend.probeLatency, int64(took))
+	if probed != nil {
+		probed(backend, took)
+	}
+	if healthy {
+		backend.ObserveLatency(took)
+	}
+	if backend.recordCheck(healthy, opts) && observer != nil {
+		observer(backend, healthy)
+	}
+}
+
+// recordCheck counts a check result towards the backend's thresholds and
+// reports whether it changed the backend's health.
+func (b *Backend) recordCheck(passed bool, opts HealthCheckOptions) bool {
+	if passed {
+		atomic.StoreInt32(&b.failures, 0)
+		if atomic.AddInt32(&b.successes, 1) < int32(opts.HealthyThreshold) {
+			return false
+		}
+		if !atomic.CompareAndSwapInt32(&b.healthy, 0, 1) {
+			return false
 		}
+		b.markAvailable()
+		return true
+	}
+	atomic.StoreInt32(&b.successes, 0)
+	if atomic.AddInt32(&b.failures, 1) < int32(opts.UnhealthyThreshold) {
+		return false
+	}
+	return atomic.CompareAndSwapInt32(&b.healthy, 1, 0)
+}
+
+// prober checks backends with a protocol that needs per-configuration setup.
+type prober interface {
+	check(address string) bool
+}
+
+// newProber prepares the checks of opts, or returns nil for TCP checks.
+func newProber(opts HealthCheckOptions) prober {
+	switch opts.Protocol {
+	case protocolHTTP:
+		return newHTTPProbe(opts)
+	case protocolGRPC:
+		return newGRPCProbe(opts)
+	default:
+		return nil
 	}
 }
 
 // checkBackendHealth checks a single backend using the configured protocol.
-func checkBackendHealth(backend *Backend, opts HealthCheckOptions) bool {
+func checkBackendHealth(backend *Backend, opts HealthCheckOptions, probe prober) bool {
 	address := net.JoinHostPort(backend.Address, strconv.Itoa(backend.Port))
 
 	switch opts.Protocol {
-	case "HTTP":
-		return checkHTTP(address, opts)
+	case protocolHTTP, protocolGRPC:
+		return probe != nil && probe.check(address)
 	default: // TCP
 		return checkTCP(address, opts.Timeout)
 	}
 }
 
+// tlsConfig returns the TLS settings of HTTPS and gRPC-over-TLS checks, or nil
+// when opts do not ask for TLS.
+func tlsConfig(opts HealthCheckOptions) (*tls.Config, error) {
+	if opts.Scheme != schemeHTTPS {
+		return nil, nil
+	}
+	config := &tls.Config{
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+		ServerName:         opts.Host,
+	}
+	if len(opts.CABundle) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(opts.CABundle) {
+			return nil, errors.New("caBundle contains no PEM certificates")
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
 func checkTCP(address string, timeout time.Duration) bool {
 	d := net.Dialer{Timeout: timeout}
 	conn, err := d.Dial("tcp", address)
@@ -98,20 +319,103 @@ func checkTCP(address string, timeout time.Duration) bool {
 	return false
 }
 
-func checkHTTP(address string, opts HealthCheckOptions) bool {
-	path := opts.HTTPPath
-	if path == "" {
-		path = "/"
+// httpProbe runs the HTTP health checks of one configuration. It is built
+// once per configuration, so the TLS setup and the body pattern are not
+// redone on every check.
+type httpProbe struct {
+	client   *http.Client
+	scheme   string
+	host     string
+	path     string
+	method   string
+	headers  http.Header
+	statuses []int
+	contains []byte
+	pattern  *regexp.Regexp
+	// err is why the configuration cannot be probed; every check fails.
+	err error
+}
+
+// newHTTPProbe prepares the HTTP checks described by opts.
+func newHTTPProbe(opts HealthCheckOptions) *httpProbe {
+	p := &httpProbe{
+		scheme:   "http",
+		host:     opts.Host,
+		path:     opts.HTTPPath,
+		method:   opts.Method,
+		headers:  make(http.Header, len(opts.Headers)),
+		statuses: opts.ExpectedStatuses,
+		contains: []byte(opts.BodyContains),
 	}
-	url := fmt.Sprintf("http://%s%s", address, path)
-	client := &http.Client{Timeout: opts.Timeout}
-	resp, err := client.Get(url)
+	if p.path == "" {
+		p.path = "/"
+	}
+	if p.method == "" {
+		p.method = http.MethodGet
+	}
+	for name, value := range opts.Headers {
+		p.headers.Set(name, value)
+	}
+	if opts.BodyRegex != "" {
+		p.pattern, p.err = regexp.Compile(opts.BodyRegex)
+	}
+
+	// Every check dials afresh: a kept-alive connection would hide a
+	// backend that stopped accepting new ones.
+	transport := &http.Transport{DisableKeepAlives: true}
+	if config, err := tlsConfig(opts); err != nil {
+		p.err = err
+	} else if config != nil {
+		p.scheme = "https"
+		transport.TLSClientConfig = config
+	}
+	p.client = &http.Client{
+		Timeout:   opts.Timeout,
+		Transport: transport,
+		// A redirect is the backend's answer; it is judged by its status.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	return p
+}
+
+// check reports whether the backend at address passes the probe.
+func (p *httpProbe) check(address string) bool {
+	if p.err != nil {
+		return false
+	}
+	req, err := http.NewRequest(p.method, p.scheme+"://"+address+p.path, nil)
+	if err != nil {
+		return false
+	}
+	req.Header = p.headers.Clone()
+	if p.host != "" {
+		req.Host = p.host
+	}
+	resp, err := p.client.Do(req)
 	if err != nil {
 		return false
 	}
 	defer func() {
-		_, _ = io.Copy(io.Discard, resp.Body)
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxHealthBody))
 		_ = resp.Body.Close()
 	}()
-	return resp.StatusCode >= 200 && resp.StatusCode < 400
+
+	if len(p.statuses) > 0 {
+		if !slices.Contains(p.statuses, resp.StatusCode) {
+			return false
+		}
+	} else if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return false
+	}
+	if len(p.contains) == 0 && p.pattern == nil {
+		return true
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthBody))
+	if err != nil {
+		return false
+	}
+	if len(p.contains) > 0 && !bytes.Contains(body, p.contains) {
+		return false
+	}
+	return p.pattern == nil || p.pattern.Match(body)
 }
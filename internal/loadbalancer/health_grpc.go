@@ -0,0 +1,58 @@
+package loadbalancer
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// grpcProbe runs gRPC health checks: a grpc.health.v1.Health/Check call that
+// passes when the backend reports the service SERVING.
+type grpcProbe struct {
+	opts    HealthCheckOptions
+	dialOpt []grpc.DialOption
+	// err is why the configuration cannot be probed; every check fails.
+	err error
+}
+
+// newGRPCProbe prepares the gRPC checks described by opts.
+func newGRPCProbe(opts HealthCheckOptions) *grpcProbe {
+	p := &grpcProbe{opts: opts}
+	config, err := tlsConfig(opts)
+	switch {
+	case err != nil:
+		p.err = err
+	case config != nil:
+		p.dialOpt = append(p.dialOpt, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	default:
+		p.dialOpt = append(p.dialOpt, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	if opts.Host != "" {
+		p.dialOpt = append(p.dialOpt, grpc.WithAuthority(opts.Host))
+	}
+	return p
+}
+
+// check reports whether the backend at address passes the probe. Every check
+// uses a fresh connection, like the TCP and HTTP checks.
+func (p *grpcProbe) check(address string) bool {
+	if p.err != nil {
+		return false
+	}
+	conn, err := grpc.NewClient("passthrough:///"+address, p.dialOpt...)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = conn.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.opts.Timeout)
+	defer cancel()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: p.opts.GRPCService})
+	if err != nil {
+		return false
+	}
+	return resp.GetStatus() == healthpb.HealthCheckResponse_SERVING
+}
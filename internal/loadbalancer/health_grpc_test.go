@@ -0,0 +1,97 @@
+package loadbalancer
+
+import (
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// startGRPCHealthServer serves grpc.health.v1.Health on a local port and
+// returns its address and health state.
+func startGRPCHealthServer(t *testing.T, opts ...grpc.ServerOption) (string, *health.Server) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := grpc.NewServer(opts...)
+	state := health.NewServer()
+	healthpb.RegisterHealthServer(server, state)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+	return listener.Addr().String(), state
+}
+
+func TestGRPCProbe(t *testing.T) {
+	// httptest's certificate is valid for 127.0.0.1 and example.com.
+	certServer := httptest.NewTLSServer(http.NotFoundHandler())
+	certServer.Close()
+	cert := certServer.TLS.Certificates[0]
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certServer.Certificate().Raw})
+
+	plain, plainState := startGRPCHealthServer(t)
+	plainState.SetServingStatus("orders", healthpb.HealthCheckResponse_SERVING)
+	plainState.SetServingStatus("billing", healthpb.HealthCheckResponse_NOT_SERVING)
+	down, downState := startGRPCHealthServer(t)
+	downState.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	secure, _ := startGRPCHealthServer(t, grpc.Creds(credentials.NewServerTLSFromCert(&cert)))
+
+	opts := func(mutate func(*HealthCheckOptions)) HealthCheckOptions {
+		o := HealthCheckOptions{Timeout: time.Second, Protocol: protocolGRPC}
+		if mutate != nil {
+			mutate(&o)
+		}
+		return o
+	}
+	tests := []struct {
+		name    string
+		address string
+		opts    HealthCheckOptions
+		want    bool
+	}{
+		{"serving server passes", plain, opts(nil), true},
+		{"not serving server fails", down, opts(nil), false},
+		{"serving service passes", plain, opts(func(o *HealthCheckOptions) { o.GRPCService = "orders" }), true},
+		{"not serving service fails", plain, opts(func(o *HealthCheckOptions) { o.GRPCService = "billing" }), false},
+		{"unknown service fails", plain, opts(func(o *HealthCheckOptions) { o.GRPCService = "unknown" }), false},
+		{"TLS verified with the CA bundle", secure, opts(func(o *HealthCheckOptions) {
+			o.Scheme = schemeHTTPS
+			o.CABundle = caBundle
+			o.Host = "example.com"
+		}), true},
+		{"TLS with an unknown CA fails", secure, opts(func(o *HealthCheckOptions) { o.Scheme = schemeHTTPS }), false},
+		{"TLS with verification skipped", secure, opts(func(o *HealthCheckOptions) {
+			o.Scheme = schemeHTTPS
+			o.InsecureSkipVerify = true
+		}), true},
+		{"plaintext to a TLS server fails", secure, opts(nil), false},
+		{"nothing listening fails", closedAddress(t), opts(nil), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newGRPCProbe(tt.opts).check(tt.address); got != tt.want {
+				t.Errorf("check() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// closedAddress returns a local address nothing listens on.
+func closedAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	address := listener.Addr().String()
+	_ = listener.Close()
+	return address
+}
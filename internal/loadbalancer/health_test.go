@@ -0,0 +1,235 @@
+package loadbalancer
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPProbe(t *testing.T) {
+	// The handler answers with the status and body the request asks for,
+	// and fails requests that miss the expected host or header.
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/need-host" && r.Host != "api.example.com" {
+			w.WriteHeader(http.StatusMisdirectedRequest)
+			return
+		}
+		if r.URL.Path == "/need-header" && r.Header.Get("X-Probe") != "helios" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path == "/post-only" && r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		switch r.URL.Path {
+		case "/down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/redirect":
+			http.Redirect(w, r, "/down", http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"status": "up", "checks": 3}`))
+	})
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	secure := httptest.NewTLSServer(handler)
+	defer secure.Close()
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: secure.Certificate().Raw})
+
+	opts := func(path string) HealthCheckOptions {
+		return HealthCheckOptions{Timeout: time.Second, Protocol: "HTTP", HTTPPath: path}
+	}
+	tests := []struct {
+		name   string
+		server *httptest.Server
+		opts   func() HealthCheckOptions
+		want   bool
+	}{
+		{"2xx passes by default", plain, func() HealthCheckOptions { return opts("/") }, true},
+		{"5xx fails by default", plain, func() HealthCheckOptions { return opts("/down") }, false},
+		{"3xx passes by default without following", plain, func() HealthCheckOptions { return opts("/redirect") }, true},
+		{"expected statuses replace the default", plain, func() HealthCheckOptions {
+			o := opts("/down")
+			o.ExpectedStatuses = []int{503}
+			return o
+		}, true},
+		{"unexpected status fails", plain, func() HealthCheckOptions {
+			o := opts("/")
+			o.ExpectedStatuses = []int{204}
+			return o
+		}, false},
+		{"host header is sent", plain, func() HealthCheckOptions {
+			o := opts("/need-host")
+			o.Host = "api.example.com"
+			return o
+		}, true},
+		{"custom headers are sent", plain, func() HealthCheckOptions {
+			o := opts("/need-header")
+			o.Headers = map[string]string{"X-Probe": "helios"}
+			return o
+		}, true},
+		{"method is used", plain, func() HealthCheckOptions {
+			o := opts("/post-only")
+			o.Method = http.MethodPost
+			return o
+		}, true},
+		{"body substring matches", plain, func() HealthCheckOptions {
+			o := opts("/")
+			o.BodyContains = `"up"`
+			return o
+		}, true},
+		{"body substring missing fails", plain, func() HealthCheckOptions {
+			o := opts("/")
+			o.BodyContains = "down"
+			return o
+		}, false},
+		{"body regex matches", plain, func() HealthCheckOptions {
+			o := opts("/")
+			o.BodyRegex = `"checks":\s*[1-9]`
+			return o
+		}, true},
+		{"body regex mismatch fails", plain, func() HealthCheckOptions {
+			o := opts("/")
+			o.BodyRegex = `"checks":\s*0\b`
+			return o
+		}, false},
+		{"invalid body regex fails every check", plain, func() HealthCheckOptions {
+			o := opts("/")
+			o.BodyRegex = "("
+			return o
+		}, false},
+		{"HTTPS verified with the CA bundle", secure, func() HealthCheckOptions {
+			o := opts("/")
+			o.Scheme = schemeHTTPS
+			o.CABundle = caBundle
+			o.Host = "example.com"
+			return o
+		}, true},
+		{"HTTPS with an unknown CA fails", secure, func() HealthCheckOptions {
+			o := opts("/")
+			o.Scheme = schemeHTTPS
+			return o
+		}, false},
+		{"HTTPS with verification skipped", secure, func() HealthCheckOptions {
+			o := opts("/")
+			o.Scheme = schemeHTTPS
+			o.InsecureSkipVerify = true
+			return o
+		}, true},
+		{"plain HTTP to a TLS server fails", secure, func() HealthCheckOptions { return opts("/") }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address := strings.TrimPrefix(strings.TrimPrefix(tt.server.URL, "https://"), "http://")
+			if got := newHTTPProbe(tt.opts()).check(address); got != tt.want {
+				t.Errorf("check() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// slowProbe is a prober that takes delay per check and tracks how many
+// checks run at once, overall and per address.
+type slowProbe struct {
+	delay time.Duration
+
+	mu          sync.Mutex
+	running     int
+	maxRunning  int
+	perAddress  map[string]int
+	overlapping bool
+	checks      map[string]int
+}
+
+func (p *slowProbe) check(address string) bool {
+	p.mu.Lock()
+	p.running++
+	p.maxRunning = max(p.maxRunning, p.running)
+	p.perAddress[address]++
+	if p.perAddress[address] > 1 {
+		p.overlapping = true
+	}
+	p.checks[address]++
+	p.mu.Unlock()
+
+	time.Sleep(p.delay)
+
+	p.mu.Lock()
+	p.running--
+	p.perAddress[address]--
+	p.mu.Unlock()
+	return true
+}
+
+func (p *slowProbe) checked(address string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.checks[address]
+}
+
+func TestHealthCheckScheduler(t *testing.T) {
+	// Probes take longer than the interval, so a scheduler that started a
+	// round per tick would overlap them.
+	probe := &slowProbe{delay: 30 * time.Millisecond, perAddress: map[string]int{}, checks: map[string]int{}}
+	opts := HealthCheckOptions{Timeout: time.Second, Protocol: protocolHTTP, HTTPPath: "/", Concurrency: 3}
+	lb := NewLoadBalancer(BalancerConfig{Type: RoundRobin, HealthCheckOpts: opts})
+	defer lb.Stop()
+	lb.mu.Lock()
+	lb.probe = probe
+	lb.mu.Unlock()
+	var backends []*Backend
+	for i := range 10 {
+		b := createTestBackend("10.0.0."+strconv.Itoa(i+1), "test-service", 1)
+		backends = append(backends, b)
+		lb.AddBackend(b)
+	}
+	lb.Reconfigure(BalancerConfig{Type: RoundRobin, HealthCheck: true, CheckInterval: 10 * time.Millisecond, HealthCheckOpts: opts})
+
+	// A backend added while the scheduler runs is picked up.
+	added := createTestBackend("10.0.1.1", "other-service", 1)
+	lb.AddBackend(added)
+	time.Sleep(300 * time.Millisecond)
+	lb.RemoveBackend(added.Address, added.ServiceName)
+	time.Sleep(50 * time.Millisecond)
+	afterRemoval := probe.checked(backendKey(added))
+	time.Sleep(100 * time.Millisecond)
+	lb.Stop()
+
+	probe.mu.Lock()
+	defer probe.mu.Unlock()
+	if probe.maxRunning > 3 {
+		t.Errorf("%d checks ran at once, want at most 3", probe.maxRunning)
+	}
+	if probe.overlapping {
+		t.Error("checks of one backend overlapped")
+	}
+	for _, b := range append(backends, added) {
+		if probe.checks[backendKey(b)] == 0 {
+			t.Errorf("backend %s was never checked", b.Address)
+		}
+		if b != added && b.ProbeLatency() < probe.delay {
+			t.Errorf("ProbeLatency() of %s = %v, want at least %v", b.Address, b.ProbeLatency(), probe.delay)
+		}
+	}
+	if got := probe.checks[backendKey(added)]; got != afterRemoval {
+		t.Errorf("removed backend checked %d more times", got-afterRemoval)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	interval := time.Second
+	for range 100 {
+		if got := jitter(interval); got < 900*time.Millisecond || got > 1100*time.Millisecond {
+			t.Fatalf("jitter(%v) = %v, want within 10%%", interval, got)
+		}
+	}
+	if got := jitter(time.Nanosecond); got != time.Nanosecond {
+		t.Errorf("jitter(1ns) = %v, want it unchanged", got)
+	}
+}
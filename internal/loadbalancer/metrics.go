@@ -20,6 +20,9 @@ func (lb *LoadBalancer) UpdateMetrics(recorder *metrics.MetricsRecorder) {
 		for _, backend := range backends {
 			recorder.RecordBackendHealth(backend.Address, serviceName, backend.IsHealthy())
 			recorder.RecordBackendConnections(backend.Address, serviceName, float64(backend.Connections))
+			if latency := backend.ProbeLatency(); latency > 0 {
+				recorder.RecordBackendProbeLatency(backend.Address, serviceName, latency.Seconds())
+			}
 		}
 	}
 }
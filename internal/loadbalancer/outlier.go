@@ -0,0 +1,186 @@
+package loadbalancer
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// OutlierDetectionOptions holds the parameters of passive health checking:
+// ejecting backends whose traffic keeps failing.
+type OutlierDetectionOptions struct {
+	// ConsecutiveFailures is the number of failures in a row that eject a
+	// backend.
+	ConsecutiveFailures int
+	// BaseEjectionTime is how long the first ejection lasts. Each further
+	// ejection doubles it, up to MaxEjectionTime.
+	BaseEjectionTime time.Duration
+	MaxEjectionTime  time.Duration
+	// MaxEjectionPercent caps the share of a pool's backends ejected at once.
+	// One backend can always be ejected, so the cap does not switch outlier
+	// detection off for pools too small to reach a whole backend.
+	MaxEjectionPercent int
+}
+
+// DefaultOutlierDetectionOptions returns the default outlier detection
+// options.
+func DefaultOutlierDetectionOptions() OutlierDetectionOptions {
+	return OutlierDetectionOptions{
+		ConsecutiveFailures: 5,
+		BaseEjectionTime:    30 * time.Second,
+		MaxEjectionTime:     5 * time.Minute,
+		MaxEjectionPercent:  10,
+	}
+}
+
+// withDefaults fills in the options left unset.
+func (o OutlierDetectionOptions) withDefaults() OutlierDetectionOptions {
+	defaults := DefaultOutlierDetectionOptions()
+	if o.ConsecutiveFailures <= 0 {
+		o.ConsecutiveFailures = defaults.ConsecutiveFailures
+	}
+	if o.BaseEjectionTime <= 0 {
+		o.BaseEjectionTime = defaults.BaseEjectionTime
+	}
+	if o.MaxEjectionTime <= 0 {
+		o.MaxEjectionTime = defaults.MaxEjectionTime
+	}
+	if o.MaxEjectionTime < o.BaseEjectionTime {
+		o.MaxEjectionTime = o.BaseEjectionTime
+	}
+	if o.MaxEjectionPercent <= 0 {
+		o.MaxEjectionPercent = defaults.MaxEjectionPercent
+	}
+	return o
+}
+
+// OutlierObserver is called when outlier detection ejects a backend or lets
+// it back in.
+type OutlierObserver func(backend *Backend, ejected bool)
+
+// OnOutlierChange sets the observer told about ejections. It is called from
+// the goroutines reporting traffic results and from timers, and must not
+// block.
+func (lb *LoadBalancer) OnOutlierChange(observer OutlierObserver) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.outlierObserver = observer
+}
+
+// Ejected reports whether outlier detection keeps the backend out.
+func (b *Backend) Ejected() bool {
+	return atomic.LoadInt32(&b.ejected) == 1
+}
+
+// EjectedUntil returns when the backend's current or last ejection ends, or
+// the zero time if it was never ejected.
+func (b *Backend) EjectedUntil() time.Time {
+	until := atomic.LoadInt64(&b.ejectedUntil)
+	if until == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, until)
+}
+
+// ReportResult records the outcome of traffic forwarded to backend. Enough
+// failures in a row eject the backend; a success resets the count. Without
+// outlier detection it does nothing.
+func (lb *LoadBalancer) ReportResult(backend *Backend, success bool) {
+	lb.mu.RLock()
+	enabled, opts := lb.config.OutlierDetection, lb.config.OutlierDetectionOpts
+	lb.mu.RUnlock()
+	if !enabled {
+		return
+	}
+
+	now := time.Now()
+	if success {
+		atomic.StoreInt32(&backend.outlierFailures, 0)
+		// A backend that stayed in for MaxEjectionTime since its last
+		// ejection starts over at BaseEjectionTime.
+		if atomic.LoadInt32(&backend.ejections) > 0 && !backend.Ejected() &&
+			now.UnixNano() > atomic.LoadInt64(&backend.ejectedUntil)+int64(opts.MaxEjectionTime) {
+			atomic.StoreInt32(&backend.ejections, 0)
+		}
+		return
+	}
+	if atomic.AddInt32(&backend.outlierFailures, 1) < int32(opts.ConsecutiveFailures) || backend.Ejected() {
+		return
+	}
+	lb.eject(backend, opts, now)
+}
+
+// eject takes backend out of its pool unless that would eject more than
+// MaxEjectionPercent of the pool, and schedules its return. Like Envoy, it
+// allows at least one ejection whatever the percentage.
+func (lb *LoadBalancer) eject(backend *Backend, opts OutlierDetectionOptions, now time.Time) {
+	lb.outlierMu.Lock()
+	lb.mu.RLock()
+	pool := lb.backends[backend.ServiceName]
+	member, ejected := false, 0
+	for _, b := range pool {
+		if b == backend {
+			member = true
+		}
+		if b.Ejected() {
+			ejected++
+		}
+	}
+	observer := lb.outlierObserver
+	lb.mu.RUnlock()
+	if !member || backend.Ejected() || ejected+1 > max(1, len(pool)*opts.MaxEjectionPercent/100) {
+		lb.outlierMu.Unlock()
+		return
+	}
+
+	d := opts.BaseEjectionTime
+	for i := atomic.AddInt32(&backend.ejections, 1); i > 1 && d < opts.MaxEjectionTime; i-- {
+		d *= 2
+	}
+	d = min(d, opts.MaxEjectionTime)
+	until := now.Add(d).UnixNano()
+	atomic.StoreInt64(&backend.ejectedUntil, until)
+	atomic.StoreInt32(&backend.outlierFailures, 0)
+	atomic.StoreInt32(&backend.ejected, 1)
+	lb.outlierMu.Unlock()
+
+	if observer != nil {
+		observer(backend, true)
+	}
+	time.AfterFunc(d, func() { lb.unEject(backend, until) })
+}
+
+// unEject lets backend back in when the ejection that ends at until is still
+// the current one.
+func (lb *LoadBalancer) unEject(backend *Backend, until int64) {
+	if atomic.LoadInt64(&backend.ejectedUntil) != until || !atomic.CompareAndSwapInt32(&backend.ejected, 1, 0) {
+		return
+	}
+	backend.markAvailable()
+	select {
+	case <-lb.stopCh:
+		return
+	default:
+	}
+	lb.mu.RLock()
+	observer := lb.outlierObserver
+	lb.mu.RUnlock()
+	if observer != nil {
+		observer(backend, false)
+	}
+}
+
+// clearEjections lets every ejected backend back in and forgets their
+// failures and past ejections.
+func (lb *LoadBalancer) clearEjections() {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	for _, backends := range lb.backends {
+		for _, backend := range backends {
+			atomic.StoreInt32(&backend.outlierFailures, 0)
+			atomic.StoreInt32(&backend.ejections, 0)
+			if atomic.CompareAndSwapInt32(&backend.ejected, 1, 0) && lb.outlierObserver != nil {
+				lb.outlierObserver(backend, false)
+			}
+		}
+	}
+}
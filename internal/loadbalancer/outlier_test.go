@@ -0,0 +1,163 @@
+package loadbalancer
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newOutlierBalancer returns a RoundRobin balancer with outlier detection and
+// n backends in "test-service".
+func newOutlierBalancer(opts OutlierDetectionOptions, n int) (*LoadBalancer, []*Backend) {
+	lb := NewLoadBalancer(BalancerConfig{Type: RoundRobin, OutlierDetection: true, OutlierDetectionOpts: opts})
+	backends := make([]*Backend, n)
+	for i := range backends {
+		backends[i] = createTestBackend("10.0.0."+string(rune('1'+i)), "test-service", 1)
+		lb.AddBackend(backends[i])
+	}
+	return lb, backends
+}
+
+func TestOutlierDetection(t *testing.T) {
+	t.Run("consecutive failures eject", func(t *testing.T) {
+		lb, backends := newOutlierBalancer(OutlierDetectionOptions{ConsecutiveFailures: 3, MaxEjectionPercent: 50}, 2)
+		defer lb.Stop()
+		failing := backends[0]
+
+		lb.ReportResult(failing, false)
+		lb.ReportResult(failing, false)
+		lb.ReportResult(failing, true)
+		lb.ReportResult(failing, false)
+		lb.ReportResult(failing, false)
+		if failing.Ejected() {
+			t.Fatal("backend ejected although a success broke the run of failures")
+		}
+		lb.ReportResult(failing, false)
+		if !failing.Ejected() || failing.Available() {
+			t.Fatal("backend not ejected after three failures in a row")
+		}
+		for range 4 {
+			if got := lb.NextBackend("test-service", ""); got != backends[1] {
+				t.Errorf("NextBackend() = %v, want the backend that was not ejected", got.Address)
+			}
+		}
+	})
+
+	t.Run("default options eject one backend of a small pool", func(t *testing.T) {
+		lb, backends := newOutlierBalancer(DefaultOutlierDetectionOptions(), 3)
+		defer lb.Stop()
+		for _, b := range backends {
+			for range DefaultOutlierDetectionOptions().ConsecutiveFailures {
+				lb.ReportResult(b, false)
+			}
+		}
+		ejected := 0
+		for _, b := range backends {
+			if b.Ejected() {
+				ejected++
+			}
+		}
+		if !backends[0].Ejected() || ejected != 1 {
+			t.Errorf("ejected %d of 3 backends, want only the first to fail: one ejection is always allowed", ejected)
+		}
+	})
+
+	t.Run("ejections never exceed the max percent", func(t *testing.T) {
+		lb, backends := newOutlierBalancer(OutlierDetectionOptions{ConsecutiveFailures: 1, MaxEjectionPercent: 40}, 5)
+		defer lb.Stop()
+		for _, b := range backends {
+			lb.ReportResult(b, false)
+		}
+		ejected := 0
+		for _, b := range backends {
+			if b.Ejected() {
+				ejected++
+			}
+		}
+		if ejected != 2 {
+			t.Errorf("ejected %d of 5 backends, want 2 (40%%)", ejected)
+		}
+	})
+
+	t.Run("ejection time grows exponentially", func(t *testing.T) {
+		opts := OutlierDetectionOptions{
+			ConsecutiveFailures: 1,
+			BaseEjectionTime:    20 * time.Millisecond,
+			MaxEjectionTime:     50 * time.Millisecond,
+			MaxEjectionPercent:  100,
+		}
+		lb, backends := newOutlierBalancer(opts, 1)
+		defer lb.Stop()
+		backend := backends[0]
+
+		for _, want := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond} {
+			start := time.Now()
+			lb.ReportResult(backend, false)
+			if got := backend.EjectedUntil().Sub(start); got < want || got > want+10*time.Millisecond {
+				t.Errorf("ejection lasts %v, want %v", got, want)
+			}
+			deadline := time.Now().Add(time.Second)
+			for backend.Ejected() && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			if backend.Ejected() {
+				t.Fatal("backend was not let back in")
+			}
+		}
+	})
+
+	t.Run("observer sees ejection and return", func(t *testing.T) {
+		lb, backends := newOutlierBalancer(OutlierDetectionOptions{
+			ConsecutiveFailures: 1,
+			BaseEjectionTime:    10 * time.Millisecond,
+			MaxEjectionPercent:  100,
+		}, 1)
+		defer lb.Stop()
+		changes := make(chan bool, 2)
+		lb.OnOutlierChange(func(_ *Backend, ejected bool) { changes <- ejected })
+
+		lb.ReportResult(backends[0], false)
+		for _, want := range []bool{true, false} {
+			select {
+			case got := <-changes:
+				if got != want {
+					t.Errorf("ejected = %v, want %v", got, want)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("no change to ejected = %v reported", want)
+			}
+		}
+	})
+
+	t.Run("disabled does nothing", func(t *testing.T) {
+		lb := NewLoadBalancer(BalancerConfig{Type: RoundRobin})
+		defer lb.Stop()
+		backend := createTestBackend("10.0.0.1", "test-service", 1)
+		lb.AddBackend(backend)
+		for range 10 {
+			lb.ReportResult(backend, false)
+		}
+		if backend.Ejected() {
+			t.Error("backend ejected without outlier detection")
+		}
+	})
+
+	t.Run("turning it off lets ejected backends back in", func(t *testing.T) {
+		lb, backends := newOutlierBalancer(OutlierDetectionOptions{ConsecutiveFailures: 1, MaxEjectionPercent: 100}, 1)
+		defer lb.Stop()
+		var returned atomic.Int32
+		lb.OnOutlierChange(func(_ *Backend, ejected bool) {
+			if !ejected {
+				returned.Add(1)
+			}
+		})
+		lb.ReportResult(backends[0], false)
+		if !backends[0].Ejected() {
+			t.Fatal("backend not ejected")
+		}
+		lb.Reconfigure(BalancerConfig{Type: RoundRobin})
+		if backends[0].Ejected() || returned.Load() != 1 {
+			t.Errorf("ejected = %v, returns reported = %d; want back in and one return", backends[0].Ejected(), returned.Load())
+		}
+	})
+}
@@ -13,6 +13,10 @@ const (
 	WeightedRoundRobin BalancerType = "weightedroundrobin"
 	IPHash             BalancerType = "iphash"
 	RandomSelection    BalancerType = "random"
+	Maglev             BalancerType = "maglev"
+	RingHash           BalancerType = "ringhash"
+	P2C                BalancerType = "p2c"
+	LeastResponseTime  BalancerType = "leastresponsetime"
 )
 
 // Algorithm defines the interface for load balancing algorithms.
@@ -35,6 +39,17 @@ type BalancerConfig struct {
 	MetricsEnabled  bool
 	Weights         []Weight
 	HealthCheckOpts HealthCheckOptions
+	// OutlierDetection ejects backends the proxy sees failing, for the
+	// times OutlierDetectionOpts sets.
+	OutlierDetection     bool
+	OutlierDetectionOpts OutlierDetectionOptions
+	// SlowStart ramps the share of a backend that was added or turned
+	// available up to full over this long, under WeightedRoundRobin,
+	// LeastConnection and RandomSelection. Zero disables it.
+	SlowStart time.Duration
+	// DrainTimeout is how long a removed backend keeps its open
+	// connections before it is dropped. Zero drops it at once.
+	DrainTimeout time.Duration
 }
 
 type Backend struct {
@@ -44,6 +59,29 @@ type Backend struct {
 	Connections int32
 	ServiceName string
 	Weight      int
+	// successes and failures count the consecutive passed and failed
+	// health checks.
+	successes int32
+	failures  int32
+	// latency is the moving average of the backend's observed latency in
+	// nanoseconds, zero until the first observation.
+	latency int64
+	// probeLatency is how long the last health check took, in nanoseconds.
+	probeLatency int64
+	// outlierFailures counts the consecutive failures reported for the
+	// backend's traffic. ejected is 1 while outlier detection keeps the
+	// backend out, until ejectedUntil in Unix nanoseconds; ejections counts
+	// its recent ejections, which lengthen the next one.
+	outlierFailures int32
+	ejected         int32
+	ejectedUntil    int64
+	ejections       int32
+	// availableSince is when the backend was added or last turned
+	// available, in Unix nanoseconds; slow start ramps from it.
+	availableSince int64
+	// draining is the backend's drain state: drainNone, drainActive or
+	// drainDone.
+	draining int32
 }
 
 type LoadBalancerStats struct {
@@ -65,4 +103,26 @@ type LoadBalancer struct {
 	stopCh    chan struct{}
 	wg        sync.WaitGroup
 	checkWg   sync.WaitGroup
+
+	// lifecycleMu serializes Reconfigure and Stop, which start and stop
+	// the health check loop.
+	lifecycleMu sync.Mutex
+	// checkStop stops the running health check loop; nil when none runs.
+	checkStop chan struct{}
+	// backendsChanged tells the health check loop that backends were added
+	// or removed.
+	backendsChanged chan struct{}
+	// healthObserver is told about health changes; guarded by mu.
+	healthObserver HealthObserver
+	// probeObserver is told how long each check took; guarded by mu.
+	probeObserver ProbeObserver
+	// probe runs HTTP and gRPC health checks; nil for TCP. Guarded by mu.
+	probe prober
+	// outlierObserver is told about ejections; guarded by mu.
+	outlierObserver OutlierObserver
+	// outlierMu serializes ejections, so two backends failing at once
+	// cannot both pass the MaxEjectionPercent check.
+	outlierMu sync.Mutex
+	// drainObserver is told when backends finish draining; guarded by mu.
+	drainObserver DrainObserver
 }
@@ -17,6 +17,9 @@ const (
 	labelResult         = "result"
 	labelReason         = "reason"
 	labelIPAddress      = "ip_address"
+	labelFrontend       = "frontend"
+	labelState          = "state"
+	labelFamily         = "family"
 )
 
 var (
@@ -47,6 +50,15 @@ var (
 		[]string{labelBackendAddress, labelServiceName},
 	)
 
+	// Duration of the last health check of each backend
+	backendProbeLatency = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helios_backend_probe_latency_seconds",
+			Help: "Duration of the last health check of a backend in seconds",
+		},
+		[]string{labelBackendAddress, labelServiceName},
+	)
+
 	// Load balancer request processing time
 	requestDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -103,6 +115,15 @@ var (
 		[]string{labelName, labelNamespace, labelReason},
 	)
 
+	// IP release counter
+	ipReleasedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "helios_ip_released_total",
+			Help: "Total number of IPs released from services by reason",
+		},
+		[]string{labelName, labelNamespace, labelReason},
+	)
+
 	// IP allocation pool utilization
 	ipPoolUtilization = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -111,6 +132,51 @@ var (
 		},
 		[]string{labelName, labelNamespace},
 	)
+
+	// Addresses still free in the ranges a config allocates from
+	ipPoolFree = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helios_ip_pool_free",
+			Help: "Number of IPs still available to a HeliosConfig by address family",
+		},
+		[]string{labelName, labelNamespace, labelFamily},
+	)
+
+	// Backend health changes seen by the built-in proxy's health checks
+	backendHealthTransitions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "helios_backend_health_transitions_total",
+			Help: "Total number of backend health changes per proxy frontend by new state",
+		},
+		[]string{labelFrontend, labelBackendAddress, labelState},
+	)
+
+	// Backends ejected by the built-in proxy's outlier detection
+	backendOutlierEjections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "helios_backend_outlier_ejections_total",
+			Help: "Total number of backend ejections by outlier detection per proxy frontend",
+		},
+		[]string{labelFrontend, labelBackendAddress},
+	)
+
+	// Backends currently ejected by outlier detection
+	backendEjected = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helios_backend_ejected",
+			Help: "Whether a backend of a proxy frontend is ejected by outlier detection (1) or not (0)",
+		},
+		[]string{labelFrontend, labelBackendAddress},
+	)
+
+	// Active UDP flows of the built-in proxy
+	udpFlows = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helios_proxy_udp_flows",
+			Help: "Number of active UDP flows per proxy frontend",
+		},
+		[]string{labelFrontend},
+	)
 )
 
 func init() {
@@ -119,13 +185,20 @@ func init() {
 		lbStatus,
 		backendConnections,
 		backendHealth,
+		backendProbeLatency,
 		requestDuration,
 		operationTotal,
 		ipAllocationStatus,
 		reconcileDuration,
 		reconcileTotal,
 		requeueReasonTotal,
+		ipReleasedTotal,
 		ipPoolUtilization,
+		ipPoolFree,
+		backendHealthTransitions,
+		backendOutlierEjections,
+		backendEjected,
+		udpFlows,
 	)
 }
 
@@ -150,6 +223,12 @@ func (m *MetricsRecorder) RecordBackendConnections(backendAddr, serviceName stri
 	backendConnections.WithLabelValues(backendAddr, serviceName).Set(connections)
 }
 
+// RecordBackendProbeLatency records how long the last health check of a
+// backend took
+func (m *MetricsRecorder) RecordBackendProbeLatency(backendAddr, serviceName string, seconds float64) {
+	backendProbeLatency.WithLabelValues(backendAddr, serviceName).Set(seconds)
+}
+
 // RecordBackendHealth records the health status of a backend
 func (m *MetricsRecorder) RecordBackendHealth(backendAddr, serviceName string, healthy bool) {
 	value := 0.0
@@ -189,7 +268,49 @@ func (m *MetricsRecorder) RecordRequeueReason(name, namespace, reason string) {
 	requeueReasonTotal.WithLabelValues(name, namespace, reason).Inc()
 }
 
+// RecordIPRelease records an IP released from a service that no longer needs it
+func (m *MetricsRecorder) RecordIPRelease(name, namespace, reason string) {
+	ipReleasedTotal.WithLabelValues(name, namespace, reason).Inc()
+}
+
 // RecordIPPoolUtilization records the number of allocated IPs for a config
 func (m *MetricsRecorder) RecordIPPoolUtilization(name, namespace string, count int) {
 	ipPoolUtilization.WithLabelValues(name, namespace).Set(float64(count))
 }
+
+// RecordIPPoolFree records the number of IPs of one address family ("ipv4" or
+// "ipv6") still available to a config
+func (m *MetricsRecorder) RecordIPPoolFree(name, namespace, family string, free float64) {
+	ipPoolFree.WithLabelValues(name, namespace, family).Set(free)
+}
+
+// RecordBackendHealthTransition records a backend of a proxy frontend turning
+// healthy or unhealthy
+func (m *MetricsRecorder) RecordBackendHealthTransition(frontend, backendAddr string, healthy bool) {
+	state := "unhealthy"
+	if healthy {
+		state = "healthy"
+	}
+	backendHealthTransitions.WithLabelValues(frontend, backendAddr, state).Inc()
+}
+
+// RecordBackendEjection records a backend of a proxy frontend being ejected
+// by outlier detection, or let back in
+func (m *MetricsRecorder) RecordBackendEjection(frontend, backendAddr string, ejected bool) {
+	if ejected {
+		backendOutlierEjections.WithLabelValues(frontend, backendAddr).Inc()
+		backendEjected.WithLabelValues(frontend, backendAddr).Set(1)
+		return
+	}
+	backendEjected.WithLabelValues(frontend, backendAddr).Set(0)
+}
+
+// RecordUDPFlows records the number of active UDP flows of a proxy frontend
+func (m *MetricsRecorder) RecordUDPFlows(frontend string, count int) {
+	udpFlows.WithLabelValues(frontend).Set(float64(count))
+}
+
+// DeleteUDPFlows removes the UDP flow gauge of a frontend that stopped listening
+func (m *MetricsRecorder) DeleteUDPFlows(frontend string) {
+	udpFlows.DeleteLabelValues(frontend)
+}
@@ -21,6 +21,8 @@ func TestMetricsRecorder(t *testing.T) {
 		recorder.RecordBackendHealth("192.168.1.1", "test-service", false)
 		// Test different service
 		recorder.RecordBackendHealth("192.168.1.2", "other-service", true)
+		// Test probe latency
+		recorder.RecordBackendProbeLatency("192.168.1.1", "test-service", 0.002)
 	})
 
 	t.Run("Backend connections metrics", func(t *testing.T) {
@@ -88,10 +90,33 @@ func TestMetricsRecorder(t *testing.T) {
 		recorder.RecordRequeueReason("config2", "default", "periodic")
 	})
 
+	t.Run("IP release metrics", func(t *testing.T) {
+		recorder.RecordIPRelease("config1", "default", "service_deleted")
+		recorder.RecordIPRelease("config1", "default", "service_type_changed")
+	})
+
 	t.Run("IP pool utilization metrics", func(t *testing.T) {
 		recorder.RecordIPPoolUtilization("config1", "default", 5)
 		recorder.RecordIPPoolUtilization("config2", "default", 0)
 		recorder.RecordIPPoolUtilization("config1", "default", 10)
+		recorder.RecordIPPoolFree("config1", "default", "ipv4", 244)
+		recorder.RecordIPPoolFree("config1", "default", "ipv6", 1<<64)
+	})
+
+	t.Run("UDP flow metrics", func(t *testing.T) {
+		recorder.RecordUDPFlows("192.0.2.10:53/UDP", 3)
+		recorder.RecordUDPFlows("192.0.2.10:53/UDP", 0)
+		recorder.DeleteUDPFlows("192.0.2.10:53/UDP")
+	})
+
+	t.Run("Backend health transitions", func(t *testing.T) {
+		recorder.RecordBackendHealthTransition("192.0.2.10:80/TCP", "10.0.0.1:8080", false)
+		recorder.RecordBackendHealthTransition("192.0.2.10:80/TCP", "10.0.0.1:8080", true)
+	})
+
+	t.Run("Backend ejections", func(t *testing.T) {
+		recorder.RecordBackendEjection("192.0.2.10:80/TCP", "10.0.0.1:8080", true)
+		recorder.RecordBackendEjection("192.0.2.10:80/TCP", "10.0.0.1:8080", false)
 	})
 
 	t.Run("Edge cases", func(t *testing.T) {
@@ -0,0 +1,245 @@
+package network
+
+import (
+	"math/big"
+	"math/rand/v2"
+	"net/netip"
+)
+
+// addrInterval is an inclusive range of addresses of one family.
+type addrInterval struct {
+	start netip.Addr
+	end   netip.Addr
+}
+
+// size returns the number of addresses in the interval.
+func (iv addrInterval) size() *big.Int {
+	n := new(big.Int).Sub(addrToInt(iv.end), addrToInt(iv.start))
+	return n.Add(n, big.NewInt(1))
+}
+
+// addrToInt returns the address as an unsigned integer.
+func addrToInt(a netip.Addr) *big.Int {
+	return new(big.Int).SetBytes(a.AsSlice())
+}
+
+// intervalNode is a node of the treap behind intervalSet, keyed by the start
+// of its interval.
+type intervalNode struct {
+	iv          addrInterval
+	priority    uint64
+	left, right *intervalNode
+}
+
+// intervalSet is a set of addresses stored as disjoint, non-adjacent
+// intervals in a treap ordered by interval start. Membership, insertion,
+// removal and finding the lowest member-free address above a point all take
+// O(log n) expected time in the number of intervals, independent of how many
+// addresses the intervals span, so a /64 costs the same as a /24.
+//
+// Keeping neighbouring intervals merged is what makes the lowest free address
+// a single lookup: the address after the interval covering a point is never
+// itself a member.
+type intervalSet struct {
+	root *intervalNode
+	n    int // number of intervals
+}
+
+// floor returns the node with the greatest start <= a, or nil.
+func (s *intervalSet) floor(a netip.Addr) *intervalNode {
+	var best *intervalNode
+	for n := s.root; n != nil; {
+		if n.iv.start.Compare(a) <= 0 {
+			best = n
+			n = n.right
+		} else {
+			n = n.left
+		}
+	}
+	return best
+}
+
+// ceiling returns the node with the smallest start >= a, or nil.
+func (s *intervalSet) ceiling(a netip.Addr) *intervalNode {
+	var best *intervalNode
+	for n := s.root; n != nil; {
+		if n.iv.start.Compare(a) >= 0 {
+			best = n
+			n = n.left
+		} else {
+			n = n.right
+		}
+	}
+	return best
+}
+
+// contains reports whether a is a member.
+func (s *intervalSet) contains(a netip.Addr) bool {
+	n := s.floor(a)
+	return n != nil && a.Compare(n.iv.end) <= 0
+}
+
+// add inserts a, merging it with the intervals on either side when adjacent.
+func (s *intervalSet) add(a netip.Addr) {
+	left := s.floor(a)
+	if left != nil && a.Compare(left.iv.end) <= 0 {
+		return
+	}
+	right := s.ceiling(a)
+	joinLeft := left != nil && left.iv.end.Next() == a
+	joinRight := right != nil && a.Next() == right.iv.start
+
+	switch {
+	case joinLeft && joinRight:
+		end := right.iv.end
+		s.delete(right.iv.start)
+		left.iv.end = end
+	case joinLeft:
+		left.iv.end = a
+	case joinRight:
+		end := right.iv.end
+		s.delete(right.iv.start)
+		s.insert(addrInterval{start: a, end: end})
+	default:
+		s.insert(addrInterval{start: a, end: a})
+	}
+}
+
+// remove deletes a, splitting the interval that covers it if needed.
+func (s *intervalSet) remove(a netip.Addr) {
+	n := s.floor(a)
+	if n == nil || a.Compare(n.iv.end) > 0 {
+		return
+	}
+	iv := n.iv
+	switch {
+	case iv.start == a && iv.end == a:
+		s.delete(iv.start)
+	case iv.start == a:
+		s.delete(iv.start)
+		s.insert(addrInterval{start: a.Next(), end: iv.end})
+	case iv.end == a:
+		n.iv.end = a.Prev()
+	default:
+		n.iv.end = a.Prev()
+		s.insert(addrInterval{start: a.Next(), end: iv.end})
+	}
+}
+
+// firstFree returns the lowest address in [from, to] that is not a member.
+func (s *intervalSet) firstFree(from, to netip.Addr) (netip.Addr, bool) {
+	if n := s.floor(from); n != nil && from.Compare(n.iv.end) <= 0 {
+		from = n.iv.end.Next()
+		if !from.IsValid() {
+			return netip.Addr{}, false
+		}
+	}
+	if from.Compare(to) > 0 {
+		return netip.Addr{}, false
+	}
+	return from, true
+}
+
+// countIn returns the number of members within iv.
+func (s *intervalSet) countIn(iv addrInterval) *big.Int {
+	total := new(big.Int)
+	n := s.floor(iv.start)
+	if n == nil || iv.start.Compare(n.iv.end) > 0 {
+		n = s.ceiling(iv.start)
+	}
+	for n != nil && n.iv.start.Compare(iv.end) <= 0 {
+		overlap := n.iv
+		if overlap.start.Compare(iv.start) < 0 {
+			overlap.start = iv.start
+		}
+		if overlap.end.Compare(iv.end) > 0 {
+			overlap.end = iv.end
+		}
+		total.Add(total, overlap.size())
+		next := n.iv.end.Next()
+		if !next.IsValid() {
+			break
+		}
+		n = s.ceiling(next)
+	}
+	return total
+}
+
+// insert adds a node for iv, which must not overlap any existing interval.
+func (s *intervalSet) insert(iv addrInterval) {
+	s.root = insertNode(s.root, &intervalNode{iv: iv, priority: rand.Uint64()})
+	s.n++
+}
+
+func insertNode(root, node *intervalNode) *intervalNode {
+	if root == nil {
+		return node
+	}
+	if node.iv.start.Compare(root.iv.start) < 0 {
+		root.left = insertNode(root.left, node)
+		if root.left.priority > root.priority {
+			root = rotateRight(root)
+		}
+	} else {
+		root.right = insertNode(root.right, node)
+		if root.right.priority > root.priority {
+			root = rotateLeft(root)
+		}
+	}
+	return root
+}
+
+// delete removes the node whose interval starts at start.
+func (s *intervalSet) delete(start netip.Addr) {
+	var removed bool
+	s.root, removed = deleteNode(s.root, start)
+	if removed {
+		s.n--
+	}
+}
+
+func deleteNode(root *intervalNode, start netip.Addr) (*intervalNode, bool) {
+	if root == nil {
+		return nil, false
+	}
+	var removed bool
+	switch c := start.Compare(root.iv.start); {
+	case c < 0:
+		root.left, removed = deleteNode(root.left, start)
+	case c > 0:
+		root.right, removed = deleteNode(root.right, start)
+	default:
+		return mergeNodes(root.left, root.right), true
+	}
+	return root, removed
+}
+
+// mergeNodes joins two treaps where every start in a precedes every start in b.
+func mergeNodes(a, b *intervalNode) *intervalNode {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case a.priority > b.priority:
+		a.right = mergeNodes(a.right, b)
+		return a
+	default:
+		b.left = mergeNodes(a, b.left)
+		return b
+	}
+}
+
+func rotateRight(n *intervalNode) *intervalNode {
+	l := n.left
+	n.left = l.right
+	l.right = n
+	return l
+}
+
+func rotateLeft(n *intervalNode) *intervalNode {
+	r := n.right
+	n.right = r.left
+	r.left = n
+	return r
+}
@@ -1,37 +1,41 @@
 package network
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
+	"math/big"
 	"net"
+	"net/netip"
 	"strings"
 	"sync"
 )
 
-// defaultMaxScan bounds AllocateIP's linear scan. 65536 covers a full IPv4 /16
-// (the largest realistic LB IP pool); without it, a very large range such as an
-// IPv6 /64 would scan an effectively unbounded address space while holding a.mu.
-const defaultMaxScan = 1 << 16
-
-// IPAllocator handles IP address allocation
+// IPAllocator handles IP address allocation.
+//
+// Used addresses are kept as an intervalSet rather than one entry per address,
+// so allocation, release and the lookup of the next free address cost
+// O(log n) in the number of used runs whatever the size of the range: an IPv6
+// /64 is handled like an IPv4 /24, and a nearly full pool is as fast as an
+// empty one.
 type IPAllocator struct {
-	mu      sync.Mutex
-	used    map[string]bool
-	maxScan int
+	mu   sync.Mutex
+	used intervalSet
 }
 
 // NewIPAllocator creates a new IPAllocator
 func NewIPAllocator() *IPAllocator {
-	return &IPAllocator{
-		used:    make(map[string]bool),
-		maxScan: defaultMaxScan,
-	}
+	return &IPAllocator{}
 }
 
-// AllocateIP allocates an available IP from the range
+// AllocateIP allocates an available IP from the range. ipRange may be a range
+// set; the lowest free address across all of its ranges is handed out first,
+// whatever order they were listed in. Excluded ranges of the set are skipped.
 func (a *IPAllocator) AllocateIP(ipRange string) (string, error) {
-	start, end, err := ParseIPRange(ipRange)
+	ranges, err := ParseRangeSet(ipRange)
+	if err != nil {
+		return "", err
+	}
+	excluded, err := ParseExclusions(ipRange)
 	if err != nil {
 		return "", err
 	}
@@ -40,32 +44,113 @@ func (a *IPAllocator) AllocateIP(ipRange string) (string, error) {
 	defer a.mu.Unlock()
 
 	// If the requested IP is a single IP within the range
-	if start.Equal(end) {
-		ipStr := start.String()
+	if len(ranges) == 1 && ranges[0].Start.Equal(ranges[0].End) {
+		if findRange(excluded, ranges[0].Start) != nil {
+			return "", fmt.Errorf("no available IPs in range %s", ipRange)
+		}
+		addr := toAddr(ranges[0].Start)
 		// Even if it's already in use, return the same IP
-		a.used[ipStr] = true
-		return ipStr, nil
+		a.used.add(addr)
+		return addr.String(), nil
 	}
 
-	// Allocate IP from the range using bytes comparison instead of string comparison.
-	// The scan is bounded by a.maxScan so a very large range (e.g. an IPv6 /64) cannot
-	// hold a.mu while scanning an effectively unbounded address space.
-	scanned := 0
-	for ip := start; bytes.Compare(ip, end) <= 0; ip = IncrementIP(ip) {
-		if scanned >= a.maxScan {
-			return "", fmt.Errorf("no available IP found in range %s within scan limit (%d addresses)", ipRange, a.maxScan)
-		}
-		scanned++
-		ipStr := ip.String()
-		if !a.used[ipStr] {
-			a.used[ipStr] = true
-			return ipStr, nil
+	for _, iv := range allocatableIntervals(ranges, excluded) {
+		if addr, ok := a.used.firstFree(iv.start, iv.end); ok {
+			a.used.add(addr)
+			return addr.String(), nil
 		}
 	}
 
 	return "", fmt.Errorf("no available IPs in range %s", ipRange)
 }
 
+// FreeCount returns the exact number of addresses AllocateIP could still hand
+// out from ipRange. It is a big.Int because an IPv6 range can hold more than
+// 2^64 addresses.
+func (a *IPAllocator) FreeCount(ipRange string) (*big.Int, error) {
+	ranges, err := ParseRangeSet(ipRange)
+	if err != nil {
+		return nil, err
+	}
+	excluded, err := ParseExclusions(ipRange)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	free := new(big.Int)
+	for _, iv := range allocatableIntervals(ranges, excluded) {
+		free.Add(free, iv.size())
+		free.Sub(free, a.used.countIn(iv))
+	}
+	return free, nil
+}
+
+// allocatableIntervals returns the addresses of ranges minus those of excluded
+// as sorted, disjoint intervals.
+func allocatableIntervals(ranges, excluded []IPRange) []addrInterval {
+	var out []addrInterval
+	for _, r := range ranges {
+		iv := addrInterval{start: toAddr(r.Start), end: toAddr(r.End)}
+		if n := len(out); n > 0 && iv.start.Compare(out[n-1].end) <= 0 {
+			if iv.end.Compare(out[n-1].end) > 0 {
+				out[n-1].end = iv.end
+			}
+			continue
+		}
+		out = append(out, iv)
+	}
+	for _, ex := range excluded {
+		out = subtractInterval(out, addrInterval{start: toAddr(ex.Start), end: toAddr(ex.End)})
+	}
+	return out
+}
+
+// subtractInterval removes the addresses of cut from the sorted intervals.
+func subtractInterval(ivs []addrInterval, cut addrInterval) []addrInterval {
+	out := make([]addrInterval, 0, len(ivs)+1)
+	for _, iv := range ivs {
+		if iv.start.BitLen() != cut.start.BitLen() || cut.end.Less(iv.start) || iv.end.Less(cut.start) {
+			out = append(out, iv)
+			continue
+		}
+		if iv.start.Less(cut.start) {
+			out = append(out, addrInterval{start: iv.start, end: cut.start.Prev()})
+		}
+		if cut.end.Less(iv.end) {
+			out = append(out, addrInterval{start: cut.end.Next(), end: iv.end})
+		}
+	}
+	return out
+}
+
+// toAddr converts a parsed address to a netip.Addr, with IPv4 in its 4-byte form.
+func toAddr(ip net.IP) netip.Addr {
+	addr, _ := netip.AddrFromSlice(NormalizeIP(ip))
+	return addr.Unmap()
+}
+
+// parseAddr parses an address string as the allocator keys it.
+func parseAddr(ip string) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.WithZone("").Unmap(), true
+}
+
+// findRange returns the first range containing ip, or nil.
+func findRange(ranges []IPRange, ip net.IP) *IPRange {
+	for i := range ranges {
+		if ranges[i].Contains(ip) {
+			return &ranges[i]
+		}
+	}
+	return nil
+}
+
 // ErrIPUnavailable reports that a specifically requested IP cannot be handed
 // out. Callers surface it rather than substituting a different address, so a
 // user who asked for one IP is never silently given another.
@@ -80,7 +165,7 @@ var ErrIPUnavailable = errors.New("requested IP is unavailable")
 //
 // The IP must be allocatable, not merely contained in the range — for an IPv4
 // CIDR that excludes the network and broadcast addresses, which AllocateIP
-// never hands out either.
+// never hands out either, and any excluded range of the set.
 func (a *IPAllocator) AllocateSpecificIP(ipRange, requested string) (string, error) {
 	target := net.ParseIP(strings.TrimSpace(requested))
 	if target == nil {
@@ -91,21 +176,22 @@ func (a *IPAllocator) AllocateSpecificIP(ipRange, requested string) (string, err
 		return "", fmt.Errorf("%w: %s is not an allocatable address in range %s", ErrIPUnavailable, requested, ipRange)
 	}
 
-	ipStr := NormalizeIP(target).String()
+	addr := toAddr(target)
 
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	if a.used[ipStr] {
-		return "", fmt.Errorf("%w: %s is already allocated", ErrIPUnavailable, ipStr)
+	if a.used.contains(addr) {
+		return "", fmt.Errorf("%w: %s is already allocated", ErrIPUnavailable, addr)
 	}
 
-	a.used[ipStr] = true
-	return ipStr, nil
+	a.used.add(addr)
+	return addr.String(), nil
 }
 
 // IPAllocatable reports whether ip is an address the allocator would hand out
-// for ipRange.
+// for ipRange, which may be a range set. Addresses in an excluded range of the
+// set are not allocatable.
 //
 // This is stricter than IPInRange, which answers plain containment: for an IPv4
 // CIDR wider than /31, IPInRange accepts the network and broadcast addresses
@@ -116,29 +202,43 @@ func IPAllocatable(ip string, ipRange string) bool {
 		return false
 	}
 
-	start, end, err := ParseIPRange(ipRange)
+	ranges, err := ParseRangeSet(ipRange)
+	if err != nil {
+		return false
+	}
+	excluded, err := ParseExclusions(ipRange)
 	if err != nil {
 		return false
 	}
 
-	normalized := NormalizeIP(target)
-	return bytes.Compare(normalized, start) >= 0 && bytes.Compare(normalized, end) <= 0
+	return findRange(ranges, target) != nil && findRange(excluded, target) == nil
 }
 
 // IPInRange checks if the given IP string falls within the specified range.
-// Supports single IP, range format, and CIDR notation.
+// Supports single IP, range format, CIDR notation, and range sets of those.
 //
-// This answers containment only. Use IPAllocatable when the question is whether
-// an address can actually be handed out.
+// This answers containment only, so excluded ranges of a set are ignored. Use
+// IPAllocatable when the question is whether an address can actually be handed out.
 func IPInRange(ip string, ipRange string) bool {
 	target := net.ParseIP(strings.TrimSpace(ip))
 	if target == nil {
 		return false
 	}
 
+	included, _ := splitExclusions(SplitRangeSet(ipRange))
+	for _, part := range included {
+		if ipInSingleRange(target, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipInSingleRange reports whether target falls within one range of a set.
+func ipInSingleRange(target net.IP, ipRange string) bool {
 	// Fast path for CIDR: use net.Contains directly
 	if strings.Contains(ipRange, "/") {
-		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(ipRange))
+		_, ipNet, err := net.ParseCIDR(ipRange)
 		if err != nil {
 			return false
 		}
@@ -149,21 +249,28 @@ func IPInRange(ip string, ipRange string) bool {
 	if err != nil {
 		return false
 	}
-	normalized := NormalizeIP(target)
-	return bytes.Compare(normalized, start) >= 0 && bytes.Compare(normalized, end) <= 0
+	return IPRange{Start: start, End: end}.Contains(target)
 }
 
 // MarkUsed marks an IP as used without allocating it.
 // This is used to prevent conflicts with IPs allocated by other HeliosConfigs.
 func (a *IPAllocator) MarkUsed(ip string) {
+	addr, ok := parseAddr(ip)
+	if !ok {
+		return
+	}
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	a.used[ip] = true
+	a.used.add(addr)
 }
 
 // ReleaseIP releases an allocated IP
 func (a *IPAllocator) ReleaseIP(ip string) {
+	addr, ok := parseAddr(ip)
+	if !ok {
+		return
+	}
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	delete(a.used, ip)
+	a.used.remove(addr)
 }
@@ -1,6 +1,8 @@
 package network
 
 import (
+	"math/rand/v2"
+	"net/netip"
 	"strings"
 	"testing"
 )
@@ -341,29 +343,223 @@ func TestIPInRange_IPv6(t *testing.T) {
 	})
 }
 
-func TestIPAllocator_ScanLimit(t *testing.T) {
-	t.Run("Scan stops at maxScan and returns scan-limit error", func(t *testing.T) {
+// Neither a huge range nor a long run of used addresses limits allocation:
+// the allocator jumps over used runs instead of walking them.
+func TestIPAllocator_LargeRanges(t *testing.T) {
+	t.Run("IPv6 /64", func(t *testing.T) {
 		allocator := NewIPAllocator()
-		allocator.maxScan = 4
+		ip, err := allocator.AllocateIP("fd00::/64")
+		if err != nil {
+			t.Fatalf("AllocateIP error: %v", err)
+		}
+		if ip != "fd00::" {
+			t.Errorf("Expected fd00::, got %s", ip)
+		}
+	})
 
-		// Exhaust the first maxScan addresses so the scan reaches the cap
-		// before it can reach the free .5 address.
-		for _, ip := range []string{testRangeStartIP, testRangeSecondIP, "192.168.1.3", "192.168.1.4"} {
-			allocator.MarkUsed(ip)
+	t.Run("Used run longer than an IPv4 /16", func(t *testing.T) {
+		allocator := NewIPAllocator()
+		start := netip.MustParseAddr("10.0.0.1")
+		addr := start
+		for range 70000 {
+			allocator.MarkUsed(addr.String())
+			addr = addr.Next()
 		}
 
-		_, err := allocator.AllocateIP("192.168.1.1-192.168.1.10")
-		if err == nil {
-			t.Fatal("Expected scan-limit error, got nil")
+		ip, err := allocator.AllocateIP("10.0.0.0/8")
+		if err != nil {
+			t.Fatalf("AllocateIP error: %v", err)
 		}
-		if !strings.Contains(err.Error(), "scan limit") {
-			t.Errorf("Expected scan-limit error, got %q", err)
+		if ip != addr.String() {
+			t.Errorf("Expected %s, got %s", addr, ip)
 		}
 	})
 
-	t.Run("Default maxScan is set by constructor", func(t *testing.T) {
-		if got := NewIPAllocator().maxScan; got != defaultMaxScan {
-			t.Errorf("Expected default maxScan %d, got %d", defaultMaxScan, got)
+	t.Run("Full range is reported as exhausted", func(t *testing.T) {
+		allocator := NewIPAllocator()
+		for _, ip := range []string{testRangeStartIP, testRangeSecondIP, "192.168.1.3"} {
+			allocator.MarkUsed(ip)
+		}
+		_, err := allocator.AllocateIP("192.168.1.1-192.168.1.3")
+		if err == nil || !strings.Contains(err.Error(), "no available IPs") {
+			t.Errorf("Expected exhaustion error, got %v", err)
 		}
 	})
 }
+
+func TestIPAllocator_FreeCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		ipRange string
+		used    []string
+		want    string
+	}{
+		{"IPv4 CIDR excludes network and broadcast", "192.168.1.0/24", nil, "254"},
+		{"used addresses are subtracted", "192.168.1.1-192.168.1.10", []string{testRangeStartIP, "192.168.1.5", "192.168.2.1"}, "8"},
+		{"range set", "10.0.0.1-10.0.0.4,10.0.1.1", []string{"10.0.1.1"}, "4"},
+		{"overlapping ranges count once", "10.0.0.1-10.0.0.4,10.0.0.3-10.0.0.6", nil, "6"},
+		{"exclusions are subtracted", "192.168.1.0/24,!192.168.1.50-192.168.1.60", []string{"192.168.1.55", "192.168.1.61"}, "242"},
+		{"IPv6 /64 exceeds uint64", "fd00::/64", nil, "18446744073709551616"},
+		{"IPv6 /64 minus used", "fd00::/64", []string{"fd00::", "fd00::1"}, "18446744073709551614"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allocator := NewIPAllocator()
+			for _, ip := range tt.used {
+				allocator.MarkUsed(ip)
+			}
+			got, err := allocator.FreeCount(tt.ipRange)
+			if err != nil {
+				t.Fatalf("FreeCount error: %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("FreeCount(%s) = %s, want %s", tt.ipRange, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPAllocator_FreeCountTracksAllocation(t *testing.T) {
+	allocator := NewIPAllocator()
+	const ipRange = "192.168.1.1-192.168.1.10"
+
+	for want := int64(9); want >= 0; want-- {
+		if _, err := allocator.AllocateIP(ipRange); err != nil {
+			t.Fatalf("AllocateIP error: %v", err)
+		}
+		if got, _ := allocator.FreeCount(ipRange); got.Int64() != want {
+			t.Fatalf("FreeCount = %s, want %d", got, want)
+		}
+	}
+
+	allocator.ReleaseIP("192.168.1.5")
+	if got, _ := allocator.FreeCount(ipRange); got.Int64() != 1 {
+		t.Errorf("FreeCount after release = %s, want 1", got)
+	}
+	if ip, _ := allocator.AllocateIP(ipRange); ip != "192.168.1.5" {
+		t.Errorf("Expected the released 192.168.1.5 to be reused, got %s", ip)
+	}
+}
+
+// Release in the middle of a used run splits it; the freed address is the
+// next one handed out and the run merges back when it is taken again.
+func TestIPAllocator_ReleaseSplitsAndMergesRuns(t *testing.T) {
+	allocator := NewIPAllocator()
+	const ipRange = "10.0.0.1-10.0.0.100"
+	for range 10 {
+		if _, err := allocator.AllocateIP(ipRange); err != nil {
+			t.Fatalf("AllocateIP error: %v", err)
+		}
+	}
+	if allocator.used.n != 1 {
+		t.Fatalf("Expected one used run, got %d", allocator.used.n)
+	}
+
+	allocator.ReleaseIP("10.0.0.5")
+	allocator.ReleaseIP("10.0.0.1")
+	allocator.ReleaseIP("10.0.0.10")
+	if allocator.used.n != 2 {
+		t.Fatalf("Expected two used runs after releases, got %d", allocator.used.n)
+	}
+
+	for _, want := range []string{"10.0.0.1", "10.0.0.5", "10.0.0.10", "10.0.0.11"} {
+		ip, err := allocator.AllocateIP(ipRange)
+		if err != nil {
+			t.Fatalf("AllocateIP error: %v", err)
+		}
+		if ip != want {
+			t.Errorf("Expected %s, got %s", want, ip)
+		}
+	}
+	if allocator.used.n != 1 {
+		t.Errorf("Expected the runs to merge back into one, got %d", allocator.used.n)
+	}
+}
+
+// Marking and releasing addresses in a random order must leave the interval
+// set agreeing with a plain map of used addresses.
+func TestIPAllocator_MatchesReferenceModel(t *testing.T) {
+	allocator := NewIPAllocator()
+	reference := make(map[string]bool)
+	rng := rand.New(rand.NewPCG(1, 2))
+	base := netip.MustParseAddr("10.0.0.0")
+
+	for range 5000 {
+		addr := base
+		for range rng.IntN(64) {
+			addr = addr.Next()
+		}
+		if rng.IntN(3) == 0 {
+			allocator.ReleaseIP(addr.String())
+			delete(reference, addr.String())
+		} else {
+			allocator.MarkUsed(addr.String())
+			reference[addr.String()] = true
+		}
+	}
+
+	addr := base
+	for range 64 {
+		if got := allocator.used.contains(addr); got != reference[addr.String()] {
+			t.Errorf("used(%s) = %v, want %v", addr, got, reference[addr.String()])
+		}
+		addr = addr.Next()
+	}
+	free, _ := allocator.FreeCount("10.0.0.0-10.0.0.63")
+	if want := int64(64 - len(reference)); free.Int64() != want {
+		t.Errorf("FreeCount = %s, want %d", free, want)
+	}
+}
+
+func BenchmarkIPAllocator_AllocateIPv4(b *testing.B) {
+	allocator := NewIPAllocator()
+	const ipRange = "10.0.0.0/8"
+	for b.Loop() {
+		if _, err := allocator.AllocateIP(ipRange); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkIPAllocator_AllocateIPv6Slash64(b *testing.B) {
+	allocator := NewIPAllocator()
+	const ipRange = "fd00::/64"
+	for b.Loop() {
+		if _, err := allocator.AllocateIP(ipRange); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Every other address is used, the worst case for the number of runs.
+func BenchmarkIPAllocator_AllocateReleaseFragmented(b *testing.B) {
+	allocator := NewIPAllocator()
+	const ipRange = "10.0.0.0/16"
+	addr := netip.MustParseAddr("10.0.0.1")
+	for range 30000 {
+		allocator.MarkUsed(addr.String())
+		addr = addr.Next().Next()
+	}
+	for b.Loop() {
+		ip, err := allocator.AllocateIP(ipRange)
+		if err != nil {
+			b.Fatal(err)
+		}
+		allocator.ReleaseIP(ip)
+	}
+}
+
+func BenchmarkIPAllocator_FreeCount(b *testing.B) {
+	allocator := NewIPAllocator()
+	const ipRange = "fd00::/64"
+	for range 1000 {
+		if _, err := allocator.AllocateIP(ipRange); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for b.Loop() {
+		if _, err := allocator.FreeCount(ipRange); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
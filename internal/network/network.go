@@ -1,5 +1,7 @@
 package network
 
+import "math/big"
+
 type NetworkManager struct {
 	ipAllocator *IPAllocator
 }
@@ -21,6 +23,11 @@ func (nm *NetworkManager) AllocateSpecificIP(ipRange, requested string) (string,
 	return nm.ipAllocator.AllocateSpecificIP(ipRange, requested)
 }
 
+// FreeCount returns the number of addresses still available in the given range.
+func (nm *NetworkManager) FreeCount(ipRange string) (*big.Int, error) {
+	return nm.ipAllocator.FreeCount(ipRange)
+}
+
 // MarkUsed marks an IP as used to prevent conflict during allocation.
 func (nm *NetworkManager) MarkUsed(ip string) {
 	nm.ipAllocator.MarkUsed(ip)
@@ -4,9 +4,44 @@ import (
 	"bytes"
 	"fmt"
 	"net"
+	"slices"
 	"strings"
 )
 
+// RangeSetSeparator separates the ranges of a range set expression, e.g.
+// "10.0.1.10-10.0.1.20,10.0.5.0/28".
+const RangeSetSeparator = ","
+
+// ExclusionPrefix marks a range of a range set expression as excluded: its
+// addresses are never handed out even where another range of the set covers
+// them, e.g. "192.168.1.0/24,!192.168.1.1,!192.168.1.50-192.168.1.60".
+const ExclusionPrefix = "!"
+
+// IPRange is one contiguous, inclusive range of normalized addresses.
+type IPRange struct {
+	Start net.IP
+	End   net.IP
+}
+
+// Contains reports whether ip lies within the range. An address of the other
+// family is never contained.
+func (r IPRange) Contains(ip net.IP) bool {
+	normalized := NormalizeIP(ip)
+	if len(normalized) != len(r.Start) {
+		return false
+	}
+	return bytes.Compare(normalized, r.Start) >= 0 && bytes.Compare(normalized, r.End) <= 0
+}
+
+// Overlaps reports whether the two ranges share at least one address. Ranges
+// of different families never overlap.
+func (r IPRange) Overlaps(other IPRange) bool {
+	if len(r.Start) != len(other.Start) {
+		return false
+	}
+	return CompareIPs(r.Start, other.End) <= 0 && CompareIPs(r.End, other.Start) >= 0
+}
+
 // NormalizeIP returns a consistent representation of an IP address.
 // IPv4 addresses are returned as 4-byte slices, IPv6 as 16-byte slices.
 // This ensures bytes.Compare works correctly across all IP comparisons.
@@ -24,9 +59,17 @@ func NormalizeIP(ip net.IP) net.IP {
 //
 // Supports both IPv4 and IPv6 addresses.
 // Returns normalized start and end IPs.
+//
+// A range set holding several ranges has no single start and end; it is
+// rejected here so that a caller cannot mistake the gaps between its ranges
+// for usable addresses. Use ParseRangeSet for those.
 func ParseIPRange(ipRange string) (start, end net.IP, err error) {
 	trimmed := strings.TrimSpace(ipRange)
 
+	if strings.Contains(trimmed, RangeSetSeparator) {
+		return nil, nil, fmt.Errorf("%s is a range set, not a single range", ipRange)
+	}
+
 	// Try CIDR notation (e.g., "192.168.1.0/24" or "fd00::/120")
 	if strings.Contains(trimmed, "/") {
 		_, ipNet, err := net.ParseCIDR(trimmed)
@@ -70,6 +113,144 @@ func ParseIPRange(ipRange string) (start, end net.IP, err error) {
 	return NormalizeIP(start), NormalizeIP(end), nil
 }
 
+// ParseRangeSet parses a range set expression: one or more ranges in any
+// format ParseIPRange accepts, separated by RangeSetSeparator. A plain single
+// range is a set of one. Excluded ranges are skipped; see ParseExclusions.
+//
+// The ranges are returned sorted by start address (then end address), so the
+// order in which the allocator walks them does not depend on the order they
+// were listed in.
+func ParseRangeSet(expr string) ([]IPRange, error) {
+	parts, _ := splitExclusions(SplitRangeSet(expr))
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("empty IP range: %q", expr)
+	}
+	ranges := make([]IPRange, 0, len(parts))
+	for _, part := range parts {
+		start, end, err := ParseIPRange(part)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, IPRange{Start: start, End: end})
+	}
+	slices.SortStableFunc(ranges, func(a, b IPRange) int {
+		if c := compareFamilyFirst(a.Start, b.Start); c != 0 {
+			return c
+		}
+		return compareFamilyFirst(a.End, b.End)
+	})
+	return ranges, nil
+}
+
+// ParseExclusions returns the excluded ranges of a range set expression, those
+// marked with ExclusionPrefix. Unlike ParseIPRange, an excluded IPv4 CIDR
+// covers its network and broadcast addresses too, so excluding a subnet
+// removes every address in it.
+func ParseExclusions(expr string) ([]IPRange, error) {
+	_, excluded := splitExclusions(SplitRangeSet(expr))
+	ranges := make([]IPRange, 0, len(excluded))
+	for _, part := range excluded {
+		r, err := ParseAddressBlock(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid excluded range: %w", err)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// ParseAddressBlock parses a single range like ParseIPRange, except that a CIDR
+// covers every address of the prefix, including the IPv4 network and broadcast
+// addresses.
+func ParseAddressBlock(block string) (IPRange, error) {
+	trimmed := strings.TrimSpace(block)
+	if strings.Contains(trimmed, "/") {
+		_, ipNet, err := net.ParseCIDR(trimmed)
+		if err != nil {
+			return IPRange{}, fmt.Errorf("invalid CIDR format: %s", block)
+		}
+		start := NormalizeIP(ipNet.IP)
+		end := make(net.IP, len(start))
+		for i := range start {
+			end[i] = start[i] | ^ipNet.Mask[i]
+		}
+		return IPRange{Start: start, End: end}, nil
+	}
+	start, end, err := ParseIPRange(trimmed)
+	if err != nil {
+		return IPRange{}, err
+	}
+	return IPRange{Start: start, End: end}, nil
+}
+
+// splitExclusions separates the parts of a range set into included ranges and
+// excluded ones, with ExclusionPrefix stripped from the latter.
+func splitExclusions(parts []string) (included, excluded []string) {
+	for _, part := range parts {
+		if rest, ok := strings.CutPrefix(part, ExclusionPrefix); ok {
+			excluded = append(excluded, strings.TrimSpace(rest))
+			continue
+		}
+		included = append(included, part)
+	}
+	return included, excluded
+}
+
+// WithExclusions returns the range set expression expr with the entries of
+// excluded appended as excluded ranges. Entries of the other address family
+// than expr are left out, so one exclusion list can be applied to both the
+// IPv4 and the IPv6 set; entries that do not parse are kept, so the error
+// surfaces when the set is used. An empty expr stays empty.
+func WithExclusions(expr string, excluded []string) string {
+	ranges, err := ParseRangeSet(expr)
+	if err != nil || len(excluded) == 0 {
+		return expr
+	}
+	family := len(ranges[0].Start)
+	parts := []string{expr}
+	for _, e := range excluded {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if r, err := ParseAddressBlock(e); err == nil && len(r.Start) != family {
+			continue
+		}
+		parts = append(parts, ExclusionPrefix+e)
+	}
+	return JoinRangeSet(parts...)
+}
+
+// compareFamilyFirst orders IPv4 before IPv6, then by address.
+func compareFamilyFirst(a, b net.IP) int {
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	return bytes.Compare(a, b)
+}
+
+// SplitRangeSet returns the trimmed, non-empty ranges of a range set
+// expression in the order they were written.
+func SplitRangeSet(expr string) []string {
+	var parts []string
+	for part := range strings.SplitSeq(expr, RangeSetSeparator) {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// JoinRangeSet builds a range set expression from individual ranges, skipping
+// empty ones. It returns "" when no range is given.
+func JoinRangeSet(ranges ...string) string {
+	var parts []string
+	for _, r := range ranges {
+		parts = append(parts, SplitRangeSet(r)...)
+	}
+	return strings.Join(parts, RangeSetSeparator)
+}
+
 // CompareIPs compares two IPs byte-by-byte. Returns -1, 0, or 1.
 func CompareIPs(a, b net.IP) int {
 	a = NormalizeIP(a)
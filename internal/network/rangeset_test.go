@@ -0,0 +1,235 @@
+package network
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRangeSet_SortsRanges(t *testing.T) {
+	ranges, err := ParseRangeSet("10.0.5.0/28, 10.0.1.10-10.0.1.20")
+	if err != nil {
+		t.Fatalf("ParseRangeSet error: %v", err)
+	}
+	if len(ranges) != 2 {
+		t.Fatalf("got %d ranges, want 2", len(ranges))
+	}
+	if got := ranges[0].Start.String(); got != "10.0.1.10" {
+		t.Errorf("first range starts at %s, want 10.0.1.10", got)
+	}
+	if got := ranges[1].Start.String(); got != "10.0.5.1" {
+		t.Errorf("second range starts at %s, want 10.0.5.1", got)
+	}
+}
+
+func TestParseRangeSet_Rejections(t *testing.T) {
+	for _, expr := range []string{"", " , ", "10.0.0.1,bad"} {
+		if _, err := ParseRangeSet(expr); err == nil {
+			t.Errorf("ParseRangeSet(%q) succeeded, want error", expr)
+		}
+	}
+}
+
+// ParseIPRange has a single start and end, so it must not quietly span the
+// gap between the ranges of a set.
+func TestParseIPRange_RejectsRangeSet(t *testing.T) {
+	if _, _, err := ParseIPRange("10.0.0.1,10.0.0.9"); err == nil {
+		t.Error("ParseIPRange accepted a range set")
+	}
+}
+
+func TestJoinRangeSet(t *testing.T) {
+	tests := []struct {
+		name   string
+		ranges []string
+		want   string
+	}{
+		{"none", nil, ""},
+		{"only empty", []string{"", " "}, ""},
+		{"single", []string{"10.0.0.1"}, "10.0.0.1"},
+		{"skips empty", []string{"", "10.0.0.1", "10.0.1.0/30"}, "10.0.0.1,10.0.1.0/30"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := JoinRangeSet(tt.ranges...); got != tt.want {
+				t.Errorf("JoinRangeSet(%q) = %q, want %q", tt.ranges, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPAllocator_RangeSet(t *testing.T) {
+	allocator := NewIPAllocator()
+	// Listed out of order on purpose: allocation follows address order.
+	set := "10.0.5.0/30,10.0.1.10-10.0.1.11"
+
+	want := []string{"10.0.1.10", "10.0.1.11", "10.0.5.1", "10.0.5.2"}
+	for _, w := range want {
+		ip, err := allocator.AllocateIP(set)
+		if err != nil {
+			t.Fatalf("AllocateIP error: %v", err)
+		}
+		if ip != w {
+			t.Errorf("AllocateIP = %s, want %s", ip, w)
+		}
+	}
+	if _, err := allocator.AllocateIP(set); err == nil {
+		t.Error("expected an error once every range is exhausted")
+	}
+}
+
+func TestIPAllocator_RangeSetOfSingleIPs(t *testing.T) {
+	allocator := NewIPAllocator()
+	set := "10.0.0.9,10.0.0.3"
+
+	for _, w := range []string{"10.0.0.3", "10.0.0.9"} {
+		ip, err := allocator.AllocateIP(set)
+		if err != nil {
+			t.Fatalf("AllocateIP error: %v", err)
+		}
+		if ip != w {
+			t.Errorf("AllocateIP = %s, want %s", ip, w)
+		}
+	}
+	if _, err := allocator.AllocateIP(set); err == nil {
+		t.Error("a set of single IPs must not hand out an address twice")
+	}
+}
+
+func TestIPAllocatable_RangeSet(t *testing.T) {
+	set := "10.0.1.10-10.0.1.20,10.0.5.0/28"
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.0.1.10", true},
+		{"10.0.1.20", true},
+		{"10.0.5.1", true},
+		{"10.0.5.14", true},
+		{"10.0.1.21", false}, // the gap between the ranges
+		{"10.0.3.1", false},
+		{"10.0.5.0", false},  // network address of the CIDR
+		{"10.0.5.15", false}, // broadcast address of the CIDR
+	}
+	for _, tt := range tests {
+		if got := IPAllocatable(tt.ip, set); got != tt.want {
+			t.Errorf("IPAllocatable(%s, %s) = %v, want %v", tt.ip, set, got, tt.want)
+		}
+		if tt.want && !IPInRange(tt.ip, set) {
+			t.Errorf("IPInRange(%s, %s) = false for an allocatable address", tt.ip, set)
+		}
+	}
+}
+
+func TestAllocateSpecificIP_RangeSet(t *testing.T) {
+	allocator := NewIPAllocator()
+	set := "10.0.1.10-10.0.1.20,10.0.5.0/28"
+
+	if ip, err := allocator.AllocateSpecificIP(set, "10.0.5.3"); err != nil || ip != "10.0.5.3" {
+		t.Errorf("AllocateSpecificIP = %q, %v; want 10.0.5.3", ip, err)
+	}
+	if _, err := allocator.AllocateSpecificIP(set, "10.0.2.1"); !errors.Is(err, ErrIPUnavailable) {
+		t.Errorf("AllocateSpecificIP in the gap = %v, want ErrIPUnavailable", err)
+	}
+}
+
+func TestIPAllocator_SkipsExclusions(t *testing.T) {
+	allocator := NewIPAllocator()
+	set := WithExclusions("192.168.1.0/24", []string{"192.168.1.1", "192.168.1.2-192.168.1.4", "fd00::1"})
+
+	ip, err := allocator.AllocateIP(set)
+	if err != nil {
+		t.Fatalf("AllocateIP error: %v", err)
+	}
+	if ip != "192.168.1.5" {
+		t.Errorf("AllocateIP = %s, want 192.168.1.5", ip)
+	}
+}
+
+// An excluded CIDR covers its network and broadcast addresses, so the address
+// right after a /28 block is the first one handed out.
+func TestIPAllocator_ExcludedCIDRCoversWholeBlock(t *testing.T) {
+	allocator := NewIPAllocator()
+	set := "192.168.1.0/24,!192.168.1.0/28"
+
+	ip, err := allocator.AllocateIP(set)
+	if err != nil {
+		t.Fatalf("AllocateIP error: %v", err)
+	}
+	if ip != "192.168.1.16" {
+		t.Errorf("AllocateIP = %s, want 192.168.1.16", ip)
+	}
+}
+
+func TestIPAllocator_ExclusionsExhaustTheRange(t *testing.T) {
+	allocator := NewIPAllocator()
+
+	if _, err := allocator.AllocateIP("10.0.0.1-10.0.0.3,!10.0.0.1-10.0.0.3"); err == nil {
+		t.Error("expected an error when every address is excluded")
+	}
+	if _, err := allocator.AllocateIP("10.0.0.1,!10.0.0.1"); err == nil {
+		t.Error("expected an error for an excluded single IP")
+	}
+	if _, err := allocator.AllocateIP("10.0.0.1-10.0.0.3,!bad"); err == nil {
+		t.Error("expected an error for an invalid exclusion")
+	}
+}
+
+// A large exclusion is stepped over as a whole.
+func TestIPAllocator_SkipsLargeExclusion(t *testing.T) {
+	allocator := NewIPAllocator()
+
+	ip, err := allocator.AllocateIP("10.0.0.0/16,!10.0.0.0/17")
+	if err != nil {
+		t.Fatalf("AllocateIP error: %v", err)
+	}
+	if ip != "10.0.128.0" {
+		t.Errorf("AllocateIP = %s, want 10.0.128.0", ip)
+	}
+}
+
+func TestAllocateSpecificIP_RejectsExclusions(t *testing.T) {
+	allocator := NewIPAllocator()
+	set := "192.168.1.0/24,!192.168.1.1,!192.168.1.50-192.168.1.60"
+
+	for _, requested := range []string{"192.168.1.1", "192.168.1.55"} {
+		if _, err := allocator.AllocateSpecificIP(set, requested); !errors.Is(err, ErrIPUnavailable) {
+			t.Errorf("AllocateSpecificIP(%s) = %v, want ErrIPUnavailable", requested, err)
+		}
+	}
+	if ip, err := allocator.AllocateSpecificIP(set, "192.168.1.61"); err != nil || ip != "192.168.1.61" {
+		t.Errorf("AllocateSpecificIP = %q, %v; want 192.168.1.61", ip, err)
+	}
+}
+
+func TestIPInRange_IgnoresExclusions(t *testing.T) {
+	set := "192.168.1.0/24,!192.168.1.50-192.168.1.60"
+
+	if !IPInRange("192.168.1.55", set) {
+		t.Error("IPInRange must still report an excluded address as contained")
+	}
+	if IPAllocatable("192.168.1.55", set) {
+		t.Error("IPAllocatable must reject an excluded address")
+	}
+}
+
+func TestWithExclusions(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		excluded []string
+		want     string
+	}{
+		{"no exclusions", "10.0.0.0/24", nil, "10.0.0.0/24"},
+		{"empty set stays empty", "", []string{"10.0.0.1"}, ""},
+		{"keeps same family", "10.0.0.0/24", []string{"10.0.0.1", "fd00::1"}, "10.0.0.0/24,!10.0.0.1"},
+		{"IPv6 set", "fd00::/120", []string{"10.0.0.1", "fd00::1"}, "fd00::/120,!fd00::1"},
+		{"keeps unparseable", "10.0.0.0/24", []string{"bad"}, "10.0.0.0/24,!bad"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := WithExclusions(tt.expr, tt.excluded); got != tt.want {
+				t.Errorf("WithExclusions(%q, %q) = %q, want %q", tt.expr, tt.excluded, got, tt.want)
+			}
+		})
+	}
+}
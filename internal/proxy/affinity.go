@@ -0,0 +1,366 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/somaz94/helios-lb/internal/loadbalancer"
+)
+
+// Session affinity modes of a Frontend.
+const (
+	AffinityHeader = "Header"
+	AffinityCookie = "Cookie"
+)
+
+const (
+	// defaultAffinityCookie names the cookie of Cookie affinity unless the
+	// frontend sets its own.
+	defaultAffinityCookie = "helios-affinity"
+	// defaultAffinityTimeout is how long a pin lasts unless the frontend
+	// sets its own.
+	defaultAffinityTimeout = 3 * time.Hour
+	// maxHeadLen bounds how much of an HTTP head is read to route a request
+	// or to insert a cookie into a response.
+	maxHeadLen = 16 << 10
+	// requestHeadTimeout bounds how long a client of a frontend with
+	// affinity may take to send its request head.
+	requestHeadTimeout = 10 * time.Second
+)
+
+// headEnd is the blank line that ends an HTTP head.
+var headEnd = []byte("\r\n\r\n")
+
+// Affinity pins the HTTP/1.x clients of a TCP frontend to a backend. The
+// first request of a client is balanced as usual; the backend it got is
+// remembered for its value of the Header, or named in a Cookie the proxy
+// sets on the response. A pinned backend that left the frontend or is not
+// available is replaced the same way.
+//
+// The proxy routes a connection by the head of its first request only, so
+// with affinity every connection carries a single request: the request and
+// the final response both get "Connection: close", and a keep-alive client
+// opens a new connection for its next request. Interim 1xx responses, such
+// as 100 Continue, pass through unchanged. Requests pipelined behind the
+// first are not forwarded; the client sends them again on a new connection.
+// This costs a TCP handshake per request. Connections that upgrade, such as
+// WebSockets, are left open and stay on the backend of their upgrade request.
+type Affinity struct {
+	Mode string
+	// Name is the header routed on, or the cookie set; empty selects
+	// the default cookie.
+	Name string
+	// Timeout is how long a header value stays pinned after its last
+	// request, and the Max-Age of the cookie; zero selects the default.
+	Timeout time.Duration
+}
+
+// withDefaults fills in the settings left unset.
+func (a Affinity) withDefaults() Affinity {
+	if a.Mode == AffinityCookie && a.Name == "" {
+		a.Name = defaultAffinityCookie
+	}
+	if a.Timeout <= 0 {
+		a.Timeout = defaultAffinityTimeout
+	}
+	return a
+}
+
+// affinityTable remembers the backend each header value is pinned to.
+type affinityTable struct {
+	mu    sync.Mutex
+	pins  map[string]affinityPin
+	swept time.Time
+}
+
+type affinityPin struct {
+	backend  string
+	lastSeen time.Time
+}
+
+func newAffinityTable() *affinityTable {
+	return &affinityTable{pins: make(map[string]affinityPin)}
+}
+
+// get returns the backend key is pinned to and renews the pin, or "" when
+// the key is not pinned or its pin expired.
+func (t *affinityTable) get(key string, timeout time.Duration, now time.Time) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pin, ok := t.pins[key]
+	if !ok || now.Sub(pin.lastSeen) >= timeout {
+		return ""
+	}
+	pin.lastSeen = now
+	t.pins[key] = pin
+	return pin.backend
+}
+
+// put pins key to backend. Expired pins are dropped at most once per
+// timeout.
+func (t *affinityTable) put(key, backend string, timeout time.Duration, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now.Sub(t.swept) >= timeout {
+		for k, pin := range t.pins {
+			if now.Sub(pin.lastSeen) >= timeout {
+				delete(t.pins, k)
+			}
+		}
+		t.swept = now
+	}
+	t.pins[key] = affinityPin{backend: backend, lastSeen: now}
+}
+
+// reset forgets every pin.
+func (t *affinityTable) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	clear(t.pins)
+}
+
+// setAffinity applies a frontend's session affinity, nil for none. Pins made
+// under other settings are forgotten.
+func (l *listener) setAffinity(a *Affinity) {
+	if a != nil {
+		withDefaults := a.withDefaults()
+		a = &withDefaults
+	}
+	old := l.affinity.Load()
+	if old == nil && a == nil || old != nil && a != nil && *old == *a {
+		return
+	}
+	l.affinity.Store(a)
+	if l.pins != nil {
+		l.pins.reset()
+	}
+}
+
+// pickByAffinity returns the backend the request head pins the client to
+// and, when the client was balanced afresh under Cookie affinity, the cookie
+// to set on the response.
+func (l *listener) pickByAffinity(client net.Conn, a *Affinity, head []byte) (*loadbalancer.Backend, string) {
+	var key, id string
+	now := time.Now()
+	if req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(head))); err == nil {
+		switch a.Mode {
+		case AffinityHeader:
+			if key = req.Header.Get(a.Name); key != "" {
+				id = l.pins.get(key, a.Timeout, now)
+			}
+		case AffinityCookie:
+			if c, err := req.Cookie(a.Name); err == nil {
+				id = c.Value
+			}
+		}
+	}
+	if id != "" {
+		if backend := l.pinnedBackend(id); backend != nil {
+			return backend, ""
+		}
+	}
+
+	backend := l.lb.NextBackend(l.pool, clientIP(client.RemoteAddr()))
+	if backend == nil {
+		return nil, ""
+	}
+	switch {
+	case a.Mode == AffinityHeader && key != "":
+		l.pins.put(key, backendID(backend), a.Timeout, now)
+	case a.Mode == AffinityCookie:
+		cookie := &http.Cookie{Name: a.Name, Value: backendID(backend), Path: "/", MaxAge: int(a.Timeout / time.Second), HttpOnly: true}
+		return backend, cookie.String()
+	}
+	return backend, ""
+}
+
+// pinnedBackend returns the available backend of the frontend with the given
+// ID, or nil.
+func (l *listener) pinnedBackend(id string) *loadbalancer.Backend {
+	for _, b := range l.lb.Backends(l.pool) {
+		if b.Available() && backendID(b) == id {
+			return b
+		}
+	}
+	return nil
+}
+
+// backendID names a backend in pins and cookies without giving its address
+// away.
+func backendID(b *loadbalancer.Backend) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(backendAddress(b)))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// readRequestHead reads the head of a client's first request, or as much of
+// it as fits in maxHeadLen. It reports false when the client sent nothing.
+func readRequestHead(client net.Conn) ([]byte, bool) {
+	head := make([]byte, maxHeadLen)
+	_ = client.SetReadDeadline(time.Now().Add(requestHeadTimeout))
+	n, _ := readHead(client, head, 0)
+	_ = client.SetReadDeadline(time.Time{})
+	return head[:n], n > 0
+}
+
+// readHead reads from c into buf, after the n bytes it already holds, until
+// buf holds the blank line that ends an HTTP head, buf is full or reading
+// fails, and returns how much buf holds.
+func readHead(c net.Conn, buf []byte, n int) (int, error) {
+	start := 0
+	for !bytes.Contains(buf[start:n], headEnd) && n < len(buf) {
+		// The blank line may straddle two reads.
+		start = max(0, n-len(headEnd)+1)
+		m, err := c.Read(buf[n:])
+		n += m
+		if err != nil && !bytes.Contains(buf[start:n], headEnd) {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// firstRequest is the first request of a client of a frontend with affinity:
+// its head, and the client's stream after the head, which starts with its
+// body.
+type firstRequest struct {
+	head   []byte
+	stream *bufio.Reader
+	// length is the size of a body that is neither chunked nor tunneled.
+	length  int64
+	chunked bool
+	// tunnel is set when the rest of the connection belongs to the request:
+	// it upgrades the connection, or its head could not be parsed.
+	tunnel bool
+}
+
+// parseFirstRequest splits buf, the start of a client's stream, into the head
+// of its first request and the stream after it, which continues with client.
+func parseFirstRequest(buf []byte, client io.Reader) *firstRequest {
+	r := &firstRequest{head: buf, tunnel: true}
+	end := bytes.Index(buf, headEnd)
+	if end < 0 {
+		r.stream = bufio.NewReader(client)
+		return r
+	}
+	end += len(headEnd)
+	r.head = buf[:end]
+	r.stream = bufio.NewReader(io.MultiReader(bytes.NewReader(buf[end:]), client))
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(r.head)))
+	if err != nil || req.Method == http.MethodConnect || req.Header.Get("Upgrade") != "" {
+		return r
+	}
+	r.tunnel = false
+	r.chunked = slices.Contains(req.TransferEncoding, "chunked")
+	r.length = max(0, req.ContentLength)
+	return r
+}
+
+// copyBody copies the request's body from the client's stream to dst, and
+// with a tunnel everything the client sends. Whatever the client sends after
+// the body is left in the stream.
+func (r *firstRequest) copyBody(dst io.Writer) error {
+	switch {
+	case r.tunnel:
+		_, err := io.Copy(dst, r.stream)
+		return err
+	case r.chunked:
+		return copyChunked(dst, r.stream)
+	default:
+		_, err := io.CopyN(dst, r.stream, r.length)
+		return err
+	}
+}
+
+// copyChunked copies a chunked body from src to dst as it is, up to and
+// including the blank line after its trailers.
+func copyChunked(dst io.Writer, src *bufio.Reader) error {
+	for {
+		line, err := src.ReadSlice('\n')
+		if err != nil {
+			return err
+		}
+		if _, err := dst.Write(line); err != nil {
+			return err
+		}
+		sizeField, _, _ := bytes.Cut(line, []byte(";"))
+		size, err := strconv.ParseUint(string(bytes.TrimSpace(sizeField)), 16, 62)
+		if err != nil {
+			return err
+		}
+		if size > 0 {
+			// The chunk is followed by a CRLF.
+			if _, err := io.CopyN(dst, src, int64(size)+2); err != nil {
+				return err
+			}
+			continue
+		}
+		for {
+			trailer, err := src.ReadSlice('\n')
+			if err != nil {
+				return err
+			}
+			if _, err := dst.Write(trailer); err != nil {
+				return err
+			}
+			if len(bytes.TrimRight(trailer, "\r\n")) == 0 {
+				return nil
+			}
+		}
+	}
+}
+
+// withConnectionClose returns b with the Connection header of the HTTP/1.x
+// head at its start replaced by "Connection: close", so the peer ends the
+// connection after the current request and response. Keep-Alive headers go
+// too. A head that asks for or accepts an upgrade is returned unchanged, as is
+// b when it does not start with a whole HTTP/1.x head.
+func withConnectionClose(b []byte) []byte {
+	end := bytes.Index(b, headEnd)
+	if end < 0 {
+		return b
+	}
+	lines := bytes.Split(b[:end], []byte("\r\n"))
+	if !bytes.Contains(lines[0], []byte("HTTP/1.")) {
+		return b
+	}
+	out := make([]byte, 0, len(b)+len("Connection: close\r\n"))
+	out = append(out, lines[0]...)
+	out = append(out, "\r\n"...)
+	for _, line := range lines[1:] {
+		name, _, _ := bytes.Cut(line, []byte(":"))
+		name = bytes.TrimSpace(name)
+		switch {
+		case bytes.EqualFold(name, []byte("Upgrade")):
+			return b
+		case bytes.EqualFold(name, []byte("Connection")), bytes.EqualFold(name, []byte("Keep-Alive")):
+			continue
+		}
+		out = append(out, line...)
+		out = append(out, "\r\n"...)
+	}
+	out = append(out, "Connection: close\r\n"...)
+	return append(out, b[end+2:]...)
+}
+
+// insertHeader adds a header to the HTTP/1.x response head at the start of
+// reply. A reply that does not start with a whole response head is returned
+// unchanged.
+func insertHeader(reply []byte, name, value string) []byte {
+	end := bytes.Index(reply, headEnd)
+	if end < 0 || !bytes.HasPrefix(reply, []byte("HTTP/1.")) {
+		return reply
+	}
+	out := make([]byte, 0, len(reply)+len(name)+len(value)+4)
+	out = append(out, reply[:end+2]...)
+	out = append(out, name+": "+value+"\r\n"...)
+	return append(out, reply[end+2:]...)
+}
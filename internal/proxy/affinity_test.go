@@ -0,0 +1,300 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/somaz94/helios-lb/internal/loadbalancer"
+)
+
+// httpBackend starts an HTTP server on address that answers every request
+// with its name, and returns its endpoint.
+func httpBackend(t *testing.T, address, name string) Endpoint {
+	t.Helper()
+	ln, err := net.Listen("tcp", net.JoinHostPort(address, "0"))
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, name)
+	}))
+	srv.Listener = ln
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return Endpoint{Address: address, Port: ln.Addr().(*net.TCPAddr).Port}
+}
+
+// affinityProxy serves a RoundRobin frontend over backends with the given
+// affinity and returns a function that syncs a new backend set, and the
+// frontend URL.
+func affinityProxy(t *testing.T, affinity *Affinity, backends ...Endpoint) (func(...Endpoint), string) {
+	t.Helper()
+	p := startProxy(t)
+	port := freePort(t, "tcp")
+	sync := func(backends ...Endpoint) {
+		t.Helper()
+		err := p.Sync([]Group{{
+			Name:     "default/web",
+			Balancer: loadbalancer.BalancerConfig{Type: loadbalancer.RoundRobin},
+			Frontends: []Frontend{{
+				IP: frontendIP, Port: port, Protocol: ProtocolTCP, Backends: backends, Affinity: affinity,
+			}},
+		}})
+		if err != nil {
+			t.Fatalf("Sync() error = %v", err)
+		}
+	}
+	sync(backends...)
+	return sync, "http://" + net.JoinHostPort(frontendIP, strconv.Itoa(port)) + "/"
+}
+
+// get sends one request on a new connection and returns the body.
+func get(t *testing.T, client *http.Client, url string, header http.Header) string {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	return string(body)
+}
+
+func newHTTPClient(jar http.CookieJar) *http.Client {
+	return &http.Client{
+		Jar:       jar,
+		Timeout:   2 * time.Second,
+		Transport: &http.Transport{DisableKeepAlives: true},
+	}
+}
+
+func TestProxy_CookieAffinity(t *testing.T) {
+	a := httpBackend(t, "127.0.0.2", "a")
+	b := httpBackend(t, "127.0.0.3", "b")
+	sync, url := affinityProxy(t, &Affinity{Mode: AffinityCookie, Timeout: time.Minute}, a, b)
+
+	jar, _ := cookiejar.New(nil)
+	sticky := newHTTPClient(jar)
+	first := get(t, sticky, url, nil)
+	for range 4 {
+		if got := get(t, sticky, url, nil); got != first {
+			t.Errorf("reply = %q, want %q from the backend the cookie names", got, first)
+		}
+	}
+
+	// Without the cookie every connection is balanced afresh.
+	plain := newHTTPClient(nil)
+	if got, next := get(t, plain, url, nil), get(t, plain, url, nil); got == next {
+		t.Errorf("replies = %q and %q, want both backends without a cookie", got, next)
+	}
+
+	// A client whose backend left is balanced again and gets a new cookie.
+	if first == "a" {
+		sync(b)
+	} else {
+		sync(a)
+	}
+	moved := get(t, sticky, url, nil)
+	if moved == first {
+		t.Fatalf("reply = %q from the backend that left", moved)
+	}
+	if got := get(t, sticky, url, nil); got != moved {
+		t.Errorf("reply = %q, want %q from the new backend", got, moved)
+	}
+}
+
+func TestProxy_HeaderAffinity(t *testing.T) {
+	a := httpBackend(t, "127.0.0.2", "a")
+	b := httpBackend(t, "127.0.0.3", "b")
+	_, url := affinityProxy(t, &Affinity{Mode: AffinityHeader, Name: "X-User"}, a, b)
+	client := newHTTPClient(nil)
+
+	alice := http.Header{"X-User": []string{"alice"}}
+	bob := http.Header{"X-User": []string{"bob"}}
+	gotAlice, gotBob := get(t, client, url, alice), get(t, client, url, bob)
+	if gotAlice == gotBob {
+		t.Errorf("alice and bob both got %q, want them balanced over both backends", gotAlice)
+	}
+	for range 3 {
+		if got := get(t, client, url, alice); got != gotAlice {
+			t.Errorf("alice got %q, want %q", got, gotAlice)
+		}
+		if got := get(t, client, url, bob); got != gotBob {
+			t.Errorf("bob got %q, want %q", got, gotBob)
+		}
+	}
+}
+
+// A keep-alive client is told to close each connection, so every request is
+// routed by its own head rather than riding the first request's backend.
+func TestProxy_AffinityWithKeepAlive(t *testing.T) {
+	a := httpBackend(t, "127.0.0.2", "a")
+	b := httpBackend(t, "127.0.0.3", "b")
+	_, url := affinityProxy(t, &Affinity{Mode: AffinityHeader, Name: "X-User"}, a, b)
+	client := &http.Client{Timeout: 2 * time.Second, Transport: &http.Transport{}}
+	t.Cleanup(client.CloseIdleConnections)
+
+	alice := http.Header{"X-User": []string{"alice"}}
+	bob := http.Header{"X-User": []string{"bob"}}
+	gotAlice, gotBob := get(t, client, url, alice), get(t, client, url, bob)
+	if gotAlice == gotBob {
+		t.Errorf("alice and bob both got %q, want each request routed by its own header", gotAlice)
+	}
+	for range 3 {
+		if got := get(t, client, url, bob); got != gotBob {
+			t.Errorf("bob got %q, want %q", got, gotBob)
+		}
+		if got := get(t, client, url, alice); got != gotAlice {
+			t.Errorf("alice got %q, want %q", got, gotAlice)
+		}
+	}
+}
+
+// The backend's 100 Continue passes through, and the cookie lands on the
+// final response.
+func TestProxy_AffinityWithExpectContinue(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = io.WriteString(w, "a:"+string(body))
+	}))
+	srv.Listener = ln
+	srv.Start()
+	t.Cleanup(srv.Close)
+	backend := Endpoint{Address: "127.0.0.2", Port: ln.Addr().(*net.TCPAddr).Port}
+	_, url := affinityProxy(t, &Affinity{Mode: AffinityCookie}, backend)
+
+	// The client holds the body back for longer than the test may take,
+	// unless it sees the 100 Continue.
+	client := &http.Client{Timeout: 2 * time.Second, Transport: &http.Transport{ExpectContinueTimeout: time.Minute}}
+	t.Cleanup(client.CloseIdleConnections)
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader("ping"))
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	req.Header.Set("Expect", "100-continue")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if string(body) != "a:ping" {
+		t.Errorf("body = %q, want %q", body, "a:ping")
+	}
+	if cookies := resp.Cookies(); len(cookies) != 1 || cookies[0].Name != defaultAffinityCookie {
+		t.Errorf("cookies = %v, want the affinity cookie on the final response", cookies)
+	}
+	if !resp.Close {
+		t.Error("final response does not close the connection")
+	}
+}
+
+func TestFirstRequest(t *testing.T) {
+	tests := []struct {
+		name, head, forwarded, rest string
+	}{
+		{"no body", "GET / HTTP/1.1\r\nHost: a\r\n\r\n", "", "GET /next HTTP/1.1\r\n\r\n"},
+		{"content length", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 4\r\n\r\n", "ping", "GET /next HTTP/1.1\r\n\r\n"},
+		{"chunked", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n",
+			"4;x=1\r\npi\r\n\r\n0\r\nX-Sum: 1\r\n\r\n", "GET /next HTTP/1.1\r\n\r\n"},
+		{"upgrade", "GET /ws HTTP/1.1\r\nHost: a\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n", "frames", ""},
+		{"partial head", "GET / HTTP/1.1\r\nHost", "", ""},
+	}
+	for _, tt := range tests {
+		// The head and a few bytes after it were read before routing; the
+		// rest comes from the client.
+		stream := tt.head + tt.forwarded + tt.rest
+		read := min(len(stream), len(tt.head)+3)
+		r := parseFirstRequest([]byte(stream[:read]), strings.NewReader(stream[read:]))
+		if string(r.head) != tt.head {
+			t.Errorf("%s: head = %q, want %q", tt.name, r.head, tt.head)
+		}
+		var forwarded strings.Builder
+		if err := r.copyBody(&forwarded); err != nil {
+			t.Errorf("%s: copyBody() error = %v", tt.name, err)
+		}
+		if forwarded.String() != tt.forwarded {
+			t.Errorf("%s: forwarded %q, want %q", tt.name, forwarded.String(), tt.forwarded)
+		}
+		if rest, _ := io.ReadAll(r.stream); string(rest) != tt.rest {
+			t.Errorf("%s: left %q, want %q", tt.name, rest, tt.rest)
+		}
+	}
+}
+
+func TestAffinityTable(t *testing.T) {
+	table := newAffinityTable()
+	now := time.Now()
+	table.put("alice", "a", time.Minute, now)
+	if got := table.get("alice", time.Minute, now.Add(50*time.Second)); got != "a" {
+		t.Errorf("get() = %q, want a", got)
+	}
+	// The lookup renewed the pin.
+	if got := table.get("alice", time.Minute, now.Add(100*time.Second)); got != "a" {
+		t.Errorf("get() after a renewal = %q, want a", got)
+	}
+	if got := table.get("alice", time.Minute, now.Add(200*time.Second)); got != "" {
+		t.Errorf("get() after the timeout = %q, want none", got)
+	}
+	table.put("bob", "b", time.Minute, now.Add(200*time.Second))
+	if _, ok := table.pins["alice"]; ok {
+		t.Error("expired pin kept")
+	}
+}
+
+func TestWithConnectionClose(t *testing.T) {
+	tests := []struct {
+		name, head, want string
+	}{
+		{"request", "GET / HTTP/1.1\r\nHost: a\r\n\r\n", "GET / HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n"},
+		{"keep-alive response", "HTTP/1.1 200 OK\r\nconnection: keep-alive\r\nKeep-Alive: timeout=5\r\nContent-Length: 2\r\n\r\nok",
+			"HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok"},
+		{"upgrade", "GET /ws HTTP/1.1\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n", "GET /ws HTTP/1.1\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n"},
+		{"partial head", "HTTP/1.1 200 OK\r\nContent-Le", "HTTP/1.1 200 OK\r\nContent-Le"},
+		{"not HTTP", "SSH-2.0-OpenSSH\r\n\r\n", "SSH-2.0-OpenSSH\r\n\r\n"},
+	}
+	for _, tt := range tests {
+		if got := string(withConnectionClose([]byte(tt.head))); got != tt.want {
+			t.Errorf("%s: withConnectionClose() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInsertHeader(t *testing.T) {
+	tests := []struct {
+		name, reply, want string
+	}{
+		{"response head", "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nSet-Cookie: c=1\r\n\r\nok"},
+		{"partial head", "HTTP/1.1 200 OK\r\nContent-Le", "HTTP/1.1 200 OK\r\nContent-Le"},
+		{"not HTTP", "SSH-2.0-OpenSSH\r\n\r\n", "SSH-2.0-OpenSSH\r\n\r\n"},
+	}
+	for _, tt := range tests {
+		if got := string(insertHeader([]byte(tt.reply), "Set-Cookie", "c=1")); got != tt.want {
+			t.Errorf("%s: insertHeader() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
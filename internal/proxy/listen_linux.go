@@ -0,0 +1,26 @@
+//go:build linux
+
+package proxy
+
+import (
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// freebind lets a listener bind an address that is not configured on the
+// node, so frontends are ready before the address is routed here.
+func freebind(network, _ string, c syscall.RawConn) error {
+	var sockErr error
+	if err := c.Control(func(fd uintptr) {
+		if strings.HasSuffix(network, "6") {
+			sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_FREEBIND, 1)
+			return
+		}
+		sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_FREEBIND, 1)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package proxy
+
+import "syscall"
+
+// freebind does nothing: binding addresses the host does not have is only
+// supported on Linux, so a listener fails until the address is configured.
+func freebind(string, string, syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,464 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/somaz94/helios-lb/internal/loadbalancer"
+	"github.com/somaz94/helios-lb/internal/metrics"
+)
+
+// Protocols a Frontend can accept.
+const (
+	ProtocolTCP = "TCP"
+	ProtocolUDP = "UDP"
+)
+
+const (
+	// dialTimeout bounds how long a session waits for its backend to accept.
+	dialTimeout = 5 * time.Second
+	// defaultUDPIdleTimeout ends a UDP flow after this long without a
+	// datagram in either direction, unless the frontend sets its own.
+	defaultUDPIdleTimeout = 2 * time.Minute
+	// maxDatagramLen fits any UDP payload.
+	maxDatagramLen = 65535
+)
+
+// Endpoint is one backend address traffic can be forwarded to.
+type Endpoint struct {
+	Address string
+	Port    int
+	// Weight is the endpoint's share under WeightedRoundRobin; zero counts
+	// as 1.
+	Weight int
+}
+
+// Frontend is an address and port the proxy accepts traffic on, and the
+// backends that traffic is spread over.
+type Frontend struct {
+	IP       string
+	Port     int
+	Protocol string
+	Backends []Endpoint
+	// IdleTimeout ends a UDP flow after this long without traffic; zero
+	// selects the default. TCP frontends ignore it.
+	IdleTimeout time.Duration
+	// Affinity pins HTTP clients to a backend; nil balances every
+	// connection. UDP frontends ignore it.
+	Affinity *Affinity
+}
+
+// key identifies the listening socket of a frontend. It is also the pool name
+// the frontend's backends are registered under in its balancer.
+func (f Frontend) key() string {
+	return net.JoinHostPort(f.IP, strconv.Itoa(f.Port)) + "/" + f.Protocol
+}
+
+// Group is a set of frontends that share one balancer configuration, the
+// frontends of one HeliosConfig.
+type Group struct {
+	Name      string
+	Balancer  loadbalancer.BalancerConfig
+	Frontends []Frontend
+}
+
+// HealthObserver is told when a health check changes the health of a
+// backend of a frontend in a group.
+type HealthObserver func(group, frontend string, backend Endpoint, healthy bool)
+
+// OutlierObserver is told when outlier detection ejects a backend of a
+// frontend in a group, until the given time, or lets it back in.
+type OutlierObserver func(group, frontend string, backend Endpoint, ejected bool, until time.Time)
+
+// balancer is the running balancer of a group.
+type balancer struct {
+	config loadbalancer.BalancerConfig
+	lb     *loadbalancer.LoadBalancer
+}
+
+// Proxy is a userspace TCP/UDP proxy. Every frontend gets its own listening
+// socket; each accepted connection, or each new UDP client, is handed to the
+// backend the group's balancer picks with NextBackend and spliced to it until
+// either side is done. Sync converges the listeners on the desired groups;
+// listeners and sessions of frontends that did not change are left alone.
+type Proxy struct {
+	logger        logr.Logger
+	metrics       *metrics.MetricsRecorder
+	healthChange  HealthObserver
+	outlierChange OutlierObserver
+
+	mu        sync.Mutex
+	closed    bool
+	balancers map[string]*balancer
+	listeners map[string]*listener
+}
+
+// New creates a proxy with no listeners. recorder may be nil.
+func New(logger logr.Logger, recorder *metrics.MetricsRecorder) *Proxy {
+	return &Proxy{
+		logger:    logger,
+		metrics:   recorder,
+		balancers: make(map[string]*balancer),
+		listeners: make(map[string]*listener),
+	}
+}
+
+// OnHealthChange sets the observer told about backend health changes. It
+// must be called before the first Sync and must not block.
+func (p *Proxy) OnHealthChange(observer HealthObserver) {
+	p.healthChange = observer
+}
+
+// OnOutlierChange sets the observer told about backend ejections. It must be
+// called before the first Sync and must not block.
+func (p *Proxy) OnOutlierChange(observer OutlierObserver) {
+	p.outlierChange = observer
+}
+
+// Sync opens, updates and closes listeners so exactly the frontends of the
+// given groups are served. A group whose balancer configuration changed is
+// reconfigured in place; its frontends, sessions and backends are kept.
+// Frontends that fail to listen are reported and retried on the next Sync.
+func (p *Proxy) Sync(groups []Group) error {
+	type wanted struct {
+		group    string
+		frontend Frontend
+	}
+	wantGroups := make(map[string]Group, len(groups))
+	wantFrontends := make(map[string]wanted)
+	for _, g := range groups {
+		wantGroups[g.Name] = g
+		for _, f := range g.Frontends {
+			if _, ok := wantFrontends[f.key()]; !ok {
+				wantFrontends[f.key()] = wanted{group: g.Name, frontend: f}
+			}
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+
+	for key, l := range p.listeners {
+		if w, ok := wantFrontends[key]; !ok || w.group != l.group {
+			l.close()
+			delete(p.listeners, key)
+			p.logger.Info("stopped listening", "frontend", key)
+		}
+	}
+	for name, b := range p.balancers {
+		g, ok := wantGroups[name]
+		if !ok {
+			b.lb.Stop()
+			delete(p.balancers, name)
+			continue
+		}
+		if !reflect.DeepEqual(b.config, g.Balancer) {
+			b.config = g.Balancer
+			b.lb.Reconfigure(g.Balancer)
+		}
+	}
+	for name, g := range wantGroups {
+		if _, ok := p.balancers[name]; !ok {
+			lb := loadbalancer.NewLoadBalancer(g.Balancer)
+			lb.OnHealthChange(func(b *loadbalancer.Backend, healthy bool) {
+				p.backendHealthChanged(name, b, healthy)
+			})
+			lb.OnProbe(p.backendProbed)
+			lb.OnOutlierChange(func(b *loadbalancer.Backend, ejected bool) {
+				p.backendEjectionChanged(name, b, ejected)
+			})
+			lb.OnDrain(func(b *loadbalancer.Backend, timedOut bool) {
+				p.backendDrained(name, b, timedOut)
+			})
+			p.balancers[name] = &balancer{config: g.Balancer, lb: lb}
+		}
+	}
+
+	var errs []error
+	for key, w := range wantFrontends {
+		l, ok := p.listeners[key]
+		if !ok {
+			var err error
+			l, err = p.listen(w.group, w.frontend, p.balancers[w.group].lb)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to listen on %s: %w", key, err))
+				continue
+			}
+			p.listeners[key] = l
+			p.logger.Info("listening", "frontend", key)
+		}
+		l.setBackends(w.frontend.Backends)
+		l.setIdleTimeout(w.frontend.IdleTimeout)
+		l.setAffinity(w.frontend.Affinity)
+	}
+	return errors.Join(errs...)
+}
+
+// backendHealthChanged reports a health change of a backend of group. The
+// backend's pool is the frontend it serves.
+func (p *Proxy) backendHealthChanged(group string, b *loadbalancer.Backend, healthy bool) {
+	p.logger.Info("backend health changed", "frontend", b.ServiceName, "backend", backendAddress(b), "healthy", healthy)
+	if p.metrics != nil {
+		p.metrics.RecordBackendHealthTransition(b.ServiceName, backendAddress(b), healthy)
+	}
+	if p.healthChange != nil {
+		p.healthChange(group, b.ServiceName, Endpoint{Address: b.Address, Port: b.Port, Weight: b.Weight}, healthy)
+	}
+}
+
+// backendProbed records how long a health check of a backend took.
+func (p *Proxy) backendProbed(b *loadbalancer.Backend, took time.Duration) {
+	if p.metrics != nil {
+		p.metrics.RecordBackendProbeLatency(backendAddress(b), b.ServiceName, took.Seconds())
+	}
+}
+
+// backendEjectionChanged reports outlier detection ejecting a backend of
+// group or letting it back in.
+func (p *Proxy) backendEjectionChanged(group string, b *loadbalancer.Backend, ejected bool) {
+	p.logger.Info("backend ejection changed", "frontend", b.ServiceName, "backend", backendAddress(b),
+		"ejected", ejected, "until", b.EjectedUntil())
+	if p.metrics != nil {
+		p.metrics.RecordBackendEjection(b.ServiceName, backendAddress(b), ejected)
+	}
+	if p.outlierChange != nil {
+		p.outlierChange(group, b.ServiceName, Endpoint{Address: b.Address, Port: b.Port, Weight: b.Weight}, ejected, b.EjectedUntil())
+	}
+}
+
+// backendDrained ends the sessions a removed backend of group still has when
+// its drain timeout passed.
+func (p *Proxy) backendDrained(group string, b *loadbalancer.Backend, timedOut bool) {
+	p.logger.Info("backend drained", "frontend", b.ServiceName, "backend", backendAddress(b), "timedOut", timedOut)
+	if !timedOut {
+		return
+	}
+	p.mu.Lock()
+	l := p.listeners[b.ServiceName]
+	p.mu.Unlock()
+	if l != nil && l.group == group {
+		l.closeBackend(b)
+	}
+}
+
+// Listening returns the keys of the frontends currently served.
+func (p *Proxy) Listening() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys := make([]string, 0, len(p.listeners))
+	for key := range p.listeners {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Start serves until ctx is done, then closes every listener and session. It
+// implements manager.Runnable.
+func (p *Proxy) Start(ctx context.Context) error {
+	<-ctx.Done()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for key, l := range p.listeners {
+		l.close()
+		delete(p.listeners, key)
+	}
+	for name, b := range p.balancers {
+		b.lb.Stop()
+		delete(p.balancers, name)
+	}
+	return nil
+}
+
+// NeedLeaderElection reports false: every speaker proxies the traffic that
+// reaches its own node.
+func (p *Proxy) NeedLeaderElection() bool {
+	return false
+}
+
+// listen opens the socket of a frontend and starts serving it.
+func (p *Proxy) listen(group string, f Frontend, lb *loadbalancer.LoadBalancer) (*listener, error) {
+	l := &listener{
+		group:    group,
+		pool:     f.key(),
+		logger:   p.logger.WithValues("frontend", f.key()),
+		metrics:  p.metrics,
+		backends: make(map[string]*loadbalancer.Backend),
+		conns:    make(map[io.Closer]*loadbalancer.Backend),
+		lb:       lb,
+	}
+
+	lc := net.ListenConfig{Control: freebind}
+	address := net.JoinHostPort(f.IP, strconv.Itoa(f.Port))
+	switch f.Protocol {
+	case ProtocolUDP:
+		pc, err := lc.ListenPacket(context.Background(), "udp", address)
+		if err != nil {
+			return nil, err
+		}
+		l.socket = pc
+		l.flows = newFlowTable()
+		l.setIdleTimeout(f.IdleTimeout)
+		l.recordFlows(0)
+		l.wg.Add(1)
+		go l.serveUDP(pc.(*net.UDPConn))
+	case ProtocolTCP, "":
+		ln, err := lc.Listen(context.Background(), "tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		l.socket = ln
+		l.pins = newAffinityTable()
+		l.setAffinity(f.Affinity)
+		l.wg.Add(1)
+		go l.serveTCP(ln)
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q", f.Protocol)
+	}
+	return l, nil
+}
+
+// listener serves one frontend.
+type listener struct {
+	group   string
+	pool    string
+	logger  logr.Logger
+	metrics *metrics.MetricsRecorder
+	socket  io.Closer
+	lb      *loadbalancer.LoadBalancer
+
+	// backends is guarded by Proxy.mu and keyed by address: the balancer
+	// identifies the backends of a pool by address.
+	backends map[string]*loadbalancer.Backend
+
+	// flows is the flow table of a UDP frontend, nil for TCP.
+	flows          *flowTable
+	udpIdleTimeout atomic.Int64
+
+	// affinity is the session affinity of a TCP frontend, nil for none;
+	// pins holds its header pins.
+	affinity atomic.Pointer[Affinity]
+	pins     *affinityTable
+
+	mu      sync.Mutex
+	closing bool
+	// conns maps the open sockets of sessions to the backend they are
+	// forwarded to, nil while it is not picked yet.
+	conns map[io.Closer]*loadbalancer.Backend
+	wg    sync.WaitGroup
+}
+
+// setBackends registers exactly the given endpoints with the balancer. It
+// only adds and removes the difference: backends that stay keep their
+// connection counts and their place in the balancer's rotation; a backend
+// whose port or weight changed is replaced. Removed backends drain for the
+// group's drain timeout, if it has one, before their sessions end. Endpoints
+// are already known to be serving, so new backends start out healthy; the
+// balancer's own health checks take over from there.
+func (l *listener) setBackends(endpoints []Endpoint) {
+	lb := l.lb
+	want := make(map[string]Endpoint, len(endpoints))
+	for _, ep := range endpoints {
+		want[ep.Address] = ep
+	}
+	for address, b := range l.backends {
+		if ep, ok := want[address]; !ok || ep.Port != b.Port || ep.Weight != b.Weight {
+			lb.RemoveBackend(address, l.pool)
+			delete(l.backends, address)
+		}
+	}
+	for address, ep := range want {
+		if _, ok := l.backends[address]; ok {
+			continue
+		}
+		b := &loadbalancer.Backend{Address: ep.Address, Port: ep.Port, ServiceName: l.pool, Weight: ep.Weight}
+		b.SetHealthy(true)
+		lb.AddBackend(b)
+		l.backends[address] = b
+	}
+}
+
+// track registers a connection to backend, which may be nil, to be closed
+// with the listener. It reports false, and the caller must close c itself,
+// once the listener is closing.
+func (l *listener) track(c io.Closer, backend *loadbalancer.Backend) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closing {
+		return false
+	}
+	l.conns[c] = backend
+	l.wg.Add(1)
+	return true
+}
+
+// assign records the backend a tracked connection is forwarded to.
+func (l *listener) assign(c io.Closer, backend *loadbalancer.Backend) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.conns[c]; ok {
+		l.conns[c] = backend
+	}
+}
+
+// closeBackend closes the connections forwarded to backend, which ends their
+// sessions.
+func (l *listener) closeBackend(backend *loadbalancer.Backend) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for c, b := range l.conns {
+		if b == backend {
+			_ = c.Close()
+		}
+	}
+}
+
+func (l *listener) untrack(c io.Closer) {
+	l.mu.Lock()
+	delete(l.conns, c)
+	l.mu.Unlock()
+	l.wg.Done()
+}
+
+// close stops accepting, ends every session and waits for them to return.
+func (l *listener) close() {
+	l.mu.Lock()
+	l.closing = true
+	_ = l.socket.Close()
+	for c := range l.conns {
+		_ = c.Close()
+	}
+	l.mu.Unlock()
+	l.wg.Wait()
+	if l.flows != nil && l.metrics != nil {
+		l.metrics.DeleteUDPFlows(l.pool)
+	}
+}
+
+// backendAddress returns the dial address of a backend.
+func backendAddress(b *loadbalancer.Backend) string {
+	return net.JoinHostPort(b.Address, strconv.Itoa(b.Port))
+}
+
+// clientIP returns the host part of a peer address, the key IPHash balances on.
+func clientIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,777 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/somaz94/helios-lb/internal/loadbalancer"
+	"github.com/somaz94/helios-lb/internal/metrics"
+)
+
+const frontendIP = "127.0.0.1"
+
+// freePort returns a port that was free on frontendIP a moment ago.
+func freePort(t *testing.T, network string) int {
+	t.Helper()
+	if network == "udp" {
+		pc, err := net.ListenPacket("udp", net.JoinHostPort(frontendIP, "0"))
+		if err != nil {
+			t.Fatalf("ListenPacket() error = %v", err)
+		}
+		defer func() { _ = pc.Close() }()
+		return pc.LocalAddr().(*net.UDPAddr).Port
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(frontendIP, "0"))
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer func() { _ = ln.Close() }()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// tcpBackend starts a server on address that answers each line with its name
+// and the line, and returns its endpoint. Backends of one pool are told apart
+// by address, so each test backend gets its own loopback address.
+func tcpBackend(t *testing.T, address, name string) Endpoint {
+	t.Helper()
+	ln, err := net.Listen("tcp", net.JoinHostPort(address, "0"))
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if _, err := io.WriteString(conn, name+":"+line); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return Endpoint{Address: address, Port: ln.Addr().(*net.TCPAddr).Port}
+}
+
+// udpBackend starts a server on address that answers each datagram with its
+// name and the payload.
+func udpBackend(t *testing.T, address, name string) Endpoint {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", net.JoinHostPort(address, "0"))
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+	go func() {
+		buf := make([]byte, maxDatagramLen)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = pc.WriteTo(append([]byte(name+":"), buf[:n]...), from)
+		}
+	}()
+	return Endpoint{Address: address, Port: pc.LocalAddr().(*net.UDPAddr).Port}
+}
+
+func startProxy(t *testing.T) *Proxy {
+	t.Helper()
+	return startProxyWithMetrics(t, nil)
+}
+
+// startProxyWithMetrics starts a proxy that records its metrics with
+// recorder.
+func startProxyWithMetrics(t *testing.T, recorder *metrics.MetricsRecorder) *Proxy {
+	t.Helper()
+	p := New(logr.Discard(), recorder)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_ = p.Start(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return p
+}
+
+// roundTrip opens a connection to the frontend, sends one line and returns
+// the reply.
+func roundTrip(t *testing.T, network string, port int, msg string) string {
+	t.Helper()
+	conn, err := net.DialTimeout(network, net.JoinHostPort(frontendIP, strconv.Itoa(port)), time.Second)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.WriteString(conn, msg+"\n"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestProxy_TCPRoundRobin(t *testing.T) {
+	p := startProxy(t)
+	port := freePort(t, "tcp")
+	a := tcpBackend(t, "127.0.0.2", "a")
+	b := tcpBackend(t, "127.0.0.3", "b")
+
+	err := p.Sync([]Group{{
+		Name:     "default/web",
+		Balancer: loadbalancer.BalancerConfig{Type: loadbalancer.RoundRobin},
+		Frontends: []Frontend{
+			{IP: frontendIP, Port: port, Protocol: ProtocolTCP, Backends: []Endpoint{a, b}},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	seen := map[string]int{}
+	for range 4 {
+		seen[roundTrip(t, "tcp", port, "ping")]++
+	}
+	if seen["a:ping\n"] != 2 || seen["b:ping\n"] != 2 {
+		t.Errorf("replies = %v, want two from each backend", seen)
+	}
+}
+
+func TestProxy_UDPSessionSticksToBackend(t *testing.T) {
+	p := startProxy(t)
+	port := freePort(t, "udp")
+	a := udpBackend(t, "127.0.0.2", "a")
+	b := udpBackend(t, "127.0.0.3", "b")
+
+	err := p.Sync([]Group{{
+		Name:     "default/dns",
+		Balancer: loadbalancer.BalancerConfig{Type: loadbalancer.RoundRobin},
+		Frontends: []Frontend{
+			{IP: frontendIP, Port: port, Protocol: ProtocolUDP, Backends: []Endpoint{a, b}},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	conn, err := net.Dial("udp", net.JoinHostPort(frontendIP, strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	var first string
+	buf := make([]byte, 256)
+	for i := range 3 {
+		if _, err := conn.Write([]byte("q")); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if i == 0 {
+			first = string(buf[:n])
+		} else if string(buf[:n]) != first {
+			t.Errorf("reply %d = %q, want %q from the same backend", i, buf[:n], first)
+		}
+	}
+}
+
+func TestProxy_LeastConnectionCountsOpenSessions(t *testing.T) {
+	p := startProxy(t)
+	port := freePort(t, "tcp")
+	a := tcpBackend(t, "127.0.0.2", "a")
+	b := tcpBackend(t, "127.0.0.3", "b")
+
+	err := p.Sync([]Group{{
+		Name:     "default/web",
+		Balancer: loadbalancer.BalancerConfig{Type: loadbalancer.LeastConnection},
+		Frontends: []Frontend{
+			{IP: frontendIP, Port: port, Protocol: ProtocolTCP, Backends: []Endpoint{a, b}},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	// Hold one session open: the next connection must go to the other backend.
+	held, err := net.Dial("tcp", net.JoinHostPort(frontendIP, strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer func() { _ = held.Close() }()
+	_ = held.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.WriteString(held, "hold\n"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	reply, err := bufio.NewReader(held).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	other := "a:ping\n"
+	if reply == "a:hold\n" {
+		other = "b:ping\n"
+	}
+
+	if got := roundTrip(t, "tcp", port, "ping"); got != other {
+		t.Errorf("reply = %q, want %q from the backend without the held session", got, other)
+	}
+}
+
+func TestProxy_SyncClosesRemovedFrontends(t *testing.T) {
+	p := startProxy(t)
+	port := freePort(t, "tcp")
+	a := tcpBackend(t, "127.0.0.2", "a")
+	group := Group{
+		Name:     "default/web",
+		Balancer: loadbalancer.BalancerConfig{Type: loadbalancer.RoundRobin},
+		Frontends: []Frontend{
+			{IP: frontendIP, Port: port, Protocol: ProtocolTCP, Backends: []Endpoint{a}},
+		},
+	}
+	if err := p.Sync([]Group{group}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if got := p.Listening(); len(got) != 1 {
+		t.Fatalf("Listening() = %v, want one frontend", got)
+	}
+
+	if err := p.Sync(nil); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if got := p.Listening(); len(got) != 0 {
+		t.Errorf("Listening() = %v, want none", got)
+	}
+	if conn, err := net.DialTimeout("tcp", net.JoinHostPort(frontendIP, strconv.Itoa(port)), time.Second); err == nil {
+		_ = conn.Close()
+		t.Error("frontend still accepts connections after it was removed")
+	}
+}
+
+func TestProxy_SyncSwitchesBalancerAndBackends(t *testing.T) {
+	p := startProxy(t)
+	port := freePort(t, "tcp")
+	a := tcpBackend(t, "127.0.0.2", "a")
+	b := tcpBackend(t, "127.0.0.3", "b")
+	group := Group{
+		Name:     "default/web",
+		Balancer: loadbalancer.BalancerConfig{Type: loadbalancer.RoundRobin},
+		Frontends: []Frontend{
+			{IP: frontendIP, Port: port, Protocol: ProtocolTCP, Backends: []Endpoint{a}},
+		},
+	}
+	if err := p.Sync([]Group{group}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if got := roundTrip(t, "tcp", port, "ping"); got != "a:ping\n" {
+		t.Fatalf("reply = %q, want a:ping", got)
+	}
+
+	group.Balancer.Type = loadbalancer.RandomSelection
+	group.Frontends[0].Backends = []Endpoint{b}
+	if err := p.Sync([]Group{group}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	for range 3 {
+		if got := roundTrip(t, "tcp", port, "ping"); got != "b:ping\n" {
+			t.Errorf("reply = %q, want b:ping after the backend was replaced", got)
+		}
+	}
+}
+
+func TestProxy_NoBackendClosesConnection(t *testing.T) {
+	p := startProxy(t)
+	port := freePort(t, "tcp")
+	err := p.Sync([]Group{{
+		Name:      "default/web",
+		Balancer:  loadbalancer.BalancerConfig{Type: loadbalancer.RoundRobin},
+		Frontends: []Frontend{{IP: frontendIP, Port: port, Protocol: ProtocolTCP}},
+	}})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(frontendIP, strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read() error = %v, want EOF", err)
+	}
+}
+
+func TestProxy_SyncKeepsConnectionCounts(t *testing.T) {
+	p := startProxy(t)
+	port := freePort(t, "tcp")
+	a := tcpBackend(t, "127.0.0.2", "a")
+	b := tcpBackend(t, "127.0.0.3", "b")
+	group := Group{
+		Name:     "default/web",
+		Balancer: loadbalancer.BalancerConfig{Type: loadbalancer.LeastConnection},
+		Frontends: []Frontend{
+			{IP: frontendIP, Port: port, Protocol: ProtocolTCP, Backends: []Endpoint{a}},
+		},
+	}
+	if err := p.Sync([]Group{group}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	held, err := net.Dial("tcp", net.JoinHostPort(frontendIP, strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer func() { _ = held.Close() }()
+	_ = held.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.WriteString(held, "hold\n"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := bufio.NewReader(held).ReadString('\n'); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+
+	// An endpoint update must not reset the held session's count on a.
+	group.Frontends[0].Backends = []Endpoint{a, b}
+	if err := p.Sync([]Group{group}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if got := roundTrip(t, "tcp", port, "ping"); got != "b:ping\n" {
+		t.Errorf("reply = %q, want b:ping from the backend without the held session", got)
+	}
+}
+
+func TestProxy_DrainsRemovedBackend(t *testing.T) {
+	p := startProxy(t)
+	port := freePort(t, "tcp")
+	a := tcpBackend(t, "127.0.0.2", "a")
+	b := tcpBackend(t, "127.0.0.3", "b")
+	group := Group{
+		Name:     "default/web",
+		Balancer: loadbalancer.BalancerConfig{Type: loadbalancer.RoundRobin, DrainTimeout: 300 * time.Millisecond},
+		Frontends: []Frontend{
+			{IP: frontendIP, Port: port, Protocol: ProtocolTCP, Backends: []Endpoint{a}},
+		},
+	}
+	if err := p.Sync([]Group{group}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	held, err := net.Dial("tcp", net.JoinHostPort(frontendIP, strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer func() { _ = held.Close() }()
+	_ = held.SetDeadline(time.Now().Add(2 * time.Second))
+	r := bufio.NewReader(held)
+	if _, err := io.WriteString(held, "before\n"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := r.ReadString('\n'); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+
+	group.Frontends[0].Backends = []Endpoint{b}
+	if err := p.Sync([]Group{group}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if got := roundTrip(t, "tcp", port, "new"); got != "b:new\n" {
+		t.Errorf("reply = %q, want b:new: a draining backend takes no new connections", got)
+	}
+	if _, err := io.WriteString(held, "draining\n"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if got, err := r.ReadString('\n'); err != nil || got != "a:draining\n" {
+		t.Errorf("reply = %q, %v; want a:draining on the session that is draining", got, err)
+	}
+
+	// Past the drain timeout the session is closed.
+	if _, err := r.ReadString('\n'); err != io.EOF {
+		t.Errorf("Read() error = %v, want EOF once the drain timed out", err)
+	}
+}
+
+func TestProxy_UDPFlowExpiresWhenIdle(t *testing.T) {
+	p := startProxy(t)
+	port := freePort(t, "udp")
+	a := udpBackend(t, "127.0.0.2", "a")
+	frontend := Frontend{
+		IP: frontendIP, Port: port, Protocol: ProtocolUDP,
+		Backends:    []Endpoint{a},
+		IdleTimeout: 100 * time.Millisecond,
+	}
+	err := p.Sync([]Group{{
+		Name:      "default/syslog",
+		Balancer:  loadbalancer.BalancerConfig{Type: loadbalancer.RoundRobin},
+		Frontends: []Frontend{frontend},
+	}})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	l := p.listeners[frontend.key()]
+	backend := l.backends[a.Address]
+
+	conn, err := net.Dial("udp", net.JoinHostPort(frontendIP, strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	exchange := func() {
+		t.Helper()
+		if _, err := conn.Write([]byte("q")); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		if _, err := conn.Read(make([]byte, 64)); err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+	}
+
+	exchange()
+	if n := l.flows.len(); n != 1 {
+		t.Fatalf("flows = %d, want 1", n)
+	}
+	if c := atomic.LoadInt32(&backend.Connections); c != 1 {
+		t.Errorf("backend connections = %d, want 1 while the flow is open", c)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for l.flows.len() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := l.flows.len(); n != 0 {
+		t.Fatalf("flows = %d, want 0 after the idle timeout", n)
+	}
+	if c := atomic.LoadInt32(&backend.Connections); c != 0 {
+		t.Errorf("backend connections = %d, want 0 once the flow expired", c)
+	}
+
+	// The same client starts a fresh flow.
+	exchange()
+	if n := l.flows.len(); n != 1 {
+		t.Errorf("flows = %d, want 1 after the client came back", n)
+	}
+}
+
+func TestProxy_UDPFlowsPerClient(t *testing.T) {
+	p := startProxy(t)
+	port := freePort(t, "udp")
+	a := udpBackend(t, "127.0.0.2", "a")
+	b := udpBackend(t, "127.0.0.3", "b")
+	frontend := Frontend{IP: frontendIP, Port: port, Protocol: ProtocolUDP, Backends: []Endpoint{a, b}}
+	err := p.Sync([]Group{{
+		Name:      "default/dns",
+		Balancer:  loadbalancer.BalancerConfig{Type: loadbalancer.RoundRobin},
+		Frontends: []Frontend{frontend},
+	}})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	// Two client sockets are two flows, spread over both backends.
+	seen := map[string]bool{}
+	for range 2 {
+		conn, err := net.Dial("udp", net.JoinHostPort(frontendIP, strconv.Itoa(port)))
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		defer func() { _ = conn.Close() }()
+		_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Write([]byte("q")); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		seen[string(buf[:n])] = true
+	}
+	if !seen["a:q"] || !seen["b:q"] {
+		t.Errorf("replies = %v, want one flow on each backend", seen)
+	}
+	if n := p.listeners[frontend.key()].flows.len(); n != 2 {
+		t.Errorf("flows = %d, want 2", n)
+	}
+}
+
+func TestProxy_ObservesBackendLatency(t *testing.T) {
+	p := startProxy(t)
+	tcpPort := freePort(t, "tcp")
+	udpPort := freePort(t, "udp")
+	tcpFrontend := Frontend{IP: frontendIP, Port: tcpPort, Protocol: ProtocolTCP, Backends: []Endpoint{tcpBackend(t, "127.0.0.2", "a")}}
+	udpFrontend := Frontend{IP: frontendIP, Port: udpPort, Protocol: ProtocolUDP, Backends: []Endpoint{udpBackend(t, "127.0.0.2", "a")}}
+	err := p.Sync([]Group{{
+		Name:      "default/web",
+		Balancer:  loadbalancer.BalancerConfig{Type: loadbalancer.LeastResponseTime},
+		Frontends: []Frontend{tcpFrontend, udpFrontend},
+	}})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	roundTrip(t, "tcp", tcpPort, "ping")
+	roundTrip(t, "udp", udpPort, "ping")
+	for _, f := range []Frontend{tcpFrontend, udpFrontend} {
+		// Both observations land before the reply reaches the client.
+		if p.listeners[f.key()].backends["127.0.0.2"].Latency() == 0 {
+			t.Errorf("%s backend latency not observed", f.Protocol)
+		}
+	}
+}
+
+func TestProxy_WeightedEndpoints(t *testing.T) {
+	p := startProxy(t)
+	port := freePort(t, "tcp")
+	a := tcpBackend(t, "127.0.0.2", "a")
+	b := tcpBackend(t, "127.0.0.3", "b")
+	a.Weight = 3
+
+	sync := func(backends ...Endpoint) {
+		t.Helper()
+		err := p.Sync([]Group{{
+			Name:      "default/web",
+			Balancer:  loadbalancer.BalancerConfig{Type: loadbalancer.WeightedRoundRobin},
+			Frontends: []Frontend{{IP: frontendIP, Port: port, Protocol: ProtocolTCP, Backends: backends}},
+		}})
+		if err != nil {
+			t.Fatalf("Sync() error = %v", err)
+		}
+	}
+	count := func() map[string]int {
+		seen := map[string]int{}
+		for range 8 {
+			seen[roundTrip(t, "tcp", port, "ping")]++
+		}
+		return seen
+	}
+
+	sync(a, b)
+	if seen := count(); seen["a:ping\n"] != 6 || seen["b:ping\n"] != 2 {
+		t.Errorf("replies = %v, want a three times as often as b", seen)
+	}
+
+	// A new weight takes effect on the next Sync.
+	a.Weight, b.Weight = 1, 3
+	sync(a, b)
+	if seen := count(); seen["a:ping\n"] != 2 || seen["b:ping\n"] != 6 {
+		t.Errorf("replies = %v, want b three times as often as a", seen)
+	}
+}
+
+func TestProxy_ReportsBackendHealthChanges(t *testing.T) {
+	p := startProxy(t)
+	type change struct {
+		group, frontend string
+		backend         Endpoint
+		healthy         bool
+	}
+	changes := make(chan change, 4)
+	p.OnHealthChange(func(group, frontend string, backend Endpoint, healthy bool) {
+		changes <- change{group, frontend, backend, healthy}
+	})
+
+	// Nothing listens on the backend, so its health checks fail.
+	backend := Endpoint{Address: "127.0.0.2", Port: freePort(t, "tcp")}
+	frontend := Frontend{IP: frontendIP, Port: freePort(t, "tcp"), Protocol: ProtocolTCP, Backends: []Endpoint{backend}}
+	err := p.Sync([]Group{{
+		Name: "default/web",
+		Balancer: loadbalancer.BalancerConfig{
+			Type:            loadbalancer.RoundRobin,
+			HealthCheck:     true,
+			CheckInterval:   10 * time.Millisecond,
+			HealthCheckOpts: loadbalancer.HealthCheckOptions{UnhealthyThreshold: 2},
+		},
+		Frontends: []Frontend{frontend},
+	}})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	select {
+	case got := <-changes:
+		want := change{"default/web", frontend.key(), backend, false}
+		if got != want {
+			t.Errorf("health change = %+v, want %+v", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no health change reported")
+	}
+}
+
+// probeLatencyRecorded reports whether helios_backend_probe_latency_seconds
+// has a sample for backend of frontend.
+func probeLatencyRecorded(t *testing.T, frontend, backend string) bool {
+	t.Helper()
+	families, err := ctrlmetrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	for _, mf := range families {
+		if mf.GetName() != "helios_backend_probe_latency_seconds" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["backend_address"] == backend && labels["service_name"] == frontend {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestProxy_RecordsProbeLatency(t *testing.T) {
+	p := startProxyWithMetrics(t, metrics.NewMetricsRecorder())
+	backend := tcpBackend(t, "127.0.0.2", "a")
+	frontend := Frontend{IP: frontendIP, Port: freePort(t, "tcp"), Protocol: ProtocolTCP, Backends: []Endpoint{backend}}
+	err := p.Sync([]Group{{
+		Name: "default/web",
+		Balancer: loadbalancer.BalancerConfig{
+			Type:          loadbalancer.RoundRobin,
+			HealthCheck:   true,
+			CheckInterval: 10 * time.Millisecond,
+		},
+		Frontends: []Frontend{frontend},
+	}})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	address := net.JoinHostPort(backend.Address, strconv.Itoa(backend.Port))
+	deadline := time.Now().Add(2 * time.Second)
+	for !probeLatencyRecorded(t, frontend.key(), address) {
+		if time.Now().After(deadline) {
+			t.Fatalf("no probe latency recorded for %s", address)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProxy_EjectsFailingBackend(t *testing.T) {
+	p := startProxy(t)
+	type change struct {
+		backend Endpoint
+		ejected bool
+	}
+	changes := make(chan change, 4)
+	p.OnOutlierChange(func(_, _ string, backend Endpoint, ejected bool, _ time.Time) {
+		changes <- change{backend, ejected}
+	})
+
+	// Backend a answers every request with an HTTP 503 status line.
+	port := freePort(t, "tcp")
+	a := tcpBackend(t, "127.0.0.2", "HTTP/1.1 503 Service Unavailable")
+	b := tcpBackend(t, "127.0.0.3", "b")
+	err := p.Sync([]Group{{
+		Name: "default/web",
+		Balancer: loadbalancer.BalancerConfig{
+			Type:             loadbalancer.RoundRobin,
+			OutlierDetection: true,
+			OutlierDetectionOpts: loadbalancer.OutlierDetectionOptions{
+				ConsecutiveFailures: 2,
+				BaseEjectionTime:    300 * time.Millisecond,
+				MaxEjectionPercent:  50,
+			},
+		},
+		Frontends: []Frontend{{IP: frontendIP, Port: port, Protocol: ProtocolTCP, Backends: []Endpoint{a, b}}},
+	}})
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	for range 4 {
+		roundTrip(t, "tcp", port, "ping")
+	}
+	select {
+	case got := <-changes:
+		if got != (change{a, true}) {
+			t.Fatalf("outlier change = %+v, want a ejected", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("failing backend was not ejected")
+	}
+	for range 4 {
+		if got := roundTrip(t, "tcp", port, "ping"); got != "b:ping\n" {
+			t.Errorf("reply = %q while a is ejected, want b", got)
+		}
+	}
+
+	select {
+	case got := <-changes:
+		if got != (change{a, false}) {
+			t.Errorf("outlier change = %+v, want a back in", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ejected backend did not come back")
+	}
+}
+
+func TestIsInterimResponse(t *testing.T) {
+	tests := []struct {
+		reply string
+		want  bool
+	}{
+		{"HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\n", true},
+		{"HTTP/1.1 103 Early Hints\r\nLink: </a.css>\r\n\r\n", true},
+		{"HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n", false},
+		{"HTTP/1.1 200 OK\r\n\r\n", false},
+		{"HTTP/1.1 100 Continue\r\n", false},
+	}
+	for _, tt := range tests {
+		if got := isInterimResponse([]byte(tt.reply)); got != tt.want {
+			t.Errorf("isInterimResponse(%q) = %v, want %v", tt.reply, got, tt.want)
+		}
+	}
+}
+
+func TestIsHTTPServerError(t *testing.T) {
+	tests := []struct {
+		reply string
+		want  bool
+	}{
+		{"HTTP/1.1 503 Service Unavailable\r\n", true},
+		{"HTTP/1.0 500 Internal Server Error\r\n", true},
+		{"HTTP/1.1 200 OK\r\n", false},
+		{"HTTP/1.1 404 Not Found\r\n", false},
+		{"HTTP/1.1 5", false},
+		{"SSH-2.0-OpenSSH_9.6\r\n", false},
+	}
+	for _, tt := range tests {
+		if got := isHTTPServerError([]byte(tt.reply)); got != tt.want {
+			t.Errorf("isHTTPServerError(%q) = %v, want %v", tt.reply, got, tt.want)
+		}
+	}
+}
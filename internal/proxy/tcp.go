@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/somaz94/helios-lb/internal/loadbalancer"
+)
+
+// firstResponseLen is how much of a backend's first reply is read before
+// the rest is spliced through; it holds an HTTP status line.
+const firstResponseLen = 4096
+
+// serveTCP accepts connections until the socket is closed.
+func (l *listener) serveTCP(ln net.Listener) {
+	defer l.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			l.logger.Error(err, "failed to accept connection")
+			continue
+		}
+		if !l.track(conn, nil) {
+			_ = conn.Close()
+			return
+		}
+		go l.handleTCP(conn)
+	}
+}
+
+// handleTCP forwards one client connection to the backend the balancer picks,
+// or with affinity the backend its first request is pinned to. With affinity
+// the connection carries that one request only: both sides are told to close
+// it afterwards, and anything the client sends after the request is dropped,
+// so a keep-alive client sends its next request on a new connection that is
+// routed by its own head. The backend's connection count
+// covers the whole session, so LeastConnection sees long-lived connections as
+// load.
+func (l *listener) handleTCP(client net.Conn) {
+	defer l.untrack(client)
+	defer func() { _ = client.Close() }()
+
+	var (
+		backend   *loadbalancer.Backend
+		request   *firstRequest
+		setCookie string
+	)
+	if a := l.affinity.Load(); a != nil {
+		head, ok := readRequestHead(client)
+		if !ok {
+			return
+		}
+		backend, setCookie = l.pickByAffinity(client, a, head)
+		request = parseFirstRequest(head, client)
+		request.head = withConnectionClose(request.head)
+	} else {
+		backend = l.lb.NextBackend(l.pool, clientIP(client.RemoteAddr()))
+	}
+	if backend == nil {
+		l.logger.V(1).Info("no backend available", "client", client.RemoteAddr().String())
+		return
+	}
+	l.lb.IncrementConnections(backend)
+	defer l.lb.DecrementConnections(backend)
+	l.assign(client, backend)
+
+	start := time.Now()
+	upstream, err := net.DialTimeout("tcp", backendAddress(backend), dialTimeout)
+	if err != nil {
+		l.logger.Error(err, "failed to connect to backend", "backend", backendAddress(backend))
+		l.lb.ReportResult(backend, false)
+		return
+	}
+	// The connect time is the latency LeastResponseTime balances on.
+	backend.ObserveLatency(time.Since(start))
+	if !l.track(upstream, backend) {
+		_ = upstream.Close()
+		return
+	}
+	defer l.untrack(upstream)
+	defer func() { _ = upstream.Close() }()
+	if request != nil {
+		if _, err := upstream.Write(request.head); err != nil {
+			return
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if request == nil {
+			pipe(upstream, client)
+			return
+		}
+		if request.copyBody(upstream) == nil {
+			// The backend is not half-closed until the client is done:
+			// some servers take EOF for an aborted request.
+			_, _ = io.Copy(io.Discard, request.stream)
+		}
+		pipeDone(upstream)
+	}()
+	l.pipeResponse(client, upstream, backend, request != nil, setCookie)
+	wg.Wait()
+}
+
+// pipeResponse is pipe for the backend's side of a session. It reads the
+// backend's first reply itself to report the session to outlier detection:
+// a reset before any reply, or a reply that starts with an HTTP 5xx status
+// line, is a failure and any other reply a success. Sessions that end
+// without a reply are not reported. With affinity the head of the final
+// reply is read whole and gets "Connection: close", and a non-empty
+// setCookie is added to it as a Set-Cookie header; interim 1xx replies before
+// it are passed on unchanged.
+func (l *listener) pipeResponse(client, upstream net.Conn, backend *loadbalancer.Backend, affinity bool, setCookie string) {
+	var (
+		n   int
+		err error
+		buf []byte
+	)
+	if !affinity {
+		buf = make([]byte, firstResponseLen)
+		n, err = upstream.Read(buf)
+	} else {
+		buf = make([]byte, maxHeadLen)
+		n, err = readHead(upstream, buf, 0)
+		for err == nil && isInterimResponse(buf[:n]) {
+			end := bytes.Index(buf[:n], headEnd) + len(headEnd)
+			if _, werr := client.Write(buf[:end]); werr != nil {
+				pipeDone(client)
+				return
+			}
+			n = copy(buf, buf[end:n])
+			n, err = readHead(upstream, buf, n)
+		}
+	}
+	if n > 0 {
+		l.lb.ReportResult(backend, !isHTTPServerError(buf[:n]))
+		reply := buf[:n]
+		if affinity {
+			reply = withConnectionClose(reply)
+		}
+		if setCookie != "" {
+			reply = insertHeader(reply, "Set-Cookie", setCookie)
+		}
+		if _, werr := client.Write(reply); werr != nil {
+			err = werr
+		}
+	} else if errors.Is(err, syscall.ECONNRESET) {
+		l.lb.ReportResult(backend, false)
+	}
+	if err != nil {
+		pipeDone(client)
+		return
+	}
+	pipe(client, upstream)
+}
+
+// isHTTPServerError reports whether b starts with an HTTP/1.x status line
+// with a 5xx code.
+func isHTTPServerError(b []byte) bool {
+	// "HTTP/1.1 503 Service Unavailable"
+	const prefix = "HTTP/1."
+	if len(b) < len(prefix)+5 || !bytes.HasPrefix(b, []byte(prefix)) || b[len(prefix)+1] != ' ' {
+		return false
+	}
+	return b[len(prefix)+2] == '5'
+}
+
+// isInterimResponse reports whether b starts with the whole head of an
+// HTTP/1.x 1xx response other than 101 Switching Protocols, after which the
+// backend sends another response to the same request.
+func isInterimResponse(b []byte) bool {
+	// "HTTP/1.1 100 Continue"
+	const prefix = "HTTP/1."
+	if len(b) < len(prefix)+5 || !bytes.HasPrefix(b, []byte(prefix)) || b[len(prefix)+1] != ' ' {
+		return false
+	}
+	status := b[len(prefix)+2 : len(prefix)+5]
+	return status[0] == '1' && string(status) != "101" && bytes.Contains(b, headEnd)
+}
+
+// pipe copies src to dst until src is drained, then half-closes dst so its
+// peer sees EOF while the other direction keeps flowing.
+func pipe(dst, src net.Conn) {
+	_, _ = io.Copy(dst, src)
+	pipeDone(dst)
+}
+
+// pipeDone half-closes dst, or closes it if it cannot be half-closed.
+func pipeDone(dst net.Conn) {
+	if c, ok := dst.(interface{ CloseWrite() error }); ok {
+		_ = c.CloseWrite()
+		return
+	}
+	_ = dst.Close()
+}
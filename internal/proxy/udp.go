@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/somaz94/helios-lb/internal/loadbalancer"
+)
+
+// udpFlow is one entry of the flow table: the datagrams a client sends go out
+// on a socket connected to the backend the flow is pinned to, and replies on
+// that socket go back to the client. The backend's connection count covers
+// the flow's lifetime, so LeastConnection weighs UDP clients like TCP ones.
+type udpFlow struct {
+	client   netip.AddrPort
+	upstream *net.UDPConn
+	backend  *loadbalancer.Backend
+	// lastSeen is the time of the latest datagram in either direction, in
+	// nanoseconds since the epoch.
+	lastSeen atomic.Int64
+}
+
+func (f *udpFlow) touch() {
+	f.lastSeen.Store(time.Now().UnixNano())
+}
+
+// flowTable holds the UDP flows of one listener. The listener fixes the
+// protocol and the frontend address and port, so the client address and port
+// complete the 5-tuple a flow is keyed by.
+type flowTable struct {
+	mu    sync.Mutex
+	flows map[netip.AddrPort]*udpFlow
+}
+
+func newFlowTable() *flowTable {
+	return &flowTable{flows: make(map[netip.AddrPort]*udpFlow)}
+}
+
+func (t *flowTable) get(client netip.AddrPort) *udpFlow {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.flows[client]
+}
+
+// add records a flow and returns the number of flows.
+func (t *flowTable) add(f *udpFlow) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.flows[f.client] = f
+	return len(t.flows)
+}
+
+// remove deletes f, unless its client has moved on to a newer flow, and
+// returns the number of flows.
+func (t *flowTable) remove(f *udpFlow) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.flows[f.client] == f {
+		delete(t.flows, f.client)
+	}
+	return len(t.flows)
+}
+
+func (t *flowTable) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.flows)
+}
+
+// serveUDP reads client datagrams until the socket is closed.
+func (l *listener) serveUDP(conn *net.UDPConn) {
+	defer l.wg.Done()
+	buf := make([]byte, maxDatagramLen)
+	for {
+		n, client, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			l.logger.Error(err, "failed to read datagram")
+			continue
+		}
+		f := l.flows.get(client)
+		if f == nil {
+			if f = l.openFlow(conn, client); f == nil {
+				continue
+			}
+		}
+		f.touch()
+		if _, err := f.upstream.Write(buf[:n]); err != nil {
+			l.logger.V(1).Info("failed to forward datagram", "client", client.String(), "error", err.Error())
+		}
+	}
+}
+
+// openFlow pins a new client to the backend the balancer picks.
+func (l *listener) openFlow(conn *net.UDPConn, client netip.AddrPort) *udpFlow {
+	backend := l.lb.NextBackend(l.pool, client.Addr().String())
+	if backend == nil {
+		l.logger.V(1).Info("no backend available", "client", client.String())
+		return nil
+	}
+	upstream, err := net.DialTimeout("udp", backendAddress(backend), dialTimeout)
+	if err != nil {
+		l.logger.Error(err, "failed to connect to backend", "backend", backendAddress(backend))
+		l.lb.ReportResult(backend, false)
+		return nil
+	}
+	if !l.track(upstream, backend) {
+		_ = upstream.Close()
+		return nil
+	}
+	l.lb.IncrementConnections(backend)
+	f := &udpFlow{client: client, upstream: upstream.(*net.UDPConn), backend: backend}
+	f.touch()
+	l.recordFlows(l.flows.add(f))
+	go l.relayUDP(conn, f)
+	return f
+}
+
+// relayUDP returns the backend's replies to the client until the flow has
+// been idle for the listener's idle timeout or the listener closes.
+func (l *listener) relayUDP(conn *net.UDPConn, f *udpFlow) {
+	defer l.untrack(f.upstream)
+	defer func() {
+		l.recordFlows(l.flows.remove(f))
+		_ = f.upstream.Close()
+		l.lb.DecrementConnections(f.backend)
+	}()
+
+	// The time to the first reply is the latency LeastResponseTime
+	// balances on; UDP has no handshake to time.
+	opened := time.Now()
+	replied := false
+
+	buf := make([]byte, maxDatagramLen)
+	for {
+		// Client datagrams only move lastSeen, so the deadline is re-armed
+		// here rather than on every datagram in the hot path.
+		deadline := time.Unix(0, f.lastSeen.Load()).Add(l.idleTimeout())
+		if !time.Now().Before(deadline) {
+			return
+		}
+		_ = f.upstream.SetReadDeadline(deadline)
+		n, err := f.upstream.Read(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				continue
+			}
+			// A port unreachable reply surfaces as a refused read.
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				l.lb.ReportResult(f.backend, false)
+			}
+			return
+		}
+		if !replied {
+			f.backend.ObserveLatency(time.Since(opened))
+			l.lb.ReportResult(f.backend, true)
+			replied = true
+		}
+		f.touch()
+		if _, err := conn.WriteToUDPAddrPort(buf[:n], f.client); err != nil {
+			return
+		}
+	}
+}
+
+// idleTimeout returns how long a flow of this listener may stay silent.
+func (l *listener) idleTimeout() time.Duration {
+	return time.Duration(l.udpIdleTimeout.Load())
+}
+
+// setIdleTimeout applies a frontend's idle timeout; zero selects the default.
+// Running flows pick it up the next time their deadline is re-armed.
+func (l *listener) setIdleTimeout(d time.Duration) {
+	if d <= 0 {
+		d = defaultUDPIdleTimeout
+	}
+	l.udpIdleTimeout.Store(int64(d))
+}
+
+func (l *listener) recordFlows(n int) {
+	if l.metrics != nil {
+		l.metrics.RecordUDPFlows(l.pool, n)
+	}
+}
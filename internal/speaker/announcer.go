@@ -0,0 +1,144 @@
+package speaker
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/go-logr/logr"
+)
+
+// maxFrameLen is large enough for any frame on a standard-MTU link.
+const maxFrameLen = 1514
+
+// Announcer makes the IPs owned by this node reachable on one layer-2
+// segment. IPv4 addresses are served with ARP and IPv6 addresses with NDP: it
+// answers ARP requests and neighbor solicitations for them, and sends a
+// gratuitous ARP or unsolicited neighbor advertisement as soon as an address
+// is taken over, so peers update stale caches right away.
+type Announcer struct {
+	conn   PacketConn
+	logger logr.Logger
+
+	mu    sync.RWMutex
+	owned map[string]net.IP
+}
+
+// NewAnnouncer creates an Announcer that speaks on conn.
+func NewAnnouncer(conn PacketConn, logger logr.Logger) *Announcer {
+	return &Announcer{
+		conn:   conn,
+		logger: logger,
+		owned:  make(map[string]net.IP),
+	}
+}
+
+// SetOwned replaces the set of addresses this node answers for. Addresses
+// that were not owned before are announced immediately.
+func (a *Announcer) SetOwned(ips []net.IP) {
+	next := make(map[string]net.IP, len(ips))
+	for _, ip := range ips {
+		if v4 := ip.To4(); v4 != nil {
+			next[v4.String()] = v4
+		} else if v6 := ip.To16(); v6 != nil {
+			next[v6.String()] = v6
+		}
+	}
+
+	a.mu.Lock()
+	var gained []net.IP
+	for key, ip := range next {
+		if _, ok := a.owned[key]; !ok {
+			gained = append(gained, ip)
+		}
+	}
+	for key := range a.owned {
+		if _, ok := next[key]; !ok {
+			a.logger.Info("stopped announcing IP", "ip", key)
+		}
+	}
+	a.owned = next
+	a.mu.Unlock()
+
+	for _, ip := range gained {
+		a.logger.Info("announcing IP", "ip", ip.String())
+		a.announce(ip)
+	}
+}
+
+// announce tells the segment that ip is now reachable through this node.
+func (a *Announcer) announce(ip net.IP) {
+	frame := unsolicitedNA(a.conn.HardwareAddr(), ip)
+	if ip.To4() != nil {
+		frame = gratuitousARP(a.conn.HardwareAddr(), ip)
+	}
+	if err := a.conn.WriteFrame(frame); err != nil {
+		a.logger.Error(err, "failed to announce IP", "ip", ip.String())
+	}
+}
+
+// Owned returns the addresses currently announced, sorted.
+func (a *Announcer) Owned() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	out := make([]string, 0, len(a.owned))
+	for key := range a.owned {
+		out = append(out, key)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (a *Announcer) owns(ip net.IP) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	_, ok := a.owned[ip.String()]
+	return ok
+}
+
+// Start answers address resolution requests until ctx is done. It implements
+// manager.Runnable.
+func (a *Announcer) Start(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { _ = a.conn.Close() })
+	defer stop()
+
+	buf := make([]byte, maxFrameLen)
+	for {
+		n, err := a.conn.ReadFrame(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		a.handleFrame(buf[:n])
+	}
+}
+
+// NeedLeaderElection reports false: every speaker answers for the addresses
+// its own node was elected for.
+func (a *Announcer) NeedLeaderElection() bool {
+	return false
+}
+
+func (a *Announcer) handleFrame(frame []byte) {
+	if req, ok := parseARPFrame(frame); ok {
+		if req.Operation != arpOpRequest || !a.owns(req.TargetIP) {
+			return
+		}
+		if err := a.conn.WriteFrame(arpReply(a.conn.HardwareAddr(), req)); err != nil {
+			a.logger.Error(err, "failed to send ARP reply", "ip", req.TargetIP.String())
+		}
+		return
+	}
+	if ns, ok := parseNDPFrame(frame); ok {
+		if ns.Type != icmpTypeNeighSol || !a.owns(ns.Target) {
+			return
+		}
+		srcHW := net.HardwareAddr(frame[6:12])
+		if err := a.conn.WriteFrame(solicitedNA(a.conn.HardwareAddr(), ns, srcHW)); err != nil {
+			a.logger.Error(err, "failed to send neighbor advertisement", "ip", ns.Target.String())
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package speaker
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+var (
+	testHW   = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	clientHW = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x99}
+	clientIP = net.ParseIP("192.0.2.1").To4()
+)
+
+// fakeConn is an in-memory PacketConn. Frames pushed with inject are returned
+// by ReadFrame; frames written by the code under test are collected.
+type fakeConn struct {
+	in     chan []byte
+	closed chan struct{}
+	once   sync.Once
+
+	mu      sync.Mutex
+	written [][]byte
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{in: make(chan []byte, 16), closed: make(chan struct{})}
+}
+
+func (c *fakeConn) inject(frame []byte) { c.in <- frame }
+
+func (c *fakeConn) ReadFrame(b []byte) (int, error) {
+	select {
+	case frame := <-c.in:
+		return copy(b, frame), nil
+	case <-c.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (c *fakeConn) WriteFrame(b []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.written = append(c.written, append([]byte(nil), b...))
+	return nil
+}
+
+func (c *fakeConn) HardwareAddr() net.HardwareAddr { return testHW }
+
+func (c *fakeConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *fakeConn) frames() [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([][]byte(nil), c.written...)
+}
+
+// waitForFrames polls until n frames were written or the deadline passes.
+func (c *fakeConn) waitForFrames(t *testing.T, n int) [][]byte {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if got := c.frames(); len(got) >= n {
+			return got
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected %d written frames, got %d", n, len(c.frames()))
+	return nil
+}
+
+func arpRequestFor(target net.IP) []byte {
+	return marshalARPFrame(ethernetBroadcast, arpPacket{
+		Operation: arpOpRequest,
+		SenderHW:  clientHW,
+		SenderIP:  clientIP,
+		TargetHW:  net.HardwareAddr{0, 0, 0, 0, 0, 0},
+		TargetIP:  target,
+	})
+}
+
+func TestARPFrame_RoundTrip(t *testing.T) {
+	frame := arpRequestFor(net.ParseIP("192.0.2.10"))
+	if len(frame) != ethernetMinFrameLen {
+		t.Errorf("frame length = %d, want padding to %d", len(frame), ethernetMinFrameLen)
+	}
+	p, ok := parseARPFrame(frame)
+	if !ok {
+		t.Fatal("parseARPFrame() rejected a frame it built")
+	}
+	if p.Operation != arpOpRequest || !p.TargetIP.Equal(net.ParseIP("192.0.2.10")) ||
+		!p.SenderIP.Equal(clientIP) || p.SenderHW.String() != clientHW.String() {
+		t.Errorf("parseARPFrame() = %+v", p)
+	}
+}
+
+func TestParseARPFrame_RejectsOtherFrames(t *testing.T) {
+	if _, ok := parseARPFrame(make([]byte, 10)); ok {
+		t.Error("accepted a truncated frame")
+	}
+	ipv4 := marshalEthernet(ethernetBroadcast, clientHW, etherTypeIPv4, make([]byte, arpPacketLen))
+	if _, ok := parseARPFrame(ipv4); ok {
+		t.Error("accepted a non-ARP EtherType")
+	}
+}
+
+func TestAnnouncer_SetOwnedSendsGratuitousARPOnce(t *testing.T) {
+	conn := newFakeConn()
+	a := NewAnnouncer(conn, logr.Discard())
+
+	ip := net.ParseIP("192.0.2.10")
+	a.SetOwned([]net.IP{ip})
+	a.SetOwned([]net.IP{ip}) // unchanged ownership is not re-announced
+
+	frames := conn.frames()
+	if len(frames) != 1 {
+		t.Fatalf("wrote %d frames, want 1 gratuitous ARP", len(frames))
+	}
+	p, ok := parseARPFrame(frames[0])
+	if !ok {
+		t.Fatal("gratuitous frame is not ARP")
+	}
+	if p.Operation != arpOpReply || !p.SenderIP.Equal(ip) || !p.TargetIP.Equal(ip) ||
+		p.SenderHW.String() != testHW.String() {
+		t.Errorf("gratuitous ARP = %+v", p)
+	}
+	if got := a.Owned(); len(got) != 1 || got[0] != "192.0.2.10" {
+		t.Errorf("Owned() = %v", got)
+	}
+}
+
+func TestAnnouncer_SetOwnedSendsUnsolicitedNAForIPv6(t *testing.T) {
+	conn := newFakeConn()
+	a := NewAnnouncer(conn, logr.Discard())
+	ip := net.ParseIP("fd00::10")
+	a.SetOwned([]net.IP{ip})
+
+	frames := conn.frames()
+	if len(frames) != 1 {
+		t.Fatalf("wrote %d frames, want 1 unsolicited advertisement", len(frames))
+	}
+	na, ok := parseNDPFrame(frames[0])
+	if !ok {
+		t.Fatal("announcement is not a valid NDP message")
+	}
+	if na.Type != icmpTypeNeighAdv || na.Flags != ndpFlagOverride || !na.Target.Equal(ip) ||
+		!na.DestIP.Equal(allNodesMulticast) || na.LinkAddr.String() != testHW.String() {
+		t.Errorf("unsolicited NA = %+v", na)
+	}
+	if dst := net.HardwareAddr(frames[0][0:6]); dst.String() != "33:33:00:00:00:01" {
+		t.Errorf("sent to %s, want the all-nodes group address", dst)
+	}
+}
+
+func TestAnnouncer_AnswersRequestsForOwnedIPsOnly(t *testing.T) {
+	conn := newFakeConn()
+	a := NewAnnouncer(conn, logr.Discard())
+	owned := net.ParseIP("192.0.2.10")
+	a.SetOwned([]net.IP{owned})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- a.Start(ctx) }()
+
+	conn.inject(arpRequestFor(net.ParseIP("192.0.2.20"))) // not ours
+	conn.inject(arpRequestFor(owned))
+
+	frames := conn.waitForFrames(t, 2) // gratuitous + one reply
+	reply, ok := parseARPFrame(frames[1])
+	if !ok {
+		t.Fatal("reply is not ARP")
+	}
+	if reply.Operation != arpOpReply || !reply.SenderIP.Equal(owned) ||
+		reply.SenderHW.String() != testHW.String() || !reply.TargetIP.Equal(clientIP) {
+		t.Errorf("reply = %+v", reply)
+	}
+	if dst := net.HardwareAddr(frames[1][0:6]); dst.String() != clientHW.String() {
+		t.Errorf("reply sent to %s, want unicast to the requester %s", dst, clientHW)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Start() = %v, want nil after cancel", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Start() did not return after cancel")
+	}
+	if n := len(conn.frames()); n != 2 {
+		t.Errorf("wrote %d frames, want no reply for an address we do not own", n)
+	}
+}
+
+func TestAnnouncer_StopsAnsweringReleasedIPs(t *testing.T) {
+	conn := newFakeConn()
+	a := NewAnnouncer(conn, logr.Discard())
+	ip := net.ParseIP("192.0.2.10")
+	a.SetOwned([]net.IP{ip})
+	a.SetOwned(nil)
+
+	a.handleFrame(arpRequestFor(ip))
+	if n := len(conn.frames()); n != 1 {
+		t.Errorf("wrote %d frames, want only the original gratuitous ARP", n)
+	}
+}
@@ -0,0 +1,112 @@
+package speaker
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// Ethernet and ARP wire constants (RFC 826).
+const (
+	ethernetHeaderLen = 14
+	// ethernetMinFrameLen is the minimum frame size without the FCS. Raw
+	// sockets do not pad short frames on every driver, so we pad ourselves.
+	ethernetMinFrameLen = 60
+
+	etherTypeIPv4 = 0x0800
+	etherTypeARP  = 0x0806
+
+	arpPacketLen     = 28
+	arpHardwareEther = 1
+	arpOpRequest     = 1
+	arpOpReply       = 2
+	ethernetAddrLen  = 6
+	ipv4AddrLen      = 4
+)
+
+var ethernetBroadcast = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// arpPacket is an ARP message for IPv4 over Ethernet.
+type arpPacket struct {
+	Operation uint16
+	SenderHW  net.HardwareAddr
+	SenderIP  net.IP
+	TargetHW  net.HardwareAddr
+	TargetIP  net.IP
+}
+
+// marshalEthernet builds a frame with the given header and payload, padded to
+// the Ethernet minimum.
+func marshalEthernet(dst, src net.HardwareAddr, etherType uint16, payload []byte) []byte {
+	size := ethernetHeaderLen + len(payload)
+	if size < ethernetMinFrameLen {
+		size = ethernetMinFrameLen
+	}
+	frame := make([]byte, size)
+	copy(frame[0:6], dst)
+	copy(frame[6:12], src)
+	binary.BigEndian.PutUint16(frame[12:14], etherType)
+	copy(frame[ethernetHeaderLen:], payload)
+	return frame
+}
+
+// marshalARPFrame encodes p in an Ethernet frame addressed to dst.
+func marshalARPFrame(dst net.HardwareAddr, p arpPacket) []byte {
+	payload := make([]byte, arpPacketLen)
+	binary.BigEndian.PutUint16(payload[0:2], arpHardwareEther)
+	binary.BigEndian.PutUint16(payload[2:4], etherTypeIPv4)
+	payload[4] = ethernetAddrLen
+	payload[5] = ipv4AddrLen
+	binary.BigEndian.PutUint16(payload[6:8], p.Operation)
+	copy(payload[8:14], p.SenderHW)
+	copy(payload[14:18], p.SenderIP.To4())
+	copy(payload[18:24], p.TargetHW)
+	copy(payload[24:28], p.TargetIP.To4())
+	return marshalEthernet(dst, p.SenderHW, etherTypeARP, payload)
+}
+
+// parseARPFrame decodes an Ethernet frame carrying an IPv4 ARP message. The
+// second result is false for anything else.
+func parseARPFrame(frame []byte) (arpPacket, bool) {
+	if len(frame) < ethernetHeaderLen+arpPacketLen {
+		return arpPacket{}, false
+	}
+	if binary.BigEndian.Uint16(frame[12:14]) != etherTypeARP {
+		return arpPacket{}, false
+	}
+	payload := frame[ethernetHeaderLen:]
+	if binary.BigEndian.Uint16(payload[0:2]) != arpHardwareEther ||
+		binary.BigEndian.Uint16(payload[2:4]) != etherTypeIPv4 ||
+		payload[4] != ethernetAddrLen || payload[5] != ipv4AddrLen {
+		return arpPacket{}, false
+	}
+	return arpPacket{
+		Operation: binary.BigEndian.Uint16(payload[6:8]),
+		SenderHW:  net.HardwareAddr(append([]byte(nil), payload[8:14]...)),
+		SenderIP:  net.IP(append([]byte(nil), payload[14:18]...)),
+		TargetHW:  net.HardwareAddr(append([]byte(nil), payload[18:24]...)),
+		TargetIP:  net.IP(append([]byte(nil), payload[24:28]...)),
+	}, true
+}
+
+// gratuitousARP builds the broadcast reply that tells every host on the
+// segment that ip now lives at hw.
+func gratuitousARP(hw net.HardwareAddr, ip net.IP) []byte {
+	return marshalARPFrame(ethernetBroadcast, arpPacket{
+		Operation: arpOpReply,
+		SenderHW:  hw,
+		SenderIP:  ip,
+		TargetHW:  ethernetBroadcast,
+		TargetIP:  ip,
+	})
+}
+
+// arpReply builds the unicast answer to req, claiming req.TargetIP for hw.
+func arpReply(hw net.HardwareAddr, req arpPacket) []byte {
+	return marshalARPFrame(req.SenderHW, arpPacket{
+		Operation: arpOpReply,
+		SenderHW:  hw,
+		SenderIP:  req.TargetIP,
+		TargetHW:  req.SenderHW,
+		TargetIP:  req.SenderIP,
+	})
+}
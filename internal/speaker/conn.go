@@ -0,0 +1,24 @@
+package speaker
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrUnsupported is returned by ListenPacket on platforms without raw
+// link-layer sockets.
+var ErrUnsupported = errors.New("link-layer sockets are not supported on this platform")
+
+// PacketConn reads and writes whole Ethernet frames on a single interface.
+// The production implementation is a Linux AF_PACKET socket; tests substitute
+// an in-memory fake.
+type PacketConn interface {
+	// ReadFrame reads the next frame into b and returns its length.
+	ReadFrame(b []byte) (int, error)
+	// WriteFrame writes a complete frame, Ethernet header included.
+	WriteFrame(b []byte) error
+	// HardwareAddr returns the MAC address of the interface.
+	HardwareAddr() net.HardwareAddr
+	// Close releases the socket and unblocks a pending ReadFrame.
+	Close() error
+}
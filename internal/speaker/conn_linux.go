@@ -0,0 +1,91 @@
+//go:build linux
+
+package speaker
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// packetConn is an AF_PACKET socket bound to one interface. The socket is
+// non-blocking and wrapped in an *os.File so reads park on the runtime poller
+// and Close reliably unblocks them.
+type packetConn struct {
+	file    *os.File
+	ifindex int
+	hwAddr  net.HardwareAddr
+}
+
+// ListenPacket opens a raw link-layer socket on the named interface. It needs
+// CAP_NET_RAW.
+func ListenPacket(ifaceName string) (PacketConn, error) {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return nil, fmt.Errorf("lookup interface %q: %w", ifaceName, err)
+	}
+	if len(iface.HardwareAddr) != 6 {
+		return nil, fmt.Errorf("interface %q has no Ethernet address", ifaceName)
+	}
+
+	proto := htons(unix.ETH_P_ALL)
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, int(proto))
+	if err != nil {
+		return nil, fmt.Errorf("open packet socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: proto, Ifindex: iface.Index}); err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("bind packet socket to %q: %w", ifaceName, err)
+	}
+	// Neighbor solicitations are sent to solicited-node multicast groups the
+	// kernel has not joined for us; receive all multicast on the interface.
+	mreq := &unix.PacketMreq{Ifindex: int32(iface.Index), Type: unix.PACKET_MR_ALLMULTI}
+	if err := unix.SetsockoptPacketMreq(fd, unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, mreq); err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("enable all-multicast on %q: %w", ifaceName, err)
+	}
+
+	return &packetConn{
+		file:    os.NewFile(uintptr(fd), "packet:"+ifaceName),
+		ifindex: iface.Index,
+		hwAddr:  iface.HardwareAddr,
+	}, nil
+}
+
+func (c *packetConn) ReadFrame(b []byte) (int, error) {
+	return c.file.Read(b)
+}
+
+func (c *packetConn) WriteFrame(b []byte) error {
+	rc, err := c.file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	to := &unix.SockaddrLinklayer{Ifindex: c.ifindex, Halen: 6}
+	copy(to.Addr[:], b[:6])
+
+	var sendErr error
+	err = rc.Write(func(fd uintptr) bool {
+		sendErr = unix.Sendto(int(fd), b, 0, to)
+		return sendErr != unix.EAGAIN
+	})
+	if err != nil {
+		return err
+	}
+	return sendErr
+}
+
+func (c *packetConn) HardwareAddr() net.HardwareAddr {
+	return c.hwAddr
+}
+
+func (c *packetConn) Close() error {
+	return c.file.Close()
+}
+
+// htons converts a short from host to network byte order.
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package speaker
+
+// ListenPacket is only implemented on Linux.
+func ListenPacket(_ string) (PacketConn, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,25 @@
+package speaker
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// ElectNode picks the node that announces ip out of the candidate nodes.
+//
+// Every speaker runs the same computation over the same inputs, so they all
+// agree on a single owner without talking to each other. Hashing the node name
+// together with the address spreads addresses across nodes, and losing a node
+// only moves the addresses it owned. Returns "" when there are no candidates.
+func ElectNode(ip string, nodes []string) string {
+	var owner string
+	var ownerSum [sha256.Size]byte
+	for _, node := range nodes {
+		sum := sha256.Sum256([]byte(node + "#" + ip))
+		if owner == "" || bytes.Compare(sum[:], ownerSum[:]) < 0 {
+			owner = node
+			ownerSum = sum
+		}
+	}
+	return owner
+}
@@ -0,0 +1,49 @@
+package speaker
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestElectNode_NoCandidates(t *testing.T) {
+	if got := ElectNode("192.0.2.10", nil); got != "" {
+		t.Errorf("ElectNode() = %q, want empty with no candidates", got)
+	}
+}
+
+func TestElectNode_IndependentOfCandidateOrder(t *testing.T) {
+	a := ElectNode("192.0.2.10", []string{"node-a", "node-b", "node-c"})
+	b := ElectNode("192.0.2.10", []string{"node-c", "node-a", "node-b"})
+	if a != b {
+		t.Errorf("ElectNode() = %q and %q for the same set in a different order", a, b)
+	}
+}
+
+// Removing a node must only move the addresses that node owned; every other
+// address keeps its owner so peers' ARP caches stay valid.
+func TestElectNode_RemovingANodeOnlyMovesItsAddresses(t *testing.T) {
+	all := []string{"node-a", "node-b", "node-c"}
+	remaining := []string{"node-a", "node-c"}
+
+	for i := 1; i <= 200; i++ {
+		ip := fmt.Sprintf("10.0.%d.%d", i/250, i%250)
+		before := ElectNode(ip, all)
+		after := ElectNode(ip, remaining)
+		if before != "node-b" && before != after {
+			t.Fatalf("%s moved from %s to %s although its owner is still present", ip, before, after)
+		}
+	}
+}
+
+func TestElectNode_SpreadsAddresses(t *testing.T) {
+	nodes := []string{"node-a", "node-b", "node-c"}
+	counts := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		counts[ElectNode(fmt.Sprintf("10.1.%d.%d", i/250, i%250), nodes)]++
+	}
+	for _, node := range nodes {
+		if counts[node] == 0 {
+			t.Errorf("node %s owns no addresses out of 300: %v", node, counts)
+		}
+	}
+}
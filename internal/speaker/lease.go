@@ -0,0 +1,182 @@
+package speaker
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+const (
+	// speakerLeasePrefix names the lease each speaker holds for its node.
+	speakerLeasePrefix = "helios-speaker-"
+	// speakerLeaseDuration is how long a lease stays fresh after a renewal.
+	// A speaker that stops renewing, because it crashed or its node lost the
+	// API server, drops out of the election once it has passed.
+	speakerLeaseDuration = 15 * time.Second
+	// speakerLeaseRenewInterval is how often a running speaker renews.
+	speakerLeaseRenewInterval = 5 * time.Second
+)
+
+// speakerLeaseName returns the name of the lease the speaker on node holds.
+func speakerLeaseName(node string) string {
+	return speakerLeasePrefix + node
+}
+
+// Heartbeat holds the lease of the speaker on this node while the speaker
+// runs, so the other speakers only elect nodes where a speaker answers. The
+// lease is deleted on shutdown; a speaker that dies without deleting it drops
+// out when the lease expires.
+type Heartbeat struct {
+	Client client.Client
+	// Reader reads the lease back. It defaults to the Client; main wires an
+	// uncached reader so a renewal never starts from a stale copy.
+	Reader    client.Reader
+	Namespace string
+	NodeName  string
+	Logger    logr.Logger
+}
+
+// Start renews the lease until ctx is done. It implements manager.Runnable.
+func (h *Heartbeat) Start(ctx context.Context) error {
+	ticker := time.NewTicker(speakerLeaseRenewInterval)
+	defer ticker.Stop()
+	for {
+		if err := h.renew(ctx); err != nil && ctx.Err() == nil {
+			h.Logger.Error(err, "failed to renew speaker lease")
+		}
+		select {
+		case <-ctx.Done():
+			h.release()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection reports false: every speaker holds its own lease.
+func (h *Heartbeat) NeedLeaderElection() bool {
+	return false
+}
+
+// renew creates the lease, or marks it renewed now.
+func (h *Heartbeat) renew(ctx context.Context) error {
+	now := metav1.NewMicroTime(time.Now())
+	reader := h.Reader
+	if reader == nil {
+		reader = h.Client
+	}
+	var lease coordinationv1.Lease
+	err := reader.Get(ctx, types.NamespacedName{Namespace: h.Namespace, Name: speakerLeaseName(h.NodeName)}, &lease)
+	if apierrors.IsNotFound(err) {
+		lease = coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Namespace: h.Namespace, Name: speakerLeaseName(h.NodeName)},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       ptr.To(h.NodeName),
+				LeaseDurationSeconds: ptr.To(int32(speakerLeaseDuration / time.Second)),
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		return h.Client.Create(ctx, &lease)
+	}
+	if err != nil {
+		return err
+	}
+	if !leaseFresh(&lease, now.Time) {
+		lease.Spec.AcquireTime = &now
+	}
+	lease.Spec.HolderIdentity = ptr.To(h.NodeName)
+	lease.Spec.LeaseDurationSeconds = ptr.To(int32(speakerLeaseDuration / time.Second))
+	lease.Spec.RenewTime = &now
+	return h.Client.Update(ctx, &lease)
+}
+
+// release deletes the lease so this node leaves the election at once rather
+// than when the lease expires.
+func (h *Heartbeat) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), speakerLeaseRenewInterval)
+	defer cancel()
+	lease := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Namespace: h.Namespace, Name: speakerLeaseName(h.NodeName)}}
+	if err := h.Client.Delete(ctx, lease); err != nil && !apierrors.IsNotFound(err) {
+		h.Logger.Error(err, "failed to release speaker lease")
+	}
+}
+
+// leaseExpiry returns when a lease stops being fresh, or the zero time if it
+// was never renewed.
+func leaseExpiry(lease *coordinationv1.Lease) time.Time {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return time.Time{}
+	}
+	return lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+}
+
+func leaseFresh(lease *coordinationv1.Lease, now time.Time) bool {
+	return leaseExpiry(lease).After(now)
+}
+
+// speakerNodes returns the sorted names of the nodes that may announce
+// addresses: Ready nodes whose speaker holds a fresh lease. A node that is not
+// Ready, or where no speaker runs because of a node selector or a crash,
+// cannot be relied on to answer, so it is left out of the election and its
+// addresses move to a node that can. It also returns when the first of those
+// leases expires, so the election is rerun then even if no event arrives.
+func speakerNodes(nodes []corev1.Node, leases []coordinationv1.Lease, now time.Time) ([]string, time.Time) {
+	speakers := make(map[string]time.Time)
+	for i := range leases {
+		lease := &leases[i]
+		if !strings.HasPrefix(lease.Name, speakerLeasePrefix) || lease.Spec.HolderIdentity == nil {
+			continue
+		}
+		if expiry := leaseExpiry(lease); expiry.After(now) {
+			speakers[*lease.Spec.HolderIdentity] = expiry
+		}
+	}
+
+	var names []string
+	var next time.Time
+	for i := range nodes {
+		expiry, ok := speakers[nodes[i].Name]
+		if !ok || !nodeReady(&nodes[i]) {
+			continue
+		}
+		names = append(names, nodes[i].Name)
+		if next.IsZero() || expiry.Before(next) {
+			next = expiry
+		}
+	}
+	sort.Strings(names)
+	return names, next
+}
+
+// speakerLeaseResumed filters out the renewals of a fresh lease, which do not
+// change the election. A renewal of a lease that had already expired brings
+// its node back and passes.
+func speakerLeaseResumed() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldLease, ok := e.ObjectOld.(*coordinationv1.Lease)
+			if !ok {
+				return false
+			}
+			newLease, ok := e.ObjectNew.(*coordinationv1.Lease)
+			if !ok || newLease.Spec.RenewTime == nil {
+				return false
+			}
+			return !leaseFresh(oldLease, newLease.Spec.RenewTime.Time) ||
+				!ptr.Equal(oldLease.Spec.HolderIdentity, newLease.Spec.HolderIdentity)
+		},
+	}
+}
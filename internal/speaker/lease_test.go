@@ -0,0 +1,89 @@
+package speaker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestSpeakerNodes(t *testing.T) {
+	now := time.Now()
+	nodes := []corev1.Node{
+		*testNode("node-c", true), *testNode("node-a", true), *testNode("node-b", true),
+		*testNode("node-d", false), *testNode("node-e", true),
+	}
+	leases := []coordinationv1.Lease{
+		*testLease("node-a", now.Add(-10*time.Second)),
+		*testLease("node-c", now),
+		*testLease("node-d", now),
+		// Expired: the speaker on node-e stopped renewing.
+		*testLease("node-e", now.Add(-time.Minute)),
+		// node-b runs no speaker, e.g. it is left out by a node selector.
+	}
+
+	names, next := speakerNodes(nodes, leases, now)
+	if want := []string{"node-a", "node-c"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("speakerNodes() = %v, want %v", names, want)
+	}
+	if want := now.Add(5 * time.Second); !next.Equal(want) {
+		t.Errorf("next expiry = %v, want %v from node-a's lease", next, want)
+	}
+
+	if names, next := speakerNodes(nodes, nil, now); len(names) != 0 || !next.IsZero() {
+		t.Errorf("speakerNodes() without leases = %v, %v, want none", names, next)
+	}
+}
+
+func TestHeartbeat_RenewsAndReleasesLease(t *testing.T) {
+	cl := fake.NewClientBuilder().WithScheme(newTestScheme()).Build()
+	h := &Heartbeat{Client: cl, Namespace: testNamespace, NodeName: "node-a", Logger: logr.Discard()}
+	key := types.NamespacedName{Namespace: testNamespace, Name: speakerLeaseName("node-a")}
+
+	if err := h.renew(context.Background()); err != nil {
+		t.Fatalf("renew() error = %v", err)
+	}
+	var lease coordinationv1.Lease
+	if err := cl.Get(context.Background(), key, &lease); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if *lease.Spec.HolderIdentity != "node-a" || !leaseFresh(&lease, time.Now()) {
+		t.Errorf("lease = %+v, want a fresh lease held by node-a", lease.Spec)
+	}
+	first := lease.Spec.RenewTime.Time
+
+	time.Sleep(time.Millisecond)
+	if err := h.renew(context.Background()); err != nil {
+		t.Fatalf("renew() error = %v", err)
+	}
+	if err := cl.Get(context.Background(), key, &lease); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if !lease.Spec.RenewTime.After(first) {
+		t.Errorf("renew time = %v, want it moved past %v", lease.Spec.RenewTime, first)
+	}
+
+	h.release()
+	if err := cl.Get(context.Background(), key, &lease); !apierrors.IsNotFound(err) {
+		t.Errorf("Get() after release error = %v, want not found", err)
+	}
+}
+
+func TestSpeakerLeaseResumed(t *testing.T) {
+	p := speakerLeaseResumed()
+	now := time.Now()
+	if p.Update(event.UpdateEvent{ObjectOld: testLease("n", now.Add(-5*time.Second)), ObjectNew: testLease("n", now)}) {
+		t.Error("renewal of a fresh lease should be filtered")
+	}
+	if !p.Update(event.UpdateEvent{ObjectOld: testLease("n", now.Add(-time.Minute)), ObjectNew: testLease("n", now)}) {
+		t.Error("renewal of an expired lease should pass")
+	}
+}
@@ -0,0 +1,184 @@
+package speaker
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// IPv6 and NDP wire constants (RFC 8200, RFC 4443, RFC 4861).
+const (
+	etherTypeIPv6 = 0x86dd
+
+	ipv6HeaderLen    = 40
+	ipv6NextICMP     = 58
+	ndpHopLimit      = 255
+	ndpMessageLen    = 24 // ICMPv6 header, flags/reserved and target address
+	ndpLLAOptionLen  = 8
+	icmpTypeNeighSol = 135
+	icmpTypeNeighAdv = 136
+
+	ndpOptSourceLLA = 1
+	ndpOptTargetLLA = 2
+
+	ndpFlagSolicited = 0x40
+	ndpFlagOverride  = 0x20
+)
+
+// allNodesMulticast is ff02::1, where unsolicited advertisements are sent.
+var allNodesMulticast = net.ParseIP("ff02::1")
+
+// ndpMessage is a neighbor solicitation or advertisement.
+type ndpMessage struct {
+	Type     uint8
+	Flags    uint8
+	SourceIP net.IP
+	DestIP   net.IP
+	Target   net.IP
+	// LinkAddr is the source (NS) or target (NA) link-layer address option;
+	// nil when the message carries none.
+	LinkAddr net.HardwareAddr
+}
+
+// multicastMAC maps an IPv6 multicast address to its Ethernet group address
+// (RFC 2464 section 7).
+func multicastMAC(ip net.IP) net.HardwareAddr {
+	ip16 := ip.To16()
+	return net.HardwareAddr{0x33, 0x33, ip16[12], ip16[13], ip16[14], ip16[15]}
+}
+
+// marshalNDPFrame encodes m as an ICMPv6 packet in an Ethernet frame from src
+// to dst.
+func marshalNDPFrame(dst, src net.HardwareAddr, m ndpMessage) []byte {
+	body := make([]byte, ndpMessageLen, ndpMessageLen+ndpLLAOptionLen)
+	body[0] = m.Type
+	body[4] = m.Flags
+	copy(body[8:24], m.Target.To16())
+	if m.LinkAddr != nil {
+		opt := ndpOptTargetLLA
+		if m.Type == icmpTypeNeighSol {
+			opt = ndpOptSourceLLA
+		}
+		body = append(body, byte(opt), 1)
+		body = append(body, m.LinkAddr...)
+	}
+	binary.BigEndian.PutUint16(body[2:4], icmpv6Checksum(m.SourceIP, m.DestIP, body))
+
+	packet := make([]byte, ipv6HeaderLen+len(body))
+	packet[0] = 6 << 4
+	binary.BigEndian.PutUint16(packet[4:6], uint16(len(body)))
+	packet[6] = ipv6NextICMP
+	packet[7] = ndpHopLimit
+	copy(packet[8:24], m.SourceIP.To16())
+	copy(packet[24:40], m.DestIP.To16())
+	copy(packet[ipv6HeaderLen:], body)
+	return marshalEthernet(dst, src, etherTypeIPv6, packet)
+}
+
+// parseNDPFrame decodes an Ethernet frame carrying a neighbor solicitation or
+// advertisement. Messages that fail the RFC 4861 validity checks a receiver
+// must apply (hop limit 255, code 0, valid checksum) are rejected.
+func parseNDPFrame(frame []byte) (ndpMessage, bool) {
+	if len(frame) < ethernetHeaderLen+ipv6HeaderLen+ndpMessageLen {
+		return ndpMessage{}, false
+	}
+	if binary.BigEndian.Uint16(frame[12:14]) != etherTypeIPv6 {
+		return ndpMessage{}, false
+	}
+	packet := frame[ethernetHeaderLen:]
+	if packet[0]>>4 != 6 || packet[6] != ipv6NextICMP || packet[7] != ndpHopLimit {
+		return ndpMessage{}, false
+	}
+	payloadLen := int(binary.BigEndian.Uint16(packet[4:6]))
+	if payloadLen < ndpMessageLen || ipv6HeaderLen+payloadLen > len(packet) {
+		return ndpMessage{}, false
+	}
+	src := net.IP(append([]byte(nil), packet[8:24]...))
+	dst := net.IP(append([]byte(nil), packet[24:40]...))
+	body := packet[ipv6HeaderLen : ipv6HeaderLen+payloadLen]
+	if body[0] != icmpTypeNeighSol && body[0] != icmpTypeNeighAdv || body[1] != 0 {
+		return ndpMessage{}, false
+	}
+	if icmpv6Checksum(src, dst, body) != 0 {
+		return ndpMessage{}, false
+	}
+
+	m := ndpMessage{
+		Type:     body[0],
+		Flags:    body[4],
+		SourceIP: src,
+		DestIP:   dst,
+		Target:   net.IP(append([]byte(nil), body[8:24]...)),
+	}
+	for opts := body[ndpMessageLen:]; len(opts) >= 2; {
+		optLen := int(opts[1]) * 8
+		if optLen == 0 || optLen > len(opts) {
+			return ndpMessage{}, false
+		}
+		if (opts[0] == ndpOptSourceLLA || opts[0] == ndpOptTargetLLA) && optLen >= 8 {
+			m.LinkAddr = net.HardwareAddr(append([]byte(nil), opts[2:8]...))
+		}
+		opts = opts[optLen:]
+	}
+	return m, true
+}
+
+// icmpv6Checksum computes the ICMPv6 checksum of body over the IPv6
+// pseudo-header. Run over a body whose checksum field is filled in, it
+// returns 0 for a valid message.
+func icmpv6Checksum(src, dst net.IP, body []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(src.To16())
+	add(dst.To16())
+	var lenNext [8]byte
+	binary.BigEndian.PutUint32(lenNext[0:4], uint32(len(body)))
+	lenNext[7] = ipv6NextICMP
+	add(lenNext[:])
+	add(body)
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// unsolicitedNA builds the advertisement sent to all nodes when this node
+// takes over ip, overriding any cached link-layer address.
+func unsolicitedNA(hw net.HardwareAddr, ip net.IP) []byte {
+	return marshalNDPFrame(multicastMAC(allNodesMulticast), hw, ndpMessage{
+		Type:     icmpTypeNeighAdv,
+		Flags:    ndpFlagOverride,
+		SourceIP: ip,
+		DestIP:   allNodesMulticast,
+		Target:   ip,
+		LinkAddr: hw,
+	})
+}
+
+// solicitedNA builds the answer to the neighbor solicitation ns. A
+// solicitation from the unspecified address (duplicate address detection) is
+// answered to all nodes without the solicited flag, as RFC 4861 section 7.2.4
+// requires.
+func solicitedNA(hw net.HardwareAddr, ns ndpMessage, srcHW net.HardwareAddr) []byte {
+	if ns.SourceIP.IsUnspecified() {
+		return unsolicitedNA(hw, ns.Target)
+	}
+	dstHW := ns.LinkAddr
+	if dstHW == nil {
+		dstHW = srcHW
+	}
+	return marshalNDPFrame(dstHW, hw, ndpMessage{
+		Type:     icmpTypeNeighAdv,
+		Flags:    ndpFlagSolicited | ndpFlagOverride,
+		SourceIP: ns.Target,
+		DestIP:   ns.SourceIP,
+		Target:   ns.Target,
+		LinkAddr: hw,
+	})
+}
@@ -0,0 +1,115 @@
+package speaker
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+var clientIPv6 = net.ParseIP("fd00::99")
+
+func neighborSolicitation(src, target net.IP) []byte {
+	dst := solicitedNodeMulticast(target)
+	ns := ndpMessage{Type: icmpTypeNeighSol, SourceIP: src, DestIP: dst, Target: target}
+	if !src.IsUnspecified() {
+		ns.LinkAddr = clientHW
+	}
+	return marshalNDPFrame(multicastMAC(dst), clientHW, ns)
+}
+
+// solicitedNodeMulticast returns ff02::1:ffXX:XXXX for ip.
+func solicitedNodeMulticast(ip net.IP) net.IP {
+	group := net.ParseIP("ff02::1:ff00:0")
+	copy(group[13:], ip.To16()[13:])
+	return group
+}
+
+func TestNDPFrame_RoundTrip(t *testing.T) {
+	target := net.ParseIP("fd00::10")
+	m, ok := parseNDPFrame(neighborSolicitation(clientIPv6, target))
+	if !ok {
+		t.Fatal("parseNDPFrame() rejected a frame it built")
+	}
+	if m.Type != icmpTypeNeighSol || !m.Target.Equal(target) || !m.SourceIP.Equal(clientIPv6) ||
+		m.LinkAddr.String() != clientHW.String() {
+		t.Errorf("parseNDPFrame() = %+v", m)
+	}
+}
+
+func TestParseNDPFrame_RejectsInvalidMessages(t *testing.T) {
+	valid := neighborSolicitation(clientIPv6, net.ParseIP("fd00::10"))
+
+	badChecksum := append([]byte(nil), valid...)
+	badChecksum[ethernetHeaderLen+ipv6HeaderLen+2] ^= 0xff
+	if _, ok := parseNDPFrame(badChecksum); ok {
+		t.Error("accepted a message with a bad checksum")
+	}
+
+	forwarded := append([]byte(nil), valid...)
+	forwarded[ethernetHeaderLen+7] = 64 // hop limit must be 255
+	if _, ok := parseNDPFrame(forwarded); ok {
+		t.Error("accepted a message that crossed a router")
+	}
+
+	if _, ok := parseNDPFrame(arpRequestFor(net.ParseIP("192.0.2.10"))); ok {
+		t.Error("accepted an ARP frame")
+	}
+}
+
+func TestAnnouncer_AnswersNeighborSolicitations(t *testing.T) {
+	conn := newFakeConn()
+	a := NewAnnouncer(conn, logr.Discard())
+	owned := net.ParseIP("fd00::10")
+	a.SetOwned([]net.IP{owned})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = a.Start(ctx) }()
+
+	conn.inject(neighborSolicitation(clientIPv6, net.ParseIP("fd00::20"))) // not ours
+	conn.inject(neighborSolicitation(clientIPv6, owned))
+
+	frames := conn.waitForFrames(t, 2) // unsolicited NA + one solicited NA
+	na, ok := parseNDPFrame(frames[1])
+	if !ok {
+		t.Fatal("answer is not a valid NDP message")
+	}
+	if na.Type != icmpTypeNeighAdv || na.Flags != ndpFlagSolicited|ndpFlagOverride ||
+		!na.Target.Equal(owned) || !na.DestIP.Equal(clientIPv6) || na.LinkAddr.String() != testHW.String() {
+		t.Errorf("solicited NA = %+v", na)
+	}
+	if dst := net.HardwareAddr(frames[1][0:6]); dst.String() != clientHW.String() {
+		t.Errorf("answer sent to %s, want unicast to the solicitor %s", dst, clientHW)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if n := len(conn.frames()); n != 2 {
+		t.Errorf("wrote %d frames, want no answer for an address we do not own", n)
+	}
+}
+
+// Duplicate address detection probes come from :: and must be answered to all
+// nodes, since the prober has no address to reply to yet.
+func TestAnnouncer_AnswersDADProbesToAllNodes(t *testing.T) {
+	conn := newFakeConn()
+	a := NewAnnouncer(conn, logr.Discard())
+	owned := net.ParseIP("fd00::10")
+	a.SetOwned([]net.IP{owned})
+
+	a.handleFrame(neighborSolicitation(net.IPv6unspecified, owned))
+
+	frames := conn.frames()
+	if len(frames) != 2 {
+		t.Fatalf("wrote %d frames, want an answer to the probe", len(frames))
+	}
+	na, ok := parseNDPFrame(frames[1])
+	if !ok {
+		t.Fatal("answer is not a valid NDP message")
+	}
+	if !na.DestIP.Equal(allNodesMulticast) || na.Flags&ndpFlagSolicited != 0 {
+		t.Errorf("DAD answer = %+v, want an unsolicited advertisement to all nodes", na)
+	}
+}
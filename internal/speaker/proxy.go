@@ -0,0 +1,511 @@
+package speaker
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	balancerv1 "github.com/somaz94/helios-lb/api/v1"
+	"github.com/somaz94/helios-lb/internal/loadbalancer"
+	"github.com/somaz94/helios-lb/internal/proxy"
+)
+
+// DataPlane is the part of proxy.Proxy the ProxySyncer drives.
+type DataPlane interface {
+	Sync(groups []proxy.Group) error
+}
+
+// proxySyncRequest is the single work item the ProxySyncer reconciles.
+var proxySyncRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name: "proxy"}}
+
+// balancerTypes maps the spec.method values to the balancer algorithms.
+var balancerTypes = map[string]loadbalancer.BalancerType{
+	balancerv1.MethodRoundRobin:         loadbalancer.RoundRobin,
+	balancerv1.MethodLeastConnection:    loadbalancer.LeastConnection,
+	balancerv1.MethodWeightedRoundRobin: loadbalancer.WeightedRoundRobin,
+	balancerv1.MethodIPHash:             loadbalancer.IPHash,
+	balancerv1.MethodRandom:             loadbalancer.RandomSelection,
+	balancerv1.MethodMaglev:             loadbalancer.Maglev,
+	balancerv1.MethodRingHash:           loadbalancer.RingHash,
+	balancerv1.MethodP2C:                loadbalancer.P2C,
+	balancerv1.MethodLeastResponseTime:  loadbalancer.LeastResponseTime,
+}
+
+// ProxySyncer keeps the userspace proxy serving every allocated address on
+// the ports of its HeliosConfig. Each address forwards to the endpoints of the
+// service it was allocated to, spread by the config's method. Every
+// node proxies every address, so traffic is handled wherever the address is
+// announced.
+type ProxySyncer struct {
+	client.Client
+	Proxy DataPlane
+	// NodeName and Recorder are used to report backend health changes
+	// on the HeliosConfig; with no Recorder they are not reported.
+	NodeName string
+	Recorder record.EventRecorder
+}
+
+// Reconcile recomputes the frontends of every config and hands them to the
+// proxy. Frontends that failed to listen are retried with backoff.
+func (s *ProxySyncer) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) {
+	var configs balancerv1.HeliosConfigList
+	if err := s.List(ctx, &configs); err != nil {
+		return ctrl.Result{}, err
+	}
+	var services corev1.ServiceList
+	if err := s.List(ctx, &services); err != nil {
+		return ctrl.Result{}, err
+	}
+	var slices discoveryv1.EndpointSliceList
+	if err := s.List(ctx, &slices); err != nil {
+		return ctrl.Result{}, err
+	}
+	// Only pod annotations matter, so pods are read as metadata.
+	pods := &metav1.PartialObjectMetadataList{}
+	pods.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("PodList"))
+	if err := s.List(ctx, pods); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, s.Proxy.Sync(proxyGroups(configs.Items, services.Items, slices.Items, podWeights(pods.Items)))
+}
+
+// HealthChanged records an event on the HeliosConfig of group when a health
+// check of this node's proxy ejects a backend or lets it back in. It is a
+// proxy.HealthObserver.
+func (s *ProxySyncer) HealthChanged(group, frontend string, backend proxy.Endpoint, healthy bool) {
+	if s.Recorder == nil {
+		return
+	}
+	namespace, name, ok := strings.Cut(group, "/")
+	if !ok {
+		return
+	}
+	var hc balancerv1.HeliosConfig
+	if err := s.Get(context.Background(), types.NamespacedName{Namespace: namespace, Name: name}, &hc); err != nil {
+		return
+	}
+	address := net.JoinHostPort(backend.Address, strconv.Itoa(backend.Port))
+	if healthy {
+		s.Recorder.Eventf(&hc, corev1.EventTypeNormal, "BackendHealthy",
+			"Backend %s of %s passed health checks on node %s", address, frontend, s.NodeName)
+		return
+	}
+	s.Recorder.Eventf(&hc, corev1.EventTypeWarning, "BackendUnhealthy",
+		"Backend %s of %s failed health checks on node %s", address, frontend, s.NodeName)
+}
+
+// OutlierChanged keeps this node's entries in the status.ejectedBackends of
+// the HeliosConfig of group current, and records an event, when outlier
+// detection on this node's proxy ejects a backend or lets it back in. It is a
+// proxy.OutlierObserver; the API calls run in the background so the session
+// that tripped the ejection is not held up.
+func (s *ProxySyncer) OutlierChanged(group, frontend string, backend proxy.Endpoint, ejected bool, until time.Time) {
+	namespace, name, ok := strings.Cut(group, "/")
+	if !ok {
+		return
+	}
+	address := net.JoinHostPort(backend.Address, strconv.Itoa(backend.Port))
+	go s.recordEjection(types.NamespacedName{Namespace: namespace, Name: name}, frontend, address, ejected, until)
+}
+
+// recordEjection writes one ejection change to the status of the
+// HeliosConfig key and records an event for it.
+func (s *ProxySyncer) recordEjection(key types.NamespacedName, frontend, address string, ejected bool, until time.Time) {
+	ctx := context.Background()
+	logger := log.FromContext(ctx).WithValues("heliosconfig", key, "node", s.NodeName)
+	var hc balancerv1.HeliosConfig
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := s.Get(ctx, key, &hc); err != nil {
+			return err
+		}
+		entry := balancerv1.BackendEjection{Node: s.NodeName, Frontend: frontend, Backend: address}
+		if ejected {
+			entry.EjectedUntil = metav1.NewTime(until)
+		}
+		if !setEjection(&hc.Status, entry, ejected, time.Now()) {
+			return nil
+		}
+		return s.Status().Update(ctx, &hc)
+	})
+	if err != nil {
+		logger.Error(err, "failed to update ejected backends", "frontend", frontend, "backend", address)
+		return
+	}
+
+	if s.Recorder == nil {
+		return
+	}
+	if ejected {
+		s.Recorder.Eventf(&hc, corev1.EventTypeWarning, "BackendEjected",
+			"Backend %s of %s ejected by outlier detection on node %s until %s",
+			address, frontend, s.NodeName, until.UTC().Format(time.RFC3339))
+		return
+	}
+	s.Recorder.Eventf(&hc, corev1.EventTypeNormal, "BackendReturned",
+		"Backend %s of %s returned from outlier ejection on node %s", address, frontend, s.NodeName)
+}
+
+// setEjection adds entry to status.ejectedBackends, or removes it when the
+// backend is no longer ejected. Entries of the same node whose ejection ended
+// before now are dropped too: they are left over from a proxy that stopped
+// before it could remove them. It reports whether the status changed.
+func setEjection(status *balancerv1.HeliosConfigStatus, entry balancerv1.BackendEjection, ejected bool, now time.Time) bool {
+	var kept []balancerv1.BackendEjection
+	found := false
+	for _, e := range status.EjectedBackends {
+		if e.Node != entry.Node {
+			kept = append(kept, e)
+			continue
+		}
+		if e.Frontend == entry.Frontend && e.Backend == entry.Backend {
+			if ejected && !found {
+				kept = append(kept, entry)
+				found = true
+			}
+			continue
+		}
+		if !e.EjectedUntil.Time.Before(now) {
+			kept = append(kept, e)
+		}
+	}
+	if ejected && !found {
+		kept = append(kept, entry)
+	}
+	if equality.Semantic.DeepEqual(kept, status.EjectedBackends) {
+		return false
+	}
+	status.EjectedBackends = kept
+	return true
+}
+
+// podWeights returns the endpoint weights pods set with the
+// balancer.helios.dev/weight annotation. Values outside 1 to 100 are ignored.
+func podWeights(pods []metav1.PartialObjectMetadata) map[types.NamespacedName]int {
+	weights := make(map[types.NamespacedName]int)
+	for i := range pods {
+		value, ok := pods[i].Annotations[balancerv1.EndpointWeightAnnotation]
+		if !ok {
+			continue
+		}
+		w, err := strconv.Atoi(value)
+		if err != nil || w < 1 || w > 100 {
+			continue
+		}
+		weights[types.NamespacedName{Namespace: pods[i].Namespace, Name: pods[i].Name}] = w
+	}
+	return weights
+}
+
+// proxyGroups builds one proxy group per config: a frontend for every
+// allocated address and every spec.ports entry the service also exposes.
+// Under WeightedRoundRobin each endpoint is weighted by its pod's annotation,
+// else by its service's entry in spec.weights.
+func proxyGroups(configs []balancerv1.HeliosConfig, services []corev1.Service, slices []discoveryv1.EndpointSlice, pods map[types.NamespacedName]int) []proxy.Group {
+	svcs := make(map[types.NamespacedName]*corev1.Service, len(services))
+	for i := range services {
+		svcs[types.NamespacedName{Namespace: services[i].Namespace, Name: services[i].Name}] = &services[i]
+	}
+	slicesBySvc := make(map[types.NamespacedName][]*discoveryv1.EndpointSlice)
+	for i := range slices {
+		name := slices[i].Labels[discoveryv1.LabelServiceName]
+		if name == "" {
+			continue
+		}
+		key := types.NamespacedName{Namespace: slices[i].Namespace, Name: name}
+		slicesBySvc[key] = append(slicesBySvc[key], &slices[i])
+	}
+
+	var groups []proxy.Group
+	for i := range configs {
+		hc := &configs[i]
+		// A config being deleted with a drain timeout keeps its frontends,
+		// with every backend draining, until its addresses are released.
+		deleting := !hc.DeletionTimestamp.IsZero()
+		if deleting && !drainsOnDelete(hc) {
+			continue
+		}
+		g := proxy.Group{Name: hc.Namespace + "/" + hc.Name, Balancer: balancerConfig(hc)}
+		for _, alloc := range hc.Status.Allocations {
+			key := types.NamespacedName{Namespace: alloc.Namespace, Name: alloc.Name}
+			svc, ok := svcs[key]
+			if !ok || (alloc.UID != "" && alloc.UID != svc.UID) {
+				continue
+			}
+			weight := endpointWeight(hc, svc.Name, pods)
+			for _, pc := range hc.Spec.Ports {
+				protocol := portProtocol(hc, pc)
+				sp := servicePort(svc, pc.Port, protocol)
+				if sp == nil {
+					continue
+				}
+				for _, ip := range alloc.Addresses() {
+					addr, err := netip.ParseAddr(ip)
+					if err != nil {
+						continue
+					}
+					f := proxy.Frontend{
+						IP:       addr.String(),
+						Port:     int(pc.Port),
+						Protocol: protocol,
+						Backends: serviceEndpoints(slicesBySvc[key], sp, addr.Is4(), weight),
+					}
+					if protocol == balancerv1.ProtocolUDP {
+						f.IdleTimeout = time.Duration(pc.IdleTimeoutSeconds) * time.Second
+					} else if pc.Affinity != nil {
+						f.Affinity = frontendAffinity(pc.Affinity)
+					}
+					if deleting {
+						f.Backends = nil
+					}
+					g.Frontends = append(g.Frontends, f)
+				}
+			}
+		}
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// endpointWeight returns how the endpoints of a service are weighted under a
+// config, or nil when the config's method does not use weights.
+func endpointWeight(hc *balancerv1.HeliosConfig, service string, pods map[types.NamespacedName]int) func(*discoveryv1.Endpoint) int {
+	if hc.Spec.Method != balancerv1.MethodWeightedRoundRobin {
+		return nil
+	}
+	serviceWeight := 0
+	for _, w := range hc.Spec.Weights {
+		if w.ServiceName == service {
+			serviceWeight = int(w.Weight)
+			break
+		}
+	}
+	return func(ep *discoveryv1.Endpoint) int {
+		if ref := ep.TargetRef; ref != nil && ref.Kind == "Pod" {
+			if w, ok := pods[types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}]; ok {
+				return w
+			}
+		}
+		return serviceWeight
+	}
+}
+
+// balancerConfig translates the method, slow start, drain timeout, health
+// check and outlier detection of a config.
+func balancerConfig(hc *balancerv1.HeliosConfig) loadbalancer.BalancerConfig {
+	cfg := loadbalancer.BalancerConfig{
+		Type:         loadbalancer.RoundRobin,
+		SlowStart:    time.Duration(hc.Spec.SlowStartSeconds) * time.Second,
+		DrainTimeout: time.Duration(hc.Spec.DrainTimeoutSeconds) * time.Second,
+	}
+	if t, ok := balancerTypes[hc.Spec.Method]; ok {
+		cfg.Type = t
+	}
+	if check := hc.Spec.HealthCheck; check != nil && check.Enabled {
+		cfg.HealthCheck = true
+		cfg.CheckInterval = time.Duration(check.IntervalSeconds) * time.Second
+		cfg.HealthCheckOpts = loadbalancer.HealthCheckOptions{
+			Timeout:            time.Duration(check.TimeoutMs) * time.Millisecond,
+			Protocol:           check.Protocol,
+			HTTPPath:           check.HTTPPath,
+			Scheme:             check.Scheme,
+			InsecureSkipVerify: check.InsecureSkipVerify,
+			CABundle:           check.CABundle,
+			Host:               check.Host,
+			GRPCService:        check.GRPCService,
+			Method:             check.Method,
+			BodyContains:       check.BodyContains,
+			BodyRegex:          check.BodyRegex,
+			HealthyThreshold:   int(check.HealthyThreshold),
+			UnhealthyThreshold: int(check.UnhealthyThreshold),
+		}
+		// Left nil when unset, so an unchanged config compares equal and
+		// the balancer is not reconfigured on every sync.
+		if len(check.Headers) > 0 {
+			cfg.HealthCheckOpts.Headers = make(map[string]string, len(check.Headers))
+			for _, h := range check.Headers {
+				cfg.HealthCheckOpts.Headers[h.Name] = h.Value
+			}
+		}
+		for _, code := range check.ExpectedStatuses {
+			cfg.HealthCheckOpts.ExpectedStatuses = append(cfg.HealthCheckOpts.ExpectedStatuses, int(code))
+		}
+	}
+	if od := hc.Spec.OutlierDetection; od != nil && od.Enabled {
+		cfg.OutlierDetection = true
+		cfg.OutlierDetectionOpts = loadbalancer.OutlierDetectionOptions{
+			ConsecutiveFailures: int(od.ConsecutiveFailures),
+			BaseEjectionTime:    time.Duration(od.BaseEjectionSeconds) * time.Second,
+			MaxEjectionTime:     time.Duration(od.MaxEjectionSeconds) * time.Second,
+			MaxEjectionPercent:  int(od.MaxEjectionPercent),
+		}
+	}
+	return cfg
+}
+
+// frontendAffinity translates the session affinity of a port.
+func frontendAffinity(a *balancerv1.SessionAffinityConfig) *proxy.Affinity {
+	affinity := &proxy.Affinity{Mode: a.Mode, Timeout: time.Duration(a.TimeoutSeconds) * time.Second}
+	switch a.Mode {
+	case balancerv1.AffinityModeHeader:
+		affinity.Name = a.HeaderName
+	case balancerv1.AffinityModeCookie:
+		affinity.Name = a.CookieName
+	}
+	return affinity
+}
+
+// portProtocol returns the protocol of a spec.ports entry, which defaults to
+// spec.protocol and then to TCP.
+func portProtocol(hc *balancerv1.HeliosConfig, pc balancerv1.PortConfig) string {
+	switch {
+	case pc.Protocol != "":
+		return pc.Protocol
+	case hc.Spec.Protocol != "":
+		return hc.Spec.Protocol
+	default:
+		return balancerv1.ProtocolTCP
+	}
+}
+
+// servicePort returns the port of svc that serves port over protocol.
+func servicePort(svc *corev1.Service, port int32, protocol string) *corev1.ServicePort {
+	for i := range svc.Spec.Ports {
+		sp := &svc.Spec.Ports[i]
+		spProtocol := sp.Protocol
+		if spProtocol == "" {
+			spProtocol = corev1.ProtocolTCP
+		}
+		if sp.Port == port && string(spProtocol) == protocol {
+			return sp
+		}
+	}
+	return nil
+}
+
+// serviceEndpoints returns the endpoints traffic for a service port goes to.
+// Ready endpoints are used while there are any. Once every endpoint is
+// terminating, those still serving keep taking traffic so connections drain
+// during a rollout instead of being refused, as kube-proxy does. Endpoints of
+// the frontend's address family are preferred; a single-stack service behind
+// a dual-stack config is reached across families. An address listed in more
+// than one slice, as happens while slices are rebalanced, is used once.
+// weight, if not nil, sets each endpoint's weight.
+func serviceEndpoints(slices []*discoveryv1.EndpointSlice, sp *corev1.ServicePort, ipv4 bool, weight func(*discoveryv1.Endpoint) int) []proxy.Endpoint {
+	family := discoveryv1.AddressTypeIPv6
+	if ipv4 {
+		family = discoveryv1.AddressTypeIPv4
+	}
+	// Indexed by [terminating][other family].
+	var found [2][2][]proxy.Endpoint
+	seen := make(map[string]bool)
+	for _, slice := range slices {
+		if slice.AddressType == discoveryv1.AddressTypeFQDN {
+			continue
+		}
+		port, ok := slicePort(slice, sp)
+		if !ok {
+			continue
+		}
+		otherFamily := 0
+		if slice.AddressType != family {
+			otherFamily = 1
+		}
+		for i := range slice.Endpoints {
+			ep := &slice.Endpoints[i]
+			terminating := 0
+			switch ready, serving := endpointState(ep.Conditions); {
+			case ready:
+			case serving:
+				terminating = 1
+			default:
+				continue
+			}
+			w := 0
+			if weight != nil {
+				w = weight(ep)
+			}
+			for _, address := range ep.Addresses {
+				if seen[address] {
+					continue
+				}
+				seen[address] = true
+				found[terminating][otherFamily] = append(found[terminating][otherFamily], proxy.Endpoint{Address: address, Port: port, Weight: w})
+			}
+		}
+	}
+	for _, byFamily := range found {
+		for _, endpoints := range byFamily {
+			if len(endpoints) > 0 {
+				return endpoints
+			}
+		}
+	}
+	return nil
+}
+
+// endpointState reports whether an endpoint is ready, and whether it is
+// terminating but still serving. Unset conditions mean unknown, which
+// consumers treat as ready and serving.
+func endpointState(c discoveryv1.EndpointConditions) (ready, servingTerminating bool) {
+	terminating := c.Terminating != nil && *c.Terminating
+	ready = (c.Ready == nil || *c.Ready) && !terminating
+	serving := c.Serving == nil || *c.Serving
+	return ready, terminating && serving
+}
+
+// slicePort returns the target port of sp in an EndpointSlice. Slice ports
+// carry the name of the service port they resolve.
+func slicePort(slice *discoveryv1.EndpointSlice, sp *corev1.ServicePort) (int, bool) {
+	spProtocol := sp.Protocol
+	if spProtocol == "" {
+		spProtocol = corev1.ProtocolTCP
+	}
+	for _, p := range slice.Ports {
+		name := ""
+		if p.Name != nil {
+			name = *p.Name
+		}
+		protocol := corev1.ProtocolTCP
+		if p.Protocol != nil {
+			protocol = *p.Protocol
+		}
+		if name == sp.Name && protocol == spProtocol && p.Port != nil {
+			return int(*p.Port), true
+		}
+	}
+	return 0, false
+}
+
+// SetupWithManager sets up the proxy syncer with the Manager.
+func (s *ProxySyncer) SetupWithManager(mgr ctrl.Manager) error {
+	enqueue := handler.EnqueueRequestsFromMapFunc(func(context.Context, client.Object) []reconcile.Request {
+		return []reconcile.Request{proxySyncRequest}
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("proxy").
+		Watches(&balancerv1.HeliosConfig{}, enqueue).
+		Watches(&corev1.Service{}, enqueue).
+		Watches(&discoveryv1.EndpointSlice{}, enqueue).
+		// Pods change status all the time; only their weight annotation
+		// matters here.
+		WatchesMetadata(&corev1.Pod{}, enqueue, builder.WithPredicates(predicate.AnnotationChangedPredicate{})).
+		Complete(s)
+}
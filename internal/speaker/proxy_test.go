@@ -0,0 +1,544 @@
+package speaker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	balancerv1 "github.com/somaz94/helios-lb/api/v1"
+	"github.com/somaz94/helios-lb/internal/loadbalancer"
+	"github.com/somaz94/helios-lb/internal/proxy"
+)
+
+// recordingDataPlane remembers the last groups it was given.
+type recordingDataPlane struct {
+	groups []proxy.Group
+}
+
+func (r *recordingDataPlane) Sync(groups []proxy.Group) error {
+	r.groups = groups
+	return nil
+}
+
+func testService(name string, ports ...corev1.ServicePort) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, Ports: ports},
+	}
+}
+
+func testSlice(name, service string, family discoveryv1.AddressType, port discoveryv1.EndpointPort, endpoints ...discoveryv1.Endpoint) *discoveryv1.EndpointSlice {
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: service},
+		},
+		AddressType: family,
+		Ports:       []discoveryv1.EndpointPort{port},
+		Endpoints:   endpoints,
+	}
+}
+
+func endpoint(ready bool, addresses ...string) discoveryv1.Endpoint {
+	return discoveryv1.Endpoint{Addresses: addresses, Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(ready)}}
+}
+
+func syncProxy(t *testing.T, objs ...client.Object) []proxy.Group {
+	t.Helper()
+	cl := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(objs...).Build()
+	dp := &recordingDataPlane{}
+	s := &ProxySyncer{Client: cl, Proxy: dp}
+	if _, err := s.Reconcile(context.Background(), proxySyncRequest); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	return dp.groups
+}
+
+func TestProxySyncer_FrontendsForAllocatedPorts(t *testing.T) {
+	hc := testConfig("web", map[string]string{"svc": "192.0.2.10"})
+	hc.Spec.Method = balancerv1.MethodLeastConnection
+	hc.Spec.Ports = []balancerv1.PortConfig{
+		{Port: 80},
+		{Port: 53, Protocol: balancerv1.ProtocolUDP},
+		// Not exposed by the service: no frontend.
+		{Port: 8443},
+	}
+	svc := testService("svc",
+		corev1.ServicePort{Name: "http", Port: 80, Protocol: corev1.ProtocolTCP},
+		corev1.ServicePort{Name: "dns", Port: 53, Protocol: corev1.ProtocolUDP},
+	)
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "svc-abc",
+			Namespace: "default",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: "svc"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Ports: []discoveryv1.EndpointPort{
+			{Name: ptr.To("http"), Port: ptr.To[int32](8080), Protocol: ptr.To(corev1.ProtocolTCP)},
+			{Name: ptr.To("dns"), Port: ptr.To[int32](5353), Protocol: ptr.To(corev1.ProtocolUDP)},
+		},
+		Endpoints: []discoveryv1.Endpoint{
+			endpoint(true, "10.0.0.1"),
+			endpoint(false, "10.0.0.2"),
+			{Addresses: []string{"10.0.0.3"}}, // unknown readiness counts as ready
+		},
+	}
+
+	groups := syncProxy(t, hc, svc, slice)
+	if len(groups) != 1 {
+		t.Fatalf("got %d groups, want 1", len(groups))
+	}
+	if groups[0].Balancer.Type != loadbalancer.LeastConnection {
+		t.Errorf("balancer type = %q, want %q", groups[0].Balancer.Type, loadbalancer.LeastConnection)
+	}
+	want := []proxy.Frontend{
+		{IP: "192.0.2.10", Port: 80, Protocol: proxy.ProtocolTCP, Backends: []proxy.Endpoint{
+			{Address: "10.0.0.1", Port: 8080}, {Address: "10.0.0.3", Port: 8080},
+		}},
+		{IP: "192.0.2.10", Port: 53, Protocol: proxy.ProtocolUDP, Backends: []proxy.Endpoint{
+			{Address: "10.0.0.1", Port: 5353}, {Address: "10.0.0.3", Port: 5353},
+		}},
+	}
+	if !reflect.DeepEqual(groups[0].Frontends, want) {
+		t.Errorf("frontends = %+v, want %+v", groups[0].Frontends, want)
+	}
+}
+
+func TestProxySyncer_DualStackPrefersSameFamily(t *testing.T) {
+	hc := testConfig("web", nil)
+	hc.Spec.Ports = []balancerv1.PortConfig{{Port: 80}}
+	hc.Status.SetAllocation(balancerv1.ServiceAllocation{Namespace: "default", Name: "svc", IPv4: "192.0.2.10", IPv6: "2001:db8::10"})
+	svc := testService("svc", corev1.ServicePort{Port: 80})
+	port := discoveryv1.EndpointPort{Name: ptr.To(""), Port: ptr.To[int32](8080), Protocol: ptr.To(corev1.ProtocolTCP)}
+
+	groups := syncProxy(t, hc, svc,
+		testSlice("svc-v4", "svc", discoveryv1.AddressTypeIPv4, port, endpoint(true, "10.0.0.1")),
+		testSlice("svc-v6", "svc", discoveryv1.AddressTypeIPv6, port, endpoint(true, "fd00::1")),
+	)
+	got := map[string]string{}
+	for _, f := range groups[0].Frontends {
+		if len(f.Backends) != 1 {
+			t.Fatalf("frontend %s backends = %v, want one", f.IP, f.Backends)
+		}
+		got[f.IP] = f.Backends[0].Address
+	}
+	want := map[string]string{"192.0.2.10": "10.0.0.1", "2001:db8::10": "fd00::1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("backends by frontend = %v, want %v", got, want)
+	}
+}
+
+func TestProxySyncer_SkipsStaleAndDeletingConfigs(t *testing.T) {
+	stale := testConfig("stale", nil)
+	stale.Spec.Ports = []balancerv1.PortConfig{{Port: 80}}
+	// Allocated to an earlier incarnation of the service.
+	stale.Status.SetAllocation(balancerv1.ServiceAllocation{Namespace: "default", Name: "svc", UID: "old", IPv4: "192.0.2.10"})
+
+	deleting := testConfig("deleting", map[string]string{"svc": "192.0.2.11"})
+	deleting.Spec.Ports = []balancerv1.PortConfig{{Port: 80}}
+	deleting.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	deleting.Finalizers = []string{"balancer.helios.dev/finalizer"}
+
+	svc := testService("svc", corev1.ServicePort{Port: 80})
+	svc.UID = "new"
+
+	groups := syncProxy(t, stale, deleting, svc)
+	if len(groups) != 1 || groups[0].Name != "default/stale" {
+		t.Fatalf("groups = %+v, want only default/stale", groups)
+	}
+	if len(groups[0].Frontends) != 0 {
+		t.Errorf("frontends = %+v, want none for a recreated service", groups[0].Frontends)
+	}
+}
+
+func TestProxySyncer_Affinity(t *testing.T) {
+	hc := testConfig("web", map[string]string{"svc": "192.0.2.10"})
+	hc.Spec.Ports = []balancerv1.PortConfig{
+		{Port: 80, Affinity: &balancerv1.SessionAffinityConfig{Mode: balancerv1.AffinityModeCookie, CookieName: "sticky", TimeoutSeconds: 600}},
+		{Port: 8080, Affinity: &balancerv1.SessionAffinityConfig{Mode: balancerv1.AffinityModeHeader, HeaderName: "X-User", TimeoutSeconds: 60}},
+		{Port: 443},
+	}
+	svc := testService("svc",
+		corev1.ServicePort{Name: "http", Port: 80},
+		corev1.ServicePort{Name: "alt", Port: 8080},
+		corev1.ServicePort{Name: "https", Port: 443},
+	)
+
+	groups := syncProxy(t, hc, svc)
+	if len(groups) != 1 {
+		t.Fatalf("got %d groups, want 1", len(groups))
+	}
+	want := map[int]*proxy.Affinity{
+		80:   {Mode: proxy.AffinityCookie, Name: "sticky", Timeout: 10 * time.Minute},
+		8080: {Mode: proxy.AffinityHeader, Name: "X-User", Timeout: time.Minute},
+		443:  nil,
+	}
+	for _, f := range groups[0].Frontends {
+		if !reflect.DeepEqual(f.Affinity, want[f.Port]) {
+			t.Errorf("frontend %d affinity = %+v, want %+v", f.Port, f.Affinity, want[f.Port])
+		}
+	}
+}
+
+func TestProxySyncer_DrainsDeletingConfig(t *testing.T) {
+	hc := testConfig("web", map[string]string{"svc": "192.0.2.10"})
+	hc.Spec.Ports = []balancerv1.PortConfig{{Port: 80}}
+	hc.Spec.DrainTimeoutSeconds = 30
+	hc.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	hc.Finalizers = []string{"balancer.helios.dev/finalizer"}
+	svc := testService("svc", corev1.ServicePort{Port: 80})
+	port := discoveryv1.EndpointPort{Name: ptr.To(""), Port: ptr.To[int32](8080), Protocol: ptr.To(corev1.ProtocolTCP)}
+
+	groups := syncProxy(t, hc, svc, testSlice("svc-abc", "svc", discoveryv1.AddressTypeIPv4, port, endpoint(true, "10.0.0.1")))
+	if len(groups) != 1 || groups[0].Balancer.DrainTimeout != 30*time.Second {
+		t.Fatalf("groups = %+v, want default/web draining for 30s", groups)
+	}
+	want := []proxy.Frontend{{IP: "192.0.2.10", Port: 80, Protocol: proxy.ProtocolTCP}}
+	if !reflect.DeepEqual(groups[0].Frontends, want) {
+		t.Errorf("frontends = %+v, want %+v with every backend draining", groups[0].Frontends, want)
+	}
+}
+
+// The speaker keeps advertising a deleting config exactly as long as the proxy
+// keeps serving it.
+func TestDeletingConfig_AdvertisedWhileServed(t *testing.T) {
+	for _, timeout := range []int32{-1, 0, 30} {
+		hc := testConfig("web", map[string]string{"svc": "192.0.2.10"})
+		hc.Spec.Ports = []balancerv1.PortConfig{{Port: 80}}
+		hc.Spec.DrainTimeoutSeconds = timeout
+		hc.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+		hc.Finalizers = []string{"balancer.helios.dev/finalizer"}
+		svc := testService("svc", corev1.ServicePort{Port: 80})
+		port := discoveryv1.EndpointPort{Name: ptr.To(""), Port: ptr.To[int32](8080), Protocol: ptr.To(corev1.ProtocolTCP)}
+
+		served := len(syncProxy(t, hc, svc, testSlice("svc-abc", "svc", discoveryv1.AddressTypeIPv4, port, endpoint(true, "10.0.0.1")))) > 0
+		advertised := len(reconcileAs(t, "node-a", testNode("node-a", true), hc.DeepCopy())) > 0
+		if served != advertised {
+			t.Errorf("drainTimeoutSeconds %d: served = %v, advertised = %v, want both the same", timeout, served, advertised)
+		}
+		if want := timeout > 0; served != want {
+			t.Errorf("drainTimeoutSeconds %d: served = %v, want %v", timeout, served, want)
+		}
+	}
+}
+
+func TestBalancerConfig_HealthCheck(t *testing.T) {
+	hc := testConfig("web", nil)
+	hc.Spec.Method = balancerv1.MethodIPHash
+	hc.Spec.HealthCheck = &balancerv1.HealthCheckConfig{
+		Enabled:            true,
+		IntervalSeconds:    10,
+		TimeoutMs:          500,
+		Protocol:           balancerv1.ProtocolHTTP,
+		HTTPPath:           "/healthz",
+		Scheme:             balancerv1.SchemeHTTPS,
+		Host:               "api.example.com",
+		Headers:            []balancerv1.HTTPHeader{{Name: "X-Probe", Value: "helios"}},
+		ExpectedStatuses:   []int32{200, 204},
+		BodyContains:       "ok",
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 3,
+	}
+	want := loadbalancer.BalancerConfig{
+		Type:          loadbalancer.IPHash,
+		HealthCheck:   true,
+		CheckInterval: 10 * time.Second,
+		HealthCheckOpts: loadbalancer.HealthCheckOptions{
+			Timeout:            500 * time.Millisecond,
+			Protocol:           balancerv1.ProtocolHTTP,
+			HTTPPath:           "/healthz",
+			Scheme:             balancerv1.SchemeHTTPS,
+			Host:               "api.example.com",
+			Headers:            map[string]string{"X-Probe": "helios"},
+			ExpectedStatuses:   []int{200, 204},
+			BodyContains:       "ok",
+			HealthyThreshold:   2,
+			UnhealthyThreshold: 3,
+		},
+	}
+	if got := balancerConfig(hc); !reflect.DeepEqual(got, want) {
+		t.Errorf("balancerConfig() = %+v, want %+v", got, want)
+	}
+
+	hc.Spec.HealthCheck.Enabled = false
+	if got := balancerConfig(hc); got.HealthCheck {
+		t.Errorf("balancerConfig() enables health checks for a disabled check: %+v", got)
+	}
+}
+
+func TestBalancerConfig_OutlierDetection(t *testing.T) {
+	hc := testConfig("web", nil)
+	hc.Spec.OutlierDetection = &balancerv1.OutlierDetectionConfig{
+		Enabled:             true,
+		ConsecutiveFailures: 3,
+		BaseEjectionSeconds: 10,
+		MaxEjectionSeconds:  120,
+		MaxEjectionPercent:  50,
+	}
+	want := loadbalancer.OutlierDetectionOptions{
+		ConsecutiveFailures: 3,
+		BaseEjectionTime:    10 * time.Second,
+		MaxEjectionTime:     2 * time.Minute,
+		MaxEjectionPercent:  50,
+	}
+	if got := balancerConfig(hc); !got.OutlierDetection || got.OutlierDetectionOpts != want {
+		t.Errorf("balancerConfig() = %+v, want outlier detection with %+v", got, want)
+	}
+
+	hc.Spec.OutlierDetection.Enabled = false
+	if got := balancerConfig(hc); got.OutlierDetection {
+		t.Errorf("balancerConfig() enables outlier detection when disabled: %+v", got)
+	}
+}
+
+func TestBalancerConfig_SlowStart(t *testing.T) {
+	hc := testConfig("web", nil)
+	hc.Spec.Method = balancerv1.MethodLeastConnection
+	hc.Spec.SlowStartSeconds = 45
+	want := loadbalancer.BalancerConfig{Type: loadbalancer.LeastConnection, SlowStart: 45 * time.Second}
+	if got := balancerConfig(hc); !reflect.DeepEqual(got, want) {
+		t.Errorf("balancerConfig() = %+v, want %+v", got, want)
+	}
+}
+
+func TestServiceEndpoints_Conditions(t *testing.T) {
+	sp := &corev1.ServicePort{Name: "http", Port: 80}
+	port := discoveryv1.EndpointPort{Name: ptr.To("http"), Port: ptr.To[int32](8080)}
+	terminating := func(serving bool, addresses ...string) discoveryv1.Endpoint {
+		return discoveryv1.Endpoint{Addresses: addresses, Conditions: discoveryv1.EndpointConditions{
+			Ready: ptr.To(false), Serving: ptr.To(serving), Terminating: ptr.To(true),
+		}}
+	}
+
+	tests := []struct {
+		name   string
+		slices []*discoveryv1.EndpointSlice
+		want   []string
+	}{
+		{
+			name: "ready endpoints win over terminating ones",
+			slices: []*discoveryv1.EndpointSlice{
+				testSlice("a", "svc", discoveryv1.AddressTypeIPv4, port, endpoint(true, "10.0.0.1"), terminating(true, "10.0.0.2")),
+			},
+			want: []string{"10.0.0.1"},
+		},
+		{
+			name: "serving terminating endpoints drain when none are ready",
+			slices: []*discoveryv1.EndpointSlice{
+				testSlice("a", "svc", discoveryv1.AddressTypeIPv4, port, terminating(true, "10.0.0.2"), terminating(false, "10.0.0.3")),
+			},
+			want: []string{"10.0.0.2"},
+		},
+		{
+			name: "ready endpoints of the other family win over terminating ones",
+			slices: []*discoveryv1.EndpointSlice{
+				testSlice("a", "svc", discoveryv1.AddressTypeIPv4, port, terminating(true, "10.0.0.2")),
+				testSlice("b", "svc", discoveryv1.AddressTypeIPv6, port, endpoint(true, "fd00::1")),
+			},
+			want: []string{"fd00::1"},
+		},
+		{
+			name: "an address in two slices is used once",
+			slices: []*discoveryv1.EndpointSlice{
+				testSlice("a", "svc", discoveryv1.AddressTypeIPv4, port, endpoint(true, "10.0.0.1")),
+				testSlice("b", "svc", discoveryv1.AddressTypeIPv4, port, endpoint(true, "10.0.0.1", "10.0.0.4")),
+			},
+			want: []string{"10.0.0.1", "10.0.0.4"},
+		},
+		{
+			name: "slices without the service port are ignored",
+			slices: []*discoveryv1.EndpointSlice{
+				testSlice("a", "svc", discoveryv1.AddressTypeIPv4,
+					discoveryv1.EndpointPort{Name: ptr.To("metrics"), Port: ptr.To[int32](9090)},
+					endpoint(true, "10.0.0.1")),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, ep := range serviceEndpoints(tt.slices, sp, true, nil) {
+				if ep.Port != 8080 {
+					t.Errorf("endpoint %s port = %d, want 8080", ep.Address, ep.Port)
+				}
+				got = append(got, ep.Address)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("serviceEndpoints() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxySyncer_UDPIdleTimeout(t *testing.T) {
+	hc := testConfig("web", map[string]string{"svc": "192.0.2.10"})
+	hc.Spec.Ports = []balancerv1.PortConfig{
+		{Port: 80, IdleTimeoutSeconds: 30},
+		{Port: 53, Protocol: balancerv1.ProtocolUDP, IdleTimeoutSeconds: 30},
+	}
+	svc := testService("svc",
+		corev1.ServicePort{Name: "http", Port: 80, Protocol: corev1.ProtocolTCP},
+		corev1.ServicePort{Name: "dns", Port: 53, Protocol: corev1.ProtocolUDP},
+	)
+
+	groups := syncProxy(t, hc, svc)
+	got := map[string]time.Duration{}
+	for _, f := range groups[0].Frontends {
+		got[f.Protocol] = f.IdleTimeout
+	}
+	want := map[string]time.Duration{proxy.ProtocolTCP: 0, proxy.ProtocolUDP: 30 * time.Second}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("idle timeouts = %v, want %v", got, want)
+	}
+}
+
+func TestProxySyncer_EndpointWeights(t *testing.T) {
+	hc := testConfig("web", map[string]string{"svc": "192.0.2.10"})
+	hc.Spec.Method = balancerv1.MethodWeightedRoundRobin
+	hc.Spec.Weights = []balancerv1.WeightConfig{{ServiceName: "svc", Weight: 2}}
+	hc.Spec.Ports = []balancerv1.PortConfig{{Port: 80}}
+	svc := testService("svc", corev1.ServicePort{Port: 80})
+	pod := func(name, weight string) *corev1.Pod {
+		p := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+		if weight != "" {
+			p.Annotations = map[string]string{balancerv1.EndpointWeightAnnotation: weight}
+		}
+		return p
+	}
+	podEndpoint := func(name, address string) discoveryv1.Endpoint {
+		ep := endpoint(true, address)
+		ep.TargetRef = &corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: name}
+		return ep
+	}
+	port := discoveryv1.EndpointPort{Name: ptr.To(""), Port: ptr.To[int32](8080), Protocol: ptr.To(corev1.ProtocolTCP)}
+	slice := testSlice("svc-abc", "svc", discoveryv1.AddressTypeIPv4, port,
+		podEndpoint("annotated", "10.0.0.1"),
+		podEndpoint("plain", "10.0.0.2"),
+		podEndpoint("invalid", "10.0.0.3"),
+	)
+
+	groups := syncProxy(t, hc, svc, slice, pod("annotated", "7"), pod("plain", ""), pod("invalid", "1000"))
+	got := map[string]int{}
+	for _, ep := range groups[0].Frontends[0].Backends {
+		got[ep.Address] = ep.Weight
+	}
+	// The annotation wins; without a valid one the service weight applies.
+	want := map[string]int{"10.0.0.1": 7, "10.0.0.2": 2, "10.0.0.3": 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("weights = %v, want %v", got, want)
+	}
+
+	// Other methods ignore weights.
+	hc.Spec.Method = balancerv1.MethodRoundRobin
+	hc.Spec.Weights = nil
+	groups = syncProxy(t, hc, svc, slice, pod("annotated", "7"))
+	for _, ep := range groups[0].Frontends[0].Backends {
+		if ep.Weight != 0 {
+			t.Errorf("endpoint %s weight = %d under RoundRobin, want 0", ep.Address, ep.Weight)
+		}
+	}
+}
+
+func TestProxySyncer_HealthChangedRecordsEvents(t *testing.T) {
+	hc := testConfig("web", nil)
+	cl := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(hc).Build()
+	recorder := record.NewFakeRecorder(4)
+	s := &ProxySyncer{Client: cl, NodeName: "node-a", Recorder: recorder}
+	backend := proxy.Endpoint{Address: "10.0.0.1", Port: 8080}
+
+	s.HealthChanged("default/web", "192.0.2.10:80/TCP", backend, false)
+	s.HealthChanged("default/web", "192.0.2.10:80/TCP", backend, true)
+	// Configs that are gone are skipped.
+	s.HealthChanged("default/gone", "192.0.2.11:80/TCP", backend, false)
+
+	want := []string{
+		"Warning BackendUnhealthy Backend 10.0.0.1:8080 of 192.0.2.10:80/TCP failed health checks on node node-a",
+		"Normal BackendHealthy Backend 10.0.0.1:8080 of 192.0.2.10:80/TCP passed health checks on node node-a",
+	}
+	for _, w := range want {
+		select {
+		case got := <-recorder.Events:
+			if got != w {
+				t.Errorf("event = %q, want %q", got, w)
+			}
+		default:
+			t.Errorf("missing event %q", w)
+		}
+	}
+	select {
+	case got := <-recorder.Events:
+		t.Errorf("unexpected event %q", got)
+	default:
+	}
+}
+
+func TestProxySyncer_RecordEjection(t *testing.T) {
+	hc := testConfig("web", nil)
+	// Another node's entry is left alone; this node's expired one is dropped.
+	past := metav1.NewTime(time.Now().Add(-time.Minute))
+	hc.Status.EjectedBackends = []balancerv1.BackendEjection{
+		{Node: "node-b", Frontend: "192.0.2.10:80/TCP", Backend: "10.0.0.1:8080", EjectedUntil: past},
+		{Node: "node-a", Frontend: "192.0.2.10:80/TCP", Backend: "10.0.0.9:8080", EjectedUntil: past},
+	}
+	cl := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(hc).WithStatusSubresource(hc).Build()
+	recorder := record.NewFakeRecorder(4)
+	s := &ProxySyncer{Client: cl, NodeName: "node-a", Recorder: recorder}
+	key := client.ObjectKeyFromObject(hc)
+	until := time.Now().Add(time.Minute).Truncate(time.Second)
+
+	s.recordEjection(key, "192.0.2.10:80/TCP", "10.0.0.1:8080", true, until)
+	var got balancerv1.HeliosConfig
+	if err := cl.Get(context.Background(), key, &got); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	want := []balancerv1.BackendEjection{
+		{Node: "node-b", Frontend: "192.0.2.10:80/TCP", Backend: "10.0.0.1:8080"},
+		{Node: "node-a", Frontend: "192.0.2.10:80/TCP", Backend: "10.0.0.1:8080"},
+	}
+	if len(got.Status.EjectedBackends) != len(want) {
+		t.Fatalf("ejectedBackends = %+v, want %+v", got.Status.EjectedBackends, want)
+	}
+	for i, e := range got.Status.EjectedBackends {
+		if e.Node != want[i].Node || e.Frontend != want[i].Frontend || e.Backend != want[i].Backend {
+			t.Errorf("ejectedBackends[%d] = %+v, want %+v", i, e, want[i])
+		}
+	}
+	if !got.Status.EjectedBackends[1].EjectedUntil.Time.Equal(until) {
+		t.Errorf("ejectedUntil = %v, want %v", got.Status.EjectedBackends[1].EjectedUntil, until)
+	}
+
+	s.recordEjection(key, "192.0.2.10:80/TCP", "10.0.0.1:8080", false, time.Time{})
+	if err := cl.Get(context.Background(), key, &got); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if len(got.Status.EjectedBackends) != 1 || got.Status.EjectedBackends[0].Node != "node-b" {
+		t.Errorf("ejectedBackends = %+v, want only node-b's entry", got.Status.EjectedBackends)
+	}
+
+	wantEvents := []string{
+		"Warning BackendEjected Backend 10.0.0.1:8080 of 192.0.2.10:80/TCP ejected by outlier detection on node node-a until " +
+			until.UTC().Format(time.RFC3339),
+		"Normal BackendReturned Backend 10.0.0.1:8080 of 192.0.2.10:80/TCP returned from outlier ejection on node node-a",
+	}
+	for _, w := range wantEvents {
+		select {
+		case got := <-recorder.Events:
+			if got != w {
+				t.Errorf("event = %q, want %q", got, w)
+			}
+		default:
+			t.Errorf("missing event %q", w)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package speaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	balancerv1 "github.com/somaz94/helios-lb/api/v1"
+	"github.com/somaz94/helios-lb/internal/bgp"
+)
+
+// IPAnnouncer is the part of Announcer the Speaker drives.
+type IPAnnouncer interface {
+	SetOwned(ips []net.IP)
+}
+
+// RouteAdvertiser is the part of bgp.Manager the Speaker drives.
+type RouteAdvertiser interface {
+	Sync(peers []bgp.Peer)
+}
+
+// syncRequest is the single work item the Speaker reconciles. Any change to a
+// HeliosConfig, to node readiness or to the set of running speakers can move
+// ownership of any address, so the whole set is recomputed every time.
+var syncRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name: "speaker"}}
+
+// Speaker decides which allocated addresses this node advertises. It runs on
+// every node. Addresses of L2 configs are announced by a single node: each
+// instance elects an owner for every address with ElectNode over the same set
+// of ready nodes running a speaker, so exactly one node answers for each IP.
+// Addresses of BGP configs are advertised as host routes by every such node,
+// and the fabric spreads traffic across them.
+type Speaker struct {
+	client.Client
+	NodeName string
+	// Namespace holds the leases the speakers renew with Heartbeat.
+	Namespace string
+	Announcer IPAnnouncer
+	// Routes runs the BGP sessions; BGP configs are ignored when it is nil.
+	Routes RouteAdvertiser
+	// SecretReader reads BGP password Secrets. It defaults to the Client;
+	// main wires an uncached reader so the speaker does not cache Secrets.
+	SecretReader client.Reader
+}
+
+// Reconcile recomputes the set of addresses advertised by this node.
+func (s *Speaker) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("node", s.NodeName)
+
+	var nodes corev1.NodeList
+	if err := s.List(ctx, &nodes); err != nil {
+		return ctrl.Result{}, err
+	}
+	var leases coordinationv1.LeaseList
+	if err := s.List(ctx, &leases, client.InNamespace(s.Namespace)); err != nil {
+		return ctrl.Result{}, err
+	}
+	var configs balancerv1.HeliosConfigList
+	if err := s.List(ctx, &configs); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	now := time.Now()
+	candidates, nextExpiry := speakerNodes(nodes.Items, leases.Items, now)
+	owned := s.ownedIPs(configs.Items, candidates)
+	s.Announcer.SetOwned(owned)
+
+	var peerErr error
+	var peers []bgp.Peer
+	if s.Routes != nil {
+		peers, peerErr = s.bgpPeers(ctx, configs.Items, slices.Contains(candidates, s.NodeName))
+		s.Routes.Sync(peers)
+	}
+
+	logger.V(1).Info("speaker synced", "candidates", len(candidates), "owned", len(owned), "bgpPeers", len(peers))
+	// A peer whose password cannot be read is left out; return the error so
+	// the sync is retried while the remaining peers keep their sessions.
+	var result ctrl.Result
+	if !nextExpiry.IsZero() {
+		// Rerun the election when a lease may have expired; renewals of fresh
+		// leases are filtered out, so no event would arrive.
+		result.RequeueAfter = nextExpiry.Sub(now) + time.Second
+	}
+	return result, peerErr
+}
+
+// ownedIPs returns the allocated IPv4 and IPv6 addresses of L2 configs
+// elected to this node. Both families go through the same election, so on a
+// dual-stack service the two addresses are announced independently of each
+// other.
+func (s *Speaker) ownedIPs(configs []balancerv1.HeliosConfig, candidates []string) []net.IP {
+	var owned []net.IP
+	for i := range configs {
+		if advertisementMode(&configs[i]) != balancerv1.AdvertisementModeL2 {
+			continue
+		}
+		for _, ip := range allocatedIPs(&configs[i]) {
+			if ElectNode(ip.String(), candidates) == s.NodeName {
+				owned = append(owned, ip)
+			}
+		}
+	}
+	return owned
+}
+
+// bgpPeers returns the sessions this node runs and the routes to advertise
+// over them. A node that is not a candidate advertises nothing, so its
+// sessions are torn down and the fabric stops sending it traffic.
+func (s *Speaker) bgpPeers(ctx context.Context, configs []balancerv1.HeliosConfig, ready bool) ([]bgp.Peer, error) {
+	if !ready {
+		return nil, nil
+	}
+	var peers []bgp.Peer
+	var errs []error
+	for i := range configs {
+		hc := &configs[i]
+		if advertisementMode(hc) != balancerv1.AdvertisementModeBGP || hc.Spec.Advertisement.BGP == nil {
+			continue
+		}
+		var routes []netip.Addr
+		for _, ip := range allocatedIPs(hc) {
+			if addr, ok := netip.AddrFromSlice(ip); ok {
+				routes = append(routes, addr.Unmap())
+			}
+		}
+		cfg := hc.Spec.Advertisement.BGP
+		routerID, _ := netip.ParseAddr(cfg.RouterID)
+		for _, p := range cfg.Peers {
+			addr, err := netip.ParseAddr(p.Address)
+			if err != nil {
+				continue // rejected by the webhook
+			}
+			password, err := s.peerPassword(ctx, hc.Namespace, p.PasswordSecretRef)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("peer %s of %s/%s: %w", p.Address, hc.Namespace, hc.Name, err))
+				continue
+			}
+			peers = append(peers, bgp.Peer{
+				Config: bgp.SessionConfig{
+					LocalASN:    uint32(cfg.LocalASN),
+					PeerASN:     uint32(p.ASN),
+					PeerAddress: addr,
+					PeerPort:    int(p.Port),
+					HoldTime:    time.Duration(p.HoldTimeSeconds) * time.Second,
+					Password:    password,
+					RouterID:    routerID,
+				},
+				Routes: routes,
+			})
+		}
+	}
+	return peers, errors.Join(errs...)
+}
+
+// peerPassword reads the TCP MD5 password a peer references, if any.
+func (s *Speaker) peerPassword(ctx context.Context, namespace string, ref *corev1.SecretKeySelector) (string, error) {
+	if ref == nil {
+		return "", nil
+	}
+	reader := s.SecretReader
+	if reader == nil {
+		reader = s.Client
+	}
+	var secret corev1.Secret
+	err := reader.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, &secret)
+	if apierrors.IsNotFound(err) && ref.Optional != nil && *ref.Optional {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	password, ok := secret.Data[ref.Key]
+	if !ok {
+		if ref.Optional != nil && *ref.Optional {
+			return "", nil
+		}
+		return "", fmt.Errorf("secret %s has no key %q", ref.Name, ref.Key)
+	}
+	return string(password), nil
+}
+
+// advertisementMode returns the advertisement mode of a config, L2 unless BGP
+// is selected explicitly.
+func advertisementMode(hc *balancerv1.HeliosConfig) string {
+	if hc.Spec.Advertisement != nil && hc.Spec.Advertisement.Mode == balancerv1.AdvertisementModeBGP {
+		return balancerv1.AdvertisementModeBGP
+	}
+	return balancerv1.AdvertisementModeL2
+}
+
+// allocatedIPs returns the parseable IPv4 and IPv6 addresses allocated by a
+// config. A config that is being deleted without a drain timeout has none:
+// the controller releases its addresses at once, so they must stop being
+// advertised straight away. With a drain timeout the controller keeps them
+// allocated while open connections drain, and they stay advertised until it
+// releases them and the config is gone; withdrawing them earlier would cut
+// off the connections the drain is waiting for.
+func allocatedIPs(hc *balancerv1.HeliosConfig) []net.IP {
+	if !hc.DeletionTimestamp.IsZero() && !drainsOnDelete(hc) {
+		return nil
+	}
+	var ips []net.IP
+	for _, addr := range hc.Status.AllocatedAddresses() {
+		if ip := net.ParseIP(addr); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// drainsOnDelete reports whether the controller keeps a deleted config's
+// addresses while its connections drain. The advertisements and the proxy
+// frontends of a deleting config both go by it, so traffic is never attracted
+// to an address the proxy no longer serves.
+func drainsOnDelete(hc *balancerv1.HeliosConfig) bool {
+	return hc.Spec.DrainTimeoutSeconds > 0
+}
+
+func nodeReady(node *corev1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// SetupWithManager sets up the speaker with the Manager.
+func (s *Speaker) SetupWithManager(mgr ctrl.Manager) error {
+	enqueue := handler.EnqueueRequestsFromMapFunc(func(context.Context, client.Object) []reconcile.Request {
+		return []reconcile.Request{syncRequest}
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("speaker").
+		Watches(&balancerv1.HeliosConfig{}, enqueue).
+		Watches(&corev1.Node{}, enqueue, builder.WithPredicates(nodeReadinessChanged())).
+		Watches(&coordinationv1.Lease{}, enqueue, builder.WithPredicates(speakerLeaseResumed())).
+		Complete(s)
+}
+
+// nodeReadinessChanged filters out the periodic node status heartbeats that
+// do not change readiness.
+func nodeReadinessChanged() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldNode, ok := e.ObjectOld.(*corev1.Node)
+			if !ok {
+				return false
+			}
+			newNode, ok := e.ObjectNew.(*corev1.Node)
+			if !ok {
+				return false
+			}
+			return nodeReady(oldNode) != nodeReady(newNode)
+		},
+	}
+}
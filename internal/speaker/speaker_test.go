@@ -0,0 +1,346 @@
+package speaker
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	balancerv1 "github.com/somaz94/helios-lb/api/v1"
+	"github.com/somaz94/helios-lb/internal/bgp"
+)
+
+// recordingAnnouncer remembers the last owned set it was given.
+type recordingAnnouncer struct {
+	owned []net.IP
+	calls int
+}
+
+func (r *recordingAnnouncer) SetOwned(ips []net.IP) {
+	r.owned = ips
+	r.calls++
+}
+
+func newTestScheme() *runtime.Scheme {
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = balancerv1.AddToScheme(s)
+	return s
+}
+
+func testNode(name string, ready bool) *corev1.Node {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+			{Type: corev1.NodeReady, Status: status},
+		}},
+	}
+}
+
+// testConfig builds a config whose services (by name) were allocated the
+// given IPv4 addresses.
+func testConfig(name string, allocated map[string]string) *balancerv1.HeliosConfig {
+	hc := &balancerv1.HeliosConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       balancerv1.HeliosConfigSpec{IPRange: "192.0.2.0/24"},
+	}
+	for svc, ip := range allocated {
+		hc.Status.SetAllocation(balancerv1.ServiceAllocation{Namespace: "default", Name: svc, IPv4: ip})
+	}
+	return hc
+}
+
+// recordingRoutes remembers the last set of BGP peers it was given.
+type recordingRoutes struct {
+	peers []bgp.Peer
+	calls int
+}
+
+func (r *recordingRoutes) Sync(peers []bgp.Peer) {
+	r.peers = peers
+	r.calls++
+}
+
+func testBGPConfig(name string, allocated map[string]string, peers ...balancerv1.BGPPeer) *balancerv1.HeliosConfig {
+	hc := testConfig(name, allocated)
+	hc.Spec.Advertisement = &balancerv1.AdvertisementConfig{
+		Mode: balancerv1.AdvertisementModeBGP,
+		BGP:  &balancerv1.BGPConfig{LocalASN: 65001, Peers: peers},
+	}
+	return hc
+}
+
+const testNamespace = "helios-lb-system"
+
+// testLease builds the lease of the speaker on node, last renewed at renewed.
+func testLease(node string, renewed time.Time) *coordinationv1.Lease {
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: speakerLeaseName(node), Namespace: testNamespace},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       ptr.To(node),
+			LeaseDurationSeconds: ptr.To(int32(speakerLeaseDuration / time.Second)),
+			RenewTime:            &metav1.MicroTime{Time: renewed},
+		},
+	}
+}
+
+// newSpeaker builds the speaker of node over objs. Every node in objs runs a
+// speaker that just renewed its lease, unless objs holds a lease for it.
+func newSpeaker(node string, objs ...client.Object) (*Speaker, *recordingAnnouncer, *recordingRoutes) {
+	leased := make(map[string]bool)
+	for _, obj := range objs {
+		if lease, ok := obj.(*coordinationv1.Lease); ok {
+			leased[*lease.Spec.HolderIdentity] = true
+		}
+	}
+	for _, obj := range objs {
+		if n, ok := obj.(*corev1.Node); ok && !leased[n.Name] {
+			objs = append(objs, testLease(n.Name, time.Now()))
+		}
+	}
+	cl := fake.NewClientBuilder().
+		WithScheme(newTestScheme()).
+		WithObjects(objs...).
+		WithStatusSubresource(&balancerv1.HeliosConfig{}).
+		Build()
+	ann, routes := &recordingAnnouncer{}, &recordingRoutes{}
+	return &Speaker{Client: cl, NodeName: node, Namespace: testNamespace, Announcer: ann, Routes: routes}, ann, routes
+}
+
+func reconcileAs(t *testing.T, node string, objs ...client.Object) []net.IP {
+	t.Helper()
+	s, ann, _ := newSpeaker(node, objs...)
+	if _, err := s.Reconcile(context.Background(), syncRequest); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if ann.calls != 1 {
+		t.Fatalf("SetOwned called %d times, want 1", ann.calls)
+	}
+	return ann.owned
+}
+
+func reconcileBGPAs(t *testing.T, node string, objs ...client.Object) []bgp.Peer {
+	t.Helper()
+	s, _, routes := newSpeaker(node, objs...)
+	if _, err := s.Reconcile(context.Background(), syncRequest); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if routes.calls != 1 {
+		t.Fatalf("Sync called %d times, want 1", routes.calls)
+	}
+	return routes.peers
+}
+
+// Every address must be announced by exactly one of the ready nodes.
+func TestSpeaker_EachIPHasExactlyOneOwner(t *testing.T) {
+	allocated := map[string]string{
+		"web": "192.0.2.10", "api": "192.0.2.11", "db": "192.0.2.12",
+		"cache": "192.0.2.13", "queue": "192.0.2.14",
+	}
+	objs := []client.Object{
+		testNode("node-a", true), testNode("node-b", true), testNode("node-c", true),
+		testConfig("pool", allocated),
+	}
+
+	owners := make(map[string]int)
+	for _, node := range []string{"node-a", "node-b", "node-c"} {
+		for _, ip := range reconcileAs(t, node, objs...) {
+			owners[ip.String()]++
+		}
+	}
+	for _, ip := range allocated {
+		if owners[ip] != 1 {
+			t.Errorf("%s is announced by %d nodes, want exactly 1", ip, owners[ip])
+		}
+	}
+}
+
+func TestSpeaker_NotReadyNodeOwnsNothing(t *testing.T) {
+	objs := []client.Object{
+		testNode("node-a", false), testNode("node-b", true),
+		testConfig("pool", map[string]string{"web": "192.0.2.10", "api": "192.0.2.11"}),
+	}
+	if owned := reconcileAs(t, "node-a", objs...); len(owned) != 0 {
+		t.Errorf("not-ready node owns %v, want nothing", owned)
+	}
+	if owned := reconcileAs(t, "node-b", objs...); len(owned) != 2 {
+		t.Errorf("only ready node owns %v, want both addresses", owned)
+	}
+}
+
+func TestSpeaker_NodeWithoutSpeakerOwnsNothing(t *testing.T) {
+	objs := []client.Object{
+		testNode("node-a", true), testNode("node-b", true),
+		// The speaker on node-a crashed and stopped renewing.
+		testLease("node-a", time.Now().Add(-time.Minute)),
+		testConfig("pool", map[string]string{"web": "192.0.2.10", "api": "192.0.2.11"}),
+	}
+	if owned := reconcileAs(t, "node-a", objs...); len(owned) != 0 {
+		t.Errorf("node with an expired speaker lease owns %v, want nothing", owned)
+	}
+	if owned := reconcileAs(t, "node-b", objs...); len(owned) != 2 {
+		t.Errorf("only node running a speaker owns %v, want both addresses", owned)
+	}
+}
+
+func TestSpeaker_RequeuesAtLeaseExpiry(t *testing.T) {
+	renewed := time.Now().Add(-10 * time.Second)
+	s, _, _ := newSpeaker("node-a", testNode("node-a", true), testLease("node-a", renewed),
+		testConfig("pool", map[string]string{"web": "192.0.2.10"}))
+	result, err := s.Reconcile(context.Background(), syncRequest)
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if result.RequeueAfter <= 0 || result.RequeueAfter > speakerLeaseDuration-5*time.Second+time.Second {
+		t.Errorf("RequeueAfter = %v, want shortly after the lease expires in 5s", result.RequeueAfter)
+	}
+}
+
+func TestSpeaker_SkipsUnparseableAddresses(t *testing.T) {
+	objs := []client.Object{
+		testNode("node-a", true),
+		testConfig("pool", map[string]string{"bad": "not-an-ip", "web": "192.0.2.10"}),
+	}
+	owned := reconcileAs(t, "node-a", objs...)
+	if len(owned) != 1 || !owned[0].Equal(net.ParseIP("192.0.2.10")) {
+		t.Errorf("owned = %v, want only the valid address", owned)
+	}
+}
+
+func TestSpeaker_AnnouncesDualStackAddresses(t *testing.T) {
+	hc := testConfig("pool", map[string]string{"web": "192.0.2.10"})
+	hc.Status.FindAllocation("default", "web").IPv6 = "fd00::10"
+	owned := reconcileAs(t, "node-a", testNode("node-a", true), hc)
+
+	got := make(map[string]bool)
+	for _, ip := range owned {
+		got[ip.String()] = true
+	}
+	if len(owned) != 2 || !got["192.0.2.10"] || !got["fd00::10"] {
+		t.Errorf("owned = %v, want both the IPv4 and the IPv6 address", owned)
+	}
+}
+
+// In BGP mode every ready node advertises every address; nothing is elected.
+func TestSpeaker_BGPModeAdvertisesFromEveryReadyNode(t *testing.T) {
+	objs := []client.Object{
+		testNode("node-a", true), testNode("node-b", true), testNode("node-c", false),
+		testBGPConfig("pool", map[string]string{"web": "192.0.2.10", "api": "192.0.2.11"},
+			balancerv1.BGPPeer{Address: "10.0.0.1", ASN: 65000, HoldTimeSeconds: 30}),
+	}
+	for _, node := range []string{"node-a", "node-b"} {
+		peers := reconcileBGPAs(t, node, objs...)
+		if len(peers) != 1 {
+			t.Fatalf("%s runs %d sessions, want 1", node, len(peers))
+		}
+		p := peers[0]
+		if p.Config.LocalASN != 65001 || p.Config.PeerASN != 65000 ||
+			p.Config.PeerAddress != netip.MustParseAddr("10.0.0.1") || p.Config.HoldTime != 30*time.Second {
+			t.Errorf("%s session config = %+v", node, p.Config)
+		}
+		if len(p.Routes) != 2 {
+			t.Errorf("%s advertises %v, want both addresses", node, p.Routes)
+		}
+	}
+	if peers := reconcileBGPAs(t, "node-c", objs...); len(peers) != 0 {
+		t.Errorf("not-ready node runs %d sessions, want none", len(peers))
+	}
+	if owned := reconcileAs(t, "node-a", objs...); len(owned) != 0 {
+		t.Errorf("BGP addresses are also announced over L2: %v", owned)
+	}
+}
+
+func TestSpeaker_DeletingConfigWithdrawsRoutes(t *testing.T) {
+	hc := testBGPConfig("pool", map[string]string{"web": "192.0.2.10"},
+		balancerv1.BGPPeer{Address: "10.0.0.1", ASN: 65000})
+	now := metav1.Now()
+	hc.DeletionTimestamp = &now
+	hc.Finalizers = []string{"balancer.helios.dev/finalizer"}
+
+	peers := reconcileBGPAs(t, "node-a", testNode("node-a", true), hc)
+	if len(peers) != 1 || len(peers[0].Routes) != 0 {
+		t.Errorf("peers = %+v, want the session kept with no routes", peers)
+	}
+}
+
+// A deleting config with a drain timeout stays advertised until the
+// controller releases its addresses, so draining connections keep arriving.
+func TestSpeaker_DrainingConfigKeepsAdvertising(t *testing.T) {
+	now := metav1.Now()
+	bgpConfig := testBGPConfig("pool", map[string]string{"web": "192.0.2.10"},
+		balancerv1.BGPPeer{Address: "10.0.0.1", ASN: 65000})
+	bgpConfig.DeletionTimestamp = &now
+	bgpConfig.Finalizers = []string{"balancer.helios.dev/finalizer"}
+	bgpConfig.Spec.DrainTimeoutSeconds = 30
+
+	peers := reconcileBGPAs(t, "node-a", testNode("node-a", true), bgpConfig)
+	if len(peers) != 1 || len(peers[0].Routes) != 1 {
+		t.Errorf("peers = %+v, want the route kept while draining", peers)
+	}
+
+	l2Config := testConfig("pool", map[string]string{"web": "192.0.2.20"})
+	l2Config.DeletionTimestamp = &now
+	l2Config.Finalizers = []string{"balancer.helios.dev/finalizer"}
+	l2Config.Spec.DrainTimeoutSeconds = 30
+
+	owned := reconcileAs(t, "node-a", testNode("node-a", true), l2Config)
+	if len(owned) != 1 || !owned[0].Equal(net.ParseIP("192.0.2.20")) {
+		t.Errorf("owned = %v, want the address kept while draining", owned)
+	}
+}
+
+func TestSpeaker_ReadsPeerPasswordFromSecret(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "bgp-auth", Namespace: "default"},
+		Data:       map[string][]byte{"password": []byte("s3cret")},
+	}
+	ref := func(name string) *corev1.SecretKeySelector {
+		return &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: name}, Key: "password"}
+	}
+	hc := testBGPConfig("pool", map[string]string{"web": "192.0.2.10"},
+		balancerv1.BGPPeer{Address: "10.0.0.1", ASN: 65000, PasswordSecretRef: ref("bgp-auth")},
+		balancerv1.BGPPeer{Address: "10.0.0.2", ASN: 65000, PasswordSecretRef: ref("missing")})
+
+	s, _, routes := newSpeaker("node-a", testNode("node-a", true), hc, secret)
+	if _, err := s.Reconcile(context.Background(), syncRequest); err == nil {
+		t.Error("Reconcile() error = nil, want the missing secret reported")
+	}
+	if len(routes.peers) != 1 || routes.peers[0].Config.Password != "s3cret" {
+		t.Errorf("peers = %+v, want only the peer whose secret resolved", routes.peers)
+	}
+}
+
+// Entries the controller has not migrated yet are still announced.
+func TestSpeaker_AnnouncesLegacyAllocations(t *testing.T) {
+	hc := testConfig("pool", nil)
+	hc.Status.AllocatedIPs = map[string]string{"web": "192.0.2.10"}
+	owned := reconcileAs(t, "node-a", testNode("node-a", true), hc)
+	if len(owned) != 1 || !owned[0].Equal(net.ParseIP("192.0.2.10")) {
+		t.Errorf("owned = %v, want the legacy address", owned)
+	}
+}
+
+func TestNodeReadinessChanged(t *testing.T) {
+	p := nodeReadinessChanged()
+	if p.Update(event.UpdateEvent{ObjectOld: testNode("n", true), ObjectNew: testNode("n", true)}) {
+		t.Error("heartbeat without a readiness change should be filtered")
+	}
+	if !p.Update(event.UpdateEvent{ObjectOld: testNode("n", true), ObjectNew: testNode("n", false)}) {
+		t.Error("readiness change should pass")
+	}
+}